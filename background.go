@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// snapshotGatherer runs the collection on an internal ticker and serves the
+// latest result, so concurrent scrapes by several Prometheus servers or
+// humans never reach libvirtd.
+type snapshotGatherer struct {
+	mtx sync.RWMutex
+	mfs []*dto.MetricFamily
+	err error
+	at  time.Time
+}
+
+// Gather implements prometheus.Gatherer.
+func (s *snapshotGatherer) Gather() ([]*dto.MetricFamily, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.mfs, s.err
+}
+
+// age returns the seconds since the last snapshot was taken.
+func (s *snapshotGatherer) age() float64 {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	if s.at.IsZero() {
+		return 0
+	}
+	return time.Since(s.at).Seconds()
+}
+
+// run gathers a new snapshot every interval until ctx is done. Every run gets
+// a fresh gatherer from newGatherer, bounded by the interval.
+func (s *snapshotGatherer) run(ctx context.Context, interval time.Duration, newGatherer func(ctx context.Context) (prometheus.Gatherer, error), logger log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		runCtx, cancel := context.WithTimeout(ctx, interval)
+		begin := time.Now()
+		gatherer, err := newGatherer(runCtx)
+		var mfs []*dto.MetricFamily
+		if err == nil {
+			mfs, err = gatherer.Gather()
+		}
+		cancel()
+		if err != nil {
+			level.Error(logger).Log("msg", "Background collection failed", "err", err)
+		}
+		level.Debug(logger).Log("msg", "Background collection finished", "duration_seconds", time.Since(begin).Seconds())
+
+		s.mtx.Lock()
+		s.mfs, s.err, s.at = mfs, err, time.Now()
+		s.mtx.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/nee541/libvirt-exporter/collector"
+	"github.com/prometheus/common/version"
+)
+
+// metricsCatalog is the JSON document returned by the metrics catalog
+// endpoint.
+type metricsCatalog struct {
+	Version string                    `json:"version"`
+	Metrics []collector.CatalogMetric `json:"metrics"`
+}
+
+// metricsCatalog lists every metric the exporter can emit with its labels,
+// type and collector, so catalogs of two exporter versions can be diffed.
+// Metrics about the exporter process itself are not included.
+func (h *handler) metricsCatalog(w http.ResponseWriter, r *http.Request) {
+	metrics, err := collector.Catalog(h.logger)
+	if err != nil {
+		level.Error(h.logger).Log("msg", "Couldn't create metrics catalog", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metricsCatalog{Version: version.Version, Metrics: metrics})
+}
+
+// writeMetricsCatalog writes the metrics catalog as indented JSON, for the
+// docs-metrics command.
+func writeMetricsCatalog(w io.Writer, logger log.Logger) error {
+	metrics, err := collector.Catalog(logger)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(metricsCatalog{Version: version.Version, Metrics: metrics})
+}
@@ -0,0 +1,222 @@
+package collector
+
+import (
+	"context"
+	"sync"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/nee541/libvirt-exporter/libvirt_schema"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// allocationCollector exposes the vCPUs and memory allocated to domains in
+// their definition. Unlike the other collectors it includes shut-off domains,
+// so capacity planning can account for guests that are allocated but stopped.
+// The overcommit of the host is derived from all active domains, regardless
+// of the domain filters.
+type allocationCollector struct {
+	vcpus            typedDesc
+	memory           typedDesc
+	memoryMaximum    typedDesc
+	vcpuOvercommit   typedDesc
+	memoryOvercommit typedDesc
+	logger           log.Logger
+}
+
+func init() {
+	registerCollector("allocation", defaultEnabled, NewAllocationCollector)
+}
+
+// NewAllocationCollector returns a new Collector exposing the configured
+// vCPUs and memory of active and inactive domains.
+func NewAllocationCollector(logger log.Logger) (Collector, error) {
+	newDesc := func(name, help string) typedDesc {
+		return typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "domain", name),
+				help,
+				[]string{"domain_uuid"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		}
+	}
+	return &allocationCollector{
+		vcpus:         newDesc("vcpus_configured", "Number of vCPUs online at boot according to the domain definition"),
+		memory:        newDesc("memory_configured_bytes", "Memory allocated to the domain at boot according to the domain definition"),
+		memoryMaximum: newDesc("memory_maximum_bytes", "Maximum memory of the domain according to the domain definition"),
+		vcpuOvercommit: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "host", "vcpu_overcommit_ratio"),
+				"Configured vCPUs of the active domains divided by the host CPUs",
+				nil,
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		memoryOvercommit: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "host", "memory_overcommit_ratio"),
+				"Configured memory of the active domains divided by the host memory",
+				nil,
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		logger: logger,
+	}, nil
+}
+
+func (c *allocationCollector) descs() []typedDesc {
+	return []typedDesc{c.vcpus, c.memory, c.memoryMaximum, c.vcpuOvercommit, c.memoryOvercommit}
+}
+
+func (c *allocationCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	if config.pLibvirt == nil {
+		level.Error(c.logger).Log("msg", "libvirt not created")
+		return ErrNotProvided
+	}
+	if !config.pLibvirt.IsConnected() {
+		level.Error(c.logger).Log("msg", "libvirt not connected")
+		return ErrNotProvided
+	}
+	ctx := config.context()
+	pLibvirt := config.pLibvirt
+
+	for _, lvDomain := range config.lvDomains {
+		c.updateDomain(ch, lvDomain.Schema)
+	}
+	vcpus, memory := c.activeAllocation(ctx, pLibvirt, config)
+	_, hostMemory, hostCPUs, _, _, _, _, _, err := pLibvirt.NodeGetInfo()
+	if err != nil {
+		level.Error(c.logger).Log("msg", "failed to get node info", "err", err)
+	} else {
+		if hostCPUs > 0 {
+			ch <- c.vcpuOvercommit.mustNewConstMetric(float64(vcpus) / float64(hostCPUs))
+		}
+		// NodeGetInfo reports the memory in KiB.
+		if hostMemory > 0 {
+			ch <- c.memoryOvercommit.mustNewConstMetric(float64(memory) / float64(hostMemory*1024))
+		}
+	}
+
+	// The definitions of active domains are already parsed, those of
+	// inactive domains are cached like them.
+	if domainsActiveOnly.Get() {
+		return nil
+	}
+	inactive, _, err := pLibvirt.ConnectListAllDomains(1, libvirt.ConnectListDomainsInactive)
+	if err != nil {
+		return err
+	}
+	listed := make(map[string]bool, len(inactive))
+	for _, domain := range inactive {
+		listed[uuidString(domain.UUID)] = true
+	}
+	inactiveDomainSchemas.retain(listed)
+	inactive = selectDomains(inactive)
+	wg := sync.WaitGroup{}
+	wg.Add(len(inactive))
+	for _, domain := range inactive {
+		go func(domain libvirt.Domain) {
+			defer wg.Done()
+			domainUUID := uuidString(domain.UUID)
+			if schema, ok := inactiveDomainSchemas.get(domainUUID); ok {
+				c.updateDomain(ch, schema)
+				return
+			}
+			if !acquireWorker(ctx) {
+				return
+			}
+			defer releaseWorker()
+			xmlDesc, err := pLibvirt.DomainGetXMLDesc(domain, 0)
+			if err != nil {
+				level.Error(c.logger).Log("msg", "failed to get domain xml", "domain", domain.Name, "err", err)
+				return
+			}
+			schema, err := libvirt_schema.NewDomainFromXML([]byte(xmlDesc))
+			if err != nil {
+				level.Error(c.logger).Log("msg", "failed to parse domain xml", "domain", domain.Name, "err", err)
+				return
+			}
+			inactiveDomainSchemas.set(domainUUID, schema)
+			c.updateDomain(ch, schema)
+		}(domain)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// activeAllocation sums the configured vCPUs and memory of all active
+// domains. The overcommit of the host includes the domains dropped by the
+// domain filters or opted out of the collector, only their own series are
+// left out.
+func (c *allocationCollector) activeAllocation(ctx context.Context, pLibvirt *libvirt.Libvirt, config *CollectorConfig) (uint64, uint64) {
+	var vcpus, memory uint64
+	if config.activeDomains == nil {
+		for _, lvDomain := range config.lvDomains {
+			vcpus += uint64(lvDomain.Schema.Vcpu.Configured())
+			memory += configuredMemory(lvDomain.Schema)
+		}
+		return vcpus, memory
+	}
+	var mtx sync.Mutex
+	wg := sync.WaitGroup{}
+	wg.Add(len(config.activeDomains))
+	for _, domain := range config.activeDomains {
+		go func(domain libvirt.Domain) {
+			defer wg.Done()
+			domainUUID := uuidString(domain.UUID)
+			schema, ok := domainSchemas.get(domainUUID)
+			if !ok {
+				if !acquireWorker(ctx) {
+					return
+				}
+				defer releaseWorker()
+				xmlDesc, err := pLibvirt.DomainGetXMLDesc(domain, 0)
+				if err != nil {
+					level.Error(c.logger).Log("msg", "failed to get domain xml", "domain", domain.Name, "err", err)
+					return
+				}
+				schema, err = libvirt_schema.NewDomainFromXML([]byte(xmlDesc))
+				if err != nil {
+					level.Error(c.logger).Log("msg", "failed to parse domain xml", "domain", domain.Name, "err", err)
+					return
+				}
+				domainSchemas.set(domainUUID, schema)
+			}
+			mtx.Lock()
+			defer mtx.Unlock()
+			vcpus += uint64(schema.Vcpu.Configured())
+			memory += configuredMemory(schema)
+		}(domain)
+	}
+	wg.Wait()
+	return vcpus, memory
+}
+
+func (c *allocationCollector) updateDomain(ch chan<- prometheus.Metric, schema libvirt_schema.Domain) {
+	if vcpus := schema.Vcpu.Configured(); vcpus > 0 {
+		ch <- c.vcpus.mustNewConstMetric(float64(vcpus), schema.UUID)
+	}
+	if maximum := schema.Memory.Bytes(); maximum > 0 {
+		ch <- c.memoryMaximum.mustNewConstMetric(float64(maximum), schema.UUID)
+	}
+	if memory := configuredMemory(schema); memory > 0 {
+		ch <- c.memory.mustNewConstMetric(float64(memory), schema.UUID)
+	}
+}
+
+// configuredMemory returns the memory a domain boots with. Without
+// <currentMemory> this is its maximum memory.
+func configuredMemory(schema libvirt_schema.Domain) uint64 {
+	if current := schema.CurrentMemory.Bytes(); current > 0 {
+		return current
+	}
+	return schema.Memory.Bytes()
+}
@@ -0,0 +1,180 @@
+package collector
+
+import (
+	"context"
+	"sync"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	backoffFailures = kingpin.Flag(
+		"collector.backoff.failures",
+		"Number of consecutive scrapes with timeouts after which a domain is skipped by the expensive per-domain collectors, 0 disables the backoff.",
+	).Default("3").Int()
+	backoffScrapes = kingpin.Flag(
+		"collector.backoff.scrapes",
+		"Number of scrapes a domain is skipped after --collector.backoff.failures scrapes with timeouts.",
+	).Default("10").Int()
+)
+
+// backoffCollectors are the collectors which call into the QEMU monitor or
+// guest agent of every domain, and so wait for hung domains. Other collectors
+// keep exposing domains in backoff.
+var backoffCollectors = map[string]bool{
+	"block":      true,
+	"ceilometer": true,
+	"control":    true,
+	"disk_error": true,
+	"interface":  true,
+	"iothread":   true,
+	"job":        true,
+	"memory":     true,
+}
+
+var (
+	backoffRemainingDesc = newMetricDesc(
+		prometheus.BuildFQName(namespace, "domain", "backoff_remaining_scrapes"),
+		"Number of scrapes the domain is still skipped by the expensive collectors after repeated timeouts.",
+		[]string{"domain_uuid"},
+		nil,
+	)
+	backoffsDesc = newMetricDesc(
+		prometheus.BuildFQName(namespace, "domain", "backoffs_total"),
+		"Number of times the domain was skipped by the expensive collectors after repeated timeouts.",
+		[]string{"domain_uuid"},
+		nil,
+	)
+)
+
+// backoffTracker is a circuit breaker per domain. A hung QEMU process or
+// guest agent makes every call for the domain time out, which delays the
+// scrape of the whole host. Domains timing out in several consecutive scrapes
+// are skipped by backoffCollectors for a number of scrapes.
+type backoffTracker struct {
+	mtx sync.Mutex
+	// failed holds the domains with a timeout in the current scrape.
+	failed map[string]bool
+	// failures counts the consecutive scrapes with timeouts.
+	failures map[string]int
+	// remaining counts the scrapes a domain is still skipped.
+	remaining map[string]int
+	backoffs  map[string]float64
+}
+
+var domainBackoff = &backoffTracker{
+	failed:    make(map[string]bool),
+	failures:  make(map[string]int),
+	remaining: make(map[string]int),
+	backoffs:  make(map[string]float64),
+}
+
+// fail records a timeout of the domain in the current scrape.
+func (t *backoffTracker) fail(domainUUID string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.failed[domainUUID] = true
+}
+
+// skipping reports whether the domain is in backoff.
+func (t *backoffTracker) skipping(domainUUID string) bool {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.remaining[domainUUID] > 0
+}
+
+// endScrape counts the scrape for all domains in domainUUIDs and drops the
+// state of all others.
+func (t *backoffTracker) endScrape(domainUUIDs map[string]bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	for domainUUID := range domainUUIDs {
+		switch {
+		case t.remaining[domainUUID] > 0:
+			t.remaining[domainUUID]--
+		case t.failed[domainUUID]:
+			t.failures[domainUUID]++
+			if *backoffFailures > 0 && t.failures[domainUUID] >= *backoffFailures {
+				t.remaining[domainUUID] = *backoffScrapes
+				t.backoffs[domainUUID]++
+				delete(t.failures, domainUUID)
+			}
+		default:
+			delete(t.failures, domainUUID)
+		}
+	}
+	for _, m := range []map[string]int{t.failures, t.remaining} {
+		for domainUUID, n := range m {
+			if n == 0 || !domainUUIDs[domainUUID] {
+				delete(m, domainUUID)
+			}
+		}
+	}
+	for domainUUID := range t.backoffs {
+		if !domainUUIDs[domainUUID] {
+			delete(t.backoffs, domainUUID)
+		}
+	}
+	t.failed = make(map[string]bool)
+}
+
+// metrics returns the backoff state as metrics.
+func (t *backoffTracker) metrics() []prometheus.Metric {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	metrics := make([]prometheus.Metric, 0, len(t.remaining)+len(t.backoffs))
+	for domainUUID, n := range t.remaining {
+		metrics = append(metrics, prometheus.MustNewConstMetric(backoffRemainingDesc, prometheus.GaugeValue, float64(n), domainUUID))
+	}
+	for domainUUID, n := range t.backoffs {
+		metrics = append(metrics, prometheus.MustNewConstMetric(backoffsDesc, prometheus.CounterValue, n, domainUUID))
+	}
+	return metrics
+}
+
+// busyDomains counts the calls a collector has in flight per domain, see
+// busyDomain.
+type busyDomains struct {
+	mtx   sync.Mutex
+	calls map[string]int
+}
+
+type busyDomainsKey struct{}
+
+// withBusyDomains returns a context in which busyDomain tracks the domains a
+// collector is querying.
+func withBusyDomains(ctx context.Context, busy *busyDomains) context.Context {
+	return context.WithValue(ctx, busyDomainsKey{}, busy)
+}
+
+// busyDomain marks the domain as queried by the collector of ctx until the
+// returned function is called. Domains a collector still waits for when it is
+// dropped at the scrape deadline count as timed out for domainBackoff, a hung
+// domain blocks the call instead of failing it.
+func busyDomain(ctx context.Context, domainUUID string) func() {
+	busy, ok := ctx.Value(busyDomainsKey{}).(*busyDomains)
+	if !ok {
+		return func() {}
+	}
+	busy.mtx.Lock()
+	busy.calls[domainUUID]++
+	busy.mtx.Unlock()
+	return func() {
+		busy.mtx.Lock()
+		defer busy.mtx.Unlock()
+		if busy.calls[domainUUID]--; busy.calls[domainUUID] == 0 {
+			delete(busy.calls, domainUUID)
+		}
+	}
+}
+
+// timedOut records err for all domains still queried.
+func (b *busyDomains) timedOut(collector string, err error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	for domainUUID := range b.calls {
+		domainErrors.record(domainUUID, collector, err)
+		domainBackoff.fail(domainUUID)
+	}
+}
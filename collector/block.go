@@ -2,24 +2,84 @@ package collector
 
 import (
 	"sync"
+	"time"
 
 	libvirt "github.com/digitalocean/go-libvirt"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/nee541/libvirt-exporter/libvirt_schema"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+var blockDeviceExclude = regexpFlag(
+	"collector.block.device-exclude",
+	"Regexp of disk target devices to exclude, e.g. sd[b-z]",
+)
+
 type blockCollector struct {
 	readBytes       typedDesc
 	readRequests    typedDesc
 	writeBytes      typedDesc
 	writeRequests   typedDesc
+	blockInfo       infoDesc
 	blockCapacity   typedDesc
 	blockAllocation typedDesc
 	blockPhysical   typedDesc
+	limitBytes      typedDesc
+	limitIops       typedDesc
+	capacityChanges typedDesc
+	lastResize      typedDesc
 	logger          log.Logger
 }
 
+type diskKey struct {
+	domainUUID   string
+	targetDevice string
+}
+
+type diskCapacity struct {
+	capacity   uint64
+	changes    float64
+	lastChange time.Time
+}
+
+// capacityTracker detects capacity changes of disks between scrapes, as
+// libvirt has no event for resized disks.
+type capacityTracker struct {
+	mtx   sync.Mutex
+	disks map[diskKey]*diskCapacity
+}
+
+var diskCapacities = &capacityTracker{disks: make(map[diskKey]*diskCapacity)}
+
+// observe records the current capacity of a disk and returns its state.
+func (t *capacityTracker) observe(key diskKey, capacity uint64) diskCapacity {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	disk, ok := t.disks[key]
+	if !ok {
+		disk = &diskCapacity{capacity: capacity}
+		t.disks[key] = disk
+	}
+	if disk.capacity != capacity {
+		disk.capacity = capacity
+		disk.changes++
+		disk.lastChange = time.Now()
+	}
+	return *disk
+}
+
+// retain drops the disks of all domains not in domainUUIDs.
+func (t *capacityTracker) retain(domainUUIDs map[string]bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	for key := range t.disks {
+		if !domainUUIDs[key.domainUUID] {
+			delete(t.disks, key)
+		}
+	}
+}
+
 const blockSubsystemName = "domain_block"
 
 func init() {
@@ -29,7 +89,7 @@ func init() {
 func NewBlockCollector(logger log.Logger) (Collector, error) {
 	return &blockCollector{
 		readBytes: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, blockSubsystemName, "read_bytes_total"),
 				"Total number of bytes read from a block device",
 				[]string{"domain_uuid", "source_file", "target_device"},
@@ -37,7 +97,7 @@ func NewBlockCollector(logger log.Logger) (Collector, error) {
 			valueType: prometheus.CounterValue,
 		},
 		readRequests: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, blockSubsystemName, "read_requests_total"),
 				"Total number of read requests made to a block device",
 				[]string{"domain_uuid", "source_file", "target_device"},
@@ -45,7 +105,7 @@ func NewBlockCollector(logger log.Logger) (Collector, error) {
 			valueType: prometheus.CounterValue,
 		},
 		writeBytes: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, blockSubsystemName, "write_bytes_total"),
 				"Total number of bytes written to a block device",
 				[]string{"domain_uuid", "source_file", "target_device"},
@@ -53,15 +113,18 @@ func NewBlockCollector(logger log.Logger) (Collector, error) {
 			valueType: prometheus.CounterValue,
 		},
 		writeRequests: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, blockSubsystemName, "write_requests_total"),
 				"Total number of write requests made to a block device",
 				[]string{"domain_uuid", "source_file", "target_device"},
 				nil),
 			valueType: prometheus.CounterValue,
 		},
+		blockInfo: newInfoDesc(blockSubsystemName, "info",
+			"Source and driver settings of a block device, source_type is file, block, dir, network or volume and protocol the network protocol, e.g. rbd or iscsi. The driver settings are empty if they take the hypervisor default",
+			"domain_uuid", "source_file", "target_device", "source_type", "protocol", "driver_type", "cache", "io", "discard"),
 		blockCapacity: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, blockSubsystemName, "capacity_bytes"),
 				"Capacity of a block device in bytes",
 				[]string{"domain_uuid", "source_file", "target_device"},
@@ -69,7 +132,7 @@ func NewBlockCollector(logger log.Logger) (Collector, error) {
 			valueType: prometheus.GaugeValue,
 		},
 		blockAllocation: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, blockSubsystemName, "allocation_bytes"),
 				"Allocation of a block device in bytes",
 				[]string{"domain_uuid", "source_file", "target_device"},
@@ -77,23 +140,73 @@ func NewBlockCollector(logger log.Logger) (Collector, error) {
 			valueType: prometheus.GaugeValue,
 		},
 		blockPhysical: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, blockSubsystemName, "physical_bytes"),
 				"Physical size of a block device in bytes",
 				[]string{"domain_uuid", "source_file", "target_device"},
 				nil),
 			valueType: prometheus.GaugeValue,
 		},
+		limitBytes: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, blockSubsystemName, "limit_bytes_per_second"),
+				"Configured throughput limit of a block device by operation, absent if unlimited",
+				[]string{"domain_uuid", "source_file", "target_device", "op"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		capacityChanges: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, blockSubsystemName, "capacity_changes_total"),
+				"Number of capacity changes of a block device observed since the exporter started",
+				[]string{"domain_uuid", "source_file", "target_device"},
+				nil),
+			valueType: prometheus.CounterValue,
+		},
+		lastResize: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, blockSubsystemName, "last_resize_timestamp_seconds"),
+				"Time the last capacity change of a block device was observed since unix epoch in seconds",
+				[]string{"domain_uuid", "source_file", "target_device"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		limitIops: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, blockSubsystemName, "limit_iops"),
+				"Configured I/O operations per second limit of a block device by operation, absent if unlimited",
+				[]string{"domain_uuid", "source_file", "target_device", "op"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
 
 		logger: logger,
 	}, nil
 }
 
+func (c *blockCollector) descs() []typedDesc {
+	return []typedDesc{
+		c.readBytes,
+		c.readRequests,
+		c.writeBytes,
+		c.writeRequests,
+		c.blockInfo.typedDesc,
+		c.blockCapacity,
+		c.blockAllocation,
+		c.blockPhysical,
+		c.limitBytes,
+		c.limitIops,
+		c.capacityChanges,
+		c.lastResize,
+	}
+}
+
 func (c *blockCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
 	config := &CollectorConfig{}
 	for _, opt := range opts {
 		opt(config)
 	}
+	infos := newInfoMetrics(ch)
 
 	if config.pLibvirt == nil {
 		level.Error(c.logger).Log("msg", "libvirt not created")
@@ -104,9 +217,10 @@ func (c *blockCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOp
 		return ErrNotProvided
 	}
 	if config.lvDomains == nil || len(config.lvDomains) == 0 {
-		level.Error(c.logger).Log("msg", "no domains found")
+		level.Debug(c.logger).Log("msg", "no domains found")
 		return ErrNotProvided
 	}
+	ctx := config.context()
 	pLibvirt := config.pLibvirt
 	lvDomains := config.lvDomains
 
@@ -118,20 +232,43 @@ func (c *blockCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOp
 	wg.Add(wgCounter)
 	for _, lvDomain := range lvDomains {
 		for _, disk := range lvDomain.Schema.Devices.Disks {
-			if disk.Device == "cdrom" || disk.Device == "fq" {
-				// skip cdrom and floppy disk
+			domainUUID := lvDomain.Schema.UUID
+			if reason := blockSkipReason(disk); reason != "" {
+				devicesSkipped.inc(ctx, domainUUID, "block", reason)
 				// Decrease the wait group counter to avoid deadlock
 				wg.Done()
 				continue
 			}
-			domainUUID := lvDomain.Schema.UUID
-			sourceFile := disk.Source.File
+			// source_file keeps its name for compatibility, but holds the
+			// source of block, network and volume disks as well.
+			sourceFile := disk.SourcePath()
 			targetDevice := disk.Target.Device
+			infos.emit(c.blockInfo, domainUUID, sourceFile, targetDevice, disk.SourceType(), disk.Source.Protocol,
+				disk.Driver.Type, disk.Driver.Cache, disk.Driver.IO, disk.Driver.Discard)
+			c.updateIotune(ch, disk.Iotune, domainUUID, sourceFile, targetDevice)
+
+			if !lvDomain.Running {
+				devicesSkipped.inc(ctx, domainUUID, "block", skipReasonNotRunning)
+				wg.Done()
+				continue
+			}
 
 			go func(domain libvirt.Domain, domainUUID, sourceFile, targetDevice string) {
+				if !acquireWorker(ctx) {
+					wg.Done()
+					return
+				}
+				defer releaseWorker()
+				defer busyDomain(ctx, domainUUID)()
 				rRdReq, rRdBytes, rWrReq, rWrBytes, _, err := pLibvirt.DomainBlockStats(domain, targetDevice)
+				if IsNotRunningError(err) {
+					level.Debug(c.logger).Log("msg", "domain stopped during the scrape", "domain", domain.Name, "err", err)
+					devicesSkipped.inc(ctx, domainUUID, "block", skipReasonNotRunning)
+					wg.Done()
+					return
+				}
 				if err != nil {
-					level.Error(c.logger).Log("msg", "failed to get block stats", "domain", domain.Name, "err", err)
+					domainErrorLogger(ctx, c.logger, "block", domainUUID, err).Log("msg", "failed to get block stats", "domain", domain.Name, "err", err)
 					wg.Done()
 					return
 				}
@@ -141,15 +278,26 @@ func (c *blockCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOp
 				ch <- c.writeBytes.mustNewConstMetric(float64(rWrBytes), domainUUID, sourceFile, targetDevice)
 				ch <- c.writeRequests.mustNewConstMetric(float64(rWrReq), domainUUID, sourceFile, targetDevice)
 
+				if ctx.Err() != nil {
+					wg.Done()
+					return
+				}
+				// The target device identifies disks of all source types,
+				// the source path only file and block disks.
 				var blockInfoFlags uint32 = 0
-				rAllocation, rCapacity, rPhysical, err := pLibvirt.DomainGetBlockInfo(domain, sourceFile, blockInfoFlags)
+				rAllocation, rCapacity, rPhysical, err := pLibvirt.DomainGetBlockInfo(domain, targetDevice, blockInfoFlags)
 				if err == nil {
 					level.Debug(c.logger).Log("msg", "get block info", "domain", domain.Name, "rAllocation", rAllocation, "rCapacity", rCapacity, "rPhysical", rPhysical)
 					ch <- c.blockCapacity.mustNewConstMetric(float64(rCapacity), domainUUID, sourceFile, targetDevice)
 					ch <- c.blockAllocation.mustNewConstMetric(float64(rAllocation), domainUUID, sourceFile, targetDevice)
 					ch <- c.blockPhysical.mustNewConstMetric(float64(rPhysical), domainUUID, sourceFile, targetDevice)
+					disk := diskCapacities.observe(diskKey{domainUUID, targetDevice}, rCapacity)
+					ch <- c.capacityChanges.mustNewConstMetric(disk.changes, domainUUID, sourceFile, targetDevice)
+					if !disk.lastChange.IsZero() {
+						ch <- c.lastResize.mustNewConstMetric(float64(disk.lastChange.UnixNano())/1e9, domainUUID, sourceFile, targetDevice)
+					}
 				} else {
-					level.Error(c.logger).Log("msg", "failed to get block info", "domain", domain.Name, "err", err)
+					domainErrorLogger(ctx, c.logger, "block", domainUUID, err).Log("msg", "failed to get block info", "domain", domain.Name, "err", err)
 				}
 
 				// Task finished, decrease the wait group counter
@@ -162,3 +310,37 @@ func (c *blockCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOp
 
 	return nil
 }
+
+// blockSkipReason returns why a disk is not collected, or "" to collect it.
+func blockSkipReason(disk libvirt_schema.Disk) string {
+	switch {
+	case disk.Device == "cdrom":
+		return skipReasonCdrom
+	case disk.Device == "floppy":
+		return skipReasonFloppy
+	case blockDeviceExclude.matches(disk.Target.Device):
+		return skipReasonExcluded
+	}
+	return ""
+}
+
+// updateIotune exposes the <iotune> limits of a disk, so usage can be graphed
+// against the limit. They are taken from the domain XML and cost no request.
+func (c *blockCollector) updateIotune(ch chan<- prometheus.Metric, iotune libvirt_schema.DiskIotune, domainUUID, sourceFile, targetDevice string) {
+	for _, l := range []struct {
+		desc  *typedDesc
+		op    string
+		value uint64
+	}{
+		{&c.limitBytes, "total", iotune.TotalBytesSec},
+		{&c.limitBytes, "read", iotune.ReadBytesSec},
+		{&c.limitBytes, "write", iotune.WriteBytesSec},
+		{&c.limitIops, "total", iotune.TotalIopsSec},
+		{&c.limitIops, "read", iotune.ReadIopsSec},
+		{&c.limitIops, "write", iotune.WriteIopsSec},
+	} {
+		if l.value > 0 {
+			ch <- l.desc.mustNewConstMetric(float64(l.value), domainUUID, sourceFile, targetDevice, l.op)
+		}
+	}
+}
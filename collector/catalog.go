@@ -0,0 +1,148 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CatalogMetric describes a metric the exporter can emit.
+type CatalogMetric struct {
+	Name string `json:"name"`
+	Help string `json:"help"`
+	Type string `json:"type"`
+	// Labels are the variable labels of the metric in the order of the
+	// descriptor.
+	Labels []string `json:"labels"`
+	// Collector is the collector producing the metric, empty for the
+	// metrics about the scrape itself.
+	Collector string `json:"collector,omitempty"`
+	// Enabled reports whether the collector is enabled on this instance.
+	Enabled bool `json:"enabled"`
+}
+
+// descInfo is what a descriptor was created with. prometheus.Desc keeps it
+// private, so newMetricDesc records it for the catalog.
+type descInfo struct {
+	name   string
+	help   string
+	labels []string
+}
+
+var (
+	descInfosMtx sync.Mutex
+	descInfos    = make(map[*prometheus.Desc]descInfo)
+)
+
+// newMetricDesc is prometheus.NewDesc, it has to be used for all descriptors
+// of the collectors so they can be listed in the catalog.
+func newMetricDesc(fqName, help string, variableLabels []string, constLabels prometheus.Labels) *prometheus.Desc {
+	desc := prometheus.NewDesc(fqName, help, variableLabels, constLabels)
+	descInfosMtx.Lock()
+	defer descInfosMtx.Unlock()
+	descInfos[desc] = descInfo{name: fqName, help: help, labels: append([]string{}, variableLabels...)}
+	return desc
+}
+
+// newCounterVec is prometheus.NewCounterVec recording the descriptor of the
+// vector like newMetricDesc.
+func newCounterVec(opts prometheus.CounterOpts, labelNames []string) *prometheus.CounterVec {
+	vec := prometheus.NewCounterVec(opts, labelNames)
+	descs := make(chan *prometheus.Desc, 1)
+	vec.Describe(descs)
+	descInfosMtx.Lock()
+	defer descInfosMtx.Unlock()
+	descInfos[<-descs] = descInfo{
+		name:   prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+		help:   opts.Help,
+		labels: append([]string{}, labelNames...),
+	}
+	return vec
+}
+
+// Catalog lists every metric of all registered collectors, enabled or not,
+// sorted by name. It is generated from the descriptors of the collectors, so
+// it always matches the running exporter version.
+func Catalog(logger log.Logger) ([]CatalogMetric, error) {
+	var metrics []CatalogMetric
+	add := func(collector string, enabled bool, descs ...typedDesc) error {
+		for _, d := range descs {
+			m, err := newCatalogMetric(d)
+			if err != nil {
+				return err
+			}
+			m.Collector, m.Enabled = collector, enabled
+			metrics = append(metrics, m)
+		}
+		return nil
+	}
+
+	scrapeErrorsDescs := make(chan *prometheus.Desc, 1)
+	domainScrapeErrors.Describe(scrapeErrorsDescs)
+	xmlWarningsDescs := make(chan *prometheus.Desc, 1)
+	xmlParseWarnings.Describe(xmlWarningsDescs)
+	if err := add("", true,
+		typedDesc{scrapeDurationDesc, prometheus.GaugeValue},
+		typedDesc{scrapeSuccessDesc, prometheus.GaugeValue},
+		typedDesc{scrapeCacheAgeDesc, prometheus.GaugeValue},
+		typedDesc{scrapeWorkerWaitDesc, prometheus.GaugeValue},
+		typedDesc{scrapeErrorsDesc, prometheus.CounterValue},
+		typedDesc{scrapeLastSuccessDesc, prometheus.GaugeValue},
+		typedDesc{daemonRestartsDesc, prometheus.CounterValue},
+		typedDesc{daemonStartTimeDesc, prometheus.GaugeValue},
+		typedDesc{socketInfoDesc, prometheus.GaugeValue},
+		typedDesc{domainsExcludedDesc, prometheus.GaugeValue},
+		typedDesc{devicesSkippedDesc, prometheus.CounterValue},
+		lastErrorInfo.typedDesc,
+		typedDesc{lastErrorTimestampDesc, prometheus.GaugeValue},
+		typedDesc{backoffRemainingDesc, prometheus.GaugeValue},
+		typedDesc{backoffsDesc, prometheus.CounterValue},
+		typedDesc{<-scrapeErrorsDescs, prometheus.CounterValue},
+		typedDesc{<-xmlWarningsDescs, prometheus.CounterValue},
+	); err != nil {
+		return nil, err
+	}
+
+	initiatedCollectorsMtx.Lock()
+	defer initiatedCollectorsMtx.Unlock()
+	for name, enabled := range collectorState {
+		c, ok := initiatedCollectors[name]
+		if !ok {
+			var err error
+			c, err = factories[name](log.With(logger, "collector", name))
+			if err != nil {
+				return nil, err
+			}
+			initiatedCollectors[name] = c
+		}
+		if err := add(name, *enabled, c.descs()...); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
+	return metrics, nil
+}
+
+func newCatalogMetric(d typedDesc) (CatalogMetric, error) {
+	descInfosMtx.Lock()
+	info, ok := descInfos[d.desc]
+	descInfosMtx.Unlock()
+	if !ok {
+		return CatalogMetric{}, fmt.Errorf("descriptor not created with newMetricDesc: %s", d.desc)
+	}
+
+	var typ string
+	switch d.valueType {
+	case prometheus.CounterValue:
+		typ = "counter"
+	case prometheus.GaugeValue:
+		typ = "gauge"
+	default:
+		typ = "untyped"
+	}
+	return CatalogMetric{Name: info.name, Help: info.help, Type: typ, Labels: info.labels}, nil
+}
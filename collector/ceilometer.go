@@ -0,0 +1,195 @@
+package collector
+
+import (
+	"context"
+	"sync"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/nee541/libvirt-exporter/libvirt_schema"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ceilometerCollector exposes the meters of the ceilometer compute agent with
+// the names and labels its Prometheus publisher uses, so the exporter can
+// replace the agent. Meter names have their dots replaced by underscores,
+// units follow ceilometer, e.g. cpu is in nanoseconds and memory in MB.
+type ceilometerCollector struct {
+	cpu                   typedDesc
+	vcpus                 typedDesc
+	memoryUsage           typedDesc
+	memoryResident        typedDesc
+	diskReadBytes         typedDesc
+	diskWriteBytes        typedDesc
+	diskReadRequests      typedDesc
+	diskWriteRequests     typedDesc
+	networkIncomingBytes  typedDesc
+	networkOutgoingBytes  typedDesc
+	networkIncomingPacket typedDesc
+	networkOutgoingPacket typedDesc
+	logger                log.Logger
+}
+
+func init() {
+	registerCollector("ceilometer", defaultDisabled, NewCeilometerCollector)
+}
+
+// NewCeilometerCollector returns a new Collector exposing ceilometer
+// compatible meters.
+func NewCeilometerCollector(logger log.Logger) (Collector, error) {
+	newDesc := func(name, help string, valueType prometheus.ValueType) typedDesc {
+		return typedDesc{
+			desc: newMetricDesc(
+				name,
+				help,
+				[]string{"resource_id", "project_id", "user_id"},
+				nil),
+			valueType: valueType,
+		}
+	}
+	return &ceilometerCollector{
+		cpu:                   newDesc("cpu", "CPU time used in ns", prometheus.CounterValue),
+		vcpus:                 newDesc("vcpus", "Number of virtual CPUs allocated to the instance", prometheus.GaugeValue),
+		memoryUsage:           newDesc("memory_usage", "Volume of RAM used by the instance from the amount of its allocated memory in MB", prometheus.GaugeValue),
+		memoryResident:        newDesc("memory_resident", "Volume of RAM used by the instance on the physical machine in MB", prometheus.GaugeValue),
+		diskReadBytes:         newDesc("disk_device_read_bytes", "Volume of reads in B", prometheus.CounterValue),
+		diskWriteBytes:        newDesc("disk_device_write_bytes", "Volume of writes in B", prometheus.CounterValue),
+		diskReadRequests:      newDesc("disk_device_read_requests", "Number of read requests", prometheus.CounterValue),
+		diskWriteRequests:     newDesc("disk_device_write_requests", "Number of write requests", prometheus.CounterValue),
+		networkIncomingBytes:  newDesc("network_incoming_bytes", "Number of incoming bytes", prometheus.CounterValue),
+		networkOutgoingBytes:  newDesc("network_outgoing_bytes", "Number of outgoing bytes", prometheus.CounterValue),
+		networkIncomingPacket: newDesc("network_incoming_packets", "Number of incoming packets", prometheus.CounterValue),
+		networkOutgoingPacket: newDesc("network_outgoing_packets", "Number of outgoing packets", prometheus.CounterValue),
+		logger:                logger,
+	}, nil
+}
+
+func (c *ceilometerCollector) descs() []typedDesc {
+	return []typedDesc{
+		c.cpu,
+		c.vcpus,
+		c.memoryUsage,
+		c.memoryResident,
+		c.diskReadBytes,
+		c.diskWriteBytes,
+		c.diskReadRequests,
+		c.diskWriteRequests,
+		c.networkIncomingBytes,
+		c.networkOutgoingBytes,
+		c.networkIncomingPacket,
+		c.networkOutgoingPacket,
+	}
+}
+
+func (c *ceilometerCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	if config.pLibvirt == nil {
+		level.Error(c.logger).Log("msg", "libvirt not created")
+		return ErrNotProvided
+	}
+	if !config.pLibvirt.IsConnected() {
+		level.Error(c.logger).Log("msg", "libvirt not connected")
+		return ErrNotProvided
+	}
+	if config.lvDomains == nil {
+		level.Debug(c.logger).Log("msg", "no domains found")
+		return ErrNotProvided
+	}
+	ctx := config.context()
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(config.lvDomains))
+	for _, lvDomain := range config.lvDomains {
+		go func(lvDomain libvirt_schema.LvDomain) {
+			defer wg.Done()
+			if !acquireWorker(ctx) {
+				return
+			}
+			defer releaseWorker()
+			defer busyDomain(ctx, lvDomain.Schema.UUID)()
+			c.updateDomain(ctx, ch, config.pLibvirt, lvDomain)
+		}(lvDomain)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+func (c *ceilometerCollector) updateDomain(ctx context.Context, ch chan<- prometheus.Metric, pLibvirt *libvirt.Libvirt, lvDomain libvirt_schema.LvDomain) {
+	domain, schema := lvDomain.Domain, lvDomain.Schema
+	owner := schema.Metadata.NovaInstance.Owner
+	projectID, userID := owner.Project.ProjectId, owner.User.UserId
+
+	_, _, _, nrVirtCPU, cpuTime, err := pLibvirt.DomainGetInfo(domain)
+	if err != nil {
+		domainErrorLogger(ctx, c.logger, "ceilometer", schema.UUID, err).Log("msg", "failed to get domain info", "domain", domain.Name, "err", err)
+		return
+	}
+	ch <- c.cpu.mustNewConstMetric(float64(cpuTime), schema.UUID, projectID, userID)
+	ch <- c.vcpus.mustNewConstMetric(float64(nrVirtCPU), schema.UUID, projectID, userID)
+
+	stats, err := pLibvirt.DomainMemoryStats(domain, uint32(libvirt.DomainMemoryStatNr), 0)
+	if err != nil {
+		domainErrorLogger(ctx, c.logger, "ceilometer", schema.UUID, err).Log("msg", "failed to get memory stats", "domain", domain.Name, "err", err)
+	} else {
+		// Like ceilometer, usage is derived from the stats of the balloon
+		// driver, which are in KiB.
+		var available, unused, rss uint64
+		var hasAvailable, hasUnused, hasRss bool
+		for _, stat := range stats {
+			switch libvirt.DomainMemoryStatTags(stat.Tag) {
+			case libvirt.DomainMemoryStatAvailable:
+				available, hasAvailable = stat.Val, true
+			case libvirt.DomainMemoryStatUnused:
+				unused, hasUnused = stat.Val, true
+			case libvirt.DomainMemoryStatRss:
+				rss, hasRss = stat.Val, true
+			}
+		}
+		if hasAvailable && hasUnused && available >= unused {
+			ch <- c.memoryUsage.mustNewConstMetric(float64(available-unused)/1024, schema.UUID, projectID, userID)
+		}
+		if hasRss {
+			ch <- c.memoryResident.mustNewConstMetric(float64(rss)/1024, schema.UUID, projectID, userID)
+		}
+	}
+
+	for _, disk := range schema.Devices.Disks {
+		if disk.Device == "cdrom" || disk.Target.Device == "" {
+			continue
+		}
+		rdReq, rdBytes, wrReq, wrBytes, _, err := pLibvirt.DomainBlockStats(domain, disk.Target.Device)
+		if err != nil {
+			domainErrorLogger(ctx, c.logger, "ceilometer", schema.UUID, err).Log("msg", "failed to get block stats", "domain", domain.Name, "device", disk.Target.Device, "err", err)
+			continue
+		}
+		resourceID := schema.UUID + "-" + disk.Target.Device
+		ch <- c.diskReadBytes.mustNewConstMetric(float64(rdBytes), resourceID, projectID, userID)
+		ch <- c.diskWriteBytes.mustNewConstMetric(float64(wrBytes), resourceID, projectID, userID)
+		ch <- c.diskReadRequests.mustNewConstMetric(float64(rdReq), resourceID, projectID, userID)
+		ch <- c.diskWriteRequests.mustNewConstMetric(float64(wrReq), resourceID, projectID, userID)
+	}
+
+	for _, iface := range schema.Devices.Interfaces {
+		if iface.Target.Device == "" {
+			continue
+		}
+		rxBytes, rxPackets, _, _, txBytes, txPackets, _, _, err := pLibvirt.DomainInterfaceStats(domain, iface.Target.Device)
+		if err != nil {
+			domainErrorLogger(ctx, c.logger, "ceilometer", schema.UUID, err).Log("msg", "failed to get interface stats", "domain", domain.Name, "interface", iface.Target.Device, "err", err)
+			continue
+		}
+		// ceilometer identifies vNICs by instance name, instance UUID and
+		// tap device.
+		resourceID := schema.Name + "-" + schema.UUID + "-" + iface.Target.Device
+		ch <- c.networkIncomingBytes.mustNewConstMetric(float64(rxBytes), resourceID, projectID, userID)
+		ch <- c.networkOutgoingBytes.mustNewConstMetric(float64(txBytes), resourceID, projectID, userID)
+		ch <- c.networkIncomingPacket.mustNewConstMetric(float64(rxPackets), resourceID, projectID, userID)
+		ch <- c.networkOutgoingPacket.mustNewConstMetric(float64(txPackets), resourceID, projectID, userID)
+	}
+}
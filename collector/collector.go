@@ -1,14 +1,18 @@
 package collector
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	libvirt "github.com/digitalocean/go-libvirt"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/nee541/libvirt-exporter/connection"
 	"github.com/nee541/libvirt-exporter/libvirt_schema"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -17,18 +21,57 @@ import (
 const namespace = "libvirt"
 
 var (
-	scrapeDurationDesc = prometheus.NewDesc(
+	scrapeDurationDesc = newMetricDesc(
 		prometheus.BuildFQName(namespace, "scrape", "collector_duration_seconds"),
 		"node_exporter: Duration of a collector scrape.",
 		[]string{"collector"},
 		nil,
 	)
-	scrapeSuccessDesc = prometheus.NewDesc(
+	scrapeSuccessDesc = newMetricDesc(
 		prometheus.BuildFQName(namespace, "scrape", "collector_success"),
 		"node_exporter: Whether a collector succeeded.",
 		[]string{"collector"},
 		nil,
 	)
+	daemonRestartsDesc = newMetricDesc(
+		prometheus.BuildFQName(namespace, "daemon", "restarts_total"),
+		"Number of libvirt daemon restarts detected by the exporter.",
+		nil,
+		nil,
+	)
+	scrapeCacheAgeDesc = newMetricDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_cache_age_seconds"),
+		"Age of the cached metrics served for a collector with a cache TTL.",
+		[]string{"collector"},
+		nil,
+	)
+	domainScrapeErrors = newCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "domain",
+			Name:      "scrape_errors_total",
+			Help:      "Number of times a domain was skipped because its XML description could not be retrieved or parsed.",
+		},
+		[]string{"domain"},
+	)
+	scrapeWorkerWaitDesc = newMetricDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_worker_wait_seconds"),
+		"Cumulative time the workers of a collector waited for a free slot of --collector.max-concurrency, only exposed if it is set.",
+		[]string{"collector"},
+		nil,
+	)
+	socketInfoDesc = newMetricDesc(
+		prometheus.BuildFQName(namespace, "connection", "socket_info"),
+		"Local unix socket the libvirt daemon is reached through.",
+		[]string{"path"},
+		nil,
+	)
+	daemonStartTimeDesc = newMetricDesc(
+		prometheus.BuildFQName(namespace, "daemon", "start_time_seconds"),
+		"Start time of the libvirt daemon since unix epoch in seconds, only available for local connections.",
+		nil,
+		nil,
+	)
 )
 
 const (
@@ -41,6 +84,8 @@ var (
 	initiatedCollectorsMtx = sync.Mutex{}
 	initiatedCollectors    = make(map[string]Collector)
 	collectorState         = make(map[string]*bool)
+	collectorTimeouts      = make(map[string]*reloadableValue[time.Duration])
+	collectorCacheTTLs     = make(map[string]*reloadableValue[time.Duration])
 	forcedCollectors       = map[string]bool{} // collectors which have been explicitly enabled or disabled
 )
 
@@ -59,16 +104,50 @@ func registerCollector(collector string, isDefaultEnabled bool, factory func(log
 	flag := kingpin.Flag(flagName, flagHelp).Default(defaultValue).Action(collectorFlagAction(collector)).Bool()
 	collectorState[collector] = flag
 
+	timeoutFlagName := fmt.Sprintf("collector.%s.timeout", collector)
+	timeoutFlagHelp := fmt.Sprintf("Timeout for the %s collector, 0 means only the scrape timeout applies.", collector)
+	collectorTimeouts[collector] = reloadableDuration(kingpin.Flag(timeoutFlagName, timeoutFlagHelp).Default("0s"))
+
+	cacheFlagName := fmt.Sprintf("collector.%s.cache-ttl", collector)
+	cacheFlagHelp := fmt.Sprintf("Serve cached metrics of the %s collector for this long, 0 disables caching.", collector)
+	collectorCacheTTLs[collector] = reloadableDuration(kingpin.Flag(cacheFlagName, cacheFlagHelp).Default("0s"))
+
 	factories[collector] = factory
 }
 
 // LibvirtCollector implements the prometheus.Collector interface.
 type LibvirtCollector struct {
 	Collectors map[string]Collector
-	pLibvirt   *libvirt.Libvirt
+	conn       *connection.Connection
+	ctx        context.Context
+	dryRun     bool
 	logger     log.Logger
 }
 
+// WithContext returns a copy of the collector which gives up collecting once
+// ctx is done, e.g. when the scrape timeout of Prometheus is reached.
+func (n LibvirtCollector) WithContext(ctx context.Context) *LibvirtCollector {
+	n.ctx = ctx
+	return &n
+}
+
+// DryRun returns a copy of the collector whose scrapes leave the state of the
+// exporter untouched: the state file is not written, the scrape statistics,
+// domain backoff, last errors and skipped devices are not updated and the
+// collector cache is not filled.
+func (n LibvirtCollector) DryRun() *LibvirtCollector {
+	n.dryRun = true
+	return &n
+}
+
+type dryRunKey struct{}
+
+// isDryRun reports whether ctx belongs to a scrape of a DryRun collector.
+func isDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+	return dryRun
+}
+
 // DisableDefaultCollectors sets the collector state to false for all collectors which
 // have not been explicitly enabled on the command line.
 func DisableDefaultCollectors() {
@@ -79,6 +158,50 @@ func DisableDefaultCollectors() {
 	}
 }
 
+// SetCollectorState enables or disables a collector, unless it has been
+// explicitly enabled or disabled on the command line.
+func SetCollectorState(name string, enabled bool) error {
+	state, ok := collectorState[name]
+	if !ok {
+		return fmt.Errorf("missing collector: %s", name)
+	}
+	if !forcedCollectors[name] {
+		*state = enabled
+	}
+	return nil
+}
+
+// EnabledCollectors returns the sorted names of all collectors enabled on the
+// command line.
+func EnabledCollectors() []string {
+	configMtx.RLock()
+	defer configMtx.RUnlock()
+	names := []string{}
+	for name, enabled := range collectorState {
+		if *enabled {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Collectors returns the names of all collectors, enabled or not.
+func Collectors() []string {
+	names := make([]string, 0, len(collectorState))
+	for name := range collectorState {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsKnownCollector reports whether a collector with the given name exists.
+func IsKnownCollector(name string) bool {
+	_, ok := collectorState[name]
+	return ok
+}
+
 // collectorFlagAction generates a new action function for the given collector
 // to track whether it has been explicitly enabled or disabled from the command line.
 // A new action function is needed for each collector flag because the ParseContext
@@ -92,7 +215,9 @@ func collectorFlagAction(collector string) func(ctx *kingpin.ParseContext) error
 }
 
 // NewLibvirtCollector creates a new LibvirtCollector.
-func NewLibvirtCollector(pLibvirt *libvirt.Libvirt, logger log.Logger, filters ...string) (*LibvirtCollector, error) {
+func NewLibvirtCollector(conn *connection.Connection, logger log.Logger, filters ...string) (*LibvirtCollector, error) {
+	configMtx.RLock()
+	defer configMtx.RUnlock()
 	f := make(map[string]bool)
 	for _, filter := range filters {
 		enabled, exist := collectorState[filter]
@@ -122,30 +247,61 @@ func NewLibvirtCollector(pLibvirt *libvirt.Libvirt, logger log.Logger, filters .
 			initiatedCollectors[key] = collector
 		}
 	}
-	return &LibvirtCollector{Collectors: collectors, pLibvirt: pLibvirt, logger: logger}, nil
+	return &LibvirtCollector{Collectors: collectors, conn: conn, logger: logger}, nil
 }
 
 // Describe implements the prometheus.Collector interface.
 func (n LibvirtCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- scrapeDurationDesc
 	ch <- scrapeSuccessDesc
+	ch <- scrapeCacheAgeDesc
+	ch <- scrapeWorkerWaitDesc
+	ch <- scrapeErrorsDesc
+	ch <- scrapeLastSuccessDesc
+	ch <- daemonRestartsDesc
+	ch <- daemonStartTimeDesc
+	ch <- socketInfoDesc
+	ch <- domainsExcludedDesc
+	ch <- devicesSkippedDesc
+	ch <- lastErrorInfo.desc
+	ch <- lastErrorTimestampDesc
+	ch <- backoffRemainingDesc
+	ch <- backoffsDesc
+	domainScrapeErrors.Describe(ch)
+	xmlParseWarnings.Describe(ch)
 }
 
 // Collect implements the prometheus.Collector interface.
 func (n LibvirtCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := n.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if n.dryRun {
+		ctx = context.WithValue(ctx, dryRunKey{}, true)
+	}
+
 	// manage libvirt connection
-	if n.pLibvirt == nil {
+	if n.conn == nil || n.conn.Libvirt == nil {
 		level.Error(n.logger).Log("msg", "libvirt not created")
 		return
 	}
-	if !n.pLibvirt.IsConnected() {
-		level.Warn(n.logger).Log("msg", "libvirt is not connected, try to reconnect ...")
-		if n.pLibvirt.Connect() != nil {
-			level.Error(n.logger).Log("msg", "libvirt could not connect, skip this scrape")
-			return
-		}
+	pLibvirt := n.conn.Libvirt
+	if !pLibvirt.IsConnected() {
+		// The connection is re-established in the background by connection.Supervise.
+		level.Error(n.logger).Log("msg", "libvirt is not connected, skip this scrape", "uri", n.conn.URI)
+		return
 	}
 	level.Info(n.logger).Log("msg", "libvirt connected, start to scrape ...")
+	defer domainScrapeErrors.Collect(ch)
+	defer xmlParseWarnings.Collect(ch)
+	ch <- prometheus.MustNewConstMetric(daemonRestartsDesc, prometheus.CounterValue, float64(n.conn.DaemonRestarts()))
+	if startTime, ok := n.conn.DaemonStartTime(); ok {
+		ch <- prometheus.MustNewConstMetric(daemonStartTimeDesc, prometheus.GaugeValue, startTime)
+	}
+	if path := n.conn.SocketPath(); path != "" {
+		ch <- prometheus.MustNewConstMetric(socketInfoDesc, prometheus.GaugeValue, 1, path)
+	}
 
 	/*
 		type ConnectListAllDomainsFlags int32
@@ -172,84 +328,368 @@ func (n LibvirtCollector) Collect(ch chan<- prometheus.Metric) {
 		ConnectListAllDomainsFlags enumeration from libvirt/libvirt-domain.h:1892
 	*/
 	flags := libvirt.ConnectListDomainsActive
-	domains, num, err := n.pLibvirt.ConnectListAllDomains(1, flags)
+	domains, num, err := pLibvirt.ConnectListAllDomains(1, flags)
 	if err != nil {
 		level.Error(n.logger).Log("msg", "failed to list domains", "err", err)
 		return
 	}
 	level.Debug(n.logger).Log("msg", "list domains", "num", num)
-	lvDomains := make([]libvirt_schema.LvDomain, num)
-	for i, domain := range domains {
-		xmlDesc, err := n.pLibvirt.DomainGetXMLDesc(domain, 0)
-		if err != nil {
-			level.Error(n.logger).Log("msg", "failed to get domain xml", "err", err)
+	// The stats of domains which are not running are stale or unavailable,
+	// collectors skip them instead of failing on every scrape.
+	running, _, err := pLibvirt.ConnectListAllDomains(1, libvirt.ConnectListDomainsRunning)
+	if err != nil {
+		level.Warn(n.logger).Log("msg", "failed to list running domains, assuming all are running", "err", err)
+		running = domains
+	}
+	isRunning := make(map[libvirt.UUID]bool, len(running))
+	for _, domain := range running {
+		isRunning[domain.UUID] = true
+	}
+	active := domains
+	// The domain filters are swapped by Reconfigure on a config reload.
+	configMtx.RLock()
+	selected := selectDomains(domains)
+	configMtx.RUnlock()
+	ch <- prometheus.MustNewConstMetric(domainsExcludedDesc, prometheus.GaugeValue, float64(len(domains)-len(selected)))
+	domains = selected
+	lvDomains := make([]libvirt_schema.LvDomain, 0, len(domains))
+	listed := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		if ctx.Err() != nil {
+			level.Warn(n.logger).Log("msg", "scrape deadline exceeded while preparing domains", "err", ctx.Err())
 			return
 		}
-		schema, err := libvirt_schema.NewDomainFromXML([]byte(xmlDesc))
-		if err != nil {
-			level.Error(n.logger).Log("msg", "failed to parse domain xml", "err", err)
-			return
+		domainUUID := uuidString(domain.UUID)
+		listed[domainUUID] = true
+		schema, ok := domainSchemas.get(domainUUID)
+		if !ok {
+			// A single broken domain must not cost the metrics of all
+			// others, so it is skipped and accounted for in
+			// domainScrapeErrors.
+			xmlDesc, err := pLibvirt.DomainGetXMLDesc(domain, 0)
+			if err != nil {
+				level.Error(n.logger).Log("msg", "failed to get domain xml", "domain", domain.Name, "err", err)
+				n.recordXMLError(domain.Name, domainUUID, err)
+				continue
+			}
+			schema, err = libvirt_schema.NewDomainFromXML([]byte(xmlDesc))
+			if err != nil {
+				level.Error(n.logger).Log("msg", "failed to parse domain xml", "domain", domain.Name, "err", err)
+				n.recordXMLError(domain.Name, domainUUID, err)
+				continue
+			}
+			// A dry run must not count parse warnings, the description
+			// is validated when a scrape fetches it.
+			if !n.dryRun {
+				validateDomain(schema, n.Collectors, n.logger)
+				domainSchemas.set(domainUUID, schema)
+			}
 		}
 
-		lvDomains[i] = libvirt_schema.LvDomain{
-			Domain: domain,
-			Schema: schema,
-		}
+		lvDomains = append(lvDomains, libvirt_schema.LvDomain{
+			Domain:  domain,
+			Schema:  schema,
+			Running: isRunning[domain.UUID],
+		})
+	}
+	// Collectors may look up the definitions of the active domains
+	// dropped by the filters, see allocation.
+	activeListed := make(map[string]bool, len(active))
+	for _, domain := range active {
+		activeListed[uuidString(domain.UUID)] = true
+	}
+	domainSchemas.retain(activeListed)
+	devicesSkipped.retain(listed)
+	domainErrors.retain(listed)
+	// The block collector only sees the domains it is enabled for and
+	// which are not backed off, the capacities are kept for all others.
+	diskCapacities.retain(listed)
+	if enrichment != nil {
+		enrichment.retain(listed)
+	}
+	if !n.dryRun {
+		persistState(active)
 	}
 
+	// Collectors write into an intermediate channel so the scrape can be
+	// finished on time even if some collectors are still waiting for libvirt.
+	// Their late metrics are discarded instead of being sent to ch after
+	// Collect returned.
+	inner := make(chan prometheus.Metric)
+	results := make(chan collectorResult, len(n.Collectors))
 	wg := sync.WaitGroup{}
 	wg.Add(len(n.Collectors))
 	for name, c := range n.Collectors {
 		go func(name string, c Collector) {
-			execute(name, c, ch, n.pLibvirt, lvDomains, n.logger)
+			results <- execute(ctx, name, c, inner, pLibvirt, n.conn.Local, lvDomains, active, n.logger)
 			wg.Done()
 		}(name, c)
 	}
-	wg.Wait()
+	go func() {
+		wg.Wait()
+		close(inner)
+	}()
+
+	begin := time.Now()
+	finished := make(map[string]bool, len(n.Collectors))
+	if err := forwardMetrics(ctx, rewriteUUIDLabels(inner), ch); err != nil {
+		level.Warn(n.logger).Log("msg", "scrape deadline exceeded, dropping unfinished collectors", "err", err)
+	}
+	for len(results) > 0 {
+		result := <-results
+		finished[result.name] = true
+		ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, result.duration.Seconds(), result.name)
+		ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, result.success, result.name)
+		if !n.dryRun {
+			collectorStats.observe(result.name, !result.failed, result.cached)
+		}
+		if result.cached {
+			ch <- prometheus.MustNewConstMetric(scrapeCacheAgeDesc, prometheus.GaugeValue, result.cacheAge.Seconds(), result.name)
+		} else if *maxConcurrency > 0 {
+			// Without a limit workers never wait for a slot, the time
+			// spent in the RPC queue of libvirtd is not visible to the
+			// exporter.
+			ch <- prometheus.MustNewConstMetric(scrapeWorkerWaitDesc, prometheus.GaugeValue, result.workerWait.Seconds(), result.name)
+		}
+	}
+	for name := range n.Collectors {
+		if !finished[name] {
+			ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, time.Since(begin).Seconds(), name)
+			ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, 0, name)
+			if !n.dryRun {
+				collectorStats.observe(name, false, false)
+			}
+		}
+	}
+	names := make([]string, 0, len(n.Collectors))
+	for name := range n.Collectors {
+		names = append(names, name)
+	}
+	for _, m := range collectorStats.metrics(names) {
+		ch <- m
+	}
+	if !n.dryRun {
+		domainBackoff.endScrape(listed)
+	}
+	tracked := make(chan prometheus.Metric)
+	go func() {
+		for _, m := range devicesSkipped.metrics() {
+			tracked <- m
+		}
+		for _, m := range domainErrors.metrics() {
+			tracked <- m
+		}
+		for _, m := range domainBackoff.metrics() {
+			tracked <- m
+		}
+		close(tracked)
+	}()
+	for m := range rewriteUUIDLabels(tracked) {
+		ch <- m
+	}
 	level.Info(n.logger).Log("msg", "scrape finished")
 }
 
-func execute(name string, c Collector, ch chan<- prometheus.Metric, pLibvirt *libvirt.Libvirt, lvDomains []libvirt_schema.LvDomain, logger log.Logger) {
+// recordXMLError accounts for a domain skipped because its XML description
+// could not be retrieved or parsed.
+func (n LibvirtCollector) recordXMLError(domainName, domainUUID string, err error) {
+	if n.dryRun {
+		return
+	}
+	domainScrapeErrors.WithLabelValues(domainName).Inc()
+	domainErrors.record(domainUUID, "xml", err)
+}
+
+type collectorResult struct {
+	name     string
+	duration time.Duration
+	success  float64
+	cached   bool
+	cacheAge time.Duration
+	// workerWait is the time the workers of the collector waited for a
+	// slot of the worker pool shared by all collectors.
+	workerWait time.Duration
+	// failed is set for errors other than no data, not supported and not
+	// provided, which report success 0 as well but are expected on some
+	// hosts, e.g. one without domains.
+	failed bool
+}
+
+// cacheEntry holds the metrics of the last successful run of a collector.
+type cacheEntry struct {
+	at      time.Time
+	metrics []prometheus.Metric
+}
+
+var (
+	collectorCacheMtx = sync.Mutex{}
+	collectorCache    = make(map[string]cacheEntry)
+)
+
+func execute(ctx context.Context, name string, c Collector, ch chan<- prometheus.Metric, pLibvirt *libvirt.Libvirt, local bool, lvDomains []libvirt_schema.LvDomain, active []libvirt.Domain, logger log.Logger) collectorResult {
+	if timeout := collectorTimeouts[name].Get(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 	begin := time.Now()
+	var workerWait atomic.Int64
+	ctx = withWorkerWait(ctx, &workerWait)
+	var busy *busyDomains
+	if backoffCollectors[name] && !isDryRun(ctx) {
+		busy = &busyDomains{calls: make(map[string]int)}
+		ctx = withBusyDomains(ctx, busy)
+	}
+
+	cacheTTL := collectorCacheTTLs[name].Get()
+	if cacheTTL > 0 {
+		collectorCacheMtx.Lock()
+		entry, ok := collectorCache[name]
+		collectorCacheMtx.Unlock()
+		if ok && time.Since(entry.at) < cacheTTL {
+			for _, m := range entry.metrics {
+				ch <- m
+			}
+			level.Debug(logger).Log("msg", "collector served from cache", "name", name, "age_seconds", time.Since(entry.at).Seconds())
+			return collectorResult{name: name, duration: time.Since(begin), success: 1, cached: true, cacheAge: time.Since(entry.at)}
+		}
+	}
 
 	// prepare data for collector and Update data
-	// TODO: select data for collector
-	err := c.Update(ch, WithLibvirt(pLibvirt), WithDomains(lvDomains))
+	out := make(chan prometheus.Metric)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.Update(out, WithContext(ctx), WithLibvirt(pLibvirt), WithLocalConnection(local), WithDomains(domainsForCollector(name, lvDomains)), WithActiveDomains(active))
+		close(out)
+	}()
+	src := (<-chan prometheus.Metric)(out)
+	var recorded []prometheus.Metric
+	if cacheTTL > 0 {
+		rec := make(chan prometheus.Metric)
+		go func() {
+			for m := range out {
+				recorded = append(recorded, m)
+				rec <- m
+			}
+			close(rec)
+		}()
+		src = rec
+	}
+	err := forwardMetrics(ctx, src, ch)
+	if err == nil {
+		err = <-errCh
+	} else if busy != nil {
+		busy.timedOut(name, err)
+	}
+	if cacheTTL > 0 && err == nil && !isDryRun(ctx) {
+		collectorCacheMtx.Lock()
+		collectorCache[name] = cacheEntry{at: time.Now(), metrics: recorded}
+		collectorCacheMtx.Unlock()
+	}
 
 	duration := time.Since(begin)
 	var success float64
+	var failed bool
 
 	if err != nil {
 		if IsNoDataError(err) {
 			level.Debug(logger).Log("msg", "collector returned no data", "name", name, "duration_seconds", duration.Seconds(), "err", err)
+		} else if IsUnsupportedError(err) {
+			level.Debug(logger).Log("msg", "collector not supported by the libvirt driver", "name", name, "duration_seconds", duration.Seconds(), "err", err)
 		} else if IsNotProvidedError(err) {
 			level.Debug(logger).Log("msg", "collector not provided with necessary data", "name", name, "duration_seconds", duration.Seconds(), "err", err)
 		} else {
 			level.Error(logger).Log("msg", "collector failed", "name", name, "duration_seconds", duration.Seconds(), "err", err)
+			failed = true
 		}
 		success = 0
 	} else {
 		level.Debug(logger).Log("msg", "collector succeeded", "name", name, "duration_seconds", duration.Seconds())
 		success = 1
 	}
-	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), name)
-	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, success, name)
+	return collectorResult{name: name, duration: duration, success: success, workerWait: time.Duration(workerWait.Load()), failed: failed}
+}
+
+// forwardMetrics copies metrics from in to out until in is closed or ctx is
+// done. In the latter case the remaining metrics of in are discarded in the
+// background, so senders blocked on in are released, and ctx.Err() is returned.
+func forwardMetrics(ctx context.Context, in <-chan prometheus.Metric, out chan<- prometheus.Metric) error {
+	for {
+		select {
+		case m, ok := <-in:
+			if !ok {
+				return nil
+			}
+			out <- m
+		case <-ctx.Done():
+			go func() {
+				for range in {
+				}
+			}()
+			return ctx.Err()
+		}
+	}
+}
+
+// domainsForCollector drops the domains which opted out of the named collector
+// through their libvirt metadata, and those in backoff from backoffCollectors.
+func domainsForCollector(name string, lvDomains []libvirt_schema.LvDomain) []libvirt_schema.LvDomain {
+	selected := make([]libvirt_schema.LvDomain, 0, len(lvDomains))
+	for _, lvDomain := range lvDomains {
+		if backoffCollectors[name] && domainBackoff.skipping(lvDomain.Schema.UUID) {
+			continue
+		}
+		if lvDomain.Schema.CollectorEnabled(name) {
+			selected = append(selected, lvDomain)
+		}
+	}
+	return selected
 }
 
 // Collector is the interface a collector has to implement.
 type Collector interface {
 	// Get new metrics and expose them via prometheus registry.
 	Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error
+	// descs lists the metrics the collector can emit, see Catalog.
+	descs() []typedDesc
 }
 
 // Function Options/Functional Arguments
 type CollectorConfig struct {
+	ctx       context.Context
 	pLibvirt  *libvirt.Libvirt
 	lvDomains []libvirt_schema.LvDomain
+	// activeDomains are all active domains of the host, including those
+	// dropped by the domain filters or opted out of the collector.
+	activeDomains []libvirt.Domain
+	// local is set if libvirt runs on the same host as the exporter, which
+	// allows collectors to additionally read host files like sysfs.
+	local bool
+}
+
+// context returns the context of the current scrape. Collectors should not
+// start new libvirt calls once it is done.
+func (c *CollectorConfig) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
 }
 
 type CollectorOption func(*CollectorConfig)
 
+func WithContext(ctx context.Context) CollectorOption {
+	return func(c *CollectorConfig) {
+		c.ctx = ctx
+	}
+}
+
+func WithLocalConnection(local bool) CollectorOption {
+	return func(c *CollectorConfig) {
+		c.local = local
+	}
+}
+
 func WithLibvirt(lv *libvirt.Libvirt) CollectorOption {
 	return func(c *CollectorConfig) {
 		c.pLibvirt = lv
@@ -262,6 +702,12 @@ func WithDomains(lvDomains []libvirt_schema.LvDomain) CollectorOption {
 	}
 }
 
+func WithActiveDomains(domains []libvirt.Domain) CollectorOption {
+	return func(c *CollectorConfig) {
+		c.activeDomains = domains
+	}
+}
+
 type typedDesc struct {
 	desc      *prometheus.Desc
 	valueType prometheus.ValueType
@@ -0,0 +1,133 @@
+package collector
+
+import (
+	"sync"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// controlStates names the states of the control interface (QEMU monitor) of
+// a domain, see
+// https://libvirt.org/html/libvirt-libvirt-domain.html#virDomainControlState
+var controlStates = []struct {
+	state libvirt.DomainControlState
+	name  string
+}{
+	{libvirt.DomainControlOk, "ok"},
+	{libvirt.DomainControlJob, "job"},
+	{libvirt.DomainControlOccupied, "occupied"},
+	{libvirt.DomainControlError, "error"},
+}
+
+// controlErrorReasons names the reasons of the error state, see
+// https://libvirt.org/html/libvirt-libvirt-domain.html#virDomainControlErrorReason
+var controlErrorReasons = map[libvirt.DomainControlErrorReason]string{
+	libvirt.DomainControlErrorReasonNone:     "none",
+	libvirt.DomainControlErrorReasonUnknown:  "unknown",
+	libvirt.DomainControlErrorReasonMonitor:  "monitor",
+	libvirt.DomainControlErrorReasonInternal: "internal",
+}
+
+// controlCollector exposes the state of the control interface of domains. A
+// monitor stuck in occupied explains gaps in the stats of a single domain.
+type controlCollector struct {
+	state         typedDesc
+	stateDuration typedDesc
+	errorInfo     infoDesc
+	logger        log.Logger
+}
+
+func init() {
+	registerCollector("control", defaultDisabled, NewControlCollector)
+}
+
+// NewControlCollector returns a new Collector exposing the control state of
+// domains.
+func NewControlCollector(logger log.Logger) (Collector, error) {
+	return &controlCollector{
+		state: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "domain", "control_state"),
+				"Whether the control interface of the domain is in the given state (ok, job, occupied, error)",
+				[]string{"domain_uuid", "state"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		stateDuration: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "domain", "control_state_duration_seconds"),
+				"Time the control interface of the domain has been in its current state, 0 if the state is ok",
+				[]string{"domain_uuid"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		errorInfo: newInfoDesc("domain", "control_error_info",
+			"Reason of the error state of the control interface of the domain, e.g. monitor if the connection to the QEMU monitor broke, absent if not in error state",
+			"domain_uuid", "reason"),
+		logger: logger,
+	}, nil
+}
+
+func (c *controlCollector) descs() []typedDesc {
+	return []typedDesc{c.state, c.stateDuration, c.errorInfo.typedDesc}
+}
+
+func (c *controlCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	infos := newInfoMetrics(ch)
+
+	if config.pLibvirt == nil {
+		level.Error(c.logger).Log("msg", "libvirt not created")
+		return ErrNotProvided
+	}
+	if !config.pLibvirt.IsConnected() {
+		level.Error(c.logger).Log("msg", "libvirt not connected")
+		return ErrNotProvided
+	}
+	if len(config.lvDomains) == 0 {
+		level.Debug(c.logger).Log("msg", "no domains found")
+		return ErrNotProvided
+	}
+	ctx := config.context()
+	pLibvirt := config.pLibvirt
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(config.lvDomains))
+	for _, lvDomain := range config.lvDomains {
+		go func(domain libvirt.Domain, domainUUID string) {
+			defer wg.Done()
+			if !acquireWorker(ctx) {
+				return
+			}
+			defer releaseWorker()
+			defer busyDomain(ctx, domainUUID)()
+			// DomainGetControlInfo does not wait for the monitor, so it
+			// answers even if the monitor is stuck.
+			state, details, stateTime, err := pLibvirt.DomainGetControlInfo(domain, 0)
+			if err != nil {
+				domainErrorLogger(ctx, c.logger, "control", domainUUID, err).Log("msg", "failed to get control info", "domain", domain.Name, "err", err)
+				return
+			}
+			for _, s := range controlStates {
+				ch <- c.state.mustNewConstMetric(float64(boolIndex(libvirt.DomainControlState(state) == s.state)), domainUUID, s.name)
+			}
+			ch <- c.stateDuration.mustNewConstMetric(float64(stateTime)/1e3, domainUUID)
+			if libvirt.DomainControlState(state) == libvirt.DomainControlError {
+				reason, ok := controlErrorReasons[libvirt.DomainControlErrorReason(details)]
+				if !ok {
+					reason = "unknown"
+				}
+				infos.emit(c.errorInfo, domainUUID, reason)
+			}
+		}(lvDomain.Domain, lvDomain.Schema.UUID)
+	}
+	wg.Wait()
+
+	return nil
+}
@@ -4,15 +4,44 @@ import (
 	"strconv"
 	"sync"
 
+	"github.com/alecthomas/kingpin/v2"
 	libvirt "github.com/digitalocean/go-libvirt"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+var cpuNumericState = reloadableBool(kingpin.Flag(
+	"collector.cpu.numeric-state",
+	"Use the numeric virDomainState code as state label of libvirt_domain_state, as older versions of the exporter did on the cpu metrics.",
+).Default("false"))
+
+// domainStateNames names the virDomainState codes, see
+// https://libvirt.org/html/libvirt-libvirt-domain.html#virDomainState
+var domainStateNames = map[libvirt.DomainState]string{
+	libvirt.DomainNostate:     "nostate",
+	libvirt.DomainRunning:     "running",
+	libvirt.DomainBlocked:     "blocked",
+	libvirt.DomainPaused:      "paused",
+	libvirt.DomainShutdown:    "shutdown",
+	libvirt.DomainShutoff:     "shutoff",
+	libvirt.DomainCrashed:     "crashed",
+	libvirt.DomainPmsuspended: "pmsuspended",
+}
+
+// domainStateLabel returns the state label value of a domain state.
+func domainStateLabel(state uint8) string {
+	if name, ok := domainStateNames[libvirt.DomainState(state)]; ok && !cpuNumericState.Get() {
+		return name
+	}
+	return strconv.Itoa(int(state))
+}
+
 type cpuCollector struct {
+	state        typedDesc
 	secondsTotal typedDesc
 	vCPUNumber   typedDesc
+	modeSeconds  typedDesc
 	logger       log.Logger
 }
 
@@ -22,26 +51,46 @@ func init() {
 
 func NewCPUCollector(logger log.Logger) (Collector, error) {
 	return &cpuCollector{
+		state: typedDesc{
+			newMetricDesc(
+				prometheus.BuildFQName(namespace, "domain", "state"),
+				"Whether the domain is in the given state",
+				[]string{"domain_uuid", "state"},
+				nil),
+			prometheus.GaugeValue,
+		},
 		secondsTotal: typedDesc{
-			prometheus.NewDesc(
+			newMetricDesc(
 				prometheus.BuildFQName(namespace, "domain_cpu", "seconds_total"),
 				"Seconds the vCPUs in VMs for each domain",
-				[]string{"domain_uuid", "state"},
+				[]string{"domain_uuid"},
 				nil),
 			prometheus.CounterValue,
 		},
 		vCPUNumber: typedDesc{
-			prometheus.NewDesc(
+			newMetricDesc(
 				prometheus.BuildFQName(namespace, "domain_cpu", "vcpu_number"),
 				"Number of vCPUs in VMs for each domain",
-				[]string{"domain_uuid", "state"},
+				[]string{"domain_uuid"},
 				nil),
 			prometheus.GaugeValue,
 		},
+		modeSeconds: typedDesc{
+			newMetricDesc(
+				prometheus.BuildFQName(namespace, "domain_cpu", "mode_seconds_total"),
+				"Seconds the domain spent in user mode (guest work) and system mode (hypervisor overhead)",
+				[]string{"domain_uuid", "mode"},
+				nil),
+			prometheus.CounterValue,
+		},
 		logger: logger,
 	}, nil
 }
 
+func (c *cpuCollector) descs() []typedDesc {
+	return []typedDesc{c.state, c.secondsTotal, c.vCPUNumber, c.modeSeconds}
+}
+
 func (c *cpuCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
 	config := &CollectorConfig{}
 	for _, opt := range opts {
@@ -57,9 +106,10 @@ func (c *cpuCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOpti
 		return ErrNotProvided
 	}
 	if config.lvDomains == nil || len(config.lvDomains) == 0 {
-		level.Error(c.logger).Log("msg", "no domains found")
+		level.Debug(c.logger).Log("msg", "no domains found")
 		return ErrNotProvided
 	}
+	ctx := config.context()
 	pLibvirt := config.pLibvirt
 	lvDomains := config.lvDomains
 
@@ -69,21 +119,51 @@ func (c *cpuCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOpti
 		domainUUID := lvDomain.Schema.UUID
 		go func(domain libvirt.Domain, domainUUID string) {
 			// state meaning explained here: https://libvirt.org/html/libvirt-libvirt-domain.html#virDomainState
+			if !acquireWorker(ctx) {
+				wg.Done()
+				return
+			}
+			defer releaseWorker()
 			state, _, _, nrVirtCPU, cpuTime, err := pLibvirt.DomainGetInfo(domain)
 			if err != nil {
-				level.Error(c.logger).Log("msg", "failed to get domain info", "domain", domain.Name, "err", err)
+				domainErrorLogger(ctx, c.logger, "cpu", domainUUID, err).Log("msg", "failed to get domain info", "domain", domain.Name, "err", err)
 				wg.Done()
 				return
 			}
 			level.Debug(c.logger).Log("msg", "get domain info", "domain", domain.Name, "nrVirtCPU", nrVirtCPU, "cpuTime", cpuTime)
 
-			ch <- c.secondsTotal.mustNewConstMetric(float64(cpuTime)/1e9, domainUUID, strconv.Itoa(int(state)))
-			ch <- c.vCPUNumber.mustNewConstMetric(float64(nrVirtCPU), domainUUID, strconv.Itoa(int(state)))
+			// The state is a metric of its own, as a state label would
+			// start new counter series whenever a domain pauses.
+			for s := libvirt.DomainNostate; s <= libvirt.DomainPmsuspended; s++ {
+				ch <- c.state.mustNewConstMetric(float64(boolIndex(uint8(s) == state)), domainUUID, domainStateLabel(uint8(s)))
+			}
+			ch <- c.secondsTotal.mustNewConstMetric(float64(cpuTime)/1e9, domainUUID)
+			ch <- c.vCPUNumber.mustNewConstMetric(float64(nrVirtCPU), domainUUID)
 
 			wg.Done()
 		}(lvDomain.Domain, domainUUID)
 	}
 	wg.Wait()
 
+	// The split into user and system time is only available as bulk stat,
+	// which costs one request for all domains.
+	domains := make([]libvirt.Domain, 0, len(lvDomains))
+	for _, lvDomain := range lvDomains {
+		domains = append(domains, lvDomain.Domain)
+	}
+	records, err := pLibvirt.ConnectGetAllDomainStats(domains, uint32(libvirt.DomainStatsCPUTotal), 0)
+	if err != nil {
+		errorLogger(c.logger, err).Log("msg", "failed to get cpu stats", "err", err)
+		return nil
+	}
+	for _, record := range records {
+		domainUUID := uuidString(record.Dom.UUID)
+		for _, mode := range []string{"user", "system"} {
+			if v, ok := typedParamValue(record.Params, "cpu."+mode); ok {
+				ch <- c.modeSeconds.mustNewConstMetric(v/1e9, domainUUID, mode)
+			}
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,190 @@
+package collector
+
+import (
+	"strconv"
+	"sync"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const cputuneSubsystemName = "domain_cputune"
+
+// schedulerParametersMax is REMOTE_DOMAIN_SCHEDULER_PARAMETERS_MAX, the most
+// scheduler parameters libvirt returns.
+const schedulerParametersMax = 16
+
+// cputuneCollector exposes the CPU scheduler limits of domains, so throttling
+// can be correlated with the configured cgroup limits.
+type cputuneCollector struct {
+	shares         typedDesc
+	vcpuPeriod     typedDesc
+	vcpuQuota      typedDesc
+	emulatorPeriod typedDesc
+	emulatorQuota  typedDesc
+	globalPeriod   typedDesc
+	globalQuota    typedDesc
+	iothreadPeriod typedDesc
+	iothreadQuota  typedDesc
+	vcpuPin        infoDesc
+	emulatorPin    infoDesc
+	logger         log.Logger
+}
+
+func init() {
+	registerCollector("cputune", defaultDisabled, NewCputuneCollector)
+}
+
+// NewCputuneCollector returns a new Collector exposing domain scheduler
+// parameters.
+func NewCputuneCollector(logger log.Logger) (Collector, error) {
+	newDesc := func(name, help string) typedDesc {
+		return typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, cputuneSubsystemName, name),
+				help,
+				[]string{"domain_uuid"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		}
+	}
+	return &cputuneCollector{
+		shares:         newDesc("shares", "Relative CPU weight of the domain"),
+		vcpuPeriod:     newDesc("vcpu_period_seconds", "Enforcement period of the vCPU quota"),
+		vcpuQuota:      newDesc("vcpu_quota_seconds", "CPU time each vCPU may use per period, absent if unlimited"),
+		emulatorPeriod: newDesc("emulator_period_seconds", "Enforcement period of the emulator quota"),
+		emulatorQuota:  newDesc("emulator_quota_seconds", "CPU time the emulator threads may use per period, absent if unlimited"),
+		globalPeriod:   newDesc("global_period_seconds", "Enforcement period of the global quota"),
+		globalQuota:    newDesc("global_quota_seconds", "CPU time the whole domain may use per period, absent if unlimited"),
+		iothreadPeriod: newDesc("iothread_period_seconds", "Enforcement period of the IOThread quota"),
+		iothreadQuota:  newDesc("iothread_quota_seconds", "CPU time each IOThread may use per period, absent if unlimited"),
+		vcpuPin:        newInfoDesc(cputuneSubsystemName, "vcpu_affinity_info", "Host CPUs the vCPU may run on", "domain_uuid", "vcpu", "cpus"),
+		emulatorPin: newInfoDesc(cputuneSubsystemName, "emulator_affinity_info",
+			"Host CPUs the emulator threads may run on",
+			"domain_uuid", "cpus"),
+		logger: logger,
+	}, nil
+}
+
+func (c *cputuneCollector) descs() []typedDesc {
+	return []typedDesc{
+		c.shares,
+		c.vcpuPeriod,
+		c.vcpuQuota,
+		c.emulatorPeriod,
+		c.emulatorQuota,
+		c.globalPeriod,
+		c.globalQuota,
+		c.iothreadPeriod,
+		c.iothreadQuota,
+		c.vcpuPin.typedDesc,
+		c.emulatorPin.typedDesc,
+	}
+}
+
+func (c *cputuneCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	infos := newInfoMetrics(ch)
+
+	if config.pLibvirt == nil {
+		level.Error(c.logger).Log("msg", "libvirt not created")
+		return ErrNotProvided
+	}
+	if !config.pLibvirt.IsConnected() {
+		level.Error(c.logger).Log("msg", "libvirt not connected")
+		return ErrNotProvided
+	}
+	if len(config.lvDomains) == 0 {
+		level.Debug(c.logger).Log("msg", "no domains found")
+		return ErrNotProvided
+	}
+	ctx := config.context()
+	pLibvirt := config.pLibvirt
+
+	// The CPU maps hold one bit per host CPU. Without the host CPUs only
+	// the pinning is skipped, the scheduler parameters don't need them.
+	var maplen int32
+	if _, _, hostCPUs, _, _, _, _, _, err := pLibvirt.NodeGetInfo(); err != nil {
+		level.Error(c.logger).Log("msg", "failed to get node info, skipping cpu pinning", "err", err)
+	} else {
+		maplen = (hostCPUs + 7) / 8
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(config.lvDomains))
+	for _, lvDomain := range config.lvDomains {
+		go func(domain libvirt.Domain, domainUUID string) {
+			defer wg.Done()
+			if !acquireWorker(ctx) {
+				return
+			}
+			defer releaseWorker()
+			if maplen > 0 {
+				c.updatePinning(infos, pLibvirt, domain, domainUUID, maplen)
+			}
+			params, err := pLibvirt.DomainGetSchedulerParametersFlags(domain, schedulerParametersMax, uint32(libvirt.DomainAffectCurrent))
+			if err != nil {
+				level.Error(c.logger).Log("msg", "failed to get scheduler parameters", "domain", domain.Name, "err", err)
+				return
+			}
+			if v, ok := typedParamValue(params, "cpu_shares"); ok {
+				ch <- c.shares.mustNewConstMetric(v, domainUUID)
+			}
+			// Periods and quotas are in microseconds, quotas of 0 or
+			// below mean no limit.
+			for _, p := range []struct {
+				field string
+				desc  *typedDesc
+			}{
+				{"vcpu_period", &c.vcpuPeriod},
+				{"vcpu_quota", &c.vcpuQuota},
+				{"emulator_period", &c.emulatorPeriod},
+				{"emulator_quota", &c.emulatorQuota},
+				{"global_period", &c.globalPeriod},
+				{"global_quota", &c.globalQuota},
+				{"iothread_period", &c.iothreadPeriod},
+				{"iothread_quota", &c.iothreadQuota},
+			} {
+				if v, ok := typedParamValue(params, p.field); ok && v > 0 {
+					ch <- p.desc.mustNewConstMetric(v/1e6, domainUUID)
+				}
+			}
+		}(lvDomain.Domain, lvDomain.Schema.UUID)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// updatePinning exposes the CPU affinity of the vCPUs and the emulator threads
+// of a domain. The affinity of IOThreads is exposed by the iothread collector.
+func (c *cputuneCollector) updatePinning(infos *infoMetrics, pLibvirt *libvirt.Libvirt, domain libvirt.Domain, domainUUID string, maplen int32) {
+	vcpus, err := pLibvirt.DomainGetVcpusFlags(domain, uint32(libvirt.DomainAffectCurrent))
+	if err != nil {
+		level.Error(c.logger).Log("msg", "failed to get vcpu count", "domain", domain.Name, "err", err)
+		return
+	}
+	cpumaps, num, err := pLibvirt.DomainGetVcpuPinInfo(domain, vcpus, maplen, uint32(libvirt.DomainAffectCurrent))
+	if err != nil {
+		level.Error(c.logger).Log("msg", "failed to get vcpu pinning", "domain", domain.Name, "err", err)
+	} else {
+		for vcpu := 0; vcpu < int(num) && (vcpu+1)*int(maplen) <= len(cpumaps); vcpu++ {
+			cpumap := cpumaps[vcpu*int(maplen) : (vcpu+1)*int(maplen)]
+			infos.emit(c.vcpuPin, domainUUID, strconv.Itoa(vcpu), cpusetString(cpumap))
+		}
+	}
+	cpumap, ret, err := pLibvirt.DomainGetEmulatorPinInfo(domain, maplen, libvirt.DomainAffectCurrent)
+	if err != nil {
+		level.Error(c.logger).Log("msg", "failed to get emulator pinning", "domain", domain.Name, "err", err)
+		return
+	}
+	// ret is 0 if the hypervisor has no emulator threads.
+	if ret > 0 {
+		infos.emit(c.emulatorPin, domainUUID, cpusetString(cpumap))
+	}
+}
@@ -0,0 +1,133 @@
+package collector
+
+import (
+	"sync"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// diskErrorStates names the I/O error states of disks, see
+// https://libvirt.org/html/libvirt-libvirt-domain.html#virDomainDiskErrorCode
+var diskErrorStates = []struct {
+	code libvirt.DomainDiskErrorCode
+	name string
+}{
+	{libvirt.DomainDiskErrorNone, "none"},
+	{libvirt.DomainDiskErrorUnspec, "unspec"},
+	{libvirt.DomainDiskErrorNoSpace, "no_space"},
+}
+
+// diskErrorCollector exposes the I/O error state of disks. A domain paused
+// because its image ran out of space only shows as paused otherwise. Only the
+// first disk in error of a domain is reported, see firstDiskError.
+type diskErrorCollector struct {
+	state  typedDesc
+	logger log.Logger
+}
+
+func init() {
+	registerCollector("disk_error", defaultEnabled, NewDiskErrorCollector)
+}
+
+// NewDiskErrorCollector returns a new Collector exposing disk error states.
+func NewDiskErrorCollector(logger log.Logger) (Collector, error) {
+	return &diskErrorCollector{
+		state: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "domain_block", "error_state"),
+				"Whether the disk is in the given I/O error state (none, unspec, no_space), errors persist until the domain is resumed",
+				[]string{"domain_uuid", "target_device", "state"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		logger: logger,
+	}, nil
+}
+
+func (c *diskErrorCollector) descs() []typedDesc {
+	return []typedDesc{c.state}
+}
+
+func (c *diskErrorCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	if config.pLibvirt == nil {
+		level.Error(c.logger).Log("msg", "libvirt not created")
+		return ErrNotProvided
+	}
+	if !config.pLibvirt.IsConnected() {
+		level.Error(c.logger).Log("msg", "libvirt not connected")
+		return ErrNotProvided
+	}
+	if len(config.lvDomains) == 0 {
+		level.Debug(c.logger).Log("msg", "no domains found")
+		return ErrNotProvided
+	}
+	ctx := config.context()
+	pLibvirt := config.pLibvirt
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(config.lvDomains))
+	for _, lvDomain := range config.lvDomains {
+		var targets []string
+		for _, disk := range lvDomain.Schema.Devices.Disks {
+			if disk.Target.Device != "" {
+				targets = append(targets, disk.Target.Device)
+			}
+		}
+		go func(domain libvirt.Domain, domainUUID string, targets []string) {
+			defer wg.Done()
+			if len(targets) == 0 {
+				return
+			}
+			if !acquireWorker(ctx) {
+				return
+			}
+			defer releaseWorker()
+			defer busyDomain(ctx, domainUUID)()
+			disk, code, err := firstDiskError(pLibvirt, domain)
+			if err != nil {
+				domainErrorLogger(ctx, c.logger, "disk_error", domainUUID, err).Log("msg", "failed to get disk errors", "domain", domain.Name, "err", err)
+				return
+			}
+			for _, target := range targets {
+				state := libvirt.DomainDiskErrorNone
+				if target == disk {
+					state = code
+				}
+				for _, s := range diskErrorStates {
+					ch <- c.state.mustNewConstMetric(float64(boolIndex(state == s.code)), domainUUID, target, s.name)
+				}
+			}
+		}(lvDomain.Domain, lvDomain.Schema.UUID, targets)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// firstDiskError returns the target device and error of the first disk of the
+// domain in an error state, or an empty disk if there is none.
+//
+// DomainDiskError of go-libvirt does not export its error field, so the XDR
+// decoder skips the error code of every disk and decodes the following data
+// misaligned. Requesting a single error, the skipped code is decoded as the
+// number of errors, which is used as the code instead.
+func firstDiskError(pLibvirt *libvirt.Libvirt, domain libvirt.Domain) (string, libvirt.DomainDiskErrorCode, error) {
+	diskErrors, code, err := pLibvirt.DomainGetDiskErrors(domain, 1, 0)
+	if err != nil || len(diskErrors) == 0 {
+		return "", libvirt.DomainDiskErrorNone, err
+	}
+	switch libvirt.DomainDiskErrorCode(code) {
+	case libvirt.DomainDiskErrorNoSpace:
+		return diskErrors[0].Disk, libvirt.DomainDiskErrorNoSpace, nil
+	default:
+		return diskErrors[0].Disk, libvirt.DomainDiskErrorUnspec, nil
+	}
+}
@@ -0,0 +1,129 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/nee541/libvirt-exporter/libvirt_schema"
+)
+
+var domainCacheTTL = kingpin.Flag(
+	"collector.domain-cache-ttl",
+	"Cache parsed domain XML descriptions for this long if libvirt domain events are unavailable, with events entries are kept until the domain changes. 0 disables the cache.",
+).Default("1m").Duration()
+
+// domainCacheEvents are the domain events, besides lifecycle events, after
+// which the XML description of a domain may have changed.
+var domainCacheEvents = []libvirt.DomainEventID{
+	libvirt.DomainEventIDDeviceAdded,
+	libvirt.DomainEventIDDeviceRemoved,
+	libvirt.DomainEventIDDeviceRemovalFailed,
+	libvirt.DomainEventIDDiskChange,
+	libvirt.DomainEventIDTrayChange,
+	libvirt.DomainEventIDBlockJob,
+	libvirt.DomainEventIDTunable,
+	libvirt.DomainEventIDMetadataChange,
+	libvirt.DomainEventIDJobCompleted,
+}
+
+type domainCacheEntry struct {
+	schema libvirt_schema.Domain
+	at     time.Time
+}
+
+// domainCache holds the parsed XML descriptions of domains by UUID, so
+// DomainGetXMLDesc is not called for every domain on every scrape.
+type domainCache struct {
+	mtx     sync.Mutex
+	entries map[string]domainCacheEntry
+	// watched is set while domain events invalidate the entries, they
+	// don't expire then.
+	watched bool
+}
+
+var domainSchemas = &domainCache{
+	entries: make(map[string]domainCacheEntry),
+}
+
+// inactiveDomainSchemas holds the descriptions of inactive domains for the
+// allocation collector. They are kept apart from domainSchemas, which only
+// retains the active domains of the latest scrape.
+var inactiveDomainSchemas = &domainCache{
+	entries: make(map[string]domainCacheEntry),
+}
+
+func (c *domainCache) get(domainUUID string) (libvirt_schema.Domain, bool) {
+	if *domainCacheTTL <= 0 {
+		return libvirt_schema.Domain{}, false
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	entry, ok := c.entries[domainUUID]
+	if !ok || (!c.watched && time.Since(entry.at) > *domainCacheTTL) {
+		return libvirt_schema.Domain{}, false
+	}
+	return entry.schema, true
+}
+
+func (c *domainCache) set(domainUUID string, schema libvirt_schema.Domain) {
+	if *domainCacheTTL <= 0 {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.entries[domainUUID] = domainCacheEntry{schema: schema, at: time.Now()}
+}
+
+func (c *domainCache) invalidate(domainUUID string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	delete(c.entries, domainUUID)
+}
+
+// retain drops the entries of all domains not in domainUUIDs.
+func (c *domainCache) retain(domainUUIDs map[string]bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for domainUUID := range c.entries {
+		if !domainUUIDs[domainUUID] {
+			delete(c.entries, domainUUID)
+		}
+	}
+}
+
+// setWatched switches between invalidation by events and expiry by TTL. All
+// entries are dropped, as events may have been missed in between.
+func (c *domainCache) setWatched(watched bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.watched = watched
+	c.entries = make(map[string]domainCacheEntry)
+}
+
+// eventDomain returns the domain an event subscribed in domainCacheEvents is
+// about.
+func eventDomain(ev interface{}) (libvirt.Domain, bool) {
+	switch ev := ev.(type) {
+	case *libvirt.DomainEventCallbackDeviceAddedMsg:
+		return ev.Dom, true
+	case *libvirt.DomainEventCallbackDeviceRemovedMsg:
+		return ev.Msg.Dom, true
+	case *libvirt.DomainEventCallbackDeviceRemovalFailedMsg:
+		return ev.Dom, true
+	case *libvirt.DomainEventCallbackDiskChangeMsg:
+		return ev.Msg.Dom, true
+	case *libvirt.DomainEventCallbackTrayChangeMsg:
+		return ev.Msg.Dom, true
+	case *libvirt.DomainEventCallbackBlockJobMsg:
+		return ev.Msg.Dom, true
+	case *libvirt.DomainEventCallbackTunableMsg:
+		return ev.Dom, true
+	case *libvirt.DomainEventCallbackMetadataChangeMsg:
+		return ev.Dom, true
+	case *libvirt.DomainEventCallbackJobCompletedMsg:
+		return ev.Dom, true
+	}
+	return libvirt.Domain{}, false
+}
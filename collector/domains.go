@@ -0,0 +1,113 @@
+package collector
+
+import (
+	"strconv"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// domainStateFlags maps the state label to the list flag selecting it.
+var domainStateFlags = []struct {
+	state string
+	flag  libvirt.ConnectListAllDomainsFlags
+}{
+	{"running", libvirt.ConnectListDomainsRunning},
+	{"paused", libvirt.ConnectListDomainsPaused},
+	{"shutoff", libvirt.ConnectListDomainsShutoff},
+	{"other", libvirt.ConnectListDomainsOther},
+}
+
+// domainsCollector exposes the number of domains by state, persistence and
+// autostart. The counts are assembled from a fixed number of list calls, so
+// the cost does not grow with the number of domains.
+type domainsCollector struct {
+	domains typedDesc
+	logger  log.Logger
+}
+
+func init() {
+	registerCollector("domains", defaultEnabled, NewDomainsCollector)
+}
+
+func NewDomainsCollector(logger log.Logger) (Collector, error) {
+	return &domainsCollector{
+		domains: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "", "domains"),
+				"Number of domains by state, persistence and autostart",
+				[]string{"state", "persistent", "autostart"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		logger: logger,
+	}, nil
+}
+
+func (c *domainsCollector) descs() []typedDesc {
+	return []typedDesc{c.domains}
+}
+
+func (c *domainsCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	if config.pLibvirt == nil {
+		level.Error(c.logger).Log("msg", "libvirt not created")
+		return ErrNotProvided
+	}
+	if !config.pLibvirt.IsConnected() {
+		level.Error(c.logger).Log("msg", "libvirt not connected")
+		return ErrNotProvided
+	}
+	pLibvirt := config.pLibvirt
+
+	list := func(flags libvirt.ConnectListAllDomainsFlags) (map[libvirt.UUID]bool, error) {
+		domains, _, err := pLibvirt.ConnectListAllDomains(1, flags)
+		if err != nil {
+			return nil, err
+		}
+		set := make(map[libvirt.UUID]bool, len(domains))
+		for _, domain := range selectDomains(domains) {
+			set[domain.UUID] = true
+		}
+		return set, nil
+	}
+
+	persistent, err := list(libvirt.ConnectListDomainsPersistent)
+	if err != nil {
+		return err
+	}
+	autostart, err := list(libvirt.ConnectListDomainsAutostart)
+	if err != nil {
+		return err
+	}
+	for _, s := range domainStateFlags {
+		domains, err := list(s.flag)
+		if err != nil {
+			return err
+		}
+		// [persistent][autostart]
+		var counts [2][2]int
+		for uuid := range domains {
+			counts[boolIndex(persistent[uuid])][boolIndex(autostart[uuid])]++
+		}
+		for p := 0; p < 2; p++ {
+			for a := 0; a < 2; a++ {
+				ch <- c.domains.mustNewConstMetric(float64(counts[p][a]), s.state, strconv.FormatBool(p == 1), strconv.FormatBool(a == 1))
+			}
+		}
+	}
+	return nil
+}
+
+func boolIndex(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,183 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// enrichmentWorkers is the number of lookups run at the same time.
+const enrichmentWorkers = 4
+
+// Enricher translates a domain UUID into business identifiers such as the
+// owning team or the cost center, attached as labels to libvirt_domain_info.
+// Enrich returns the values by label name, missing labels are left empty.
+// It is called in the background, never during a scrape.
+type Enricher interface {
+	Enrich(ctx context.Context, domainUUID string) (map[string]string, error)
+}
+
+type enrichmentEntry struct {
+	values  []string
+	fetched time.Time
+	pending bool
+}
+
+// enrichmentCache holds the looked up labels of every domain. Lookups are
+// asynchronous: a scrape gets the cached values, which are empty until the
+// first lookup of the domain finished, and triggers a refresh of missing and
+// expired entries.
+type enrichmentCache struct {
+	enricher Enricher
+	labels   []string
+	ttl      time.Duration
+	timeout  time.Duration
+	logger   log.Logger
+	workers  chan struct{}
+
+	mtx     sync.Mutex
+	entries map[string]*enrichmentEntry
+}
+
+var enrichment *enrichmentCache
+
+// SetEnricher attaches the given labels, looked up by e and cached for ttl,
+// to libvirt_domain_info. It must be called before the collectors are
+// created. Compiled-in enrichers are set up by calling it from main.
+func SetEnricher(e Enricher, labels []string, ttl, timeout time.Duration, logger log.Logger) error {
+	reserved := map[string]bool{}
+	for _, label := range infoLabels {
+		reserved[label] = true
+	}
+	for _, label := range labels {
+		if !infoLabelPattern.MatchString(label) || reserved[label] {
+			return fmt.Errorf("invalid or duplicate enrichment label %q", label)
+		}
+		reserved[label] = true
+	}
+	enrichment = &enrichmentCache{
+		enricher: e,
+		labels:   labels,
+		ttl:      ttl,
+		timeout:  timeout,
+		logger:   logger,
+		workers:  make(chan struct{}, enrichmentWorkers),
+		entries:  make(map[string]*enrichmentEntry),
+	}
+	return nil
+}
+
+// enrichmentLabels returns the labels added by the enricher, if any.
+func enrichmentLabels() []string {
+	if enrichment == nil {
+		return nil
+	}
+	return enrichment.labels
+}
+
+// values returns the cached label values of the domain, one per label, and
+// starts a lookup if they are missing or expired. Expired values are
+// returned until the lookup replaced them.
+func (c *enrichmentCache) values(domainUUID string) []string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	entry, ok := c.entries[domainUUID]
+	if !ok {
+		entry = &enrichmentEntry{values: make([]string, len(c.labels))}
+		c.entries[domainUUID] = entry
+	}
+	if !entry.pending && time.Since(entry.fetched) >= c.ttl {
+		entry.pending = true
+		go c.lookup(domainUUID)
+	}
+	return entry.values
+}
+
+func (c *enrichmentCache) lookup(domainUUID string) {
+	c.workers <- struct{}{}
+	defer func() { <-c.workers }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	result, err := c.enricher.Enrich(ctx, domainUUID)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	entry, ok := c.entries[domainUUID]
+	if !ok {
+		// The domain was removed meanwhile.
+		return
+	}
+	entry.pending = false
+	if err != nil {
+		// Keep the previous values and retry on the next scrape after ttl.
+		level.Warn(c.logger).Log("msg", "failed to enrich domain", "domain_uuid", domainUUID, "err", err)
+		entry.fetched = time.Now()
+		return
+	}
+	values := make([]string, len(c.labels))
+	for i, label := range c.labels {
+		values[i] = result[label]
+	}
+	entry.values = values
+	entry.fetched = time.Now()
+}
+
+// retain drops the entries of all domains not in domainUUIDs.
+func (c *enrichmentCache) retain(domainUUIDs map[string]bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for domainUUID := range c.entries {
+		if !domainUUIDs[domainUUID] {
+			delete(c.entries, domainUUID)
+		}
+	}
+}
+
+// HTTPEnricher looks up domains with a GET request to URL, in which {uuid}
+// is replaced by the domain UUID. The response must be a JSON object of
+// strings, e.g. {"owner_team": "storage", "cost_center": "4711"}. A 404
+// response means the domain is unknown and yields empty labels.
+type HTTPEnricher struct {
+	URL    string
+	Client *http.Client
+}
+
+// Enrich implements the Enricher interface.
+func (e *HTTPEnricher) Enrich(ctx context.Context, domainUUID string) (map[string]string, error) {
+	target := strings.ReplaceAll(e.URL, "{uuid}", url.PathEscape(domainUUID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return map[string]string{}, nil
+	default:
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	result := map[string]string{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("couldn't decode response: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,257 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/nee541/libvirt-exporter/libvirt_schema"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const envelopeSubsystemName = "domain_envelope"
+
+var (
+	envelopeSampleInterval = reloadableDuration(kingpin.Flag(
+		"collector.envelope.interval",
+		"Interval in which the envelope collector samples CPU and block I/O between scrapes.",
+	).Default("1s"))
+	envelopeWindow = reloadableDuration(kingpin.Flag(
+		"collector.envelope.window",
+		"Window over which the envelope collector reports the highest rates, independent of scrapes.",
+	).Default("1m"))
+)
+
+// envelopeIdleTimeout is the time without scrapes after which the sampler
+// stops. It is started again by the next scrape.
+const envelopeIdleTimeout = 10 * time.Minute
+
+// envelopeCollector samples CPU time and block I/O in the background and
+// exposes the highest rates observed in a sliding window, so short bursts are
+// not averaged away by the scrape interval.
+type envelopeCollector struct {
+	cpuMax        typedDesc
+	readBytesMax  typedDesc
+	writeBytesMax typedDesc
+	logger        log.Logger
+
+	mtx sync.Mutex
+	// sampling is set while the sampler runs.
+	sampling   bool
+	lastUpdate time.Time
+	pLibvirt   *libvirt.Libvirt
+	lvDomains  []libvirt_schema.LvDomain
+	samples    map[string]*envelopeSample
+}
+
+// envelopeSample holds the last raw counters of a domain and the rates of the
+// current window.
+type envelopeSample struct {
+	at         time.Time
+	cpuTime    uint64
+	readBytes  int64
+	writeBytes int64
+
+	rates []envelopeRate
+}
+
+// envelopeRate holds the rates between two samples.
+type envelopeRate struct {
+	at         time.Time
+	cpu        float64
+	readBytes  float64
+	writeBytes float64
+}
+
+func init() {
+	registerCollector("envelope", defaultDisabled, NewEnvelopeCollector)
+}
+
+func NewEnvelopeCollector(logger log.Logger) (Collector, error) {
+	return &envelopeCollector{
+		cpuMax: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, envelopeSubsystemName, "cpu_usage_max_cores"),
+				"Highest CPU usage of the domain in cores observed in the envelope window",
+				[]string{"domain_uuid"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		readBytesMax: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, envelopeSubsystemName, "block_read_max_bytes_per_second"),
+				"Highest block read rate of the domain observed in the envelope window",
+				[]string{"domain_uuid"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		writeBytesMax: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, envelopeSubsystemName, "block_write_max_bytes_per_second"),
+				"Highest block write rate of the domain observed in the envelope window",
+				[]string{"domain_uuid"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		logger:  logger,
+		samples: make(map[string]*envelopeSample),
+	}, nil
+}
+
+func (c *envelopeCollector) descs() []typedDesc {
+	return []typedDesc{c.cpuMax, c.readBytesMax, c.writeBytesMax}
+}
+
+func (c *envelopeCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	if config.pLibvirt == nil {
+		level.Error(c.logger).Log("msg", "libvirt not created")
+		return ErrNotProvided
+	}
+	if config.lvDomains == nil || len(config.lvDomains) == 0 {
+		level.Debug(c.logger).Log("msg", "no domains found")
+		return ErrNotProvided
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	// The sampler works on the domains of the latest scrape, dry runs
+	// neither start nor keep it running.
+	if !isDryRun(config.context()) {
+		c.pLibvirt = config.pLibvirt
+		c.lvDomains = config.lvDomains
+		c.lastUpdate = time.Now()
+		if !c.sampling {
+			c.sampling = true
+			go c.sampleLoop()
+		}
+	}
+
+	active := make(map[string]bool, len(config.lvDomains))
+	windowStart := time.Now().Add(-envelopeWindow.Get())
+	for _, lvDomain := range config.lvDomains {
+		domainUUID := lvDomain.Schema.UUID
+		active[domainUUID] = true
+		sample, ok := c.samples[domainUUID]
+		if !ok || len(sample.rates) == 0 {
+			continue
+		}
+		var cpuMax, readBytesMax, writeBytesMax float64
+		for _, rate := range sample.rates {
+			if rate.at.Before(windowStart) {
+				continue
+			}
+			cpuMax = max(cpuMax, rate.cpu)
+			readBytesMax = max(readBytesMax, rate.readBytes)
+			writeBytesMax = max(writeBytesMax, rate.writeBytes)
+		}
+		ch <- c.cpuMax.mustNewConstMetric(cpuMax, domainUUID)
+		ch <- c.readBytesMax.mustNewConstMetric(readBytesMax, domainUUID)
+		ch <- c.writeBytesMax.mustNewConstMetric(writeBytesMax, domainUUID)
+	}
+	for domainUUID := range c.samples {
+		if !active[domainUUID] {
+			delete(c.samples, domainUUID)
+		}
+	}
+	return nil
+}
+
+// sampleLoop samples all running domains every interval. It stops once the
+// collector is disabled, e.g. by a config reload, or has not been scraped for
+// envelopeIdleTimeout, and drops the samples then.
+func (c *envelopeCollector) sampleLoop() {
+	ticker := time.NewTicker(envelopeSampleInterval.Get())
+	defer ticker.Stop()
+	for range ticker.C {
+		configMtx.RLock()
+		enabled := *collectorState["envelope"]
+		configMtx.RUnlock()
+		c.mtx.Lock()
+		if !enabled || time.Since(c.lastUpdate) > envelopeIdleTimeout {
+			level.Debug(c.logger).Log("msg", "stopping envelope sampler", "enabled", enabled)
+			c.sampling = false
+			c.samples = make(map[string]*envelopeSample)
+			c.mtx.Unlock()
+			return
+		}
+		pLibvirt, lvDomains := c.pLibvirt, c.lvDomains
+		c.mtx.Unlock()
+		if pLibvirt == nil || !pLibvirt.IsConnected() {
+			continue
+		}
+		// A round of samples must not take longer than the interval, and
+		// shares the worker pool with the scrapes.
+		ctx, cancel := context.WithTimeout(context.Background(), envelopeSampleInterval.Get())
+		for _, lvDomain := range lvDomains {
+			if !lvDomain.Running {
+				continue
+			}
+			if !acquireWorker(ctx) {
+				break
+			}
+			c.sample(pLibvirt, lvDomain)
+			releaseWorker()
+		}
+		cancel()
+	}
+}
+
+func (c *envelopeCollector) sample(pLibvirt *libvirt.Libvirt, lvDomain libvirt_schema.LvDomain) {
+	now := time.Now()
+	_, _, _, _, cpuTime, err := pLibvirt.DomainGetInfo(lvDomain.Domain)
+	if err != nil {
+		level.Debug(c.logger).Log("msg", "failed to sample domain info", "domain", lvDomain.Domain.Name, "err", err)
+		return
+	}
+	var readBytes, writeBytes int64
+	for _, disk := range lvDomain.Schema.Devices.Disks {
+		// The same disks as the block collector, --collector.block.device-exclude
+		// applies.
+		if blockSkipReason(disk) != "" || disk.Target.Device == "" {
+			continue
+		}
+		_, rdBytes, _, wrBytes, _, err := pLibvirt.DomainBlockStats(lvDomain.Domain, disk.Target.Device)
+		if err != nil {
+			level.Debug(c.logger).Log("msg", "failed to sample block stats", "domain", lvDomain.Domain.Name, "err", err)
+			continue
+		}
+		readBytes += rdBytes
+		writeBytes += wrBytes
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	domainUUID := lvDomain.Schema.UUID
+	sample, ok := c.samples[domainUUID]
+	if !ok {
+		c.samples[domainUUID] = &envelopeSample{at: now, cpuTime: cpuTime, readBytes: readBytes, writeBytes: writeBytes}
+		return
+	}
+	elapsed := now.Sub(sample.at).Seconds()
+	// Counters going backwards mean the domain was restarted, skip that interval.
+	if elapsed > 0 && cpuTime >= sample.cpuTime && readBytes >= sample.readBytes && writeBytes >= sample.writeBytes {
+		sample.rates = append(sample.rates, envelopeRate{
+			at:         now,
+			cpu:        float64(cpuTime-sample.cpuTime) / 1e9 / elapsed,
+			readBytes:  float64(readBytes-sample.readBytes) / elapsed,
+			writeBytes: float64(writeBytes-sample.writeBytes) / elapsed,
+		})
+	}
+	// Drop the rates which left the window.
+	windowStart := now.Add(-envelopeWindow.Get())
+	i := 0
+	for i < len(sample.rates) && sample.rates[i].at.Before(windowStart) {
+		i++
+	}
+	sample.rates = sample.rates[i:]
+	sample.at, sample.cpuTime, sample.readBytes, sample.writeBytes = now, cpuTime, readBytes, writeBytes
+}
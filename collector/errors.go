@@ -1,6 +1,13 @@
 package collector
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
 
 // ErrNoData indicates the collector found no data to collect, but had no other error.
 var ErrNoData = errors.New("collector returned no data")
@@ -13,5 +20,47 @@ func IsNoDataError(err error) bool {
 var ErrNotProvided = errors.New("collector not provided with necessary data")
 
 func IsNotProvidedError(err error) bool {
-	return err == ErrNotProvided
+	return errors.Is(err, ErrNotProvided)
+}
+
+// errNotLocal is returned by collectors reading host files if libvirt runs on
+// another host. Like missing data it is expected and no failure.
+var errNotLocal = fmt.Errorf("%w: collector needs a local libvirt connection", ErrNotProvided)
+
+// IsUnsupportedError reports whether err is the answer of a libvirt driver to
+// an API it does not implement, e.g. the bhyve driver to memory stats.
+func IsUnsupportedError(err error) bool {
+	var lvErr libvirt.Error
+	if !errors.As(err, &lvErr) {
+		return false
+	}
+	switch libvirt.ErrorNumber(lvErr.Code) {
+	case libvirt.ErrNoSupport, libvirt.ErrOperationUnsupported:
+		return true
+	}
+	return false
+}
+
+// IsNotRunningError reports whether err is the answer to a call for a domain
+// which stopped or was undefined since it was listed.
+func IsNotRunningError(err error) bool {
+	var lvErr libvirt.Error
+	if !errors.As(err, &lvErr) {
+		return false
+	}
+	switch libvirt.ErrorNumber(lvErr.Code) {
+	case libvirt.ErrOperationInvalid, libvirt.ErrNoDomain:
+		return true
+	}
+	return false
+}
+
+// errorLogger returns the logger for a failed libvirt call. APIs the driver
+// does not implement fail on every scrape, so they are only logged at debug
+// level.
+func errorLogger(logger log.Logger, err error) log.Logger {
+	if IsUnsupportedError(err) {
+		return level.Debug(logger)
+	}
+	return level.Error(logger)
 }
@@ -0,0 +1,461 @@
+package collector
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/alecthomas/kingpin/v2"
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/nee541/libvirt-exporter/connection"
+	"github.com/nee541/libvirt-exporter/libvirt_schema"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+// eventWatcher accumulates libvirt domain events between scrapes. Events are
+// pushed by libvirt, so they also cover changes happening between two scrapes
+// which polling would miss.
+type eventWatcher struct {
+	mtx               sync.Mutex
+	definitionChanges map[string]float64
+	// events counts lifecycle events by domain UUID and event name.
+	events    map[string]map[string]float64
+	lastEvent map[string]time.Time
+	// devices counts device hotplug events by domain UUID.
+	devices map[string]map[deviceEvent]float64
+	// moved holds the domains migrated from or to this host within the
+	// grace period, by domain UUID.
+	moved map[string]domainMove
+	// hostname is the host name of the libvirt daemon, for moved.
+	hostname string
+	// booted holds the time running domains last booted or rebooted, by
+	// domain UUID.
+	booted map[string]time.Time
+}
+
+// domainMove is one side of a migration. Each host only knows its own side:
+// the source fills fromHost and the destination toHost.
+type domainMove struct {
+	fromHost string
+	toHost   string
+	at       time.Time
+}
+
+type deviceEvent struct {
+	deviceType string
+	event      string
+}
+
+var domainEvents = &eventWatcher{
+	definitionChanges: make(map[string]float64),
+	events:            make(map[string]map[string]float64),
+	lastEvent:         make(map[string]time.Time),
+	devices:           make(map[string]map[deviceEvent]float64),
+	moved:             make(map[string]domainMove),
+	booted:            make(map[string]time.Time),
+}
+
+// forgetGracePeriod is how long the counters of a domain which is no longer
+// defined are kept after its last event, so the final events are scraped.
+const forgetGracePeriod = 10 * time.Minute
+
+var movedGracePeriod = reloadableDuration(kingpin.Flag(
+	"collector.events.moved-grace-period",
+	"How long libvirt_domain_moved_info is exposed after a domain migrated from or to this host.",
+).Default("10m"))
+
+// deviceAliasTypes maps the prefixes of the device aliases assigned by
+// libvirt to a device type. Disks are named after their bus.
+var deviceAliasTypes = map[string]string{
+	"disk":     "disk",
+	"scsi":     "disk",
+	"ide":      "disk",
+	"sata":     "disk",
+	"fdc":      "disk",
+	"net":      "interface",
+	"hostdev":  "hostdev",
+	"dimm":     "memory",
+	"redir":    "redirdev",
+	"rng":      "rng",
+	"input":    "input",
+	"watchdog": "watchdog",
+	"shmem":    "shmem",
+	"vsock":    "vsock",
+}
+
+// deviceAliasType derives the device type from a device alias such as
+// "virtio-disk0" or "net1". User defined aliases ("ua-*") and devices not
+// listed in deviceAliasTypes count as "other".
+func deviceAliasType(alias string) string {
+	name := strings.TrimPrefix(alias, "virtio-")
+	if i := strings.IndexFunc(name, unicode.IsDigit); i >= 0 {
+		name = name[:i]
+	}
+	if deviceType, ok := deviceAliasTypes[name]; ok {
+		return deviceType
+	}
+	return "other"
+}
+
+// lifecycleEventNames names the lifecycle event types, see
+// https://libvirt.org/html/libvirt-libvirt-domain.html#virDomainEventType
+var lifecycleEventNames = map[libvirt.DomainEventType]string{
+	libvirt.DomainEventDefined:     "defined",
+	libvirt.DomainEventUndefined:   "undefined",
+	libvirt.DomainEventStarted:     "started",
+	libvirt.DomainEventSuspended:   "suspended",
+	libvirt.DomainEventResumed:     "resumed",
+	libvirt.DomainEventStopped:     "stopped",
+	libvirt.DomainEventShutdown:    "shutdown",
+	libvirt.DomainEventPmsuspended: "pmsuspended",
+	libvirt.DomainEventCrashed:     "crashed",
+}
+
+// lifecycleEventName returns the name of a lifecycle event. Domains stopped
+// because they crashed count as crashed, so crashes are one series.
+func lifecycleEventName(ev libvirt.DomainEventLifecycleMsg) string {
+	eventType := libvirt.DomainEventType(ev.Event)
+	if eventType == libvirt.DomainEventStopped && libvirt.DomainEventStoppedDetailType(ev.Detail) == libvirt.DomainEventStoppedCrashed {
+		return "crashed"
+	}
+	if name, ok := lifecycleEventNames[eventType]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// WatchEvents subscribes to libvirt domain events on every (re)connect of conn.
+// Subscriptions end with the connection they were made on.
+func WatchEvents(conn *connection.Connection, logger log.Logger) {
+	conn.OnConnect(func(l *libvirt.Libvirt) {
+		if hostname, err := l.ConnectGetHostname(); err == nil {
+			domainEvents.mtx.Lock()
+			domainEvents.hostname = hostname
+			domainEvents.mtx.Unlock()
+		} else {
+			level.Warn(logger).Log("msg", "failed to get the libvirt host name", "err", err)
+		}
+		lifecycle, err := l.LifecycleEvents(context.Background())
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to subscribe to lifecycle events", "err", err)
+			return
+		}
+		watched := true
+		for _, eventID := range domainCacheEvents {
+			events, err := l.SubscribeEvents(context.Background(), eventID, libvirt.OptDomain{})
+			if err != nil {
+				level.Warn(logger).Log("msg", "failed to subscribe to domain events, domain cache falls back to its TTL", "event_id", eventID, "err", err)
+				watched = false
+				continue
+			}
+			go func() {
+				for ev := range events {
+					if dom, ok := eventDomain(ev); ok {
+						domainSchemas.invalidate(uuidString(dom.UUID))
+						inactiveDomainSchemas.invalidate(uuidString(dom.UUID))
+					}
+					domainEvents.handleDevice(ev)
+				}
+			}()
+		}
+		domainSchemas.setWatched(watched)
+		inactiveDomainSchemas.setWatched(watched)
+		if reboots, err := l.SubscribeEvents(context.Background(), libvirt.DomainEventIDReboot, libvirt.OptDomain{}); err == nil {
+			go func() {
+				for ev := range reboots {
+					if ev, ok := ev.(*libvirt.DomainEventCallbackRebootMsg); ok {
+						domainEvents.handleReboot(ev)
+					}
+				}
+			}()
+		} else {
+			level.Warn(logger).Log("msg", "failed to subscribe to reboot events", "err", err)
+		}
+		go func() {
+			for ev := range lifecycle {
+				domainEvents.handleLifecycle(ev)
+			}
+			domainSchemas.setWatched(false)
+			inactiveDomainSchemas.setWatched(false)
+			level.Debug(logger).Log("msg", "lifecycle event subscription ended")
+		}()
+	})
+}
+
+func (w *eventWatcher) handleLifecycle(ev libvirt.DomainEventLifecycleMsg) {
+	// Starting, stopping or redefining a domain changes its XML description.
+	domainSchemas.invalidate(uuidString(ev.Dom.UUID))
+	inactiveDomainSchemas.invalidate(uuidString(ev.Dom.UUID))
+	if !domainSelected(ev.Dom) {
+		return
+	}
+
+	domainUUID := uuidString(ev.Dom.UUID)
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	switch libvirt.DomainEventType(ev.Event) {
+	case libvirt.DomainEventDefined, libvirt.DomainEventUndefined:
+		w.definitionChanges[domainUUID]++
+	}
+	if w.events[domainUUID] == nil {
+		w.events[domainUUID] = make(map[string]float64)
+	}
+	w.events[domainUUID][lifecycleEventName(ev)]++
+	w.lastEvent[domainUUID] = time.Now()
+
+	switch libvirt.DomainEventType(ev.Event) {
+	case libvirt.DomainEventStopped:
+		if libvirt.DomainEventStoppedDetailType(ev.Detail) == libvirt.DomainEventStoppedMigrated {
+			w.moved[domainUUID] = domainMove{fromHost: w.hostname, at: time.Now()}
+		}
+		delete(w.booted, domainUUID)
+	case libvirt.DomainEventStarted:
+		switch libvirt.DomainEventStartedDetailType(ev.Detail) {
+		case libvirt.DomainEventStartedMigrated:
+			w.moved[domainUUID] = domainMove{toHost: w.hostname, at: time.Now()}
+		case libvirt.DomainEventStartedBooted:
+			// Domains restored from a saved state or a snapshot keep
+			// running since their original boot, which is unknown.
+			w.booted[domainUUID] = time.Now()
+		}
+	}
+}
+
+// handleReboot records the reboot of a guest. The QEMU process keeps running,
+// so no lifecycle event is emitted.
+func (w *eventWatcher) handleReboot(ev *libvirt.DomainEventCallbackRebootMsg) {
+	if !domainSelected(ev.Msg.Dom) {
+		return
+	}
+	domainUUID := uuidString(ev.Msg.Dom.UUID)
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.booted[domainUUID] = time.Now()
+}
+
+// handleDevice counts device hotplug events, other events are ignored.
+func (w *eventWatcher) handleDevice(ev interface{}) {
+	var dom libvirt.Domain
+	var key deviceEvent
+	switch ev := ev.(type) {
+	case *libvirt.DomainEventCallbackDeviceAddedMsg:
+		dom, key = ev.Dom, deviceEvent{deviceAliasType(ev.DevAlias), "added"}
+	case *libvirt.DomainEventCallbackDeviceRemovedMsg:
+		dom, key = ev.Msg.Dom, deviceEvent{deviceAliasType(ev.Msg.DevAlias), "removed"}
+	case *libvirt.DomainEventCallbackDeviceRemovalFailedMsg:
+		dom, key = ev.Dom, deviceEvent{deviceAliasType(ev.DevAlias), "removal_failed"}
+	default:
+		return
+	}
+	if !domainSelected(dom) {
+		return
+	}
+
+	domainUUID := uuidString(dom.UUID)
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.devices[domainUUID] == nil {
+		w.devices[domainUUID] = make(map[deviceEvent]float64)
+	}
+	w.devices[domainUUID][key]++
+}
+
+// forget drops the counters of the domains not in defined whose last event
+// is older than forgetGracePeriod, i.e. undefined domains, transient domains
+// which stopped and domains removed while the exporter was down. Moved
+// domains expire on their own. w.mtx must be held.
+func (w *eventWatcher) forget(defined map[string]bool) {
+	known := make(map[string]bool)
+	for domainUUID := range w.definitionChanges {
+		known[domainUUID] = true
+	}
+	for domainUUID := range w.events {
+		known[domainUUID] = true
+	}
+	for domainUUID := range w.devices {
+		known[domainUUID] = true
+	}
+	for domainUUID := range w.booted {
+		known[domainUUID] = true
+	}
+	for domainUUID := range known {
+		if defined[domainUUID] || time.Since(w.lastEvent[domainUUID]) < forgetGracePeriod {
+			continue
+		}
+		delete(w.definitionChanges, domainUUID)
+		delete(w.events, domainUUID)
+		delete(w.devices, domainUUID)
+		delete(w.lastEvent, domainUUID)
+		delete(w.booted, domainUUID)
+	}
+}
+
+type eventsCollector struct {
+	definitionChanges typedDesc
+	events            typedDesc
+	lastEvent         typedDesc
+	deviceEvents      typedDesc
+	moved             infoDesc
+	bootTime          typedDesc
+	logger            log.Logger
+}
+
+func init() {
+	registerCollector("events", defaultEnabled, NewEventsCollector)
+}
+
+func NewEventsCollector(logger log.Logger) (Collector, error) {
+	return &eventsCollector{
+		definitionChanges: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "domain", "definition_changes_total"),
+				"Number of times the domain was defined, redefined or undefined since the exporter started",
+				[]string{"domain_uuid"},
+				nil),
+			valueType: prometheus.CounterValue,
+		},
+		events: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "domain", "events_total"),
+				"Number of lifecycle events of the domain since the exporter started",
+				[]string{"domain_uuid", "event"},
+				nil),
+			valueType: prometheus.CounterValue,
+		},
+		lastEvent: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "domain", "last_event_timestamp_seconds"),
+				"Time of the last lifecycle event of the domain since unix epoch in seconds",
+				[]string{"domain_uuid"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		deviceEvents: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "domain", "device_events_total"),
+				"Number of device hotplug events of the domain since the exporter started, event is added, removed or removal_failed",
+				[]string{"domain_uuid", "device_type", "event"},
+				nil),
+			valueType: prometheus.CounterValue,
+		},
+		moved: newInfoDesc("domain", "moved_info",
+			"Domain migrated away from from_host or to to_host within --collector.events.moved-grace-period, the other side is empty as only the host of that side knows it",
+			"domain_uuid", "from_host", "to_host"),
+		bootTime: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "domain", "boot_timestamp_seconds"),
+				"Time the domain last booted or rebooted since unix epoch in seconds, from lifecycle events or else the start of its QEMU process",
+				[]string{"domain_uuid"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		logger: logger,
+	}, nil
+}
+
+func (c *eventsCollector) descs() []typedDesc {
+	return []typedDesc{c.definitionChanges, c.events, c.lastEvent, c.deviceEvents, c.moved.typedDesc, c.bootTime}
+}
+
+func (c *eventsCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	infos := newInfoMetrics(ch)
+
+	booted := c.updateBootTime(ch, config.lvDomains, config.local)
+	var defined map[string]bool
+	if config.pLibvirt != nil && config.pLibvirt.IsConnected() {
+		// All domains, inactive and filtered ones included, so the
+		// counters of stopped domains are kept.
+		domains, _, err := config.pLibvirt.ConnectListAllDomains(1, 0)
+		if err != nil {
+			level.Warn(c.logger).Log("msg", "failed to list domains, keeping the counters of all domains", "err", err)
+		} else {
+			defined = make(map[string]bool, len(domains))
+			for _, domain := range domains {
+				defined[uuidString(domain.UUID)] = true
+			}
+		}
+	}
+	domainEvents.mtx.Lock()
+	defer domainEvents.mtx.Unlock()
+	if defined != nil {
+		domainEvents.forget(defined)
+	}
+	if len(domainEvents.definitionChanges) == 0 && len(domainEvents.events) == 0 && len(domainEvents.devices) == 0 && booted == 0 {
+		return ErrNoData
+	}
+	for domainUUID, changes := range domainEvents.definitionChanges {
+		ch <- c.definitionChanges.mustNewConstMetric(changes, domainUUID)
+	}
+	for domainUUID, events := range domainEvents.events {
+		for event, count := range events {
+			ch <- c.events.mustNewConstMetric(count, domainUUID, event)
+		}
+	}
+	for domainUUID, at := range domainEvents.lastEvent {
+		ch <- c.lastEvent.mustNewConstMetric(float64(at.UnixNano())/1e9, domainUUID)
+	}
+	for domainUUID, events := range domainEvents.devices {
+		for key, count := range events {
+			ch <- c.deviceEvents.mustNewConstMetric(count, domainUUID, key.deviceType, key.event)
+		}
+	}
+	for domainUUID, move := range domainEvents.moved {
+		if time.Since(move.at) > movedGracePeriod.Get() {
+			delete(domainEvents.moved, domainUUID)
+			continue
+		}
+		infos.emit(c.moved, domainUUID, move.fromHost, move.toHost)
+	}
+	return nil
+}
+
+// updateBootTime exposes the boot time of the running domains and returns
+// the number of domains it is known of. Domains which did not boot since the
+// exporter started fall back to the start of their QEMU process for local
+// connections, which is later than the boot if the domain was migrated to
+// the host.
+func (c *eventsCollector) updateBootTime(ch chan<- prometheus.Metric, lvDomains []libvirt_schema.LvDomain, local bool) int {
+	domainEvents.mtx.Lock()
+	booted := make(map[string]time.Time, len(domainEvents.booted))
+	for domainUUID, at := range domainEvents.booted {
+		booted[domainUUID] = at
+	}
+	domainEvents.mtx.Unlock()
+
+	var fs procfs.FS
+	if local {
+		fs, _ = procfs.NewDefaultFS()
+	}
+	n := 0
+	for _, lvDomain := range lvDomains {
+		domainUUID := lvDomain.Schema.UUID
+		bootTime := -1.0
+		if at, ok := booted[domainUUID]; ok {
+			bootTime = float64(at.UnixNano()) / 1e9
+		}
+		if local {
+			// A QEMU process started after the recorded boot means the
+			// domain was restarted while the exporter was down.
+			if startTime, err := qemuStartTime(fs, lvDomain.Domain.Name); err == nil {
+				bootTime = max(bootTime, startTime)
+			} else {
+				level.Debug(c.logger).Log("msg", "failed to get qemu start time", "domain", lvDomain.Domain.Name, "err", err)
+			}
+		}
+		if bootTime < 0 {
+			continue
+		}
+		ch <- c.bootTime.mustNewConstMetric(bootTime, domainUUID)
+		n++
+	}
+	return n
+}
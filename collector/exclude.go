@@ -0,0 +1,77 @@
+package collector
+
+import (
+	"regexp"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// excludeCollector drops the metrics of the wrapped collector whose name
+// matches a regular expression.
+type excludeCollector struct {
+	prometheus.Collector
+	exclude *regexp.Regexp
+
+	mtx      sync.Mutex
+	excluded map[*prometheus.Desc]bool
+}
+
+// NewExcludeCollector wraps c so that metrics with a fully-qualified name
+// matching exclude are dropped before they are emitted.
+func NewExcludeCollector(c prometheus.Collector, exclude *regexp.Regexp) prometheus.Collector {
+	return &excludeCollector{
+		Collector: c,
+		exclude:   exclude,
+		excluded:  make(map[*prometheus.Desc]bool),
+	}
+}
+
+// Describe implements the prometheus.Collector interface.
+func (c *excludeCollector) Describe(ch chan<- *prometheus.Desc) {
+	in := make(chan *prometheus.Desc)
+	go func() {
+		c.Collector.Describe(in)
+		close(in)
+	}()
+	for desc := range in {
+		if !c.isExcluded(desc) {
+			ch <- desc
+		}
+	}
+}
+
+// Collect implements the prometheus.Collector interface.
+func (c *excludeCollector) Collect(ch chan<- prometheus.Metric) {
+	in := make(chan prometheus.Metric)
+	go func() {
+		c.Collector.Collect(in)
+		close(in)
+	}()
+	for m := range in {
+		if !c.isExcluded(m.Desc()) {
+			ch <- m
+		}
+	}
+}
+
+// isExcluded matches the name of desc, the result is cached as descriptors
+// are created once per collector.
+func (c *excludeCollector) isExcluded(desc *prometheus.Desc) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	excluded, ok := c.excluded[desc]
+	if !ok {
+		excluded = c.exclude.MatchString(descName(desc))
+		c.excluded[desc] = excluded
+	}
+	return excluded
+}
+
+// descName returns the fully-qualified name of desc, or an empty string if
+// it cannot be determined.
+func descName(desc *prometheus.Desc) string {
+	descInfosMtx.Lock()
+	defer descInfosMtx.Unlock()
+	return descInfos[desc].name
+}
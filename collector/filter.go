@@ -0,0 +1,120 @@
+package collector
+
+import (
+	"regexp"
+	"sync/atomic"
+
+	"github.com/alecthomas/kingpin/v2"
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// anchoredRegexp is a kingpin value holding a regexp filter of devices or
+// domains. Like relabeling rules the regexp is anchored, an empty regexp
+// matches nothing. It may be changed by a config reload while the event
+// watcher uses it, so the regexp is swapped atomically.
+type anchoredRegexp struct {
+	filter atomic.Pointer[regexpFilter]
+}
+
+type regexpFilter struct {
+	source string
+	re     *regexp.Regexp
+}
+
+func regexpFlag(name, help string) *anchoredRegexp {
+	r := &anchoredRegexp{}
+	kingpin.Flag(name, help).PlaceHolder("REGEXP").SetValue(r)
+	return r
+}
+
+// Set implements kingpin.Value.
+func (r *anchoredRegexp) Set(value string) error {
+	if value == "" {
+		r.filter.Store(nil)
+		return nil
+	}
+	re, err := regexp.Compile("^(?:" + value + ")$")
+	if err != nil {
+		return err
+	}
+	r.filter.Store(&regexpFilter{source: value, re: re})
+	return nil
+}
+
+// String implements kingpin.Value.
+func (r *anchoredRegexp) String() string {
+	if f := r.filter.Load(); f != nil {
+		return f.source
+	}
+	return ""
+}
+
+// empty reports whether no regexp is set.
+func (r *anchoredRegexp) empty() bool {
+	return r.filter.Load() == nil
+}
+
+// matches reports whether s matches the regexp.
+func (r *anchoredRegexp) matches(s string) bool {
+	f := r.filter.Load()
+	return f != nil && f.re.MatchString(s)
+}
+
+var (
+	domainInclude = regexpFlag(
+		"domain.include",
+		"Regexp of domain names or UUIDs to collect metrics for, all domains if empty",
+	)
+	domainExclude = regexpFlag(
+		"domain.exclude",
+		"Regexp of domain names or UUIDs to ignore, e.g. ci-.* for ephemeral CI domains, applied after --domain.include",
+	)
+)
+
+var domainsActiveOnly = reloadableBool(kingpin.Flag(
+	"domains.active-only",
+	"Ignore inactive domains in the collectors which include them, allocation and savedstate. The scrape itself only lists active domains.",
+).Default("false"))
+
+// domainListFlags returns the flags listing the domains of collectors which
+// cover inactive domains as well, all domains unless --domains.active-only is
+// set.
+func domainListFlags() libvirt.ConnectListAllDomainsFlags {
+	if domainsActiveOnly.Get() {
+		return libvirt.ConnectListDomainsActive
+	}
+	return 0
+}
+
+var domainsExcludedDesc = newMetricDesc(
+	prometheus.BuildFQName(namespace, "", "domains_excluded"),
+	"Number of active domains ignored by --domain.include and --domain.exclude.",
+	nil,
+	nil,
+)
+
+// domainSelected reports whether the domain passes --domain.include and
+// --domain.exclude. Filtered domains are dropped right after listing, so no
+// collector exposes them and no RPCs are spent on them.
+func domainSelected(domain libvirt.Domain) bool {
+	domainUUID := uuidString(domain.UUID)
+	if !domainInclude.empty() && !domainInclude.matches(domain.Name) && !domainInclude.matches(domainUUID) {
+		return false
+	}
+	return !domainExclude.matches(domain.Name) && !domainExclude.matches(domainUUID)
+}
+
+// selectDomains returns the domains passing the domain filters.
+func selectDomains(domains []libvirt.Domain) []libvirt.Domain {
+	if domainInclude.empty() && domainExclude.empty() {
+		return domains
+	}
+	selected := make([]libvirt.Domain, 0, len(domains))
+	for _, domain := range domains {
+		if domainSelected(domain) {
+			selected = append(selected, domain)
+		}
+	}
+	return selected
+}
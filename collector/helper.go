@@ -14,20 +14,13 @@
 package collector
 
 import (
+	"fmt"
 	"regexp"
-)
+	"strconv"
+	"strings"
 
-// func readUintFromFile(path string) (uint64, error) {
-// 	data, err := os.ReadFile(path)
-// 	if err != nil {
-// 		return 0, err
-// 	}
-// 	value, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
-// 	if err != nil {
-// 		return 0, err
-// 	}
-// 	return value, nil
-// }
+	libvirt "github.com/digitalocean/go-libvirt"
+)
 
 var metricNameRegex = regexp.MustCompile(`_*[^0-9A-Za-z_]+_*`)
 
@@ -45,3 +38,58 @@ var metricNameRegex = regexp.MustCompile(`_*[^0-9A-Za-z_]+_*`)
 func SanitizeMetricName(metricName string) string {
 	return metricNameRegex.ReplaceAllString(metricName, "_")
 }
+
+// uuidString formats a libvirt UUID the same way it appears in the domain XML.
+func uuidString(uuid libvirt.UUID) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
+
+// typedParamValue returns the numeric value of the named typed parameter.
+func typedParamValue(params []libvirt.TypedParam, field string) (float64, bool) {
+	for _, p := range params {
+		if p.Field != field {
+			continue
+		}
+		switch v := p.Value.I.(type) {
+		case int32:
+			return float64(v), true
+		case uint32:
+			return float64(v), true
+		case int64:
+			return float64(v), true
+		case uint64:
+			return float64(v), true
+		case float64:
+			return v, true
+		case bool:
+			if v {
+				return 1, true
+			}
+			return 0, true
+		}
+		return 0, false
+	}
+	return 0, false
+}
+
+// cpusetString formats a libvirt CPU bitmap the way libvirt formats cpusets
+// in XML, e.g. "0-3,8".
+func cpusetString(cpumap []byte) string {
+	var ranges []string
+	start := -1
+	for cpu := 0; cpu <= len(cpumap)*8; cpu++ {
+		set := cpu < len(cpumap)*8 && cpumap[cpu/8]&(1<<(cpu%8)) != 0
+		switch {
+		case set && start < 0:
+			start = cpu
+		case !set && start >= 0:
+			if cpu-1 == start {
+				ranges = append(ranges, strconv.Itoa(start))
+			} else {
+				ranges = append(ranges, fmt.Sprintf("%d-%d", start, cpu-1))
+			}
+			start = -1
+		}
+	}
+	return strings.Join(ranges, ",")
+}
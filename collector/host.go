@@ -0,0 +1,104 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/nee541/libvirt-exporter/libvirt_schema"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var dmiPath = reloadableString(kingpin.Flag(
+	"collector.host.dmi-path",
+	"sysfs DMI directory read by the host collector if libvirt provides no SMBIOS information, only used for local connections.",
+).Default("/sys/class/dmi/id"))
+
+// hostCollector exposes hardware inventory information about the hypervisor,
+// so inventory joins work in deployments without node_exporter.
+type hostCollector struct {
+	info   infoDesc
+	logger log.Logger
+}
+
+func init() {
+	registerCollector("host", defaultEnabled, NewHostCollector)
+}
+
+func NewHostCollector(logger log.Logger) (Collector, error) {
+	return &hostCollector{
+		info: newInfoDesc("host", "info",
+			"Hardware information of the host from SMBIOS",
+			"vendor", "model", "serial", "sku", "bios_vendor", "bios_version"),
+		logger: logger,
+	}, nil
+}
+
+func (c *hostCollector) descs() []typedDesc {
+	return []typedDesc{c.info.typedDesc}
+}
+
+func (c *hostCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	infos := newInfoMetrics(ch)
+
+	if config.pLibvirt == nil {
+		level.Error(c.logger).Log("msg", "libvirt not created")
+		return ErrNotProvided
+	}
+	if !config.pLibvirt.IsConnected() {
+		level.Error(c.logger).Log("msg", "libvirt not connected")
+		return ErrNotProvided
+	}
+
+	var labels []string
+	xmlDesc, err := config.pLibvirt.ConnectGetSysinfo(0)
+	if err == nil {
+		sysinfo, err := libvirt_schema.NewSysinfoFromXML([]byte(xmlDesc))
+		if err != nil {
+			return err
+		}
+		labels = []string{
+			libvirt_schema.Lookup(sysinfo.System, "manufacturer"),
+			libvirt_schema.Lookup(sysinfo.System, "product"),
+			libvirt_schema.Lookup(sysinfo.System, "serial"),
+			libvirt_schema.Lookup(sysinfo.System, "sku"),
+			libvirt_schema.Lookup(sysinfo.BIOS, "vendor"),
+			libvirt_schema.Lookup(sysinfo.BIOS, "version"),
+		}
+	} else {
+		level.Debug(c.logger).Log("msg", "failed to get sysinfo", "err", err)
+		if !config.local {
+			return ErrNoData
+		}
+		// Reading sysfs only describes the hypervisor if libvirt is local.
+		labels = []string{
+			readDMI("sys_vendor"),
+			readDMI("product_name"),
+			readDMI("product_serial"),
+			readDMI("product_sku"),
+			readDMI("bios_vendor"),
+			readDMI("bios_version"),
+		}
+	}
+	for i := range labels {
+		labels[i] = strings.TrimSpace(labels[i])
+	}
+	infos.emit(c.info, labels...)
+	return nil
+}
+
+// readDMI reads a DMI attribute, some of which are only readable by root.
+func readDMI(name string) string {
+	data, err := os.ReadFile(filepath.Join(dmiPath.Get(), name))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
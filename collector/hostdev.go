@@ -0,0 +1,263 @@
+package collector
+
+import (
+	"context"
+	"strconv"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/nee541/libvirt-exporter/libvirt_schema"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const hostdevSubsystemName = "host_pci"
+
+// PCI base classes of the device kinds counted by the hostdev collector.
+const (
+	pciClassNetwork = 0x02
+	pciClassDisplay = 0x03
+)
+
+// hostdevCollector exposes the PCI devices of the host which can be passed
+// through to domains, GPUs, NICs and SR-IOV virtual functions, the mediated
+// devices (vGPUs) created on them, and which of them are assigned to running
+// domains, for placement decisions.
+type hostdevCollector struct {
+	devices       typedDesc
+	deviceInfo    infoDesc
+	vfs           typedDesc
+	vfsMax        typedDesc
+	vfsFree       typedDesc
+	assigned      infoDesc
+	mdevAvailable typedDesc
+	mdevs         typedDesc
+	mdevAssigned  infoDesc
+	logger        log.Logger
+}
+
+func init() {
+	registerCollector("hostdev", defaultDisabled, NewHostdevCollector)
+}
+
+// NewHostdevCollector returns a new Collector exposing host PCI devices.
+func NewHostdevCollector(logger log.Logger) (Collector, error) {
+	newDesc := func(subsystem, name, help string, labels ...string) typedDesc {
+		return typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, subsystem, name),
+				help,
+				labels,
+				nil),
+			valueType: prometheus.GaugeValue,
+		}
+	}
+	return &hostdevCollector{
+		devices: newDesc(hostdevSubsystemName, "devices", "Number of PCI devices of the host by kind: gpu, nic or vf (SR-IOV virtual function)", "kind"),
+		deviceInfo: newInfoDesc(hostdevSubsystemName, "device_info",
+			"PCI devices of the host of kind gpu, nic or vf, driver is vfio-pci for devices ready to be passed through",
+			"pci_address", "kind", "vendor", "product", "driver"),
+		vfs:     newDesc(hostdevSubsystemName, "vfs", "Number of virtual functions enabled on the SR-IOV physical function", "pf"),
+		vfsMax:  newDesc(hostdevSubsystemName, "vfs_max", "Maximum number of virtual functions of the SR-IOV physical function", "pf"),
+		vfsFree: newDesc(hostdevSubsystemName, "vfs_free", "Number of virtual functions of the SR-IOV physical function not assigned to a running domain", "pf"),
+		assigned: newInfoDesc("domain", "hostdev_info",
+			"PCI devices of the host assigned to the domain, kind is empty for devices other than gpu, nic or vf",
+			"domain_uuid", "pci_address", "kind"),
+		mdevAvailable: newDesc("host_mdev", "available_instances",
+			"Number of mediated devices of the type which can still be created on the parent device, e.g. vGPUs of a profile on a GPU",
+			"parent", "type", "name"),
+		mdevs: newDesc("host_mdev", "devices",
+			"Number of mediated devices of the type created on the parent device",
+			"parent", "type"),
+		mdevAssigned: newInfoDesc("domain", "mdev_info",
+			"Mediated devices assigned to the domain, e.g. vGPUs, with their type and parent device",
+			"domain_uuid", "mdev_uuid", "type", "parent"),
+		logger: logger,
+	}, nil
+}
+
+func (c *hostdevCollector) descs() []typedDesc {
+	return []typedDesc{
+		c.devices,
+		c.deviceInfo.typedDesc,
+		c.vfs,
+		c.vfsMax,
+		c.vfsFree,
+		c.assigned.typedDesc,
+		c.mdevAvailable,
+		c.mdevs,
+		c.mdevAssigned.typedDesc,
+	}
+}
+
+func (c *hostdevCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	infos := newInfoMetrics(ch)
+
+	if config.pLibvirt == nil {
+		level.Error(c.logger).Log("msg", "libvirt not created")
+		return ErrNotProvided
+	}
+	if !config.pLibvirt.IsConnected() {
+		level.Error(c.logger).Log("msg", "libvirt not connected")
+		return ErrNotProvided
+	}
+	ctx := config.context()
+	pLibvirt := config.pLibvirt
+
+	// assignedTo maps the PCI addresses passed through to running domains to
+	// the domain UUID.
+	assignedTo := make(map[string]string)
+	// mdevAssignedTo maps the UUIDs of mediated devices to the domain UUID.
+	mdevAssignedTo := make(map[string]string)
+	for _, lvDomain := range config.lvDomains {
+		for _, hostdev := range lvDomain.Schema.Devices.Hostdevs {
+			if hostdev.Source.Address == nil {
+				continue
+			}
+			switch hostdev.Type {
+			case "pci":
+				assignedTo[hostdev.Source.Address.String()] = lvDomain.Schema.UUID
+			case "mdev":
+				mdevAssignedTo[hostdev.Source.Address.UUID] = lvDomain.Schema.UUID
+			}
+		}
+		for _, iface := range lvDomain.Schema.Devices.Interfaces {
+			if iface.Type == "hostdev" && iface.Source.Address != nil {
+				assignedTo[iface.Source.Address.String()] = lvDomain.Schema.UUID
+			}
+		}
+	}
+
+	devices, _, err := pLibvirt.ConnectListAllNodeDevices(1, uint32(libvirt.ConnectListNodeDevicesCapPciDev))
+	if err != nil {
+		return err
+	}
+	counts := map[string]int{"gpu": 0, "nic": 0, "vf": 0}
+	kinds := make(map[string]string)
+	// addresses maps node device names to PCI addresses.
+	addresses := make(map[string]string)
+	for _, device := range devices {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		xmlDesc, err := pLibvirt.NodeDeviceGetXMLDesc(device.Name, 0)
+		if err != nil {
+			// The device may have been removed since it was listed.
+			level.Debug(c.logger).Log("msg", "failed to get node device xml", "device", device.Name, "err", err)
+			continue
+		}
+		schema, err := libvirt_schema.NewNodeDeviceFromXML([]byte(xmlDesc))
+		if err != nil {
+			level.Error(c.logger).Log("msg", "failed to parse node device xml", "device", device.Name, "err", err)
+			continue
+		}
+		pci := schema.Capability
+		if pci.Type != "pci" {
+			continue
+		}
+		address := pci.Address()
+		addresses[schema.Name] = address
+
+		if mdevTypes, ok := pci.Nested("mdev_types"); ok {
+			for _, mdevType := range mdevTypes.MdevTypes {
+				ch <- c.mdevAvailable.mustNewConstMetric(float64(mdevType.AvailableInstances), address, mdevType.ID, mdevType.Name)
+			}
+		}
+
+		if virtfns, ok := pci.Nested("virt_functions"); ok && virtfns.MaxCount > 0 {
+			free := 0
+			for _, vf := range virtfns.Addresses {
+				if _, ok := assignedTo[vf.String()]; !ok {
+					free++
+				}
+			}
+			ch <- c.vfs.mustNewConstMetric(float64(len(virtfns.Addresses)), address)
+			ch <- c.vfsMax.mustNewConstMetric(float64(virtfns.MaxCount), address)
+			ch <- c.vfsFree.mustNewConstMetric(float64(free), address)
+		}
+
+		kind := pciDeviceKind(pci)
+		if kind == "" {
+			continue
+		}
+		kinds[address] = kind
+		counts[kind]++
+		infos.emit(c.deviceInfo, address, kind, pci.Vendor.Name, pci.Product.Name, schema.Driver.Name)
+	}
+	for kind, count := range counts {
+		ch <- c.devices.mustNewConstMetric(float64(count), kind)
+	}
+	for address, domainUUID := range assignedTo {
+		infos.emit(c.assigned, domainUUID, address, kinds[address])
+	}
+	return c.updateMdevs(ctx, ch, infos, pLibvirt, addresses, mdevAssignedTo)
+}
+
+// updateMdevs counts the mediated devices by parent and type and exposes the
+// ones assigned to running domains.
+func (c *hostdevCollector) updateMdevs(ctx context.Context, ch chan<- prometheus.Metric, infos *infoMetrics, pLibvirt *libvirt.Libvirt, addresses map[string]string, mdevAssignedTo map[string]string) error {
+	devices, _, err := pLibvirt.ConnectListAllNodeDevices(1, uint32(libvirt.ConnectListNodeDevicesCapMdev))
+	if IsUnsupportedError(err) {
+		level.Debug(c.logger).Log("msg", "libvirt does not list mediated devices", "err", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	type mdevKey struct {
+		parent   string
+		mdevType string
+	}
+	counts := make(map[mdevKey]int)
+	for _, device := range devices {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		xmlDesc, err := pLibvirt.NodeDeviceGetXMLDesc(device.Name, 0)
+		if err != nil {
+			level.Debug(c.logger).Log("msg", "failed to get node device xml", "device", device.Name, "err", err)
+			continue
+		}
+		schema, err := libvirt_schema.NewNodeDeviceFromXML([]byte(xmlDesc))
+		if err != nil {
+			level.Error(c.logger).Log("msg", "failed to parse node device xml", "device", device.Name, "err", err)
+			continue
+		}
+		parent, ok := addresses[schema.Parent]
+		if !ok {
+			parent = schema.Parent
+		}
+		mdevType := schema.Capability.MdevType.ID
+		counts[mdevKey{parent, mdevType}]++
+		if domainUUID, ok := mdevAssignedTo[schema.MdevUUID()]; ok {
+			infos.emit(c.mdevAssigned, domainUUID, schema.MdevUUID(), mdevType, parent)
+		}
+	}
+	for key, count := range counts {
+		ch <- c.mdevs.mustNewConstMetric(float64(count), key.parent, key.mdevType)
+	}
+	return nil
+}
+
+// pciDeviceKind classifies a PCI device as gpu, nic or vf, or returns an
+// empty string for other devices.
+func pciDeviceKind(pci libvirt_schema.NodeDeviceCapability) string {
+	if _, ok := pci.Nested("phys_function"); ok {
+		return "vf"
+	}
+	class, err := strconv.ParseUint(pci.Class, 0, 32)
+	if err != nil {
+		return ""
+	}
+	switch class >> 16 {
+	case pciClassDisplay:
+		return "gpu"
+	case pciClassNetwork:
+		return "nic"
+	}
+	return ""
+}
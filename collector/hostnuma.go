@@ -0,0 +1,93 @@
+package collector
+
+import (
+	"strconv"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/nee541/libvirt-exporter/libvirt_schema"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// hostNumaCollector exposes the total and free memory of the host NUMA cells,
+// to tell which cell still has room for a pinned domain. Free huge pages by
+// cell are exposed by the hugepages collector.
+type hostNumaCollector struct {
+	total  typedDesc
+	free   typedDesc
+	logger log.Logger
+}
+
+func init() {
+	registerCollector("host_numa", defaultEnabled, NewHostNumaCollector)
+}
+
+// NewHostNumaCollector returns a new Collector exposing host NUMA memory.
+func NewHostNumaCollector(logger log.Logger) (Collector, error) {
+	return &hostNumaCollector{
+		total: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "host_numa", "memory_total_bytes"),
+				"Memory of the host NUMA cell",
+				[]string{"node"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		free: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "host_numa", "memory_free_bytes"),
+				"Free memory of the host NUMA cell",
+				[]string{"node"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		logger: logger,
+	}, nil
+}
+
+func (c *hostNumaCollector) descs() []typedDesc {
+	return []typedDesc{c.total, c.free}
+}
+
+func (c *hostNumaCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	if config.pLibvirt == nil {
+		level.Error(c.logger).Log("msg", "libvirt not created")
+		return ErrNotProvided
+	}
+	if !config.pLibvirt.IsConnected() {
+		level.Error(c.logger).Log("msg", "libvirt not connected")
+		return ErrNotProvided
+	}
+
+	xmlDesc, err := config.pLibvirt.ConnectGetCapabilities()
+	if err != nil {
+		return err
+	}
+	capabilities, err := libvirt_schema.NewCapabilitiesFromXML([]byte(xmlDesc))
+	if err != nil {
+		return err
+	}
+	if len(capabilities.Host.Cells) == 0 {
+		return ErrNoData
+	}
+
+	for _, cell := range capabilities.Host.Cells {
+		node := strconv.FormatUint(uint64(cell.ID), 10)
+		ch <- c.total.mustNewConstMetric(float64(cell.Memory.Bytes()), node)
+		// Cell IDs need not be contiguous, so every cell is queried
+		// on its own.
+		free, err := config.pLibvirt.NodeGetCellsFreeMemory(int32(cell.ID), 1)
+		if err != nil {
+			return err
+		}
+		if len(free) > 0 {
+			ch <- c.free.mustNewConstMetric(float64(free[0]), node)
+		}
+	}
+	return nil
+}
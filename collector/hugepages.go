@@ -0,0 +1,142 @@
+package collector
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/nee541/libvirt-exporter/libvirt_schema"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// hugepagesCollector exposes the total and free huge pages of the host by
+// NUMA cell and page size, as exhausting e.g. the 1GiB pages of a single cell
+// blocks domain starts even if the host has enough pages in total.
+type hugepagesCollector struct {
+	total  typedDesc
+	free   typedDesc
+	logger log.Logger
+
+	mtx   sync.Mutex
+	cells []hugepagesCell
+	at    time.Time
+}
+
+// hugepagesCell holds the huge page sizes of a NUMA cell and their totals.
+type hugepagesCell struct {
+	id     uint32
+	sizes  []uint32
+	totals []uint64
+}
+
+// hugepagesTopologyTTL is how long the huge page topology read from the host
+// capabilities is cached. Building the capabilities is expensive for libvirt,
+// and the pools are rarely resized.
+const hugepagesTopologyTTL = 10 * time.Minute
+
+func init() {
+	registerCollector("hugepages", defaultDisabled, NewHugepagesCollector)
+}
+
+// NewHugepagesCollector returns a new Collector exposing host huge pages.
+func NewHugepagesCollector(logger log.Logger) (Collector, error) {
+	return &hugepagesCollector{
+		total: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "host", "hugepages_total"),
+				"Number of huge pages of the NUMA cell by page size",
+				[]string{"node", "size_bytes"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		free: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "host", "hugepages_free"),
+				"Number of free huge pages of the NUMA cell by page size",
+				[]string{"node", "size_bytes"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		logger: logger,
+	}, nil
+}
+
+func (c *hugepagesCollector) descs() []typedDesc {
+	return []typedDesc{c.total, c.free}
+}
+
+func (c *hugepagesCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	if config.pLibvirt == nil {
+		level.Error(c.logger).Log("msg", "libvirt not created")
+		return ErrNotProvided
+	}
+	if !config.pLibvirt.IsConnected() {
+		level.Error(c.logger).Log("msg", "libvirt not connected")
+		return ErrNotProvided
+	}
+
+	cells, err := c.topology(config.pLibvirt)
+	if err != nil {
+		return err
+	}
+	for _, cell := range cells {
+		free, err := config.pLibvirt.NodeGetFreePages(cell.sizes, int32(cell.id), 1, 0)
+		if err != nil {
+			return err
+		}
+		node := strconv.FormatUint(uint64(cell.id), 10)
+		for i, size := range cell.sizes {
+			sizeBytes := strconv.FormatUint(uint64(size)*1024, 10)
+			ch <- c.total.mustNewConstMetric(float64(cell.totals[i]), node, sizeBytes)
+			if i < len(free) {
+				ch <- c.free.mustNewConstMetric(float64(free[i]), node, sizeBytes)
+			}
+		}
+	}
+	return nil
+}
+
+// topology returns the NUMA cells with huge pages, read from the host
+// capabilities at most every hugepagesTopologyTTL.
+func (c *hugepagesCollector) topology(pLibvirt *libvirt.Libvirt) ([]hugepagesCell, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.cells != nil && time.Since(c.at) < hugepagesTopologyTTL {
+		return c.cells, nil
+	}
+
+	xmlDesc, err := pLibvirt.ConnectGetCapabilities()
+	if err != nil {
+		return nil, err
+	}
+	capabilities, err := libvirt_schema.NewCapabilitiesFromXML([]byte(xmlDesc))
+	if err != nil {
+		return nil, err
+	}
+	cells := []hugepagesCell{}
+	for _, cell := range capabilities.Host.Cells {
+		// The smallest page size of a cell is the base page size, which
+		// is no huge page.
+		hugepages := hugepagesCell{id: cell.ID}
+		for i, pages := range cell.Pages {
+			if i == 0 || pages.Size <= cell.Pages[0].Size {
+				continue
+			}
+			hugepages.sizes = append(hugepages.sizes, pages.Size)
+			hugepages.totals = append(hugepages.totals, pages.Count)
+		}
+		if len(hugepages.sizes) > 0 {
+			cells = append(cells, hugepages)
+		}
+	}
+	c.cells, c.at = cells, time.Now()
+	return cells, nil
+}
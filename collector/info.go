@@ -0,0 +1,77 @@
+package collector
+
+import (
+	"strconv"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// infoCollector exposes the stable configuration of domains as labels of a
+// single series per domain, so other metrics stay low-cardinality and can be
+// joined with it on domain_uuid.
+type infoCollector struct {
+	info         infoDesc
+	graphicsInfo infoDesc
+	logger       log.Logger
+}
+
+// infoLabels are the labels of libvirt_domain_info before the labels added
+// by the enricher, see SetEnricher.
+var infoLabels = []string{"domain_uuid", "name", "title", "virt_type", "os_type", "arch", "machine"}
+
+func init() {
+	registerCollector("info", defaultEnabled, NewInfoCollector)
+}
+
+// NewInfoCollector returns a new Collector exposing domain information.
+func NewInfoCollector(logger log.Logger) (Collector, error) {
+	return &infoCollector{
+		info: newInfoDesc("domain", "info",
+			"Configuration of the domain",
+			append(append([]string{}, infoLabels...), enrichmentLabels()...)...),
+		graphicsInfo: newInfoDesc("domain", "graphics_info",
+			"Graphical consoles of the domain, type is e.g. vnc or spice, listen the address or unix socket, port and tls_port are empty if not used",
+			"domain_uuid", "type", "listen", "port", "tls_port"),
+		logger: logger,
+	}, nil
+}
+
+func (c *infoCollector) descs() []typedDesc {
+	return []typedDesc{c.info.typedDesc, c.graphicsInfo.typedDesc}
+}
+
+func (c *infoCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	infos := newInfoMetrics(ch)
+
+	if len(config.lvDomains) == 0 {
+		level.Debug(c.logger).Log("msg", "no domains found")
+		return ErrNotProvided
+	}
+	for _, lvDomain := range config.lvDomains {
+		schema := lvDomain.Schema
+		values := []string{schema.UUID, schema.Name, schema.Title, schema.Type, schema.OS.Type.Value, schema.OS.Type.Arch, schema.OS.Type.Machine}
+		if enrichment != nil {
+			values = append(values, enrichment.values(schema.UUID)...)
+		}
+		infos.emit(c.info, values...)
+		for _, graphics := range schema.Devices.Graphics {
+			infos.emit(c.graphicsInfo, schema.UUID, graphics.Type, graphics.ListenAddress(), graphicsPort(graphics.Port), graphicsPort(graphics.TLSPort))
+		}
+	}
+	return nil
+}
+
+// graphicsPort formats a console port, libvirt uses -1 and 0 for unused or
+// not yet allocated ports.
+func graphicsPort(port int) string {
+	if port <= 0 {
+		return ""
+	}
+	return strconv.Itoa(port)
+}
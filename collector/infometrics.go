@@ -0,0 +1,77 @@
+package collector
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var infoLabelPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// infoDesc describes an info metric, a gauge of constant value 1 whose labels
+// carry the information. Create it with newInfoDesc and emit it through
+// infoMetrics.
+type infoDesc struct {
+	typedDesc
+}
+
+// newInfoDesc returns the descriptor of the info metric
+// libvirt_<subsystem>_<name>. It enforces the conventions all info metrics
+// share: the name ends in info, labels are snake_case and unique, and
+// domain_uuid comes first, so info metrics join alike on domain_uuid.
+// Violations are programming errors and panic when the collector is created.
+func newInfoDesc(subsystem, name, help string, labels ...string) infoDesc {
+	if name != "info" && !strings.HasSuffix(name, "_info") {
+		panic(fmt.Sprintf("info metric %s does not end in info", name))
+	}
+	seen := make(map[string]bool, len(labels))
+	for i, label := range labels {
+		if !infoLabelPattern.MatchString(label) || seen[label] {
+			panic(fmt.Sprintf("info metric %s has an invalid or duplicate label %q", name, label))
+		}
+		if label == "domain_uuid" && i != 0 {
+			panic(fmt.Sprintf("info metric %s must have domain_uuid as first label", name))
+		}
+		seen[label] = true
+	}
+	return infoDesc{typedDesc{
+		desc:      newMetricDesc(prometheus.BuildFQName(namespace, subsystem, name), help, labels, nil),
+		valueType: prometheus.GaugeValue,
+	}}
+}
+
+type infoKey struct {
+	desc        *prometheus.Desc
+	labelValues string
+}
+
+// infoMetrics emits the info metrics of one collector run. Series with a
+// label set already emitted in the run are dropped, e.g. two huge page
+// elements of the same size, as duplicates would fail the whole scrape.
+// It is safe for concurrent use.
+type infoMetrics struct {
+	ch   chan<- prometheus.Metric
+	mtx  sync.Mutex
+	seen map[infoKey]bool
+}
+
+func newInfoMetrics(ch chan<- prometheus.Metric) *infoMetrics {
+	return &infoMetrics{ch: ch, seen: make(map[infoKey]bool)}
+}
+
+// emit sends the info metric with the given label values, unless it was
+// already sent.
+func (m *infoMetrics) emit(d infoDesc, labelValues ...string) {
+	key := infoKey{d.desc, strings.Join(labelValues, "\xff")}
+	m.mtx.Lock()
+	if m.seen[key] {
+		m.mtx.Unlock()
+		return
+	}
+	m.seen[key] = true
+	m.mtx.Unlock()
+	m.ch <- d.mustNewConstMetric(1, labelValues...)
+}
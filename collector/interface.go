@@ -1,16 +1,37 @@
 package collector
 
 import (
+	"context"
+	"strconv"
+	"strings"
 	"sync"
 
+	"github.com/alecthomas/kingpin/v2"
 	libvirt "github.com/digitalocean/go-libvirt"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/nee541/libvirt-exporter/libvirt_schema"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 const interfaceSubsystemName = "domain_interface"
 
+var interfaceAddressSource = reloadableEnum(kingpin.Flag(
+	"collector.interface.address-source",
+	"Source of the interface addresses of domains: lease (libvirt DHCP leases), agent (QEMU guest agent), arp (host ARP table) or none.",
+).Default("lease"), "lease", "agent", "arp", "none")
+
+var interfaceDeviceExclude = regexpFlag(
+	"collector.interface.device-exclude",
+	"Regexp of interface target devices to exclude, e.g. macvtap.*",
+)
+
+var interfaceAddressSources = map[string]libvirt.DomainInterfaceAddressesSource{
+	"lease": libvirt.DomainInterfaceAddressesSrcLease,
+	"agent": libvirt.DomainInterfaceAddressesSrcAgent,
+	"arp":   libvirt.DomainInterfaceAddressesSrcArp,
+}
+
 type interfaceCollector struct {
 	receiveBytesTotal    typedDesc
 	receivePacketsTotal  typedDesc
@@ -20,6 +41,21 @@ type interfaceCollector struct {
 	transmitPacketsTotal typedDesc
 	transmitErrorsTotal  typedDesc
 	transmitDropsTotal   typedDesc
+	info                 infoDesc
+	addressInfo          infoDesc
+	hostdevInfo          infoDesc
+	vfReceiveBytes       typedDesc
+	vfReceivePackets     typedDesc
+	vfReceiveDrops       typedDesc
+	vfReceiveBroadcast   typedDesc
+	vfReceiveMulticast   typedDesc
+	vfTransmitBytes      typedDesc
+	vfTransmitPackets    typedDesc
+	vfTransmitDrops      typedDesc
+	limitAverage         typedDesc
+	limitPeak            typedDesc
+	limitBurst           typedDesc
+	limitEnforced        typedDesc
 	logger               log.Logger
 }
 
@@ -28,80 +64,168 @@ func init() {
 }
 
 func NewInterfaceCollector(logger log.Logger) (Collector, error) {
+	newVFDesc := func(name, help string) typedDesc {
+		return typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, interfaceSubsystemName, name),
+				help+" of the SR-IOV virtual function as counted by its physical function, only available for local connections",
+				[]string{"domain_uuid", "interface", "pf", "vf"},
+				nil),
+			valueType: prometheus.CounterValue,
+		}
+	}
 	return &interfaceCollector{
 		receiveBytesTotal: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, interfaceSubsystemName, "receive_bytes_total"),
 				"Total number of bytes received",
-				[]string{"domain_uuid", "bridge", "interface"},
+				[]string{"domain_uuid", "bridge", "interface", "vlan"},
 				nil),
 			valueType: prometheus.CounterValue,
 		},
 		receivePacketsTotal: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, interfaceSubsystemName, "receive_packets_total"),
 				"Total number of packets received",
-				[]string{"domain_uuid", "bridge", "interface"},
+				[]string{"domain_uuid", "bridge", "interface", "vlan"},
 				nil),
 			valueType: prometheus.CounterValue,
 		},
 		receiveErrorsTotal: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, interfaceSubsystemName, "receive_errors_total"),
 				"Total number of receive errors",
-				[]string{"domain_uuid", "bridge", "interface"},
+				[]string{"domain_uuid", "bridge", "interface", "vlan"},
 				nil),
 			valueType: prometheus.CounterValue,
 		},
 		receiveDropsTotal: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, interfaceSubsystemName, "receive_drops_total"),
 				"Total number of receive drops",
-				[]string{"domain_uuid", "bridge", "interface"},
+				[]string{"domain_uuid", "bridge", "interface", "vlan"},
 				nil),
 			valueType: prometheus.CounterValue,
 		},
 		transmitBytesTotal: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, interfaceSubsystemName, "transmit_bytes_total"),
 				"Total number of bytes transmitted",
-				[]string{"domain_uuid", "bridge", "interface"},
+				[]string{"domain_uuid", "bridge", "interface", "vlan"},
 				nil),
 			valueType: prometheus.CounterValue,
 		},
 		transmitPacketsTotal: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, interfaceSubsystemName, "transmit_packets_total"),
 				"Total number of packets transmitted",
-				[]string{"domain_uuid", "bridge", "interface"},
+				[]string{"domain_uuid", "bridge", "interface", "vlan"},
 				nil),
 			valueType: prometheus.CounterValue,
 		},
 		transmitErrorsTotal: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, interfaceSubsystemName, "transmit_errors_total"),
 				"Total number of transmit errors",
-				[]string{"domain_uuid", "bridge", "interface"},
+				[]string{"domain_uuid", "bridge", "interface", "vlan"},
 				nil),
 			valueType: prometheus.CounterValue,
 		},
 		transmitDropsTotal: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, interfaceSubsystemName, "transmit_drops_total"),
 				"Total number of transmit drops",
-				[]string{"domain_uuid", "bridge", "interface"},
+				[]string{"domain_uuid", "bridge", "interface", "vlan"},
 				nil),
 			valueType: prometheus.CounterValue,
 		},
+		info: newInfoDesc(interfaceSubsystemName, "info",
+			"Configuration of the domain interfaces, type is the interface type, e.g. bridge or direct, and model the device model emulated for the guest, e.g. virtio or e1000",
+			"domain_uuid", "interface", "type", "bridge", "mac", "model", "vlan"),
+		addressInfo: newInfoDesc(interfaceSubsystemName, "address_info",
+			"IP addresses of the domain interfaces, one series per address",
+			"domain_uuid", "interface", "mac", "af", "address", "prefix"),
+		hostdevInfo: newInfoDesc(interfaceSubsystemName, "hostdev_info",
+			"SR-IOV virtual functions passed through to the domain as hostdev interfaces, interface is the PCI address of the virtual function, pf and vf the physical function and the number of the virtual function, only known for local connections",
+			"domain_uuid", "interface", "mac", "pf", "vf", "vlan"),
+		vfReceiveBytes:     newVFDesc("vf_receive_bytes_total", "Bytes received"),
+		vfReceivePackets:   newVFDesc("vf_receive_packets_total", "Packets received"),
+		vfReceiveDrops:     newVFDesc("vf_receive_drops_total", "Received packets dropped"),
+		vfReceiveBroadcast: newVFDesc("vf_receive_broadcast_packets_total", "Broadcast packets received"),
+		vfReceiveMulticast: newVFDesc("vf_receive_multicast_packets_total", "Multicast packets received"),
+		vfTransmitBytes:    newVFDesc("vf_transmit_bytes_total", "Bytes transmitted"),
+		vfTransmitPackets:  newVFDesc("vf_transmit_packets_total", "Packets transmitted"),
+		vfTransmitDrops:    newVFDesc("vf_transmit_drops_total", "Transmitted packets dropped"),
+		limitAverage: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, interfaceSubsystemName, "limit_average_bytes_per_second"),
+				"Configured average rate of the interface by direction, absent if unlimited",
+				[]string{"domain_uuid", "bridge", "interface", "vlan", "direction"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		limitPeak: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, interfaceSubsystemName, "limit_peak_bytes_per_second"),
+				"Configured peak rate of the interface by direction, absent if not set",
+				[]string{"domain_uuid", "bridge", "interface", "vlan", "direction"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		limitBurst: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, interfaceSubsystemName, "limit_burst_bytes"),
+				"Configured burst size of the interface by direction, absent if not set",
+				[]string{"domain_uuid", "bridge", "interface", "vlan", "direction"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		limitEnforced: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, interfaceSubsystemName, "limit_enforced"),
+				"Whether the qdisc enforcing the configured rate limit exists on the tap device, only available for local connections",
+				[]string{"domain_uuid", "bridge", "interface", "vlan", "direction"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
 		logger: logger,
 	}, nil
 }
 
+func (c *interfaceCollector) descs() []typedDesc {
+	return []typedDesc{
+		c.receiveBytesTotal,
+		c.receivePacketsTotal,
+		c.receiveErrorsTotal,
+		c.receiveDropsTotal,
+		c.transmitBytesTotal,
+		c.transmitPacketsTotal,
+		c.transmitErrorsTotal,
+		c.transmitDropsTotal,
+		c.info.typedDesc,
+		c.addressInfo.typedDesc,
+		c.hostdevInfo.typedDesc,
+		c.vfReceiveBytes,
+		c.vfReceivePackets,
+		c.vfReceiveDrops,
+		c.vfReceiveBroadcast,
+		c.vfReceiveMulticast,
+		c.vfTransmitBytes,
+		c.vfTransmitPackets,
+		c.vfTransmitDrops,
+		c.limitAverage,
+		c.limitPeak,
+		c.limitBurst,
+		c.limitEnforced,
+	}
+}
+
 func (c *interfaceCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
 	config := &CollectorConfig{}
 	for _, opt := range opts {
 		opt(config)
 	}
+	infos := newInfoMetrics(ch)
 
 	if config.pLibvirt == nil {
 		level.Error(c.logger).Log("msg", "libvirt not created")
@@ -112,9 +236,10 @@ func (c *interfaceCollector) Update(ch chan<- prometheus.Metric, opts ...Collect
 		return ErrNotProvided
 	}
 	if config.lvDomains == nil || len(config.lvDomains) == 0 {
-		level.Error(c.logger).Log("msg", "no domains found")
+		level.Debug(c.logger).Log("msg", "no domains found")
 		return ErrNotProvided
 	}
+	ctx := config.context()
 	pLibvirt := config.pLibvirt
 	lvDomains := config.lvDomains
 
@@ -124,26 +249,79 @@ func (c *interfaceCollector) Update(ch chan<- prometheus.Metric, opts ...Collect
 	}
 	wg := sync.WaitGroup{}
 	wg.Add(wgCounter)
+	// The counters of all virtual functions of a physical function are read
+	// at once, by network device of the physical function.
+	pfStats := make(map[string]map[uint32]vfStats)
 
 	for _, lvDomain := range lvDomains {
 		domainUUID := lvDomain.Schema.UUID
 		for _, iface := range lvDomain.Schema.Devices.Interfaces {
+			// hostdev interfaces have no device on the host, the PCI address
+			// of the virtual function takes the place of the target device.
+			hostdev := iface.Type == "hostdev"
+			if hostdev && iface.Source.Address != nil {
+				iface.Target.Device = iface.Source.Address.String()
+			}
 			if iface.Target.Device == "" {
 				level.Debug(c.logger).Log("msg", "interface has no target device", "domain", lvDomain.Domain.Name)
+				devicesSkipped.inc(ctx, domainUUID, "interface", skipReasonNoTarget)
+				wg.Done()
+				continue
+			}
+			if interfaceDeviceExclude.matches(iface.Target.Device) {
+				devicesSkipped.inc(ctx, domainUUID, "interface", skipReasonExcluded)
 				wg.Done()
 				continue
 			}
 
 			interfaceName := iface.Target.Device
-			bridgeName := iface.Source.Bridge
-			go func(domain libvirt.Domain, domainUUID, bridgeName, interfaceName string) {
-				rRxBytes, rRxPackets, rRxErrs, rRxDrop, rTxBytes, rTxPackets, rTxErrs, rTxDrop, err := pLibvirt.DomainInterfaceStats(domain, interfaceName)
+			// The bridge label holds the host side of all interface types,
+			// e.g. the host device of direct interfaces.
+			bridgeName := iface.SourceName()
+			vlan := interfaceVlanLabel(iface.Vlan)
+			infos.emit(c.info, domainUUID, interfaceName, iface.Type, bridgeName, iface.MAC.Address, iface.Model.Type, vlan)
+			// libvirt finds interfaces by target device or MAC address.
+			statsDevice := interfaceName
+			if hostdev {
+				statsDevice = iface.MAC.Address
+				c.updateHostdev(ch, infos, pfStats, config.local, lvDomain.Running, domainUUID, interfaceName, iface.MAC.Address, vlan)
+			} else {
+				c.updateBandwidth(ch, iface.Bandwidth, config.local, domainUUID, bridgeName, interfaceName, vlan)
+			}
+			if !lvDomain.Running {
+				devicesSkipped.inc(ctx, domainUUID, "interface", skipReasonNotRunning)
+				wg.Done()
+				continue
+			}
+			go func(domain libvirt.Domain, domainUUID, bridgeName, interfaceName, statsDevice, vlan string) {
+				if !acquireWorker(ctx) {
+					wg.Done()
+					return
+				}
+				defer releaseWorker()
+				defer busyDomain(ctx, domainUUID)()
+				rRxBytes, rRxPackets, rRxErrs, rRxDrop, rTxBytes, rTxPackets, rTxErrs, rTxDrop, err := pLibvirt.DomainInterfaceStats(domain, statsDevice)
+				if err != nil && hostdev {
+					// The traffic of virtual functions bypasses the host.
+					// libvirt only has stats of them if the physical
+					// function runs in switchdev mode with VF representors.
+					level.Debug(c.logger).Log("msg", "no stats of hostdev interface", "domain", domain.Name, "interface", interfaceName, "err", err)
+					devicesSkipped.inc(ctx, domainUUID, "interface", skipReasonHostdev)
+					wg.Done()
+					return
+				}
+				if IsNotRunningError(err) {
+					level.Debug(c.logger).Log("msg", "domain stopped during the scrape", "domain", domain.Name, "err", err)
+					devicesSkipped.inc(ctx, domainUUID, "interface", skipReasonNotRunning)
+					wg.Done()
+					return
+				}
 				if err != nil {
-					level.Error(c.logger).Log("msg", "failed to get interface stats", "domain", domain.Name, "interface", interfaceName, "err", err)
+					domainErrorLogger(ctx, c.logger, "interface", domainUUID, err).Log("msg", "failed to get interface stats", "domain", domain.Name, "interface", interfaceName, "err", err)
 					wg.Done()
 					return
 				}
-				promLabels := []string{domainUUID, bridgeName, interfaceName}
+				promLabels := []string{domainUUID, bridgeName, interfaceName, vlan}
 				ch <- c.receiveBytesTotal.mustNewConstMetric(float64(rRxBytes), promLabels...)
 				ch <- c.receivePacketsTotal.mustNewConstMetric(float64(rRxPackets), promLabels...)
 				ch <- c.receiveErrorsTotal.mustNewConstMetric(float64(rRxErrs), promLabels...)
@@ -152,12 +330,163 @@ func (c *interfaceCollector) Update(ch chan<- prometheus.Metric, opts ...Collect
 				ch <- c.transmitPacketsTotal.mustNewConstMetric(float64(rTxPackets), promLabels...)
 				ch <- c.transmitErrorsTotal.mustNewConstMetric(float64(rTxErrs), promLabels...)
 				ch <- c.transmitDropsTotal.mustNewConstMetric(float64(rTxDrop), promLabels...)
-
 				wg.Done()
-			}(lvDomain.Domain, domainUUID, bridgeName, interfaceName)
+			}(lvDomain.Domain, domainUUID, bridgeName, interfaceName, statsDevice, vlan)
 		}
 	}
 	wg.Wait()
 
+	if source, ok := interfaceAddressSources[interfaceAddressSource.Get()]; ok {
+		c.updateAddresses(ctx, infos, pLibvirt, lvDomains, source)
+	}
+
 	return nil
 }
+
+// updateAddresses exposes all IPv4 and IPv6 addresses of every interface.
+func (c *interfaceCollector) updateAddresses(ctx context.Context, infos *infoMetrics, pLibvirt *libvirt.Libvirt, lvDomains []libvirt_schema.LvDomain, source libvirt.DomainInterfaceAddressesSource) {
+	wg := sync.WaitGroup{}
+	wg.Add(len(lvDomains))
+	for _, lvDomain := range lvDomains {
+		go func(domain libvirt.Domain, domainUUID string) {
+			defer wg.Done()
+			if !acquireWorker(ctx) {
+				return
+			}
+			defer releaseWorker()
+			defer busyDomain(ctx, domainUUID)()
+			ifaces, err := pLibvirt.DomainInterfaceAddresses(domain, uint32(source), 0)
+			if err != nil {
+				switch errorClass(err) {
+				case "timeout", "agent_unresponsive":
+					// A hung guest agent delays every scrape until
+					// the domain is backed off.
+					domainErrorLogger(ctx, c.logger, "interface", domainUUID, err).Log("msg", "failed to get interface addresses", "domain", domain.Name, "err", err)
+				default:
+					// e.g. no guest agent configured in the domain
+					level.Debug(c.logger).Log("msg", "failed to get interface addresses", "domain", domain.Name, "err", err)
+				}
+				return
+			}
+			for _, iface := range ifaces {
+				mac := ""
+				if len(iface.Hwaddr) > 0 {
+					mac = iface.Hwaddr[0]
+				}
+				for _, addr := range iface.Addrs {
+					af := "ipv4"
+					if libvirt.IPAddrType(addr.Type) == libvirt.IPAddrTypeIpv6 {
+						af = "ipv6"
+					}
+					infos.emit(c.addressInfo, domainUUID, iface.Name, mac, af, addr.Addr, strconv.FormatUint(uint64(addr.Prefix), 10))
+				}
+			}
+		}(lvDomain.Domain, lvDomain.Schema.UUID)
+	}
+	wg.Wait()
+}
+
+// updateHostdev exposes the inventory of an SR-IOV virtual function passed
+// through as hostdev interface. The physical function is looked up in sysfs
+// for local connections, which also read the counters of running domains
+// from the physical function.
+func (c *interfaceCollector) updateHostdev(ch chan<- prometheus.Metric, infos *infoMetrics, pfStats map[string]map[uint32]vfStats, local, running bool, domainUUID, pciAddress, mac, vlan string) {
+	var vf sriovVF
+	if local {
+		var err error
+		if vf, err = lookupSriovVF(*pciSysfsPath, pciAddress); err != nil {
+			level.Debug(c.logger).Log("msg", "failed to find physical function", "pci_address", pciAddress, "err", err)
+		}
+	}
+	infos.emit(c.hostdevInfo, domainUUID, pciAddress, mac, vf.pf, vf.index, vlan)
+	if !running || vf.pfDevice == "" || vf.index == "" {
+		return
+	}
+	index, err := strconv.ParseUint(vf.index, 10, 32)
+	if err != nil {
+		return
+	}
+	stats, ok := pfStats[vf.pfDevice]
+	if !ok {
+		if stats, err = sriovVFStats(vf.pfDevice); err != nil {
+			level.Debug(c.logger).Log("msg", "failed to get virtual function stats", "pf", vf.pfDevice, "err", err)
+		}
+		pfStats[vf.pfDevice] = stats
+	}
+	s, ok := stats[uint32(index)]
+	if !ok {
+		return
+	}
+	labels := []string{domainUUID, pciAddress, vf.pf, vf.index}
+	ch <- c.vfReceiveBytes.mustNewConstMetric(float64(s.rxBytes), labels...)
+	ch <- c.vfReceivePackets.mustNewConstMetric(float64(s.rxPackets), labels...)
+	ch <- c.vfReceiveBroadcast.mustNewConstMetric(float64(s.broadcast), labels...)
+	ch <- c.vfReceiveMulticast.mustNewConstMetric(float64(s.multicast), labels...)
+	ch <- c.vfTransmitBytes.mustNewConstMetric(float64(s.txBytes), labels...)
+	ch <- c.vfTransmitPackets.mustNewConstMetric(float64(s.txPackets), labels...)
+	if s.hasDropped {
+		ch <- c.vfReceiveDrops.mustNewConstMetric(float64(s.rxDropped), labels...)
+		ch <- c.vfTransmitDrops.mustNewConstMetric(float64(s.txDropped), labels...)
+	}
+}
+
+// qdiscs are the qdiscs of a tap device libvirt uses for QoS. Inbound traffic
+// is shaped by an htb root qdisc, outbound traffic is policed on the ingress
+// qdisc of the tap device.
+type qdiscs struct {
+	root    string
+	ingress bool
+}
+
+// updateBandwidth exposes the <bandwidth> QoS settings of an interface. They
+// are taken from the domain XML and cost no request. For local connections
+// the qdiscs of the tap device are checked, as libvirt silently skips QoS on
+// some bridge types.
+func (c *interfaceCollector) updateBandwidth(ch chan<- prometheus.Metric, bandwidth libvirt_schema.InterfaceBandwidth, local bool, domainUUID, bridgeName, interfaceName, vlan string) {
+	var tc *qdiscs
+	if local && interfaceName != "" && (bandwidth.Inbound.Average > 0 || bandwidth.Outbound.Average > 0) {
+		if q, err := tcQdiscs(interfaceName); err == nil {
+			tc = &q
+		} else {
+			level.Debug(c.logger).Log("msg", "failed to list qdiscs", "interface", interfaceName, "err", err)
+		}
+	}
+	for _, l := range []struct {
+		direction string
+		limit     libvirt_schema.InterfaceBandwidthLimit
+	}{
+		{"inbound", bandwidth.Inbound},
+		{"outbound", bandwidth.Outbound},
+	} {
+		labels := []string{domainUUID, bridgeName, interfaceName, vlan, l.direction}
+		if tc != nil && l.limit.Average > 0 {
+			enforced := tc.ingress
+			if l.direction == "inbound" {
+				enforced = tc.root == "htb"
+			}
+			ch <- c.limitEnforced.mustNewConstMetric(float64(boolIndex(enforced)), labels...)
+		}
+		if l.limit.Average > 0 {
+			ch <- c.limitAverage.mustNewConstMetric(float64(l.limit.Average*1024), labels...)
+		}
+		if l.limit.Peak > 0 {
+			ch <- c.limitPeak.mustNewConstMetric(float64(l.limit.Peak*1024), labels...)
+		}
+		if l.limit.Burst > 0 {
+			ch <- c.limitBurst.mustNewConstMetric(float64(l.limit.Burst*1024), labels...)
+		}
+	}
+}
+
+// interfaceVlanLabel flattens the <vlan> element of an interface into a label
+// value. Multiple tags (trunk mode) are joined by commas, an empty string means
+// the interface is untagged.
+func interfaceVlanLabel(vlan libvirt_schema.InterfaceVlan) string {
+	ids := make([]string, 0, len(vlan.Tags))
+	for _, tag := range vlan.Tags {
+		if tag.ID != "" {
+			ids = append(ids, tag.ID)
+		}
+	}
+	return strings.Join(ids, ",")
+}
@@ -0,0 +1,128 @@
+package collector
+
+import (
+	"errors"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/nee541/libvirt-exporter/connection"
+	"github.com/nee541/libvirt-exporter/libvirt_schema"
+)
+
+// InventoryDomain is a domain of the inventory returned by Inventory.
+type InventoryDomain struct {
+	UUID  string `json:"uuid"`
+	Name  string `json:"name"`
+	Title string `json:"title,omitempty"`
+	// State is running, paused or other.
+	State       string               `json:"state"`
+	Vcpus       uint                 `json:"vcpus"`
+	MemoryBytes uint64               `json:"memory_bytes"`
+	Disks       []InventoryDisk      `json:"disks"`
+	Interfaces  []InventoryInterface `json:"interfaces"`
+}
+
+// InventoryDisk is a disk of an InventoryDomain.
+type InventoryDisk struct {
+	Target string `json:"target"`
+	// Device is disk, cdrom, floppy or lun.
+	Device string `json:"device"`
+	Type   string `json:"type"`
+	Source string `json:"source,omitempty"`
+	Format string `json:"format,omitempty"`
+}
+
+// InventoryInterface is a network interface of an InventoryDomain.
+type InventoryInterface struct {
+	Target string `json:"target,omitempty"`
+	Type   string `json:"type"`
+	MAC    string `json:"mac"`
+	Source string `json:"source,omitempty"`
+	Model  string `json:"model,omitempty"`
+}
+
+// Inventory returns the active domains passing the domain filters. The
+// definitions are taken from the cache of parsed XML descriptions shared with
+// the scrapes, only domains missing from it are fetched.
+func Inventory(conn *connection.Connection) ([]InventoryDomain, error) {
+	if conn == nil || conn.Libvirt == nil || !conn.Libvirt.IsConnected() {
+		return nil, errors.New("libvirt not connected")
+	}
+	pLibvirt := conn.Libvirt
+	domains, _, err := pLibvirt.ConnectListAllDomains(1, libvirt.ConnectListDomainsActive)
+	if err != nil {
+		return nil, err
+	}
+	// Two list calls instead of DomainGetState for every domain.
+	states := make(map[libvirt.UUID]string)
+	for state, flag := range map[string]libvirt.ConnectListAllDomainsFlags{
+		"running": libvirt.ConnectListDomainsRunning,
+		"paused":  libvirt.ConnectListDomainsPaused,
+	} {
+		listed, _, err := pLibvirt.ConnectListAllDomains(1, flag)
+		if err != nil {
+			return nil, err
+		}
+		for _, domain := range listed {
+			states[domain.UUID] = state
+		}
+	}
+
+	// The domain filters are swapped by Reconfigure on a config reload.
+	configMtx.RLock()
+	domains = selectDomains(domains)
+	configMtx.RUnlock()
+	inventory := make([]InventoryDomain, 0, len(domains))
+	for _, domain := range domains {
+		domainUUID := uuidString(domain.UUID)
+		schema, ok := domainSchemas.get(domainUUID)
+		if !ok {
+			xmlDesc, err := pLibvirt.DomainGetXMLDesc(domain, 0)
+			if err != nil {
+				// The domain may have stopped since it was listed.
+				continue
+			}
+			if schema, err = libvirt_schema.NewDomainFromXML([]byte(xmlDesc)); err != nil {
+				continue
+			}
+			domainSchemas.set(domainUUID, schema)
+		}
+		state, ok := states[domain.UUID]
+		if !ok {
+			state = "other"
+		}
+		inventory = append(inventory, inventoryDomain(schema, state))
+	}
+	return inventory, nil
+}
+
+func inventoryDomain(schema libvirt_schema.Domain, state string) InventoryDomain {
+	d := InventoryDomain{
+		UUID:        schema.UUID,
+		Name:        schema.Name,
+		Title:       schema.Title,
+		State:       state,
+		Vcpus:       schema.Vcpu.Configured(),
+		MemoryBytes: schema.Memory.Bytes(),
+		Disks:       make([]InventoryDisk, 0, len(schema.Devices.Disks)),
+		Interfaces:  make([]InventoryInterface, 0, len(schema.Devices.Interfaces)),
+	}
+	for _, disk := range schema.Devices.Disks {
+		d.Disks = append(d.Disks, InventoryDisk{
+			Target: disk.Target.Device,
+			Device: disk.Device,
+			Type:   disk.SourceType(),
+			Source: disk.SourcePath(),
+			Format: disk.Driver.Type,
+		})
+	}
+	for _, iface := range schema.Devices.Interfaces {
+		d.Interfaces = append(d.Interfaces, InventoryInterface{
+			Target: iface.Target.Device,
+			Type:   iface.Type,
+			MAC:    iface.MAC.Address,
+			Source: iface.SourceName(),
+			Model:  iface.Model.Type,
+		})
+	}
+	return d
+}
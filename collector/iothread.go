@@ -0,0 +1,150 @@
+package collector
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const iothreadSubsystemName = "domain_iothread"
+
+// iothreadCollector exposes the IOThreads of domains with their CPU affinity
+// and polling parameters, to validate the iothread tuning of storage heavy
+// domains.
+type iothreadCollector struct {
+	count       typedDesc
+	cpuAffinity infoDesc
+	pollMax     typedDesc
+	pollGrow    typedDesc
+	pollShrink  typedDesc
+	logger      log.Logger
+}
+
+func init() {
+	registerCollector("iothread", defaultDisabled, NewIothreadCollector)
+}
+
+// NewIothreadCollector returns a new Collector exposing domain IOThreads.
+func NewIothreadCollector(logger log.Logger) (Collector, error) {
+	newDesc := func(name, help string) typedDesc {
+		return typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, iothreadSubsystemName, name),
+				help,
+				[]string{"domain_uuid", "iothread"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		}
+	}
+	return &iothreadCollector{
+		count: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "domain", "iothreads"),
+				"Number of IOThreads of the domain",
+				[]string{"domain_uuid"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		cpuAffinity: newInfoDesc(iothreadSubsystemName, "cpu_affinity_info",
+			"Host CPUs the IOThread may run on",
+			"domain_uuid", "iothread", "cpus"),
+		pollMax:    newDesc("poll_max_seconds", "Maximum polling time of the IOThread, 0 means polling is disabled"),
+		pollGrow:   newDesc("poll_grow", "Factor by which the polling time of the IOThread grows, 0 means the hypervisor default"),
+		pollShrink: newDesc("poll_shrink", "Divisor by which the polling time of the IOThread shrinks, 0 means the hypervisor default"),
+		logger:     logger,
+	}, nil
+}
+
+func (c *iothreadCollector) descs() []typedDesc {
+	return []typedDesc{c.count, c.cpuAffinity.typedDesc, c.pollMax, c.pollGrow, c.pollShrink}
+}
+
+func (c *iothreadCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	infos := newInfoMetrics(ch)
+
+	if config.pLibvirt == nil {
+		level.Error(c.logger).Log("msg", "libvirt not created")
+		return ErrNotProvided
+	}
+	if !config.pLibvirt.IsConnected() {
+		level.Error(c.logger).Log("msg", "libvirt not connected")
+		return ErrNotProvided
+	}
+	if len(config.lvDomains) == 0 {
+		level.Debug(c.logger).Log("msg", "no domains found")
+		return ErrNotProvided
+	}
+	ctx := config.context()
+	pLibvirt := config.pLibvirt
+
+	domains := make([]libvirt.Domain, 0, len(config.lvDomains))
+	for _, lvDomain := range config.lvDomains {
+		domains = append(domains, lvDomain.Domain)
+	}
+	// The polling parameters of all domains are fetched with one request.
+	records, err := pLibvirt.ConnectGetAllDomainStats(domains, uint32(libvirt.DomainStatsIothread), 0)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		domainUUID := uuidString(record.Dom.UUID)
+		count, ok := typedParamValue(record.Params, "iothread.count")
+		if !ok {
+			continue
+		}
+		ch <- c.count.mustNewConstMetric(count, domainUUID)
+		for _, p := range record.Params {
+			var id uint64
+			var field string
+			if _, err := fmt.Sscanf(p.Field, "iothread.%d.%s", &id, &field); err != nil {
+				continue
+			}
+			value, ok := typedParamValue(record.Params, p.Field)
+			if !ok {
+				continue
+			}
+			iothread := strconv.FormatUint(id, 10)
+			switch field {
+			case "poll-max-ns":
+				ch <- c.pollMax.mustNewConstMetric(value/1e9, domainUUID, iothread)
+			case "poll-grow":
+				ch <- c.pollGrow.mustNewConstMetric(value, domainUUID, iothread)
+			case "poll-shrink":
+				ch <- c.pollShrink.mustNewConstMetric(value, domainUUID, iothread)
+			}
+		}
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(config.lvDomains))
+	for _, lvDomain := range config.lvDomains {
+		go func(domain libvirt.Domain, domainUUID string) {
+			defer wg.Done()
+			if !acquireWorker(ctx) {
+				return
+			}
+			defer releaseWorker()
+			defer busyDomain(ctx, domainUUID)()
+			iothreads, _, err := pLibvirt.DomainGetIothreadInfo(domain, libvirt.DomainAffectCurrent)
+			if err != nil {
+				domainErrorLogger(ctx, c.logger, "iothread", domainUUID, err).Log("msg", "failed to get iothread info", "domain", domain.Name, "err", err)
+				return
+			}
+			for _, iothread := range iothreads {
+				infos.emit(c.cpuAffinity, domainUUID, strconv.FormatUint(uint64(iothread.IothreadID), 10), cpusetString(iothread.Cpumap))
+			}
+		}(lvDomain.Domain, lvDomain.Schema.UUID)
+	}
+	wg.Wait()
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package collector
+
+import (
+	"strconv"
+	"sync"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const jobSubsystemName = "domain_job"
+
+// jobTypeNames names the job types, see
+// https://libvirt.org/html/libvirt-libvirt-domain.html#virDomainJobType
+var jobTypeNames = map[libvirt.DomainJobType]string{
+	libvirt.DomainJobBounded:   "bounded",
+	libvirt.DomainJobUnbounded: "unbounded",
+	libvirt.DomainJobCompleted: "completed",
+	libvirt.DomainJobFailed:    "failed",
+	libvirt.DomainJobCancelled: "cancelled",
+}
+
+// jobOperationNames names the operations of a job, see
+// https://libvirt.org/html/libvirt-libvirt-domain.html#virDomainJobOperation
+var jobOperationNames = map[libvirt.DomainJobOperation]string{
+	libvirt.DomainJobOperationStrUnknown:        "unknown",
+	libvirt.DomainJobOperationStrStart:          "start",
+	libvirt.DomainJobOperationStrSave:           "save",
+	libvirt.DomainJobOperationStrRestore:        "restore",
+	libvirt.DomainJobOperationStrMigrationIn:    "migration_in",
+	libvirt.DomainJobOperationStrMigrationOut:   "migration_out",
+	libvirt.DomainJobOperationStrSnapshot:       "snapshot",
+	libvirt.DomainJobOperationStrSnapshotRevert: "snapshot_revert",
+	libvirt.DomainJobOperationStrDump:           "dump",
+	libvirt.DomainJobOperationStrBackup:         "backup",
+}
+
+// jobCollector exposes the progress of running domain jobs, most notably
+// live migrations, so their convergence can be followed.
+type jobCollector struct {
+	info             infoDesc
+	timeElapsed      typedDesc
+	dataTotal        typedDesc
+	dataProcessed    typedDesc
+	dataRemaining    typedDesc
+	memoryDirtyRate  typedDesc
+	memoryIteration  typedDesc
+	expectedDowntime typedDesc
+	logger           log.Logger
+}
+
+func init() {
+	registerCollector("job", defaultDisabled, NewJobCollector)
+}
+
+// NewJobCollector returns a new Collector exposing domain job stats.
+func NewJobCollector(logger log.Logger) (Collector, error) {
+	newDesc := func(name, help string, valueType prometheus.ValueType) typedDesc {
+		return typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, jobSubsystemName, name),
+				help,
+				[]string{"domain_uuid"},
+				nil),
+			valueType: valueType,
+		}
+	}
+	return &jobCollector{
+		info: newInfoDesc(jobSubsystemName, "info",
+			"Type and operation of the job running on the domain",
+			"domain_uuid", "type", "operation"),
+		timeElapsed:      newDesc("time_elapsed_seconds", "Time elapsed since the start of the job", prometheus.GaugeValue),
+		dataTotal:        newDesc("data_total_bytes", "Total amount of data to be transferred by the job", prometheus.GaugeValue),
+		dataProcessed:    newDesc("data_processed_bytes", "Amount of data already transferred by the job", prometheus.GaugeValue),
+		dataRemaining:    newDesc("data_remaining_bytes", "Amount of data remaining to be transferred by the job", prometheus.GaugeValue),
+		memoryDirtyRate:  newDesc("memory_dirty_rate_pages_per_second", "Number of memory pages dirtied by the guest per second during migration", prometheus.GaugeValue),
+		memoryIteration:  newDesc("memory_iteration", "Number of memory passes completed by the migration", prometheus.GaugeValue),
+		expectedDowntime: newDesc("expected_downtime_seconds", "Downtime expected when switching over to the migration destination", prometheus.GaugeValue),
+		logger:           logger,
+	}, nil
+}
+
+func (c *jobCollector) descs() []typedDesc {
+	return []typedDesc{
+		c.info.typedDesc,
+		c.timeElapsed,
+		c.dataTotal,
+		c.dataProcessed,
+		c.dataRemaining,
+		c.memoryDirtyRate,
+		c.memoryIteration,
+		c.expectedDowntime,
+	}
+}
+
+func (c *jobCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	infos := newInfoMetrics(ch)
+
+	if config.pLibvirt == nil {
+		level.Error(c.logger).Log("msg", "libvirt not created")
+		return ErrNotProvided
+	}
+	if !config.pLibvirt.IsConnected() {
+		level.Error(c.logger).Log("msg", "libvirt not connected")
+		return ErrNotProvided
+	}
+	if config.lvDomains == nil {
+		level.Debug(c.logger).Log("msg", "no domains found")
+		return ErrNotProvided
+	}
+	ctx := config.context()
+	pLibvirt := config.pLibvirt
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(config.lvDomains))
+	for _, lvDomain := range config.lvDomains {
+		go func(domain libvirt.Domain, domainUUID string) {
+			defer wg.Done()
+			if !acquireWorker(ctx) {
+				return
+			}
+			defer releaseWorker()
+			defer busyDomain(ctx, domainUUID)()
+			jobType, params, err := pLibvirt.DomainGetJobStats(domain, 0)
+			if err != nil {
+				domainErrorLogger(ctx, c.logger, "job", domainUUID, err).Log("msg", "failed to get job stats", "domain", domain.Name, "err", err)
+				return
+			}
+			if libvirt.DomainJobType(jobType) == libvirt.DomainJobNone {
+				return
+			}
+			operation := "unknown"
+			if v, ok := typedParamValue(params, "operation"); ok {
+				if name, ok := jobOperationNames[libvirt.DomainJobOperation(v)]; ok {
+					operation = name
+				}
+			}
+			typeName, ok := jobTypeNames[libvirt.DomainJobType(jobType)]
+			if !ok {
+				typeName = strconv.Itoa(int(jobType))
+			}
+			infos.emit(c.info, domainUUID, typeName, operation)
+
+			// Times are reported in milliseconds.
+			for _, stat := range []struct {
+				field string
+				desc  *typedDesc
+				scale float64
+			}{
+				{"time_elapsed", &c.timeElapsed, 1e-3},
+				{"data_total", &c.dataTotal, 1},
+				{"data_processed", &c.dataProcessed, 1},
+				{"data_remaining", &c.dataRemaining, 1},
+				{"memory_dirty_rate", &c.memoryDirtyRate, 1},
+				{"memory_iteration", &c.memoryIteration, 1},
+				{"downtime", &c.expectedDowntime, 1e-3},
+			} {
+				if v, ok := typedParamValue(params, stat.field); ok {
+					ch <- stat.desc.mustNewConstMetric(v*stat.scale, domainUUID)
+				}
+			}
+		}(lvDomain.Domain, lvDomain.Schema.UUID)
+	}
+	wg.Wait()
+
+	return nil
+}
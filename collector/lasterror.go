@@ -0,0 +1,137 @@
+package collector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lastErrorMessageMax is the number of characters of an error message kept in
+// the message label. The hash label identifies the full message.
+const lastErrorMessageMax = 64
+
+var (
+	lastErrorInfo = newInfoDesc("domain", "last_error_info",
+		"Most recent error collecting the domain, message is truncated and hash identifies the full message.",
+		"domain_uuid", "collector", "class", "message", "hash")
+	lastErrorTimestampDesc = newMetricDesc(
+		prometheus.BuildFQName(namespace, "domain", "last_error_timestamp_seconds"),
+		"Time of the most recent error collecting the domain since unix epoch in seconds.",
+		[]string{"domain_uuid"},
+		nil,
+	)
+)
+
+// errorClasses names the classes of libvirt errors on-call is most likely to
+// meet. Other libvirt errors are of class libvirt.
+var errorClasses = map[libvirt.ErrorNumber]string{
+	libvirt.ErrNoSupport:            "unsupported",
+	libvirt.ErrOperationUnsupported: "unsupported",
+	libvirt.ErrNoDomain:             "no_domain",
+	libvirt.ErrOperationInvalid:     "operation_invalid",
+	libvirt.ErrOperationTimeout:     "timeout",
+	libvirt.ErrAgentUnresponsive:    "agent_unresponsive",
+	libvirt.ErrSystemError:          "system",
+	libvirt.ErrRPC:                  "rpc",
+	libvirt.ErrInternalError:        "internal",
+}
+
+// errorClass returns a label value with few distinct values for err.
+func errorClass(err error) string {
+	var lvErr libvirt.Error
+	switch {
+	case errors.As(err, &lvErr):
+		if class, ok := errorClasses[libvirt.ErrorNumber(lvErr.Code)]; ok {
+			return class
+		}
+		return "libvirt"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return "timeout"
+	}
+	return "other"
+}
+
+type domainError struct {
+	collector string
+	class     string
+	message   string
+	hash      string
+	at        time.Time
+}
+
+// errorTracker keeps the most recent collection error of every domain, so
+// on-call can see why metrics of a domain are missing without the logs of
+// the host. With one series per domain its cardinality is bounded.
+type errorTracker struct {
+	mtx    sync.Mutex
+	errors map[string]domainError
+}
+
+var domainErrors = &errorTracker{errors: make(map[string]domainError)}
+
+// record stores err as the most recent error of the domain.
+func (t *errorTracker) record(domainUUID, collector string, err error) {
+	message := err.Error()
+	sum := sha256.Sum256([]byte(message))
+	if utf8.RuneCountInString(message) > lastErrorMessageMax {
+		message = string([]rune(message)[:lastErrorMessageMax-1]) + "…"
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.errors[domainUUID] = domainError{
+		collector: collector,
+		class:     errorClass(err),
+		message:   strings.ToValidUTF8(message, ""),
+		hash:      hex.EncodeToString(sum[:4]),
+		at:        time.Now(),
+	}
+}
+
+// retain drops the errors of all domains not in domainUUIDs.
+func (t *errorTracker) retain(domainUUIDs map[string]bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	for domainUUID := range t.errors {
+		if !domainUUIDs[domainUUID] {
+			delete(t.errors, domainUUID)
+		}
+	}
+}
+
+// metrics returns the errors as metrics.
+func (t *errorTracker) metrics() []prometheus.Metric {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	metrics := make([]prometheus.Metric, 0, 2*len(t.errors))
+	for domainUUID, e := range t.errors {
+		metrics = append(metrics,
+			lastErrorInfo.mustNewConstMetric(1, domainUUID, e.collector, e.class, e.message, e.hash),
+			prometheus.MustNewConstMetric(lastErrorTimestampDesc, prometheus.GaugeValue, float64(e.at.UnixNano())/1e9, domainUUID),
+		)
+	}
+	return metrics
+}
+
+// domainErrorLogger records err as the most recent error of the domain, counts
+// timeouts for domainBackoff and returns the logger for it, see errorLogger.
+// Nothing is recorded for dry runs.
+func domainErrorLogger(ctx context.Context, logger log.Logger, collector, domainUUID string, err error) log.Logger {
+	if isDryRun(ctx) {
+		return errorLogger(logger, err)
+	}
+	domainErrors.record(domainUUID, collector, err)
+	switch errorClass(err) {
+	case "timeout", "agent_unresponsive":
+		domainBackoff.fail(domainUUID)
+	}
+	return errorLogger(logger, err)
+}
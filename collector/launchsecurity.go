@@ -0,0 +1,142 @@
+package collector
+
+import (
+	"strconv"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sevInfoMax is REMOTE_NODE_SEV_INFO_MAX, the most SEV parameters libvirt
+// returns.
+const sevInfoMax = 64
+
+// sevPolicyFlags names the bits of the SEV guest policy, see the AMD SEV API
+// specification. SEV-SNP policies have a different layout and are not
+// decoded.
+var sevPolicyFlags = []struct {
+	bit  uint
+	name string
+}{
+	{0, "nodbg"},
+	{1, "noks"},
+	{2, "es"},
+	{3, "nosend"},
+	{4, "domain"},
+	{5, "sev"},
+}
+
+// launchSecurityCollector exposes which domains run as confidential guests,
+// e.g. encrypted by AMD SEV, and whether the host supports it, so compliance
+// can verify which workloads are actually encrypted.
+type launchSecurityCollector struct {
+	info          infoDesc
+	policy        typedDesc
+	hostSupported typedDesc
+	hostMaxGuests typedDesc
+	logger        log.Logger
+}
+
+func init() {
+	registerCollector("launch_security", defaultEnabled, NewLaunchSecurityCollector)
+}
+
+// NewLaunchSecurityCollector returns a new Collector exposing launch security
+// settings.
+func NewLaunchSecurityCollector(logger log.Logger) (Collector, error) {
+	return &launchSecurityCollector{
+		info: newInfoDesc("domain", "launch_security_info",
+			"Confidential computing technology of the domain, type is sev, sev-snp, s390-pv or none, policy the guest policy as configured",
+			"domain_uuid", "type", "policy"),
+		policy: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "domain", "sev_policy"),
+				"Whether the flag of the SEV guest policy of the domain is set, e.g. es for encrypted CPU state or nodbg for debugging disabled",
+				[]string{"domain_uuid", "flag"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		hostSupported: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "host", "sev_supported"),
+				"Whether the host can run AMD SEV guests",
+				nil,
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		hostMaxGuests: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "host", "sev_max_guests"),
+				"Maximum number of SEV guests of the host by type sev or sev_es",
+				[]string{"type"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		logger: logger,
+	}, nil
+}
+
+func (c *launchSecurityCollector) descs() []typedDesc {
+	return []typedDesc{c.info.typedDesc, c.policy, c.hostSupported, c.hostMaxGuests}
+}
+
+func (c *launchSecurityCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	infos := newInfoMetrics(ch)
+
+	if config.pLibvirt == nil {
+		level.Error(c.logger).Log("msg", "libvirt not created")
+		return ErrNotProvided
+	}
+	if !config.pLibvirt.IsConnected() {
+		level.Error(c.logger).Log("msg", "libvirt not connected")
+		return ErrNotProvided
+	}
+
+	params, _, err := config.pLibvirt.NodeGetSevInfo(sevInfoMax, 0)
+	switch {
+	case err == nil:
+		ch <- c.hostSupported.mustNewConstMetric(1)
+		for _, p := range []struct {
+			field   string
+			sevType string
+		}{
+			{"max-guests", "sev"},
+			{"max-es-guests", "sev_es"},
+		} {
+			if v, ok := typedParamValue(params, p.field); ok {
+				ch <- c.hostMaxGuests.mustNewConstMetric(v, p.sevType)
+			}
+		}
+	case IsUnsupportedError(err):
+		ch <- c.hostSupported.mustNewConstMetric(0)
+	default:
+		level.Error(c.logger).Log("msg", "failed to get SEV info", "err", err)
+	}
+
+	for _, lvDomain := range config.lvDomains {
+		domainUUID := lvDomain.Schema.UUID
+		launchSecurity := lvDomain.Schema.LaunchSecurity
+		if launchSecurity == nil {
+			infos.emit(c.info, domainUUID, "none", "")
+			continue
+		}
+		infos.emit(c.info, domainUUID, launchSecurity.Type, launchSecurity.Policy)
+		if launchSecurity.Type != "sev" {
+			continue
+		}
+		policy, err := strconv.ParseUint(launchSecurity.Policy, 0, 32)
+		if err != nil {
+			level.Debug(c.logger).Log("msg", "invalid SEV policy", "domain", lvDomain.Schema.Name, "policy", launchSecurity.Policy)
+			continue
+		}
+		for _, flag := range sevPolicyFlags {
+			ch <- c.policy.mustNewConstMetric(float64(policy>>flag.bit&1), domainUUID, flag.name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package collector
+
+import (
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lifecycleCollector exposes the actions libvirt takes when a domain powers
+// off, reboots or crashes, to audit e.g. crash restart loops, and whether the
+// domain is persistent and started with the host.
+type lifecycleCollector struct {
+	actions    infoDesc
+	autostart  typedDesc
+	persistent typedDesc
+	logger     log.Logger
+}
+
+func init() {
+	registerCollector("lifecycle", defaultEnabled, NewLifecycleCollector)
+}
+
+// NewLifecycleCollector returns a new Collector exposing the lifecycle
+// actions of domains.
+func NewLifecycleCollector(logger log.Logger) (Collector, error) {
+	return &lifecycleCollector{
+		actions: newInfoDesc("domain", "lifecycle_actions_info",
+			"Actions taken when the domain powers off, reboots or crashes",
+			"domain_uuid", "on_poweroff", "on_reboot", "on_crash"),
+		autostart: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "domain", "autostart"),
+				"Whether the domain is started when the host boots",
+				[]string{"domain_uuid"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		persistent: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "domain", "persistent"),
+				"Whether the domain is persistent, 0 for transient domains which vanish when stopped",
+				[]string{"domain_uuid"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		logger: logger,
+	}, nil
+}
+
+func (c *lifecycleCollector) descs() []typedDesc {
+	return []typedDesc{c.actions.typedDesc, c.autostart, c.persistent}
+}
+
+func (c *lifecycleCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	infos := newInfoMetrics(ch)
+
+	if len(config.lvDomains) == 0 {
+		level.Debug(c.logger).Log("msg", "no domains found")
+		return ErrNotProvided
+	}
+	// Elements missing in the XML take the libvirt defaults.
+	orDefault := func(action, def string) string {
+		if action == "" {
+			return def
+		}
+		return action
+	}
+	for _, lvDomain := range config.lvDomains {
+		schema := lvDomain.Schema
+		infos.emit(c.actions, schema.UUID,
+			orDefault(schema.OnPoweroff, "destroy"),
+			orDefault(schema.OnReboot, "restart"),
+			orDefault(schema.OnCrash, "destroy"),
+		)
+	}
+
+	// Two list calls instead of DomainGetAutostart and DomainIsPersistent
+	// for every domain, like in the domains collector.
+	if config.pLibvirt == nil || !config.pLibvirt.IsConnected() {
+		level.Error(c.logger).Log("msg", "libvirt not connected")
+		return ErrNotProvided
+	}
+	for _, f := range []struct {
+		desc *typedDesc
+		flag libvirt.ConnectListAllDomainsFlags
+	}{
+		{&c.autostart, libvirt.ConnectListDomainsAutostart},
+		{&c.persistent, libvirt.ConnectListDomainsPersistent},
+	} {
+		domains, _, err := config.pLibvirt.ConnectListAllDomains(1, f.flag)
+		if err != nil {
+			return err
+		}
+		set := make(map[string]bool, len(domains))
+		for _, domain := range domains {
+			set[uuidString(domain.UUID)] = true
+		}
+		for _, lvDomain := range config.lvDomains {
+			ch <- f.desc.mustNewConstMetric(float64(boolIndex(set[lvDomain.Schema.UUID])), lvDomain.Schema.UUID)
+		}
+	}
+	return nil
+}
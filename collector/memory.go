@@ -3,6 +3,7 @@ package collector
 import (
 	"sync"
 
+	"github.com/alecthomas/kingpin/v2"
 	libvirt "github.com/digitalocean/go-libvirt"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -23,11 +24,38 @@ type memoryCollector struct {
 	diskCacheBytes      typedDesc
 	hugetlbPagesAlloc   typedDesc
 	hugetlbPageFaults   typedDesc
+	usedBytes           typedDesc
 	logger              log.Logger
 }
 
 const memorySubsystemName = "domain_memory_stat"
 
+var memoryKiBUnits = reloadableBool(kingpin.Flag(
+	"collector.memory.kib-units",
+	"Expose the *_bytes memory stats in KiB as reported by libvirt, as older versions of the exporter did.",
+).Default("false"))
+
+// memoryStatsInKiB lists the memory stats which libvirt reports in KiB. All
+// other stats are counts or timestamps and are exposed unchanged.
+var memoryStatsInKiB = map[libvirt.DomainMemoryStatTags]bool{
+	libvirt.DomainMemoryStatSwapIn:        true,
+	libvirt.DomainMemoryStatSwapOut:       true,
+	libvirt.DomainMemoryStatUnused:        true,
+	libvirt.DomainMemoryStatAvailable:     true,
+	libvirt.DomainMemoryStatActualBalloon: true,
+	libvirt.DomainMemoryStatRss:           true,
+	libvirt.DomainMemoryStatUsable:        true,
+	libvirt.DomainMemoryStatDiskCaches:    true,
+}
+
+// memoryStatValue converts a memory stat into the unit of its metric.
+func memoryStatValue(tag libvirt.DomainMemoryStatTags, val uint64) float64 {
+	if memoryStatsInKiB[tag] && !memoryKiBUnits.Get() {
+		return float64(val) * 1024
+	}
+	return float64(val)
+}
+
 func init() {
 	registerCollector("memory", defaultEnabled, NewMemoryCollector)
 }
@@ -36,7 +64,7 @@ func init() {
 func NewMemoryCollector(logger log.Logger) (Collector, error) {
 	return &memoryCollector{
 		swapInBytes: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, memorySubsystemName, "swap_in_bytes"),
 				"Total amount of data read from swap space (in bytes)",
 				[]string{"domain_uuid"},
@@ -44,7 +72,7 @@ func NewMemoryCollector(logger log.Logger) (Collector, error) {
 			valueType: prometheus.GaugeValue,
 		},
 		swapOutBytes: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, memorySubsystemName, "swap_out_bytes"),
 				"Total amount of memory written out to swap space (in bytes)",
 				[]string{"domain_uuid"},
@@ -52,7 +80,7 @@ func NewMemoryCollector(logger log.Logger) (Collector, error) {
 			valueType: prometheus.GaugeValue,
 		},
 		majorPageFaults: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, memorySubsystemName, "major_page_faults_number"),
 				"Number of major page faults",
 				[]string{"domain_uuid"},
@@ -60,7 +88,7 @@ func NewMemoryCollector(logger log.Logger) (Collector, error) {
 			valueType: prometheus.GaugeValue,
 		},
 		minorPageFaults: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, memorySubsystemName, "minor_page_faults_number"),
 				"Number of minor page faults",
 				[]string{"domain_uuid"},
@@ -68,7 +96,7 @@ func NewMemoryCollector(logger log.Logger) (Collector, error) {
 			valueType: prometheus.GaugeValue,
 		},
 		unusedBytes: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, memorySubsystemName, "unused_bytes"),
 				"Amount of memory left completely unused by the system (in bytes)",
 				[]string{"domain_uuid"},
@@ -76,7 +104,7 @@ func NewMemoryCollector(logger log.Logger) (Collector, error) {
 			valueType: prometheus.GaugeValue,
 		},
 		availableBytes: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, memorySubsystemName, "available_bytes"),
 				"Total amount of usable memory (in bytes)",
 				[]string{"domain_uuid"},
@@ -84,7 +112,7 @@ func NewMemoryCollector(logger log.Logger) (Collector, error) {
 			valueType: prometheus.GaugeValue,
 		},
 		actualBallonBytes: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, memorySubsystemName, "actual_ballon_bytes"),
 				"Current balloon value (in bytes)",
 				[]string{"domain_uuid"},
@@ -92,7 +120,7 @@ func NewMemoryCollector(logger log.Logger) (Collector, error) {
 			valueType: prometheus.GaugeValue,
 		},
 		rssBytes: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, memorySubsystemName, "rss_bytes"),
 				"Resident Set Size of the process running the domain (in bytes)",
 				[]string{"domain_uuid"},
@@ -100,7 +128,7 @@ func NewMemoryCollector(logger log.Logger) (Collector, error) {
 			valueType: prometheus.GaugeValue,
 		},
 		usableBytes: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, memorySubsystemName, "usable_bytes"),
 				"Amount of memory reclaimable by the memory reclamation subsystem (in bytes)",
 				[]string{"domain_uuid"},
@@ -108,7 +136,7 @@ func NewMemoryCollector(logger log.Logger) (Collector, error) {
 			valueType: prometheus.GaugeValue,
 		},
 		lastUpdateTimestamp: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, memorySubsystemName, "last_update_timestamp_seconds"),
 				"Timestamp of the last update of statistics, in seconds",
 				[]string{"domain_uuid"},
@@ -116,7 +144,7 @@ func NewMemoryCollector(logger log.Logger) (Collector, error) {
 			valueType: prometheus.GaugeValue,
 		},
 		diskCacheBytes: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, memorySubsystemName, "disk_cache_bytes"),
 				"Amount of memory used as disk cache (in bytes)",
 				[]string{"domain_uuid"},
@@ -124,7 +152,7 @@ func NewMemoryCollector(logger log.Logger) (Collector, error) {
 			valueType: prometheus.GaugeValue,
 		},
 		hugetlbPagesAlloc: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, memorySubsystemName, "hugetlb_pages_alloc_number"),
 				"Number of hugepages allocated",
 				[]string{"domain_uuid"},
@@ -132,18 +160,45 @@ func NewMemoryCollector(logger log.Logger) (Collector, error) {
 			valueType: prometheus.GaugeValue,
 		},
 		hugetlbPageFaults: typedDesc{
-			desc: prometheus.NewDesc(
+			desc: newMetricDesc(
 				prometheus.BuildFQName(namespace, memorySubsystemName, "hugetlb_page_faults_number"),
 				"Number of hugepages page faults",
 				[]string{"domain_uuid"},
 				nil),
 			valueType: prometheus.GaugeValue,
 		},
+		usedBytes: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, memorySubsystemName, "used_bytes"),
+				"Memory in use by the guest, i.e. available minus usable memory (in bytes)",
+				[]string{"domain_uuid"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
 
 		logger: logger,
 	}, nil
 }
 
+func (c *memoryCollector) descs() []typedDesc {
+	return []typedDesc{
+		c.swapInBytes,
+		c.swapOutBytes,
+		c.majorPageFaults,
+		c.minorPageFaults,
+		c.unusedBytes,
+		c.availableBytes,
+		c.actualBallonBytes,
+		c.rssBytes,
+		c.usableBytes,
+		c.lastUpdateTimestamp,
+		c.diskCacheBytes,
+		c.hugetlbPagesAlloc,
+		c.hugetlbPageFaults,
+		c.usedBytes,
+	}
+}
+
 func (c *memoryCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
 	config := &CollectorConfig{}
 	for _, opt := range opts {
@@ -158,9 +213,10 @@ func (c *memoryCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorO
 		return ErrNotProvided
 	}
 	if config.lvDomains == nil || len(config.lvDomains) == 0 {
-		level.Error(c.logger).Log("msg", "no domains found")
+		level.Debug(c.logger).Log("msg", "no domains found")
 		return ErrNotProvided
 	}
+	ctx := config.context()
 	pLibvirt := config.pLibvirt
 	lvDomains := config.lvDomains
 
@@ -169,47 +225,75 @@ func (c *memoryCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorO
 
 	for _, lvDomain := range lvDomains {
 		domainUUID := lvDomain.Schema.UUID
+		if !lvDomain.Running {
+			// The balloon driver of a paused guest does not update its
+			// stats.
+			level.Debug(c.logger).Log("msg", "skip memory stats of domain which is not running", "domain", lvDomain.Domain.Name)
+			wg.Done()
+			continue
+		}
 		go func(domain libvirt.Domain, domainUUID string) {
+			if !acquireWorker(ctx) {
+				wg.Done()
+				return
+			}
+			defer releaseWorker()
+			defer busyDomain(ctx, domainUUID)()
 			stats, err := pLibvirt.DomainMemoryStats(domain, uint32(libvirt.DomainMemoryStatNr), 0)
+			if IsNotRunningError(err) {
+				level.Debug(c.logger).Log("msg", "domain stopped during the scrape", "domain", domain.Name, "err", err)
+				wg.Done()
+				return
+			}
 			if err != nil {
-				level.Error(c.logger).Log("msg", "failed to get memory stats", "domain", domain.Name, "err", err)
+				domainErrorLogger(ctx, c.logger, "memory", domainUUID, err).Log("msg", "failed to get memory stats", "domain", domain.Name, "err", err)
 				wg.Done()
 				return
 			}
 
+			var (
+				available, usable       float64
+				hasAvailable, hasUsable bool
+			)
 			for _, stat := range stats {
 				tag := libvirt.DomainMemoryStatTags(stat.Tag)
+				value := memoryStatValue(tag, stat.Val)
 				switch tag {
 				case libvirt.DomainMemoryStatSwapIn:
-					ch <- c.swapInBytes.mustNewConstMetric(float64(stat.Val), domainUUID)
+					ch <- c.swapInBytes.mustNewConstMetric(value, domainUUID)
 				case libvirt.DomainMemoryStatSwapOut:
-					ch <- c.swapOutBytes.mustNewConstMetric(float64(stat.Val), domainUUID)
+					ch <- c.swapOutBytes.mustNewConstMetric(value, domainUUID)
 				case libvirt.DomainMemoryStatMajorFault:
-					ch <- c.majorPageFaults.mustNewConstMetric(float64(stat.Val), domainUUID)
+					ch <- c.majorPageFaults.mustNewConstMetric(value, domainUUID)
 				case libvirt.DomainMemoryStatMinorFault:
-					ch <- c.minorPageFaults.mustNewConstMetric(float64(stat.Val), domainUUID)
+					ch <- c.minorPageFaults.mustNewConstMetric(value, domainUUID)
 				case libvirt.DomainMemoryStatUnused:
-					ch <- c.unusedBytes.mustNewConstMetric(float64(stat.Val), domainUUID)
+					ch <- c.unusedBytes.mustNewConstMetric(value, domainUUID)
 				case libvirt.DomainMemoryStatAvailable:
-					ch <- c.availableBytes.mustNewConstMetric(float64(stat.Val), domainUUID)
+					available, hasAvailable = value, true
+					ch <- c.availableBytes.mustNewConstMetric(value, domainUUID)
 				case libvirt.DomainMemoryStatActualBalloon:
-					ch <- c.actualBallonBytes.mustNewConstMetric(float64(stat.Val), domainUUID)
+					ch <- c.actualBallonBytes.mustNewConstMetric(value, domainUUID)
 				case libvirt.DomainMemoryStatRss:
-					ch <- c.rssBytes.mustNewConstMetric(float64(stat.Val), domainUUID)
+					ch <- c.rssBytes.mustNewConstMetric(value, domainUUID)
 				case libvirt.DomainMemoryStatUsable:
-					ch <- c.usableBytes.mustNewConstMetric(float64(stat.Val), domainUUID)
+					usable, hasUsable = value, true
+					ch <- c.usableBytes.mustNewConstMetric(value, domainUUID)
 				case libvirt.DomainMemoryStatDiskCaches:
-					ch <- c.diskCacheBytes.mustNewConstMetric(float64(stat.Val), domainUUID)
+					ch <- c.diskCacheBytes.mustNewConstMetric(value, domainUUID)
 				case libvirt.DomainMemoryStatLastUpdate:
-					ch <- c.lastUpdateTimestamp.mustNewConstMetric(float64(stat.Val), domainUUID)
+					ch <- c.lastUpdateTimestamp.mustNewConstMetric(value, domainUUID)
 				case libvirt.DomainMemoryStatHugetlbPgalloc:
-					ch <- c.hugetlbPagesAlloc.mustNewConstMetric(float64(stat.Val), domainUUID)
+					ch <- c.hugetlbPagesAlloc.mustNewConstMetric(value, domainUUID)
 				case libvirt.DomainMemoryStatHugetlbPgfail:
-					ch <- c.hugetlbPageFaults.mustNewConstMetric(float64(stat.Val), domainUUID)
+					ch <- c.hugetlbPageFaults.mustNewConstMetric(value, domainUUID)
 				default:
 					level.Error(c.logger).Log("msg", "unknown memory stat", "domain", domain.Name, "tag", stat.Tag)
 				}
 			}
+			if hasAvailable && hasUsable {
+				ch <- c.usedBytes.mustNewConstMetric(available-usable, domainUUID)
+			}
 			wg.Done()
 		}(lvDomain.Domain, domainUUID)
 	}
@@ -0,0 +1,48 @@
+package collector
+
+import (
+	"testing"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+)
+
+func TestMemoryStatValue(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  libvirt.DomainMemoryStatTags
+		// bytes and kib are the expected values of a stat of 2 without
+		// and with --collector.memory.kib-units.
+		bytes float64
+		kib   float64
+	}{
+		{"swap_in", libvirt.DomainMemoryStatSwapIn, 2048, 2},
+		{"swap_out", libvirt.DomainMemoryStatSwapOut, 2048, 2},
+		{"major_fault", libvirt.DomainMemoryStatMajorFault, 2, 2},
+		{"minor_fault", libvirt.DomainMemoryStatMinorFault, 2, 2},
+		{"unused", libvirt.DomainMemoryStatUnused, 2048, 2},
+		{"available", libvirt.DomainMemoryStatAvailable, 2048, 2},
+		{"actual_balloon", libvirt.DomainMemoryStatActualBalloon, 2048, 2},
+		{"rss", libvirt.DomainMemoryStatRss, 2048, 2},
+		{"usable", libvirt.DomainMemoryStatUsable, 2048, 2},
+		{"last_update", libvirt.DomainMemoryStatLastUpdate, 2, 2},
+		{"disk_caches", libvirt.DomainMemoryStatDiskCaches, 2048, 2},
+		{"hugetlb_pgalloc", libvirt.DomainMemoryStatHugetlbPgalloc, 2, 2},
+		{"hugetlb_pgfail", libvirt.DomainMemoryStatHugetlbPgfail, 2, 2},
+		{"unknown", libvirt.DomainMemoryStatTags(99), 2, 2},
+	}
+
+	kibUnits := memoryKiBUnits.value.Load()
+	defer memoryKiBUnits.value.Store(kibUnits)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memoryKiBUnits.Set("false")
+			if got := memoryStatValue(tt.tag, 2); got != tt.bytes {
+				t.Errorf("memoryStatValue(%d, 2) = %v, want %v", tt.tag, got, tt.bytes)
+			}
+			memoryKiBUnits.Set("true")
+			if got := memoryStatValue(tt.tag, 2); got != tt.kib {
+				t.Errorf("memoryStatValue(%d, 2) with kib units = %v, want %v", tt.tag, got, tt.kib)
+			}
+		})
+	}
+}
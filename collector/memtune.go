@@ -0,0 +1,138 @@
+package collector
+
+import (
+	"strconv"
+	"sync"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/nee541/libvirt-exporter/libvirt_schema"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const memtuneSubsystemName = "domain_memtune"
+
+// memoryParametersMax is REMOTE_DOMAIN_MEMORY_PARAMETERS_MAX, the most memory
+// parameters libvirt returns.
+const memoryParametersMax = 16
+
+// memoryParamUnlimited is VIR_DOMAIN_MEMORY_PARAM_UNLIMITED in KiB.
+const memoryParamUnlimited = 9007199254740991
+
+// memtuneCollector exposes the memory limits of domains, for allocated-vs-limit
+// ratios with the allocation collector in balloon troubleshooting.
+type memtuneCollector struct {
+	hardLimit     typedDesc
+	softLimit     typedDesc
+	swapHardLimit typedDesc
+	hugepages     typedDesc
+	hugepageSize  infoDesc
+	logger        log.Logger
+}
+
+func init() {
+	registerCollector("memtune", defaultDisabled, NewMemtuneCollector)
+}
+
+// NewMemtuneCollector returns a new Collector exposing domain memory limits.
+func NewMemtuneCollector(logger log.Logger) (Collector, error) {
+	newDesc := func(subsystem, name, help string) typedDesc {
+		return typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, subsystem, name),
+				help,
+				[]string{"domain_uuid"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		}
+	}
+	return &memtuneCollector{
+		hardLimit:     newDesc(memtuneSubsystemName, "hard_limit_bytes", "Maximum memory the domain may use, absent if unlimited"),
+		softLimit:     newDesc(memtuneSubsystemName, "soft_limit_bytes", "Memory the domain is limited to under memory contention, absent if unlimited"),
+		swapHardLimit: newDesc(memtuneSubsystemName, "swap_hard_limit_bytes", "Maximum memory plus swap the domain may use, absent if unlimited"),
+		hugepages:     newDesc("domain", "hugepages_backed", "Whether the memory of the domain is backed by huge pages"),
+		hugepageSize: newInfoDesc("domain", "hugepages_info",
+			"Huge page size backing the memory of the guest NUMA nodes in nodeset, size_bytes is empty for the default huge page size of the host",
+			"domain_uuid", "size_bytes", "nodeset"),
+		logger: logger,
+	}, nil
+}
+
+func (c *memtuneCollector) descs() []typedDesc {
+	return []typedDesc{c.hardLimit, c.softLimit, c.swapHardLimit, c.hugepages, c.hugepageSize.typedDesc}
+}
+
+func (c *memtuneCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	infos := newInfoMetrics(ch)
+
+	if config.pLibvirt == nil {
+		level.Error(c.logger).Log("msg", "libvirt not created")
+		return ErrNotProvided
+	}
+	if !config.pLibvirt.IsConnected() {
+		level.Error(c.logger).Log("msg", "libvirt not connected")
+		return ErrNotProvided
+	}
+	if len(config.lvDomains) == 0 {
+		level.Debug(c.logger).Log("msg", "no domains found")
+		return ErrNotProvided
+	}
+	ctx := config.context()
+	pLibvirt := config.pLibvirt
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(config.lvDomains))
+	for _, lvDomain := range config.lvDomains {
+		domainUUID := lvDomain.Schema.UUID
+		c.updateHugepages(ch, infos, lvDomain.Schema.MemoryBacking.Hugepages, domainUUID)
+		go func(domain libvirt.Domain, domainUUID string) {
+			defer wg.Done()
+			if !acquireWorker(ctx) {
+				return
+			}
+			defer releaseWorker()
+			params, _, err := pLibvirt.DomainGetMemoryParameters(domain, memoryParametersMax, uint32(libvirt.DomainAffectCurrent))
+			if err != nil {
+				level.Error(c.logger).Log("msg", "failed to get memory parameters", "domain", domain.Name, "err", err)
+				return
+			}
+			// The limits are in KiB.
+			for _, p := range []struct {
+				field string
+				desc  *typedDesc
+			}{
+				{"hard_limit", &c.hardLimit},
+				{"soft_limit", &c.softLimit},
+				{"swap_hard_limit", &c.swapHardLimit},
+			} {
+				if v, ok := typedParamValue(params, p.field); ok && v < memoryParamUnlimited {
+					ch <- p.desc.mustNewConstMetric(v*1024, domainUUID)
+				}
+			}
+		}(lvDomain.Domain, domainUUID)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// updateHugepages exposes the <memoryBacking><hugepages> settings of a
+// domain, which has to find enough free huge pages on the host to start.
+func (c *memtuneCollector) updateHugepages(ch chan<- prometheus.Metric, infos *infoMetrics, hugepages *libvirt_schema.Hugepages, domainUUID string) {
+	if hugepages == nil {
+		ch <- c.hugepages.mustNewConstMetric(0, domainUUID)
+		return
+	}
+	ch <- c.hugepages.mustNewConstMetric(1, domainUUID)
+	if len(hugepages.Pages) == 0 {
+		infos.emit(c.hugepageSize, domainUUID, "", "")
+	}
+	for _, page := range hugepages.Pages {
+		infos.emit(c.hugepageSize, domainUUID, strconv.FormatUint(page.Bytes(), 10), page.Nodeset)
+	}
+}
@@ -0,0 +1,132 @@
+package collector
+
+import (
+	"sync"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const numaSubsystemName = "domain_numa"
+
+// numaParametersMax is REMOTE_DOMAIN_NUMA_PARAMETERS_MAX, the most NUMA
+// parameters libvirt returns.
+const numaParametersMax = 16
+
+// numatuneModes names the memory modes of <numatune>.
+var numatuneModes = map[libvirt.DomainNumatuneMemMode]string{
+	libvirt.DomainNumatuneMemStrict:     "strict",
+	libvirt.DomainNumatuneMemPreferred:  "preferred",
+	libvirt.DomainNumatuneMemInterleave: "interleave",
+	// VIR_DOMAIN_NUMATUNE_MEM_RESTRICTIVE is missing in go-libvirt.
+	3: "restrictive",
+}
+
+// numaCollector exposes the NUMA placement of domains, so domains whose
+// memory is spread over the wrong host nodes can be found.
+type numaCollector struct {
+	tuneInfo   infoDesc
+	nodeMemory typedDesc
+	logger     log.Logger
+}
+
+func init() {
+	registerCollector("numa", defaultDisabled, NewNumaCollector)
+}
+
+// NewNumaCollector returns a new Collector exposing domain NUMA placement.
+func NewNumaCollector(logger log.Logger) (Collector, error) {
+	return &numaCollector{
+		tuneInfo: newInfoDesc(numaSubsystemName, "tune_info",
+			"Memory mode and host NUMA nodes the memory of the domain is allocated from, an empty nodeset means all nodes",
+			"domain_uuid", "mode", "nodeset"),
+		nodeMemory: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, numaSubsystemName, "node_memory_bytes"),
+				"Memory of the QEMU process by host NUMA node, only available for local connections",
+				[]string{"domain_uuid", "node"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		logger: logger,
+	}, nil
+}
+
+func (c *numaCollector) descs() []typedDesc {
+	return []typedDesc{c.tuneInfo.typedDesc, c.nodeMemory}
+}
+
+func (c *numaCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	infos := newInfoMetrics(ch)
+
+	if config.pLibvirt == nil {
+		level.Error(c.logger).Log("msg", "libvirt not created")
+		return ErrNotProvided
+	}
+	if !config.pLibvirt.IsConnected() {
+		level.Error(c.logger).Log("msg", "libvirt not connected")
+		return ErrNotProvided
+	}
+	if len(config.lvDomains) == 0 {
+		level.Debug(c.logger).Log("msg", "no domains found")
+		return ErrNotProvided
+	}
+	ctx := config.context()
+	pLibvirt := config.pLibvirt
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(config.lvDomains))
+	for _, lvDomain := range config.lvDomains {
+		go func(domain libvirt.Domain, domainUUID string) {
+			defer wg.Done()
+			if !acquireWorker(ctx) {
+				return
+			}
+			defer releaseWorker()
+			params, _, err := pLibvirt.DomainGetNumaParameters(domain, numaParametersMax, uint32(libvirt.DomainAffectCurrent))
+			if err != nil {
+				level.Error(c.logger).Log("msg", "failed to get numa parameters", "domain", domain.Name, "err", err)
+			} else {
+				mode := "unknown"
+				if v, ok := typedParamValue(params, "numa_mode"); ok {
+					if name, ok := numatuneModes[libvirt.DomainNumatuneMemMode(v)]; ok {
+						mode = name
+					}
+				}
+				var nodeset string
+				for _, p := range params {
+					if s, ok := p.Value.I.(string); ok && p.Field == "numa_nodeset" {
+						nodeset = s
+					}
+				}
+				infos.emit(c.tuneInfo, domainUUID, mode, nodeset)
+			}
+
+			if !config.local {
+				return
+			}
+			pid, err := qemuPID(domain.Name)
+			if err != nil {
+				level.Debug(c.logger).Log("msg", "failed to get qemu pid", "domain", domain.Name, "err", err)
+				return
+			}
+			nodes, err := numaMapsNodeBytes(pid)
+			if err != nil {
+				level.Debug(c.logger).Log("msg", "failed to read numa maps", "domain", domain.Name, "err", err)
+				return
+			}
+			for node, size := range nodes {
+				ch <- c.nodeMemory.mustNewConstMetric(float64(size), domainUUID, node)
+			}
+		}(lvDomain.Domain, lvDomain.Schema.UUID)
+	}
+	wg.Wait()
+
+	return nil
+}
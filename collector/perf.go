@@ -0,0 +1,115 @@
+package collector
+
+import (
+	"strings"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const perfSubsystemName = "domain_perf"
+
+// perfCollector exposes the perf events of domains that have them enabled in
+// <perf>, for noisy neighbour analysis down to the last level cache and
+// memory bandwidth.
+type perfCollector struct {
+	events         typedDesc
+	cacheOccupancy typedDesc
+	memBandwidth   typedDesc
+	logger         log.Logger
+}
+
+func init() {
+	registerCollector("perf", defaultDisabled, NewPerfCollector)
+}
+
+// NewPerfCollector returns a new Collector exposing domain perf events.
+func NewPerfCollector(logger log.Logger) (Collector, error) {
+	return &perfCollector{
+		events: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, perfSubsystemName, "events_total"),
+				"Number of perf events of the domain, e.g. cpu_cycles, instructions or cache_misses",
+				[]string{"domain_uuid", "event"},
+				nil),
+			valueType: prometheus.CounterValue,
+		},
+		cacheOccupancy: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, perfSubsystemName, "cache_occupancy_bytes"),
+				"Last level cache used by the domain (cmt)",
+				[]string{"domain_uuid"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		memBandwidth: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, perfSubsystemName, "memory_bandwidth_bytes_per_second"),
+				"Memory bandwidth used by the domain, scope is total (mbmt) or local (mbml) to the NUMA node",
+				[]string{"domain_uuid", "scope"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		logger: logger,
+	}, nil
+}
+
+func (c *perfCollector) descs() []typedDesc {
+	return []typedDesc{c.events, c.cacheOccupancy, c.memBandwidth}
+}
+
+func (c *perfCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	if config.pLibvirt == nil {
+		level.Error(c.logger).Log("msg", "libvirt not created")
+		return ErrNotProvided
+	}
+	if !config.pLibvirt.IsConnected() {
+		level.Error(c.logger).Log("msg", "libvirt not connected")
+		return ErrNotProvided
+	}
+	if len(config.lvDomains) == 0 {
+		level.Debug(c.logger).Log("msg", "no domains found")
+		return ErrNotProvided
+	}
+
+	domains := make([]libvirt.Domain, 0, len(config.lvDomains))
+	for _, lvDomain := range config.lvDomains {
+		domains = append(domains, lvDomain.Domain)
+	}
+	records, err := config.pLibvirt.ConnectGetAllDomainStats(domains, uint32(libvirt.DomainStatsPerf), 0)
+	if err != nil {
+		return err
+	}
+	// Only enabled events are reported.
+	for _, record := range records {
+		domainUUID := uuidString(record.Dom.UUID)
+		for _, p := range record.Params {
+			event, ok := strings.CutPrefix(p.Field, "perf.")
+			if !ok {
+				continue
+			}
+			value, ok := typedParamValue(record.Params, p.Field)
+			if !ok {
+				continue
+			}
+			switch event {
+			case "cmt":
+				ch <- c.cacheOccupancy.mustNewConstMetric(value, domainUUID)
+			case "mbmt":
+				ch <- c.memBandwidth.mustNewConstMetric(value, domainUUID, "total")
+			case "mbml":
+				ch <- c.memBandwidth.mustNewConstMetric(value, domainUUID, "local")
+			default:
+				ch <- c.events.mustNewConstMetric(value, domainUUID, event)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const poolSubsystemName = "pool"
+
+var poolRefreshInterval = reloadableDuration(kingpin.Flag(
+	"collector.pool.refresh-interval",
+	"Refresh active storage pools if their last refresh is older than this, so broken pools such as unreachable NFS shares are detected. 0 disables refreshing.",
+).Default("0s"))
+
+// poolStates names the storage pool states, see
+// https://libvirt.org/html/libvirt-libvirt-storage.html#virStoragePoolState
+var poolStates = []struct {
+	state libvirt.StoragePoolState
+	name  string
+}{
+	{libvirt.StoragePoolInactive, "inactive"},
+	{libvirt.StoragePoolBuilding, "building"},
+	{libvirt.StoragePoolRunning, "running"},
+	{libvirt.StoragePoolDegraded, "degraded"},
+	{libvirt.StoragePoolInaccessible, "inaccessible"},
+}
+
+// poolCollector exposes the state and usage of storage pools. Pools which are
+// not running only report their state, so they are alertable instead of
+// failing the collector.
+type poolCollector struct {
+	state       typedDesc
+	capacity    typedDesc
+	allocation  typedDesc
+	available   typedDesc
+	volumes     typedDesc
+	lastRefresh typedDesc
+	logger      log.Logger
+
+	mtx sync.Mutex
+	// refreshed holds the time of the last successful refresh by pool name.
+	refreshed map[string]time.Time
+	// refreshing holds the pools with a refresh in progress. A pool whose
+	// refresh hangs, e.g. on an unreachable NFS server, is not refreshed
+	// again until that refresh returns.
+	refreshing map[string]bool
+	refresher  sync.Once
+}
+
+func init() {
+	registerCollector("pool", defaultEnabled, NewPoolCollector)
+}
+
+// NewPoolCollector returns a new Collector exposing storage pools.
+func NewPoolCollector(logger log.Logger) (Collector, error) {
+	newDesc := func(name, help string, labels ...string) typedDesc {
+		return typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, poolSubsystemName, name),
+				help,
+				append([]string{"pool"}, labels...),
+				nil),
+			valueType: prometheus.GaugeValue,
+		}
+	}
+	return &poolCollector{
+		state:       newDesc("state", "Whether the storage pool is in the given state", "state"),
+		capacity:    newDesc("capacity_bytes", "Logical size of the storage pool in bytes"),
+		allocation:  newDesc("allocation_bytes", "Current allocation of the storage pool in bytes"),
+		available:   newDesc("available_bytes", "Remaining free space of the storage pool in bytes"),
+		volumes:     newDesc("volumes", "Number of volumes in the storage pool"),
+		lastRefresh: newDesc("last_refresh_timestamp_seconds", "Time of the last successful refresh of the storage pool by the exporter since unix epoch in seconds"),
+		logger:      logger,
+		refreshed:   make(map[string]time.Time),
+		refreshing:  make(map[string]bool),
+	}, nil
+}
+
+func (c *poolCollector) descs() []typedDesc {
+	return []typedDesc{c.state, c.capacity, c.allocation, c.available, c.volumes, c.lastRefresh}
+}
+
+func (c *poolCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	if config.pLibvirt == nil {
+		level.Error(c.logger).Log("msg", "libvirt not created")
+		return ErrNotProvided
+	}
+	if !config.pLibvirt.IsConnected() {
+		level.Error(c.logger).Log("msg", "libvirt not connected")
+		return ErrNotProvided
+	}
+	ctx := config.context()
+	pLibvirt := config.pLibvirt
+
+	if poolRefreshInterval.Get() > 0 && !isDryRun(ctx) {
+		// Refreshes run in the background, they can take as long as the
+		// storage backend needs without holding up scrapes.
+		c.refresher.Do(func() {
+			go c.refreshLoop(pLibvirt)
+		})
+	}
+
+	pools, _, err := pLibvirt.ConnectListAllStoragePools(1, 0)
+	if err != nil {
+		return err
+	}
+	for _, pool := range pools {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.mtx.Lock()
+		at, ok := c.refreshed[pool.Name]
+		c.mtx.Unlock()
+		if ok {
+			ch <- c.lastRefresh.mustNewConstMetric(float64(at.UnixNano())/1e9, pool.Name)
+		}
+
+		state, capacity, allocation, available, err := pLibvirt.StoragePoolGetInfo(pool)
+		if err != nil {
+			level.Error(c.logger).Log("msg", "failed to get storage pool info", "pool", pool.Name, "err", err)
+			continue
+		}
+		for _, s := range poolStates {
+			value := 0.0
+			if libvirt.StoragePoolState(state) == s.state {
+				value = 1
+			}
+			ch <- c.state.mustNewConstMetric(value, pool.Name, s.name)
+		}
+		if libvirt.StoragePoolState(state) != libvirt.StoragePoolRunning {
+			continue
+		}
+		ch <- c.capacity.mustNewConstMetric(float64(capacity), pool.Name)
+		ch <- c.allocation.mustNewConstMetric(float64(allocation), pool.Name)
+		ch <- c.available.mustNewConstMetric(float64(available), pool.Name)
+		volumes, err := pLibvirt.StoragePoolNumOfVolumes(pool)
+		if err != nil {
+			level.Error(c.logger).Log("msg", "failed to count storage pool volumes", "pool", pool.Name, "err", err)
+			continue
+		}
+		ch <- c.volumes.mustNewConstMetric(float64(volumes), pool.Name)
+	}
+	return nil
+}
+
+// refreshLoop refreshes the storage pools whose last refresh is older than
+// the refresh interval, checking every tenth of the interval.
+func (c *poolCollector) refreshLoop(pLibvirt *libvirt.Libvirt) {
+	ticker := time.NewTicker(max(poolRefreshInterval.Get()/10, time.Second))
+	defer ticker.Stop()
+	for {
+		if pLibvirt.IsConnected() {
+			c.refresh(pLibvirt)
+		}
+		<-ticker.C
+	}
+}
+
+// refresh starts a refresh of every due pool in its own goroutine and
+// forgets pools which no longer exist.
+func (c *poolCollector) refresh(pLibvirt *libvirt.Libvirt) {
+	pools, _, err := pLibvirt.ConnectListAllStoragePools(1, 0)
+	if err != nil {
+		level.Debug(c.logger).Log("msg", "failed to list storage pools for refresh", "err", err)
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	listed := make(map[string]bool, len(pools))
+	for _, pool := range pools {
+		listed[pool.Name] = true
+		if c.refreshing[pool.Name] || time.Since(c.refreshed[pool.Name]) <= poolRefreshInterval.Get() {
+			continue
+		}
+		c.refreshing[pool.Name] = true
+		go func(pool libvirt.StoragePool) {
+			// Refreshing an inactive pool fails as well, so the
+			// timestamp stops advancing for those.
+			err := pLibvirt.StoragePoolRefresh(pool, 0)
+			if err != nil {
+				level.Warn(c.logger).Log("msg", "failed to refresh storage pool", "pool", pool.Name, "err", err)
+			}
+			c.mtx.Lock()
+			defer c.mtx.Unlock()
+			delete(c.refreshing, pool.Name)
+			if err == nil {
+				c.refreshed[pool.Name] = time.Now()
+			}
+		}(pool)
+	}
+	for name := range c.refreshed {
+		if !listed[name] {
+			delete(c.refreshed, name)
+		}
+	}
+}
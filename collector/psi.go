@@ -0,0 +1,176 @@
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/kingpin/v2"
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var psiCgroupRoot = reloadableString(kingpin.Flag(
+	"collector.psi.cgroup-root",
+	"Mount point of the cgroup v2 hierarchy read by the psi collector, only used for local connections.",
+).Default("/sys/fs/cgroup"))
+
+// psiResources are the resources with pressure stall information.
+var psiResources = []string{"cpu", "memory", "io"}
+
+// psiCollector exposes the pressure stall information (PSI) of the cgroup of
+// each domain, the time its tasks waited for CPU, memory or I/O. It is the
+// most direct signal of noisy neighbours, and libvirt has no API for it.
+type psiCollector struct {
+	waiting typedDesc
+	stalled typedDesc
+	logger  log.Logger
+}
+
+func init() {
+	registerCollector("psi", defaultDisabled, NewPSICollector)
+}
+
+// NewPSICollector returns a new Collector exposing domain cgroup pressure.
+func NewPSICollector(logger log.Logger) (Collector, error) {
+	return &psiCollector{
+		waiting: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "domain_pressure", "waiting_seconds_total"),
+				"Time at least one task of the domain waited for the resource (some) in seconds",
+				[]string{"domain_uuid", "resource"},
+				nil),
+			valueType: prometheus.CounterValue,
+		},
+		stalled: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "domain_pressure", "stalled_seconds_total"),
+				"Time all tasks of the domain waited for the resource at once (full) in seconds, not reported for cpu by older kernels",
+				[]string{"domain_uuid", "resource"},
+				nil),
+			valueType: prometheus.CounterValue,
+		},
+		logger: logger,
+	}, nil
+}
+
+func (c *psiCollector) descs() []typedDesc {
+	return []typedDesc{c.waiting, c.stalled}
+}
+
+func (c *psiCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	if !config.local {
+		return errNotLocal
+	}
+	if len(config.lvDomains) == 0 {
+		level.Debug(c.logger).Log("msg", "no domains found")
+		return ErrNotProvided
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(config.lvDomains))
+	for _, lvDomain := range config.lvDomains {
+		go func(domain libvirt.Domain, domainUUID string) {
+			defer wg.Done()
+			dir, err := domainCgroupDir(domain.Name)
+			if err != nil {
+				level.Debug(c.logger).Log("msg", "failed to find domain cgroup", "domain", domain.Name, "err", err)
+				return
+			}
+			for _, resource := range psiResources {
+				some, full, err := readPressure(filepath.Join(dir, resource+".pressure"))
+				if err != nil {
+					// e.g. the kernel is booted without psi=1
+					level.Debug(c.logger).Log("msg", "failed to read pressure", "domain", domain.Name, "resource", resource, "err", err)
+					continue
+				}
+				ch <- c.waiting.mustNewConstMetric(some, domainUUID, resource)
+				if full >= 0 {
+					ch <- c.stalled.mustNewConstMetric(full, domainUUID, resource)
+				}
+			}
+		}(lvDomain.Domain, lvDomain.Schema.UUID)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// domainCgroupDir returns the cgroup v2 directory of a domain, the machine
+// scope systemd creates for it, e.g.
+// machine.slice/machine-qemu\x2d1\x2dvm.scope. libvirt moves the QEMU
+// process itself into sub-groups of the scope, which only cover part of the
+// domain.
+func domainCgroupDir(domainName string) (string, error) {
+	pid, err := qemuPID(domainName)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		path, ok := strings.CutPrefix(line, "0::")
+		if !ok {
+			continue
+		}
+		path, _, _ = strings.Cut(path, "/libvirt/")
+		return filepath.Join(psiCgroupRoot.Get(), path), nil
+	}
+	return "", fmt.Errorf("process %d is not in a cgroup v2 hierarchy", pid)
+}
+
+// readPressure returns the some and full totals of a pressure file in
+// seconds. full is -1 if the file has no full line.
+func readPressure(path string) (some, full float64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	some, full = -1, -1
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// some avg10=0.00 avg60=0.00 avg300=0.00 total=12345
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			value, ok := strings.CutPrefix(field, "total=")
+			if !ok {
+				continue
+			}
+			us, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("invalid total in %s: %w", path, err)
+			}
+			switch fields[0] {
+			case "some":
+				some = float64(us) / 1e6
+			case "full":
+				full = float64(us) / 1e6
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	if some < 0 {
+		return 0, 0, fmt.Errorf("no some line in %s", path)
+	}
+	return some, full, nil
+}
@@ -0,0 +1,84 @@
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/procfs"
+)
+
+var qemuRunDir = kingpin.Flag(
+	"collector.qemu.run-dir",
+	"Directory holding the pid files of QEMU processes, read for per-process metrics, only used for local connections.",
+).Default("/run/libvirt/qemu").String()
+
+// qemuPID returns the pid of the QEMU process running a domain.
+func qemuPID(domainName string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(*qemuRunDir, domainName+".pid"))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// qemuStartTime returns the start time of the QEMU process running a domain
+// in seconds since the epoch.
+func qemuStartTime(fs procfs.FS, domainName string) (float64, error) {
+	pid, err := qemuPID(domainName)
+	if err != nil {
+		return 0, err
+	}
+	proc, err := fs.Proc(pid)
+	if err != nil {
+		return 0, err
+	}
+	stat, err := proc.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.StartTime()
+}
+
+// numaMapsNodeBytes sums the memory of a process by NUMA node from
+// /proc/<pid>/numa_maps, where each mapping lists its pages per node as
+// N<node>=<pages> along with the page size.
+func numaMapsNodeBytes(pid int) (map[string]uint64, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/numa_maps", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	nodes := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		pageSize := uint64(4096)
+		pages := make(map[string]uint64)
+		for _, field := range fields {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			switch {
+			case key == "kernelpagesize_kB":
+				if kb, err := strconv.ParseUint(value, 10, 64); err == nil {
+					pageSize = kb * 1024
+				}
+			case len(key) > 1 && key[0] == 'N':
+				if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+					pages[key[1:]] += n
+				}
+			}
+		}
+		for node, n := range pages {
+			nodes[node] += n * pageSize
+		}
+	}
+	return nodes, scanner.Err()
+}
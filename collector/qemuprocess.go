@@ -0,0 +1,116 @@
+package collector
+
+import (
+	"sync"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+// qemuProcessCollector exposes the host-side resource usage of the QEMU
+// process of each domain from /proc. Unlike the guest view of the memory
+// collector it includes the overhead of the hypervisor, e.g. device
+// emulation and I/O threads.
+type qemuProcessCollector struct {
+	residentMemory typedDesc
+	virtualMemory  typedDesc
+	cpuSeconds     typedDesc
+	threads        typedDesc
+	openFDs        typedDesc
+	startTime      typedDesc
+	logger         log.Logger
+}
+
+func init() {
+	registerCollector("qemu_process", defaultDisabled, NewQemuProcessCollector)
+}
+
+// NewQemuProcessCollector returns a new Collector exposing QEMU process
+// metrics.
+func NewQemuProcessCollector(logger log.Logger) (Collector, error) {
+	newDesc := func(name, help string, valueType prometheus.ValueType) typedDesc {
+		return typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "domain_qemu", name),
+				help,
+				[]string{"domain_uuid"},
+				nil),
+			valueType: valueType,
+		}
+	}
+	return &qemuProcessCollector{
+		residentMemory: newDesc("resident_memory_bytes", "Resident memory of the QEMU process of the domain in bytes", prometheus.GaugeValue),
+		virtualMemory:  newDesc("virtual_memory_bytes", "Virtual memory of the QEMU process of the domain in bytes", prometheus.GaugeValue),
+		cpuSeconds:     newDesc("cpu_seconds_total", "User and system CPU time of the QEMU process of the domain in seconds, vCPU threads included", prometheus.CounterValue),
+		threads:        newDesc("threads", "Number of threads of the QEMU process of the domain", prometheus.GaugeValue),
+		openFDs:        newDesc("open_fds", "Number of open file descriptors of the QEMU process of the domain", prometheus.GaugeValue),
+		startTime:      newDesc("start_time_seconds", "Start time of the QEMU process of the domain since unix epoch in seconds", prometheus.GaugeValue),
+		logger:         logger,
+	}, nil
+}
+
+func (c *qemuProcessCollector) descs() []typedDesc {
+	return []typedDesc{c.residentMemory, c.virtualMemory, c.cpuSeconds, c.threads, c.openFDs, c.startTime}
+}
+
+func (c *qemuProcessCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	if !config.local {
+		return errNotLocal
+	}
+	if len(config.lvDomains) == 0 {
+		level.Debug(c.logger).Log("msg", "no domains found")
+		return ErrNotProvided
+	}
+	fs, err := procfs.NewDefaultFS()
+	if err != nil {
+		return err
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(config.lvDomains))
+	for _, lvDomain := range config.lvDomains {
+		go func(domain libvirt.Domain, domainUUID string) {
+			defer wg.Done()
+			pid, err := qemuPID(domain.Name)
+			if err != nil {
+				level.Debug(c.logger).Log("msg", "failed to get qemu pid", "domain", domain.Name, "err", err)
+				return
+			}
+			proc, err := fs.Proc(pid)
+			if err != nil {
+				level.Debug(c.logger).Log("msg", "failed to open qemu process", "domain", domain.Name, "pid", pid, "err", err)
+				return
+			}
+			stat, err := proc.Stat()
+			if err != nil {
+				level.Debug(c.logger).Log("msg", "failed to read qemu process stat", "domain", domain.Name, "pid", pid, "err", err)
+				return
+			}
+			ch <- c.residentMemory.mustNewConstMetric(float64(stat.ResidentMemory()), domainUUID)
+			ch <- c.virtualMemory.mustNewConstMetric(float64(stat.VirtualMemory()), domainUUID)
+			ch <- c.cpuSeconds.mustNewConstMetric(stat.CPUTime(), domainUUID)
+			ch <- c.threads.mustNewConstMetric(float64(stat.NumThreads), domainUUID)
+			if startTime, err := stat.StartTime(); err == nil {
+				ch <- c.startTime.mustNewConstMetric(startTime, domainUUID)
+			}
+			// Reading the file descriptors of a process of another user
+			// needs CAP_SYS_PTRACE or CAP_DAC_READ_SEARCH.
+			if fds, err := proc.FileDescriptorsLen(); err == nil {
+				ch <- c.openFDs.mustNewConstMetric(float64(fds), domainUUID)
+			} else {
+				level.Debug(c.logger).Log("msg", "failed to count qemu file descriptors", "domain", domain.Name, "pid", pid, "err", err)
+			}
+		}(lvDomain.Domain, lvDomain.Schema.UUID)
+	}
+	wg.Wait()
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package collector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+// configMtx guards the collector states against changes by Reconfigure.
+// Scrapes only hold it while taking a snapshot of the states or filters, a
+// scrape waiting for libvirt must not block a reload and thereby all scrapes
+// after it. The flag values collectors read while scraping are swapped
+// atomically instead, see reloadableValue and anchoredRegexp.
+var configMtx sync.RWMutex
+
+// Reconfigure runs fn while no scrape takes a snapshot of the collector
+// states or domain filters, so fn can change them and the flag values, e.g.
+// when the config file is reloaded. The collector states are restored if fn
+// fails.
+func Reconfigure(fn func() error) error {
+	configMtx.Lock()
+	defer configMtx.Unlock()
+	states := collectorStates()
+	if err := fn(); err != nil {
+		RestoreCollectorStates(states)
+		return err
+	}
+	return nil
+}
+
+// reloadableValue is a kingpin value of a flag which may be changed by a
+// config reload while collectors read it, so the value is swapped
+// atomically.
+type reloadableValue[T any] struct {
+	value  atomic.Pointer[parsedValue[T]]
+	parse  func(string) (T, error)
+	isBool bool
+}
+
+type parsedValue[T any] struct {
+	source string
+	value  T
+}
+
+func newReloadableValue[T any](clause *kingpin.FlagClause, parse func(string) (T, error)) *reloadableValue[T] {
+	v := &reloadableValue[T]{parse: parse}
+	clause.SetValue(v)
+	return v
+}
+
+func reloadableBool(clause *kingpin.FlagClause) *reloadableValue[bool] {
+	v := &reloadableValue[bool]{parse: strconv.ParseBool, isBool: true}
+	clause.SetValue(v)
+	return v
+}
+
+func reloadableDuration(clause *kingpin.FlagClause) *reloadableValue[time.Duration] {
+	return newReloadableValue(clause, time.ParseDuration)
+}
+
+func reloadableString(clause *kingpin.FlagClause) *reloadableValue[string] {
+	return newReloadableValue(clause, func(s string) (string, error) { return s, nil })
+}
+
+func reloadableUint64(clause *kingpin.FlagClause) *reloadableValue[uint64] {
+	return newReloadableValue(clause, func(s string) (uint64, error) { return strconv.ParseUint(s, 0, 64) })
+}
+
+func reloadableEnum(clause *kingpin.FlagClause, options ...string) *reloadableValue[string] {
+	return newReloadableValue(clause, func(s string) (string, error) {
+		for _, option := range options {
+			if s == option {
+				return s, nil
+			}
+		}
+		return "", fmt.Errorf("enum value must be one of %s, got '%s'", strings.Join(options, ","), s)
+	})
+}
+
+// Set implements kingpin.Value.
+func (v *reloadableValue[T]) Set(s string) error {
+	value, err := v.parse(s)
+	if err != nil {
+		return err
+	}
+	v.value.Store(&parsedValue[T]{source: s, value: value})
+	return nil
+}
+
+// String implements kingpin.Value.
+func (v *reloadableValue[T]) String() string {
+	if p := v.value.Load(); p != nil {
+		return p.source
+	}
+	return ""
+}
+
+// IsBoolFlag lets kingpin accept boolean flags without a value.
+func (v *reloadableValue[T]) IsBoolFlag() bool {
+	return v.isBool
+}
+
+// Get returns the current value.
+func (v *reloadableValue[T]) Get() T {
+	if p := v.value.Load(); p != nil {
+		return p.value
+	}
+	var zero T
+	return zero
+}
+
+// CollectorStates returns whether each collector is enabled.
+func CollectorStates() map[string]bool {
+	configMtx.RLock()
+	defer configMtx.RUnlock()
+	return collectorStates()
+}
+
+func collectorStates() map[string]bool {
+	states := make(map[string]bool, len(collectorState))
+	for name, enabled := range collectorState {
+		states[name] = *enabled
+	}
+	return states
+}
+
+// RestoreCollectorStates enables and disables the collectors as in states,
+// which were returned by CollectorStates. It must only be called at startup
+// or within Reconfigure.
+func RestoreCollectorStates(states map[string]bool) {
+	for name, enabled := range states {
+		if state, ok := collectorState[name]; ok {
+			*state = enabled
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package collector
+
+import (
+	"sync"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// savedStateCollector exposes state a domain keeps besides its disks: a
+// managed save image it will resume from on the next start, and checkpoints
+// of incremental backup chains. Both mostly concern inactive domains, so all
+// defined domains are covered, not only the running ones.
+type savedStateCollector struct {
+	managedSave typedDesc
+	checkpoints typedDesc
+	logger      log.Logger
+}
+
+func init() {
+	registerCollector("savedstate", defaultDisabled, NewSavedStateCollector)
+}
+
+// NewSavedStateCollector returns a new Collector exposing managed save images
+// and checkpoints.
+func NewSavedStateCollector(logger log.Logger) (Collector, error) {
+	return &savedStateCollector{
+		managedSave: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "domain", "managed_save"),
+				"Whether the domain has a managed save image it resumes from on the next start",
+				[]string{"domain_uuid"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		checkpoints: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "domain", "checkpoints"),
+				"Number of checkpoints of the domain",
+				[]string{"domain_uuid"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		logger: logger,
+	}, nil
+}
+
+func (c *savedStateCollector) descs() []typedDesc {
+	return []typedDesc{c.managedSave, c.checkpoints}
+}
+
+func (c *savedStateCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	if config.pLibvirt == nil {
+		level.Error(c.logger).Log("msg", "libvirt not created")
+		return ErrNotProvided
+	}
+	if !config.pLibvirt.IsConnected() {
+		level.Error(c.logger).Log("msg", "libvirt not connected")
+		return ErrNotProvided
+	}
+	ctx := config.context()
+	pLibvirt := config.pLibvirt
+
+	domains, _, err := pLibvirt.ConnectListAllDomains(1, domainListFlags())
+	if err != nil {
+		return err
+	}
+	domains = selectDomains(domains)
+	managedSave, _, err := pLibvirt.ConnectListAllDomains(1, libvirt.ConnectListDomainsManagedsave)
+	if err != nil {
+		return err
+	}
+	hasManagedSave := make(map[libvirt.UUID]bool, len(managedSave))
+	for _, domain := range managedSave {
+		hasManagedSave[domain.UUID] = true
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(domains))
+	for _, domain := range domains {
+		domainUUID := uuidString(domain.UUID)
+		if hasManagedSave[domain.UUID] {
+			ch <- c.managedSave.mustNewConstMetric(1, domainUUID)
+		} else {
+			ch <- c.managedSave.mustNewConstMetric(0, domainUUID)
+		}
+		go func(domain libvirt.Domain, domainUUID string) {
+			defer wg.Done()
+			if !acquireWorker(ctx) {
+				return
+			}
+			defer releaseWorker()
+			// Without results only the number of checkpoints is returned.
+			_, count, err := pLibvirt.DomainListAllCheckpoints(domain, 0, 0)
+			if err != nil {
+				// e.g. checkpoints are not supported by the driver
+				level.Debug(c.logger).Log("msg", "failed to list checkpoints", "domain", domain.Name, "err", err)
+				return
+			}
+			ch <- c.checkpoints.mustNewConstMetric(float64(count), domainUUID)
+		}(domain, domainUUID)
+	}
+	wg.Wait()
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	scrapeErrorsDesc = newMetricDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_errors_total"),
+		"Number of scrapes a collector failed with an error or did not finish in time, finding no data is not an error.",
+		[]string{"collector"},
+		nil,
+	)
+	scrapeLastSuccessDesc = newMetricDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_last_success_timestamp_seconds"),
+		"Time a collector last succeeded since unix epoch in seconds, 0 if it never did. Results served from the cache do not count.",
+		[]string{"collector"},
+		nil,
+	)
+)
+
+// collectorTracker keeps the outcome of collectors across scrapes, so a
+// collector failing for hours stands out even if single scrapes are not
+// recorded.
+type collectorTracker struct {
+	mtx         sync.Mutex
+	errors      map[string]float64
+	lastSuccess map[string]time.Time
+}
+
+var collectorStats = &collectorTracker{
+	errors:      make(map[string]float64),
+	lastSuccess: make(map[string]time.Time),
+}
+
+// observe records the outcome of a collector in a scrape. success is false
+// for errors and collectors dropped at the scrape deadline only, a collector
+// which found no data, e.g. on a host without domains, succeeded.
+func (t *collectorTracker) observe(name string, success, cached bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	switch {
+	case !success:
+		t.errors[name]++
+	case !cached:
+		t.lastSuccess[name] = time.Now()
+	}
+}
+
+// metrics returns the outcomes of the named collectors as metrics.
+func (t *collectorTracker) metrics(names []string) []prometheus.Metric {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	metrics := make([]prometheus.Metric, 0, 2*len(names))
+	for _, name := range names {
+		var lastSuccess float64
+		if at, ok := t.lastSuccess[name]; ok {
+			lastSuccess = float64(at.UnixNano()) / 1e9
+		}
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(scrapeErrorsDesc, prometheus.CounterValue, t.errors[name], name),
+			prometheus.MustNewConstMetric(scrapeLastSuccessDesc, prometheus.GaugeValue, lastSuccess, name),
+		)
+	}
+	return metrics
+}
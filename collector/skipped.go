@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var devicesSkippedDesc = newMetricDesc(
+	prometheus.BuildFQName(namespace, "domain", "devices_skipped_total"),
+	"Number of times a device of the domain was skipped by a collector, by reason.",
+	[]string{"domain_uuid", "collector", "reason"},
+	nil,
+)
+
+// Reasons for skipping a device.
+const (
+	skipReasonCdrom    = "cdrom"
+	skipReasonFloppy   = "floppy"
+	skipReasonExcluded = "excluded"
+	skipReasonNoTarget = "no_target"
+	skipReasonHostdev  = "hostdev"
+	// skipReasonNotRunning is counted for the devices of paused domains and
+	// of domains stopping during the scrape.
+	skipReasonNotRunning = "not_running"
+)
+
+type skipKey struct {
+	domainUUID string
+	collector  string
+	reason     string
+}
+
+// skipTracker counts the devices collectors skip, so operators can confirm
+// their device filters drop the devices they intended.
+type skipTracker struct {
+	mtx    sync.Mutex
+	counts map[skipKey]float64
+}
+
+var devicesSkipped = &skipTracker{counts: make(map[skipKey]float64)}
+
+// inc counts a skipped device, unless ctx belongs to a dry run.
+func (t *skipTracker) inc(ctx context.Context, domainUUID, collector, reason string) {
+	if isDryRun(ctx) {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.counts[skipKey{domainUUID, collector, reason}]++
+}
+
+// retain drops the counts of all domains not in domainUUIDs.
+func (t *skipTracker) retain(domainUUIDs map[string]bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	for key := range t.counts {
+		if !domainUUIDs[key.domainUUID] {
+			delete(t.counts, key)
+		}
+	}
+}
+
+// metrics returns the counts as metrics.
+func (t *skipTracker) metrics() []prometheus.Metric {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	metrics := make([]prometheus.Metric, 0, len(t.counts))
+	for key, count := range t.counts {
+		metrics = append(metrics, prometheus.MustNewConstMetric(devicesSkippedDesc, prometheus.CounterValue, count, key.domainUUID, key.collector, key.reason))
+	}
+	return metrics
+}
@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+var pciSysfsPath = kingpin.Flag(
+	"collector.pci.sysfs-path",
+	"sysfs directory of PCI devices, read to find the physical function of SR-IOV virtual functions, only used for local connections.",
+).Default("/sys/bus/pci/devices").String()
+
+// sriovVF describes a virtual function of an SR-IOV capable network card.
+type sriovVF struct {
+	// pf is the PCI address of the physical function, like the pf label
+	// of libvirt_host_pci_vfs.
+	pf string
+	// pfDevice is the network device of the physical function, e.g.
+	// enp3s0f0, empty if it has none.
+	pfDevice string
+	// index is the number of the virtual function on the physical function.
+	index string
+}
+
+// vfStats are the counters the physical function keeps of a virtual function.
+// They are counted in hardware, from the view of the virtual function.
+type vfStats struct {
+	rxPackets uint64
+	txPackets uint64
+	rxBytes   uint64
+	txBytes   uint64
+	broadcast uint64
+	multicast uint64
+	// The drop counters are only reported by recent kernels.
+	hasDropped bool
+	rxDropped  uint64
+	txDropped  uint64
+}
+
+// lookupSriovVF finds the physical function of the virtual function at the
+// given PCI address in sysfs.
+func lookupSriovVF(sysfs, address string) (sriovVF, error) {
+	physfn, err := os.Readlink(filepath.Join(sysfs, address, "physfn"))
+	if err != nil {
+		return sriovVF{}, fmt.Errorf("%s is not a virtual function: %w", address, err)
+	}
+	pfDir := filepath.Join(sysfs, filepath.Base(physfn))
+	vf := sriovVF{pf: filepath.Base(physfn)}
+	if netdevs, err := os.ReadDir(filepath.Join(pfDir, "net")); err == nil && len(netdevs) > 0 {
+		vf.pfDevice = netdevs[0].Name()
+	}
+	virtfns, err := filepath.Glob(filepath.Join(pfDir, "virtfn*"))
+	if err != nil {
+		return vf, err
+	}
+	for _, virtfn := range virtfns {
+		target, err := os.Readlink(virtfn)
+		if err == nil && filepath.Base(target) == address {
+			vf.index = strings.TrimPrefix(filepath.Base(virtfn), "virtfn")
+			break
+		}
+	}
+	return vf, nil
+}
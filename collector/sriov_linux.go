@@ -0,0 +1,170 @@
+package collector
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+)
+
+const (
+	iflaExtMask    = 29
+	iflaVFInfoList = 22
+	iflaVFInfo     = 1
+	iflaVFMAC      = 1
+	iflaVFStats    = 16
+	rtextFilterVF  = 1
+	ifinfomsgLen   = 16
+	// nlaTypeMask strips the nested and byte order flags of an attribute
+	// type.
+	nlaTypeMask = 0x3fff
+)
+
+// The IFLA_VF_STATS_* attributes, see include/uapi/linux/if_link.h.
+const (
+	iflaVFStatsRxPackets = iota
+	iflaVFStatsTxPackets
+	iflaVFStatsRxBytes
+	iflaVFStatsTxBytes
+	iflaVFStatsBroadcast
+	iflaVFStatsMulticast
+	iflaVFStatsPad
+	iflaVFStatsRxDropped
+	iflaVFStatsTxDropped
+)
+
+// sriovVFStats reads the counters the physical function keeps of each of its
+// virtual functions with a netlink request, the way `ip -s link show <pf>`
+// does. They are keyed by the number of the virtual function.
+func sriovVFStats(pfDevice string) (map[uint32]vfStats, error) {
+	iface, err := net.InterfaceByName(pfDevice)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	req := make([]byte, syscall.NLMSG_HDRLEN+ifinfomsgLen+syscall.SizeofRtAttr+4)
+	binary.NativeEndian.PutUint32(req[0:4], uint32(len(req)))
+	binary.NativeEndian.PutUint16(req[4:6], syscall.RTM_GETLINK)
+	binary.NativeEndian.PutUint16(req[6:8], syscall.NLM_F_REQUEST)
+	binary.NativeEndian.PutUint32(req[8:12], 1)
+	req[syscall.NLMSG_HDRLEN] = syscall.AF_UNSPEC
+	binary.NativeEndian.PutUint32(req[syscall.NLMSG_HDRLEN+4:], uint32(iface.Index))
+	// The virtual functions are only included on request.
+	attr := req[syscall.NLMSG_HDRLEN+ifinfomsgLen:]
+	binary.NativeEndian.PutUint16(attr[0:2], syscall.SizeofRtAttr+4)
+	binary.NativeEndian.PutUint16(attr[2:4], iflaExtMask)
+	binary.NativeEndian.PutUint32(attr[4:8], rtextFilterVF)
+	if err := syscall.Sendto(fd, req, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	// The message grows with the number of virtual functions.
+	buf := make([]byte, 1<<20)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			switch msg.Header.Type {
+			case syscall.NLMSG_ERROR:
+				if len(msg.Data) >= 4 {
+					if errno := int32(binary.NativeEndian.Uint32(msg.Data[:4])); errno != 0 {
+						return nil, syscall.Errno(-errno)
+					}
+				}
+				return nil, nil
+			case syscall.RTM_NEWLINK:
+				if len(msg.Data) < ifinfomsgLen {
+					continue
+				}
+				stats := make(map[uint32]vfStats)
+				netlinkAttrs(msg.Data[ifinfomsgLen:], func(typ uint16, value []byte) {
+					if typ == iflaVFInfoList {
+						netlinkAttrs(value, func(typ uint16, value []byte) {
+							if typ == iflaVFInfo {
+								if vf, s, ok := parseVFInfo(value); ok {
+									stats[vf] = s
+								}
+							}
+						})
+					}
+				})
+				return stats, nil
+			}
+		}
+	}
+}
+
+// parseVFInfo returns the number and the counters of the virtual function of
+// an IFLA_VF_INFO attribute.
+func parseVFInfo(attrs []byte) (uint32, vfStats, bool) {
+	var vf uint32
+	var s vfStats
+	var hasVF, hasStats bool
+	netlinkAttrs(attrs, func(typ uint16, value []byte) {
+		switch typ {
+		case iflaVFMAC:
+			// struct ifla_vf_mac starts with the number of the
+			// virtual function.
+			if len(value) >= 4 {
+				vf, hasVF = binary.NativeEndian.Uint32(value[0:4]), true
+			}
+		case iflaVFStats:
+			hasStats = true
+			netlinkAttrs(value, func(typ uint16, value []byte) {
+				if len(value) < 8 {
+					return
+				}
+				v := binary.NativeEndian.Uint64(value[0:8])
+				switch typ {
+				case iflaVFStatsRxPackets:
+					s.rxPackets = v
+				case iflaVFStatsTxPackets:
+					s.txPackets = v
+				case iflaVFStatsRxBytes:
+					s.rxBytes = v
+				case iflaVFStatsTxBytes:
+					s.txBytes = v
+				case iflaVFStatsBroadcast:
+					s.broadcast = v
+				case iflaVFStatsMulticast:
+					s.multicast = v
+				case iflaVFStatsRxDropped:
+					s.rxDropped, s.hasDropped = v, true
+				case iflaVFStatsTxDropped:
+					s.txDropped, s.hasDropped = v, true
+				}
+			})
+		}
+	})
+	return vf, s, hasVF && hasStats
+}
+
+// netlinkAttrs calls fn for every attribute in attrs.
+func netlinkAttrs(attrs []byte, fn func(typ uint16, value []byte)) {
+	for len(attrs) >= syscall.SizeofRtAttr {
+		length := int(binary.NativeEndian.Uint16(attrs[0:2]))
+		typ := binary.NativeEndian.Uint16(attrs[2:4])
+		if length < syscall.SizeofRtAttr || length > len(attrs) {
+			return
+		}
+		fn(typ&nlaTypeMask, attrs[syscall.SizeofRtAttr:length])
+		aligned := (length + syscall.RTA_ALIGNTO - 1) &^ (syscall.RTA_ALIGNTO - 1)
+		if aligned > len(attrs) {
+			return
+		}
+		attrs = attrs[aligned:]
+	}
+}
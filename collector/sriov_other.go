@@ -0,0 +1,10 @@
+//go:build !linux
+
+package collector
+
+import "errors"
+
+// sriovVFStats is only implemented on Linux.
+func sriovVFStats(pfDevice string) (map[uint32]vfStats, error) {
+	return nil, errors.New("virtual function statistics not supported on this platform")
+}
@@ -0,0 +1,164 @@
+package collector
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/nee541/libvirt-exporter/state"
+)
+
+// eventCountersKey is the state file key of the event counters.
+const eventCountersKey = "event_counters"
+
+// eventCounters is the persisted form of the counters of domainEvents.
+type eventCounters struct {
+	DefinitionChanges map[string]float64            `json:"definition_changes"`
+	Events            map[string]map[string]float64 `json:"events"`
+	LastEvent         map[string]time.Time          `json:"last_event"`
+	// Devices is keyed by domain UUID and "<device type>/<event>".
+	Devices map[string]map[string]float64 `json:"devices"`
+	Booted  map[string]time.Time          `json:"booted"`
+}
+
+var stateWriteInterval = kingpin.Flag(
+	"state.write-interval",
+	"Interval in which the state file is written, events of the last interval are lost when the exporter is killed.",
+).Default("1m").Duration()
+
+var (
+	stateStore *state.Store
+
+	// pendingDomains holds the inventory of the latest scrape until the
+	// next write of the state file, nil if there was no scrape since.
+	pendingMtx     sync.Mutex
+	pendingDomains []state.Domain
+)
+
+// UseStateStore restores the event counters from store, so they do not reset
+// when the exporter restarts, and writes the domain inventory and the event
+// counters to it every --state.write-interval in the background. The stored
+// inventory is only read by the inspect command. The XML descriptions of
+// domains are not restored, as changes made while the exporter was down would
+// go unnoticed.
+func UseStateStore(store *state.Store, logger log.Logger) error {
+	var counters eventCounters
+	found, err := store.LoadValue(eventCountersKey, &counters)
+	if err != nil {
+		return err
+	}
+	if found {
+		domainEvents.restore(counters)
+	}
+	stateStore = store
+	go writeStateLoop(store, logger)
+	return nil
+}
+
+// persistState remembers the active domains of the current scrape for the
+// next write of the state file, if a state store is used. The inventory is
+// not restricted by the domain filters or backoff, domains whose description
+// was never fetched are stored without it.
+func persistState(active []libvirt.Domain) {
+	if stateStore == nil {
+		return
+	}
+	now := time.Now()
+	domains := make([]state.Domain, 0, len(active))
+	for _, domain := range active {
+		domainUUID := uuidString(domain.UUID)
+		schema, _ := domainSchemas.get(domainUUID)
+		domains = append(domains, state.Domain{
+			UUID:     domainUUID,
+			Name:     domain.Name,
+			LastSeen: now,
+			Schema:   schema,
+		})
+	}
+	pendingMtx.Lock()
+	pendingDomains = domains
+	pendingMtx.Unlock()
+}
+
+// writeStateLoop writes the state file every --state.write-interval, so
+// scrapes never wait for the database.
+func writeStateLoop(store *state.Store, logger log.Logger) {
+	ticker := time.NewTicker(*stateWriteInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		pendingMtx.Lock()
+		domains := pendingDomains
+		pendingDomains = nil
+		pendingMtx.Unlock()
+		if domains != nil {
+			if err := store.SaveDomains(domains); err != nil {
+				level.Error(logger).Log("msg", "failed to save domain inventory", "err", err)
+			}
+		}
+		if err := store.SaveValue(eventCountersKey, domainEvents.snapshot()); err != nil {
+			level.Error(logger).Log("msg", "failed to save event counters", "err", err)
+		}
+	}
+}
+
+func (w *eventWatcher) snapshot() eventCounters {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	counters := eventCounters{
+		DefinitionChanges: make(map[string]float64, len(w.definitionChanges)),
+		Events:            make(map[string]map[string]float64, len(w.events)),
+		LastEvent:         make(map[string]time.Time, len(w.lastEvent)),
+		Devices:           make(map[string]map[string]float64, len(w.devices)),
+		Booted:            make(map[string]time.Time, len(w.booted)),
+	}
+	for domainUUID, n := range w.definitionChanges {
+		counters.DefinitionChanges[domainUUID] = n
+	}
+	for domainUUID, events := range w.events {
+		counters.Events[domainUUID] = make(map[string]float64, len(events))
+		for event, n := range events {
+			counters.Events[domainUUID][event] = n
+		}
+	}
+	for domainUUID, at := range w.lastEvent {
+		counters.LastEvent[domainUUID] = at
+	}
+	for domainUUID, events := range w.devices {
+		counters.Devices[domainUUID] = make(map[string]float64, len(events))
+		for key, n := range events {
+			counters.Devices[domainUUID][key.deviceType+"/"+key.event] = n
+		}
+	}
+	for domainUUID, at := range w.booted {
+		counters.Booted[domainUUID] = at
+	}
+	return counters
+}
+
+func (w *eventWatcher) restore(counters eventCounters) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	for domainUUID, n := range counters.DefinitionChanges {
+		w.definitionChanges[domainUUID] = n
+	}
+	for domainUUID, events := range counters.Events {
+		w.events[domainUUID] = events
+	}
+	for domainUUID, at := range counters.LastEvent {
+		w.lastEvent[domainUUID] = at
+	}
+	for domainUUID, at := range counters.Booted {
+		w.booted[domainUUID] = at
+	}
+	for domainUUID, events := range counters.Devices {
+		w.devices[domainUUID] = make(map[deviceEvent]float64, len(events))
+		for key, n := range events {
+			deviceType, event, _ := strings.Cut(key, "/")
+			w.devices[domainUUID][deviceEvent{deviceType, event}] = n
+		}
+	}
+}
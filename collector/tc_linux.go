@@ -0,0 +1,107 @@
+package collector
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+)
+
+const (
+	tcHandleRoot    = 0xFFFFFFFF
+	tcHandleIngress = 0xFFFFFFF1
+	tcaKind         = 1
+	tcmsgLen        = 20
+)
+
+// tcQdiscs lists the qdiscs of a network device with a netlink dump, the way
+// `tc qdisc show dev <device>` does.
+func tcQdiscs(device string) (qdiscs, error) {
+	iface, err := net.InterfaceByName(device)
+	if err != nil {
+		return qdiscs{}, err
+	}
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return qdiscs{}, err
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return qdiscs{}, err
+	}
+
+	req := make([]byte, syscall.NLMSG_HDRLEN+tcmsgLen)
+	binary.NativeEndian.PutUint32(req[0:4], uint32(len(req)))
+	binary.NativeEndian.PutUint16(req[4:6], syscall.RTM_GETQDISC)
+	binary.NativeEndian.PutUint16(req[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_DUMP)
+	binary.NativeEndian.PutUint32(req[8:12], 1)
+	req[syscall.NLMSG_HDRLEN] = syscall.AF_UNSPEC
+	binary.NativeEndian.PutUint32(req[syscall.NLMSG_HDRLEN+4:], uint32(iface.Index))
+	if err := syscall.Sendto(fd, req, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return qdiscs{}, err
+	}
+
+	var result qdiscs
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return qdiscs{}, err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return qdiscs{}, err
+		}
+		for _, msg := range msgs {
+			switch msg.Header.Type {
+			case syscall.NLMSG_DONE:
+				return result, nil
+			case syscall.NLMSG_ERROR:
+				if len(msg.Data) >= 4 {
+					if errno := int32(binary.NativeEndian.Uint32(msg.Data[:4])); errno != 0 {
+						return qdiscs{}, syscall.Errno(-errno)
+					}
+				}
+				return result, nil
+			case syscall.RTM_NEWQDISC:
+				if len(msg.Data) < tcmsgLen {
+					continue
+				}
+				// Old kernels ignore the ifindex of the request.
+				if int32(binary.NativeEndian.Uint32(msg.Data[4:8])) != int32(iface.Index) {
+					continue
+				}
+				parent := binary.NativeEndian.Uint32(msg.Data[12:16])
+				kind := tcKind(msg.Data[tcmsgLen:])
+				switch parent {
+				case tcHandleRoot:
+					result.root = kind
+				case tcHandleIngress:
+					result.ingress = true
+				}
+			}
+		}
+	}
+}
+
+// tcKind returns the TCA_KIND attribute of a qdisc message.
+func tcKind(attrs []byte) string {
+	for len(attrs) >= syscall.SizeofRtAttr {
+		length := int(binary.NativeEndian.Uint16(attrs[0:2]))
+		typ := binary.NativeEndian.Uint16(attrs[2:4])
+		if length < syscall.SizeofRtAttr || length > len(attrs) {
+			return ""
+		}
+		if typ == tcaKind {
+			value := attrs[syscall.SizeofRtAttr:length]
+			for i, b := range value {
+				if b == 0 {
+					return string(value[:i])
+				}
+			}
+			return string(value)
+		}
+		// The last attribute need not be padded to the alignment.
+		attrs = attrs[min((length+syscall.RTA_ALIGNTO-1)&^(syscall.RTA_ALIGNTO-1), len(attrs)):]
+	}
+	return ""
+}
@@ -0,0 +1,41 @@
+package collector
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// rtAttr encodes a netlink attribute, padded to the attribute alignment
+// unless it is unpadded.
+func rtAttr(typ uint16, value string, padded bool) []byte {
+	attr := make([]byte, 4, 4+len(value)+3)
+	binary.NativeEndian.PutUint16(attr[0:2], uint16(4+len(value)))
+	binary.NativeEndian.PutUint16(attr[2:4], typ)
+	attr = append(attr, value...)
+	for padded && len(attr)%4 != 0 {
+		attr = append(attr, 0)
+	}
+	return attr
+}
+
+func TestTCKind(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs []byte
+		want  string
+	}{
+		{"kind first", append(rtAttr(tcaKind, "htb\x00", true), rtAttr(2, "x", true)...), "htb"},
+		{"kind after padded attribute", append(rtAttr(2, "abcde", true), rtAttr(tcaKind, "fq_codel\x00", true)...), "fq_codel"},
+		{"unpadded last attribute", rtAttr(2, "abcde", false), ""},
+		{"unpadded kind without terminator", append(rtAttr(2, "a", true), rtAttr(tcaKind, "sfq", false)...), "sfq"},
+		{"truncated attribute", rtAttr(tcaKind, "htb", true)[:5], ""},
+		{"empty", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tcKind(tt.attrs); got != tt.want {
+				t.Errorf("tcKind() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package collector
+
+import "errors"
+
+// tcQdiscs is only implemented on Linux.
+func tcQdiscs(device string) (qdiscs, error) {
+	return qdiscs{}, errors.New("traffic control introspection not supported on this platform")
+}
@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	uuidFormat = reloadableEnum(kingpin.Flag(
+		"collector.uuid-format",
+		"Format of the domain_uuid label: canonical (with dashes) or nodashes.",
+	).Default("canonical"), "canonical", "nodashes")
+	shortUUIDLabel = reloadableBool(kingpin.Flag(
+		"collector.short-uuid-label",
+		"Additionally label domain metrics with domain_short_uuid, the first 8 characters of the domain UUID.",
+	).Default("false"))
+)
+
+const shortUUIDLength = 8
+
+// uuidDescs maps the descriptors of metrics with a domain_uuid label to
+// their counterpart with the additional domain_short_uuid label. Descriptors
+// without a domain_uuid label map to nil.
+var (
+	uuidDescsMtx sync.Mutex
+	uuidDescs    = make(map[*prometheus.Desc]*prometheus.Desc)
+)
+
+// rewriteUUIDLabels applies --collector.uuid-format and
+// --collector.short-uuid-label to the metrics of in. It returns in unchanged
+// if both are at their defaults.
+func rewriteUUIDLabels(in <-chan prometheus.Metric) <-chan prometheus.Metric {
+	if uuidFormat.Get() == "canonical" && !shortUUIDLabel.Get() {
+		return in
+	}
+	out := make(chan prometheus.Metric)
+	go func() {
+		for m := range in {
+			out <- newUUIDMetric(m)
+		}
+		close(out)
+	}()
+	return out
+}
+
+func newUUIDMetric(m prometheus.Metric) prometheus.Metric {
+	desc := m.Desc()
+	uuidDescsMtx.Lock()
+	defer uuidDescsMtx.Unlock()
+	shortDesc, ok := uuidDescs[desc]
+	if !ok {
+		if cm, err := newCatalogMetric(typedDesc{desc: desc}); err == nil {
+			for _, label := range cm.Labels {
+				if label == "domain_uuid" {
+					shortDesc = newMetricDesc(cm.Name, cm.Help, append(cm.Labels, "domain_short_uuid"), nil)
+					break
+				}
+			}
+		}
+		uuidDescs[desc] = shortDesc
+	}
+	if shortDesc == nil {
+		return m
+	}
+	if !shortUUIDLabel.Get() {
+		shortDesc = desc
+	}
+	return uuidMetric{Metric: m, desc: shortDesc}
+}
+
+// uuidMetric rewrites the domain_uuid label of the wrapped metric.
+type uuidMetric struct {
+	prometheus.Metric
+	desc *prometheus.Desc
+}
+
+func (m uuidMetric) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+func (m uuidMetric) Write(out *dto.Metric) error {
+	if err := m.Metric.Write(out); err != nil {
+		return err
+	}
+	// The label pairs may be shared with the wrapped metric, so they are
+	// copied instead of modified.
+	labels := make([]*dto.LabelPair, 0, len(out.Label)+1)
+	for _, l := range out.Label {
+		name, value := l.GetName(), l.GetValue()
+		if name == "domain_uuid" {
+			if shortUUIDLabel.Get() {
+				shortName, shortValue := "domain_short_uuid", value
+				if len(shortValue) > shortUUIDLength {
+					shortValue = shortValue[:shortUUIDLength]
+				}
+				labels = append(labels, &dto.LabelPair{Name: &shortName, Value: &shortValue})
+			}
+			if uuidFormat.Get() == "nodashes" {
+				value = strings.ReplaceAll(value, "-", "")
+			}
+		}
+		labels = append(labels, &dto.LabelPair{Name: &name, Value: &value})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+	out.Label = labels
+	return nil
+}
@@ -0,0 +1,104 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// protocolVersion is the libvirt release the RPC protocol of go-libvirt was
+// generated from, the last procedure it knows is DomainGetMessages.
+const protocolVersion = 7001000
+
+var versionMaxMajorSkew = reloadableUint64(kingpin.Flag(
+	"collector.version.max-major-skew",
+	"Number of major releases the libvirt daemon may be ahead of the protocol version of the exporter before a skew warning is raised.",
+).Default("3"))
+
+// versionCollector exposes the versions of the libvirt daemon and hypervisor
+// and warns about version skew, which shows as unsupported procedures or
+// subtly changed RPC behaviour.
+type versionCollector struct {
+	info        infoDesc
+	skewWarning typedDesc
+	logger      log.Logger
+}
+
+func init() {
+	registerCollector("version", defaultEnabled, NewVersionCollector)
+}
+
+// NewVersionCollector returns a new Collector exposing libvirt versions.
+func NewVersionCollector(logger log.Logger) (Collector, error) {
+	return &versionCollector{
+		info: newInfoDesc("daemon", "version_info",
+			"Versions of the libvirt daemon, the hypervisor and the RPC protocol of the exporter, and the hypervisor driver, e.g. qemu or bhyve",
+			"daemon_version", "hypervisor_version", "protocol_version", "driver"),
+		skewWarning: typedDesc{
+			desc: newMetricDesc(
+				prometheus.BuildFQName(namespace, "daemon", "version_skew_warning"),
+				"1 if the libvirt daemon is older than the RPC protocol of the exporter or too many major releases ahead of it",
+				[]string{"daemon_version", "protocol_version"},
+				nil),
+			valueType: prometheus.GaugeValue,
+		},
+		logger: logger,
+	}, nil
+}
+
+func (c *versionCollector) descs() []typedDesc {
+	return []typedDesc{c.info.typedDesc, c.skewWarning}
+}
+
+func (c *versionCollector) Update(ch chan<- prometheus.Metric, opts ...CollectorOption) error {
+	config := &CollectorConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	infos := newInfoMetrics(ch)
+
+	if config.pLibvirt == nil {
+		level.Error(c.logger).Log("msg", "libvirt not created")
+		return ErrNotProvided
+	}
+	if !config.pLibvirt.IsConnected() {
+		level.Error(c.logger).Log("msg", "libvirt not connected")
+		return ErrNotProvided
+	}
+
+	libVersion, err := config.pLibvirt.ConnectGetLibVersion()
+	if err != nil {
+		return err
+	}
+	// Not every driver has a hypervisor version.
+	hvVersion, err := config.pLibvirt.ConnectGetVersion()
+	if err != nil {
+		level.Debug(c.logger).Log("msg", "failed to get hypervisor version", "err", err)
+	}
+	driver, err := config.pLibvirt.ConnectGetType()
+	if err != nil {
+		level.Debug(c.logger).Log("msg", "failed to get driver type", "err", err)
+	}
+	daemon, protocol := libvirtVersionString(libVersion), libvirtVersionString(protocolVersion)
+	infos.emit(c.info, daemon, libvirtVersionString(hvVersion), protocol, strings.ToLower(driver))
+
+	skew := 0.0
+	if libVersion < protocolVersion || libVersion/1000000 > protocolVersion/1000000+versionMaxMajorSkew.Get() {
+		skew = 1
+	}
+	ch <- c.skewWarning.mustNewConstMetric(skew, daemon, protocol)
+	return nil
+}
+
+// libvirtVersionString formats a version encoded as
+// major * 1,000,000 + minor * 1,000 + release, 0 means unknown.
+func libvirtVersionString(v uint64) string {
+	if v == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d.%d.%d", v/1000000, v/1000%1000, v%1000)
+}
@@ -0,0 +1,65 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+var maxConcurrency = kingpin.Flag(
+	"collector.max-concurrency",
+	"Maximum number of per-domain workers of all collectors querying libvirt at the same time, 0 means unlimited.",
+).Default("0").Int()
+
+var (
+	workerSemOnce sync.Once
+	workerSem     chan struct{}
+)
+
+// acquireWorker blocks until a worker slot of the pool shared by all
+// collectors is free, so large hypervisors don't exhaust the RPC queue of
+// libvirtd. It returns false without a slot if ctx is done first. Every
+// successful call must be paired with releaseWorker.
+func acquireWorker(ctx context.Context) bool {
+	workerSemOnce.Do(func() {
+		if *maxConcurrency > 0 {
+			workerSem = make(chan struct{}, *maxConcurrency)
+		}
+	})
+	if workerSem == nil {
+		return ctx.Err() == nil
+	}
+	if wait, ok := ctx.Value(workerWaitKey{}).(*atomic.Int64); ok {
+		begin := time.Now()
+		defer func() { wait.Add(int64(time.Since(begin))) }()
+	}
+	select {
+	case workerSem <- struct{}{}:
+		if ctx.Err() != nil {
+			<-workerSem
+			return false
+		}
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+type workerWaitKey struct{}
+
+// withWorkerWait returns a context in which acquireWorker adds the time spent
+// waiting for a worker slot to wait, so the cost of serializing the RPCs of a
+// collector can be told apart from libvirt latency.
+func withWorkerWait(ctx context.Context, wait *atomic.Int64) context.Context {
+	return context.WithValue(ctx, workerWaitKey{}, wait)
+}
+
+// releaseWorker frees a slot taken by acquireWorker.
+func releaseWorker() {
+	if workerSem != nil {
+		<-workerSem
+	}
+}
@@ -0,0 +1,46 @@
+package collector
+
+import (
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/nee541/libvirt-exporter/libvirt_schema"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var xmlStrict = kingpin.Flag(
+	"collector.xml.strict",
+	"Validate fetched domain XML descriptions and count the elements enabled collectors can not handle in libvirt_exporter_xml_parse_warnings_total.",
+).Default("false").Bool()
+
+var xmlParseWarnings = newCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "libvirt_exporter",
+		Name:      "xml_parse_warnings_total",
+		Help:      "Number of domain XML elements not understood by the exporter, counted when a description is fetched with --collector.xml.strict.",
+	},
+	[]string{"element"},
+)
+
+// validateDomain counts and logs the parse warnings of a freshly fetched
+// domain description that concern the enabled collectors. Warnings only
+// relevant to disabled collectors are ignored.
+func validateDomain(schema libvirt_schema.Domain, collectors map[string]Collector, logger log.Logger) {
+	if !*xmlStrict {
+		return
+	}
+	for _, warning := range schema.Validate() {
+		relevant := false
+		for _, name := range warning.Collectors {
+			if _, ok := collectors[name]; ok {
+				relevant = true
+				break
+			}
+		}
+		if !relevant {
+			continue
+		}
+		level.Warn(logger).Log("msg", "domain xml not fully understood", "domain", schema.Name, "element", warning.Element, "detail", warning.Detail)
+		xmlParseWarnings.WithLabelValues(warning.Element).Inc()
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/nee541/libvirt-exporter/collector"
+	"github.com/nee541/libvirt-exporter/connection"
+	"gopkg.in/yaml.v3"
+)
+
+// config is the structure of the file passed with --config.file. Settings
+// given on the command line take precedence over the file.
+type config struct {
+	Collectors collectorsConfig `yaml:"collectors"`
+	Libvirt    libvirtConfig    `yaml:"libvirt"`
+	Enrichment enrichmentConfig `yaml:"enrichment"`
+	Domains    domainsConfig    `yaml:"domains"`
+	Labels     labelsConfig     `yaml:"labels"`
+	// CollectorOptions sets the --collector.<name>.<option> flags by
+	// collector name and option, e.g. block: {device-exclude: sd[b-z]}.
+	CollectorOptions map[string]map[string]string `yaml:"collector_options"`
+}
+
+// domainsConfig holds the domain filters of the --domain.* and --domains.*
+// flags.
+type domainsConfig struct {
+	Include    string `yaml:"include"`
+	Exclude    string `yaml:"exclude"`
+	ActiveOnly *bool  `yaml:"active_only"`
+}
+
+// labelsConfig holds the label settings of the --collector.uuid-format and
+// --collector.short-uuid-label flags.
+type labelsConfig struct {
+	UUIDFormat string `yaml:"uuid_format"`
+	ShortUUID  *bool  `yaml:"short_uuid"`
+}
+
+// collectorsConfig selects the collectors to run, like the
+// --collector.disable-defaults and --[no-]collector.<name> flags.
+type collectorsConfig struct {
+	DisableDefaults bool     `yaml:"disable_defaults"`
+	Enable          []string `yaml:"enable"`
+	Disable         []string `yaml:"disable"`
+}
+
+// libvirtConfig holds the connection settings of the --libvirt.* flags. They
+// are read once at startup, a reload rejects changes of them.
+type libvirtConfig struct {
+	URI string `yaml:"uri"`
+	TLS struct {
+		CertFile           string `yaml:"cert_file"`
+		KeyFile            string `yaml:"key_file"`
+		CAFile             string `yaml:"ca_file"`
+		InsecureSkipVerify *bool  `yaml:"insecure_skip_verify"`
+	} `yaml:"tls"`
+	SSH struct {
+		User                  string `yaml:"user"`
+		KeyFile               string `yaml:"key_file"`
+		KnownHostsFile        string `yaml:"known_hosts_file"`
+		InsecureIgnoreHostKey *bool  `yaml:"insecure_ignore_host_key"`
+	} `yaml:"ssh"`
+}
+
+// enrichmentConfig configures the lookup of business identifiers of domains,
+// attached as labels to libvirt_domain_info.
+type enrichmentConfig struct {
+	HTTP struct {
+		URL     string        `yaml:"url"`
+		Timeout time.Duration `yaml:"timeout"`
+	} `yaml:"http"`
+	Labels []string      `yaml:"labels"`
+	TTL    time.Duration `yaml:"ttl"`
+}
+
+// envPattern matches ${VAR}, ${VAR:-default} and the escaped dollar $$.
+var envPattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// expandEnv replaces ${VAR} in value by the value of the environment variable
+// VAR and ${VAR:-default} by default if VAR is unset or empty. $$ yields a
+// literal $. Referencing an unset variable without default is an error, so a
+// missing secret is not silently replaced by an empty string.
+func expandEnv(value string) (string, error) {
+	var missing []string
+	expanded := envPattern.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+		groups := envPattern.FindStringSubmatch(match)
+		if value := os.Getenv(groups[1]); value != "" {
+			return value
+		}
+		if strings.Contains(match, ":-") {
+			return groups[2]
+		}
+		missing = append(missing, groups[1])
+		return ""
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variables not set: %s", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// expandEnvNode applies expandEnv to the scalar values below node. Plain
+// scalars are resolved again after the expansion, so ${VAR} works for
+// durations, booleans and numbers as if the value had been written in the
+// file, quoted scalars stay strings. Keys and comments are left alone.
+func expandEnvNode(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			if err := expandEnvNode(child); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := expandEnvNode(node.Content[i]); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		expanded, err := expandEnv(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		if expanded != node.Value {
+			node.Value = expanded
+			if node.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+				node.Tag = ""
+			}
+		}
+	}
+	// Aliases share the node of their anchor, which is expanded once.
+	return nil
+}
+
+func loadConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &config{}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("couldn't parse config file %s: %w", path, err)
+	}
+	if doc.Kind == 0 {
+		// An empty file.
+		return c, nil
+	}
+	if err := expandEnvNode(&doc); err != nil {
+		return nil, fmt.Errorf("couldn't expand config file %s: %w", path, err)
+	}
+	// The expanded document is encoded again to decode it strictly, a
+	// yaml.Node can't reject unknown fields itself.
+	if data, err = yaml.Marshal(&doc); err != nil {
+		return nil, fmt.Errorf("couldn't expand config file %s: %w", path, err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf("couldn't parse config file %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// flagValues returns the values of the flags set by the domains, labels and
+// collector_options sections by flag name.
+func (c *config) flagValues() (map[string]string, error) {
+	values := make(map[string]string)
+	setString := func(name, value string) {
+		if value != "" {
+			values[name] = value
+		}
+	}
+	setBool := func(name string, value *bool) {
+		if value != nil {
+			values[name] = strconv.FormatBool(*value)
+		}
+	}
+	setString("domain.include", c.Domains.Include)
+	setString("domain.exclude", c.Domains.Exclude)
+	setBool("domains.active-only", c.Domains.ActiveOnly)
+	setString("collector.uuid-format", c.Labels.UUIDFormat)
+	setBool("collector.short-uuid-label", c.Labels.ShortUUID)
+	for name, options := range c.CollectorOptions {
+		if !collector.IsKnownCollector(name) {
+			return nil, fmt.Errorf("collector_options: missing collector: %s", name)
+		}
+		for option, value := range options {
+			flagName := fmt.Sprintf("collector.%s.%s", name, option)
+			if kingpin.CommandLine.GetFlag(flagName) == nil {
+				return nil, fmt.Errorf("collector_options: unknown option %s of collector %s", option, name)
+			}
+			values[flagName] = value
+		}
+	}
+	return values, nil
+}
+
+// apply enables and disables the collectors listed in the file.
+func (c collectorsConfig) apply() error {
+	if c.DisableDefaults {
+		collector.DisableDefaultCollectors()
+	}
+	for _, name := range c.Enable {
+		if err := collector.SetCollectorState(name, true); err != nil {
+			return err
+		}
+	}
+	for _, name := range c.Disable {
+		if err := collector.SetCollectorState(name, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply sets up the HTTP enricher if a URL is configured.
+func (c enrichmentConfig) apply(logger log.Logger) error {
+	if c.HTTP.URL == "" {
+		return nil
+	}
+	if len(c.Labels) == 0 {
+		return fmt.Errorf("enrichment.labels must not be empty")
+	}
+	if !strings.Contains(c.HTTP.URL, "{uuid}") {
+		return fmt.Errorf("enrichment.http.url must contain {uuid}")
+	}
+	ttl, timeout := c.TTL, c.HTTP.Timeout
+	if ttl <= 0 {
+		ttl = 10 * time.Minute
+	}
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	enricher := &collector.HTTPEnricher{URL: c.HTTP.URL}
+	return collector.SetEnricher(enricher, c.Labels, ttl, timeout, logger)
+}
+
+// apply sets the connection settings of the file in conn, except those of
+// flags given on the command line.
+func (c libvirtConfig) apply(conn *connection.Config, commandLine map[string]bool) {
+	setString := func(name string, dst *string, value string) {
+		if value != "" && !commandLine[name] {
+			*dst = value
+		}
+	}
+	setBool := func(name string, dst *bool, value *bool) {
+		if value != nil && !commandLine[name] {
+			*dst = *value
+		}
+	}
+	setString("libvirt.uri", &conn.URI, c.URI)
+	setString("libvirt.tls.cert-file", &conn.TLSCertFile, c.TLS.CertFile)
+	setString("libvirt.tls.key-file", &conn.TLSKeyFile, c.TLS.KeyFile)
+	setString("libvirt.tls.ca-file", &conn.TLSCAFile, c.TLS.CAFile)
+	setBool("libvirt.tls.insecure-skip-verify", &conn.TLSInsecureSkipVerify, c.TLS.InsecureSkipVerify)
+	setString("libvirt.ssh.user", &conn.SSHUser, c.SSH.User)
+	setString("libvirt.ssh.key-file", &conn.SSHKeyFile, c.SSH.KeyFile)
+	setString("libvirt.ssh.known-hosts-file", &conn.SSHKnownHostsFile, c.SSH.KnownHostsFile)
+	setBool("libvirt.ssh.insecure-ignore-host-key", &conn.SSHInsecureIgnoreHostKey, c.SSH.InsecureIgnoreHostKey)
+}
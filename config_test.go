@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigExpandEnv(t *testing.T) {
+	t.Setenv("LIBVIRT_HOST", "kvm1")
+	t.Setenv("ENRICHMENT_TIMEOUT", "3s")
+	t.Setenv("ACTIVE_ONLY", "true")
+	t.Setenv("COLLECTOR", "memory")
+	t.Setenv("EMPTY", "")
+
+	tests := []struct {
+		name    string
+		file    string
+		check   func(*config) interface{}
+		want    interface{}
+		wantErr string
+	}{
+		{
+			name:  "string",
+			file:  "libvirt:\n  uri: qemu+tls://${LIBVIRT_HOST}/system\n",
+			check: func(c *config) interface{} { return c.Libvirt.URI },
+			want:  "qemu+tls://kvm1/system",
+		},
+		{
+			name:  "default of unset variable",
+			file:  "libvirt:\n  tls:\n    ca_file: ${LIBVIRT_CA_FILE:-/etc/pki/CA/cacert.pem}\n",
+			check: func(c *config) interface{} { return c.Libvirt.TLS.CAFile },
+			want:  "/etc/pki/CA/cacert.pem",
+		},
+		{
+			name:  "default of empty variable",
+			file:  "domains:\n  exclude: ${EMPTY:-ci-.*}\n",
+			check: func(c *config) interface{} { return c.Domains.Exclude },
+			want:  "ci-.*",
+		},
+		{
+			name:  "default ignored if set",
+			file:  "libvirt:\n  uri: qemu+tls://${LIBVIRT_HOST:-localhost}/system\n",
+			check: func(c *config) interface{} { return c.Libvirt.URI },
+			want:  "qemu+tls://kvm1/system",
+		},
+		{
+			name:  "escaped dollar",
+			file:  "domains:\n  include: ^vm-$$|$${LIBVIRT_HOST}\n",
+			check: func(c *config) interface{} { return c.Domains.Include },
+			want:  "^vm-$|${LIBVIRT_HOST}",
+		},
+		{
+			name:  "duration",
+			file:  "enrichment:\n  http:\n    timeout: ${ENRICHMENT_TIMEOUT}\n",
+			check: func(c *config) interface{} { return c.Enrichment.HTTP.Timeout },
+			want:  3 * time.Second,
+		},
+		{
+			name:  "bool",
+			file:  "domains:\n  active_only: ${ACTIVE_ONLY}\n",
+			check: func(c *config) interface{} { return *c.Domains.ActiveOnly },
+			want:  true,
+		},
+		{
+			name:  "bool default",
+			file:  "labels:\n  short_uuid: ${SHORT_UUID:-false}\n",
+			check: func(c *config) interface{} { return *c.Labels.ShortUUID },
+			want:  false,
+		},
+		{
+			name:  "list position",
+			file:  "collectors:\n  enable:\n    - cpu\n    - ${COLLECTOR}\n  disable: [\"${COLLECTOR}\"]\n",
+			check: func(c *config) interface{} { return [][]string{c.Collectors.Enable, c.Collectors.Disable} },
+			want:  [][]string{{"cpu", "memory"}, {"memory"}},
+		},
+		{
+			name:  "collector option",
+			file:  "collector_options:\n  block:\n    timeout: ${ENRICHMENT_TIMEOUT}\n",
+			check: func(c *config) interface{} { return c.CollectorOptions["block"]["timeout"] },
+			want:  "3s",
+		},
+		{
+			name:  "quoted value stays a string",
+			file:  "collector_options:\n  memory:\n    kib-units: \"${ACTIVE_ONLY}\"\n",
+			check: func(c *config) interface{} { return c.CollectorOptions["memory"]["kib-units"] },
+			want:  "true",
+		},
+		{
+			name:  "comments are not expanded",
+			file:  "# ${UNSET_IN_COMMENT}\ndomains:\n  exclude: ci-.* # ${UNSET_IN_COMMENT}\n",
+			check: func(c *config) interface{} { return c.Domains.Exclude },
+			want:  "ci-.*",
+		},
+		{
+			name:    "unset variable",
+			file:    "libvirt:\n  uri: qemu+tls://${LIBVIRT_UNSET_HOST}/system\n",
+			wantErr: "environment variables not set: LIBVIRT_UNSET_HOST",
+		},
+		{
+			name:    "empty variable without default",
+			file:    "domains:\n  exclude: ${EMPTY}\n",
+			wantErr: "environment variables not set: EMPTY",
+		},
+		{
+			name:    "invalid duration",
+			file:    "enrichment:\n  http:\n    timeout: ${LIBVIRT_HOST}\n",
+			wantErr: "couldn't parse config file",
+		},
+		{
+			name:    "unknown field",
+			file:    "domains:\n  exclud: ${LIBVIRT_HOST}\n",
+			wantErr: "field exclud not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yml")
+			if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			c, err := loadConfig(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+			if got := tt.check(c); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/nee541/libvirt-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	configHashDesc = prometheus.NewDesc(
+		"libvirt_exporter_config_hash",
+		"Hash of the effective configuration, the values of all flags and the content of the config file.",
+		nil,
+		nil,
+	)
+	collectorEnabledDesc = prometheus.NewDesc(
+		"libvirt_exporter_collector_enabled",
+		"Whether the collector is enabled.",
+		[]string{"collector"},
+		nil,
+	)
+	reloadSuccessDesc = prometheus.NewDesc(
+		"libvirt_exporter_config_last_reload_successful",
+		"Whether the last reload of the config file succeeded.",
+		nil,
+		nil,
+	)
+	reloadSuccessTimestampDesc = prometheus.NewDesc(
+		"libvirt_exporter_config_last_reload_success_timestamp_seconds",
+		"Time of the last successful reload of the config file since unix epoch in seconds.",
+		nil,
+		nil,
+	)
+)
+
+// configCollector exposes the effective configuration of the exporter, so
+// configuration drift between hosts can be queried in Prometheus.
+type configCollector struct {
+	mtx  sync.Mutex
+	hash float64
+	// Like in Prometheus, loading the configuration at startup counts as
+	// the first successful reload.
+	reloadSuccess bool
+	reloadedAt    time.Time
+}
+
+// newConfigCollector hashes the configuration. It must be created after the
+// flags are parsed and the config file is applied.
+func newConfigCollector(configFile string) (*configCollector, error) {
+	hash, err := configHash(configFile)
+	if err != nil {
+		return nil, err
+	}
+	return &configCollector{hash: hash, reloadSuccess: true, reloadedAt: time.Now()}, nil
+}
+
+// configHash hashes the values of all flags and the content of configFile.
+func configHash(configFile string) (float64, error) {
+	var flags []string
+	for _, f := range kingpin.CommandLine.Model().Flags {
+		flags = append(flags, fmt.Sprintf("%s=%q\n", f.Name, f.String()))
+	}
+	sort.Strings(flags)
+
+	h := sha256.New()
+	for _, f := range flags {
+		h.Write([]byte(f))
+	}
+	if configFile != "" {
+		data, err := os.ReadFile(configFile)
+		if err != nil {
+			return 0, err
+		}
+		h.Write(data)
+	}
+	// Like alertmanager_config_hash, only 48 bits of the hash are used, as
+	// a float64 represents them exactly.
+	sum := h.Sum(nil)
+	hash := binary.BigEndian.Uint64(append([]byte{0, 0}, sum[:6]...))
+	return float64(hash), nil
+}
+
+// reloaded records the outcome of a reload of configFile and hashes the new
+// configuration.
+func (c *configCollector) reloaded(configFile string, success bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.reloadSuccess = success
+	if !success {
+		return
+	}
+	c.reloadedAt = time.Now()
+	if hash, err := configHash(configFile); err == nil {
+		c.hash = hash
+	}
+}
+
+// Describe implements the prometheus.Collector interface.
+func (c *configCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- configHashDesc
+	ch <- collectorEnabledDesc
+	ch <- reloadSuccessDesc
+	ch <- reloadSuccessTimestampDesc
+}
+
+// Collect implements the prometheus.Collector interface.
+func (c *configCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mtx.Lock()
+	reloadSuccess := 0.0
+	if c.reloadSuccess {
+		reloadSuccess = 1
+	}
+	ch <- prometheus.MustNewConstMetric(configHashDesc, prometheus.GaugeValue, c.hash)
+	ch <- prometheus.MustNewConstMetric(reloadSuccessDesc, prometheus.GaugeValue, reloadSuccess)
+	ch <- prometheus.MustNewConstMetric(reloadSuccessTimestampDesc, prometheus.GaugeValue, float64(c.reloadedAt.UnixNano())/1e9)
+	c.mtx.Unlock()
+	enabled := make(map[string]bool)
+	for _, name := range collector.EnabledCollectors() {
+		enabled[name] = true
+	}
+	for _, name := range collector.Collectors() {
+		value := 0.0
+		if enabled[name] {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(collectorEnabledDesc, prometheus.GaugeValue, value, name)
+	}
+}
@@ -0,0 +1,203 @@
+package connection
+
+import (
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/digitalocean/go-libvirt/socket"
+)
+
+// ChaosConfig makes the connection misbehave on purpose, to exercise the
+// reconnect and partial-failure handling against a healthy daemon. It must
+// never be enabled in production.
+type ChaosConfig struct {
+	// DropProbability is the probability with which the connection is
+	// closed before sending a request to libvirt.
+	DropProbability float64
+	// MaxLatency delays every read from the connection by a random
+	// duration up to MaxLatency, simulating a slow daemon.
+	MaxLatency time.Duration
+	// ErrorProbability is the probability with which a call is answered
+	// with an error instead of being sent to libvirt, simulating failing
+	// RPCs on a connection which stays up.
+	ErrorProbability float64
+}
+
+func (c ChaosConfig) enabled() bool {
+	return c.DropProbability > 0 || c.MaxLatency > 0 || c.ErrorProbability > 0
+}
+
+// chaosErrorMessage is the message of injected RPC errors.
+const chaosErrorMessage = "chaos: injected RPC error"
+
+// chaosSpared are the procedures of the remote program which are never
+// answered with an injected error, so the connection itself is established
+// and closed as usual.
+var chaosSpared = map[uint32]bool{
+	1:  true, // ConnectOpen
+	2:  true, // ConnectClose
+	66: true, // AuthList
+}
+
+// chaosDialer wraps the connections of its dialer into chaosConns.
+type chaosDialer struct {
+	socket.Dialer
+	config ChaosConfig
+}
+
+// Dial implements socket.Dialer.
+func (d *chaosDialer) Dial() (net.Conn, error) {
+	conn, err := d.Dialer.Dial()
+	if err != nil {
+		return nil, err
+	}
+	c := &chaosConn{Conn: conn, config: d.config, messages: make(chan []byte, 16), done: make(chan struct{}), closed: make(chan struct{})}
+	go c.receive()
+	return c, nil
+}
+
+// chaosConn forwards whole messages in both directions, so injected replies
+// can be placed between the messages of the daemon.
+type chaosConn struct {
+	net.Conn
+	config ChaosConfig
+
+	// messages holds the messages to be read, from the daemon or
+	// injected. done is closed with readErr set once the daemon is gone.
+	messages chan []byte
+	done     chan struct{}
+	readErr  error
+	// closed is closed by Close, to stop receive.
+	closed    chan struct{}
+	closeOnce sync.Once
+	// unread is the rest of the message being read.
+	unread []byte
+
+	writeMtx sync.Mutex
+	// unsent holds the start of a message written in several chunks.
+	unsent []byte
+}
+
+// receive reads the messages of the daemon into c.messages until the
+// connection fails.
+func (c *chaosConn) receive() {
+	defer close(c.done)
+	for {
+		var length [4]byte
+		if _, err := io.ReadFull(c.Conn, length[:]); err != nil {
+			c.readErr = err
+			return
+		}
+		message := make([]byte, max(int(binary.BigEndian.Uint32(length[:])), len(length)))
+		copy(message, length[:])
+		if _, err := io.ReadFull(c.Conn, message[len(length):]); err != nil {
+			c.readErr = err
+			return
+		}
+		select {
+		case c.messages <- message:
+		case <-c.closed:
+			c.readErr = net.ErrClosed
+			return
+		}
+	}
+}
+
+func (c *chaosConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+func (c *chaosConn) Read(b []byte) (int, error) {
+	if len(c.unread) == 0 {
+		var message []byte
+		select {
+		case message = <-c.messages:
+		case <-c.done:
+			return 0, c.readErr
+		}
+		if c.config.MaxLatency > 0 {
+			time.Sleep(time.Duration(rand.Int63n(int64(c.config.MaxLatency))))
+		}
+		c.unread = message
+	}
+	n := copy(b, c.unread)
+	c.unread = c.unread[n:]
+	return n, nil
+}
+
+func (c *chaosConn) Write(b []byte) (int, error) {
+	if rand.Float64() < c.config.DropProbability {
+		c.Close()
+		return 0, net.ErrClosed
+	}
+	c.writeMtx.Lock()
+	defer c.writeMtx.Unlock()
+	c.unsent = append(c.unsent, b...)
+	for len(c.unsent) >= headerSize {
+		length := max(int(binary.BigEndian.Uint32(c.unsent[0:4])), headerSize)
+		if len(c.unsent) < length {
+			break
+		}
+		message := c.unsent[:length]
+		if c.failCall(message) {
+			select {
+			case c.messages <- chaosErrorReply(message):
+			case <-c.done:
+				c.unsent = nil
+				return 0, net.ErrClosed
+			}
+		} else if _, err := c.Conn.Write(message); err != nil {
+			c.unsent = nil
+			return 0, err
+		}
+		c.unsent = c.unsent[length:]
+	}
+	if len(c.unsent) == 0 {
+		c.unsent = nil
+	}
+	return len(b), nil
+}
+
+// failCall reports whether the call in message is answered with an injected
+// error.
+func (c *chaosConn) failCall(message []byte) bool {
+	if c.config.ErrorProbability <= 0 {
+		return false
+	}
+	program := binary.BigEndian.Uint32(message[4:8])
+	proc := binary.BigEndian.Uint32(message[12:16])
+	typ := binary.BigEndian.Uint32(message[16:20])
+	if typ != messageCall || (program != remoteProgram && program != qemuProgram) {
+		return false
+	}
+	if program == remoteProgram && chaosSpared[proc] {
+		return false
+	}
+	return rand.Float64() < c.config.ErrorProbability
+}
+
+// chaosErrorReply returns the error reply to the call in message, with the
+// fields of the remote_error struct go-libvirt decodes.
+func chaosErrorReply(call []byte) []byte {
+	message := make([]byte, headerSize, headerSize+32+len(chaosErrorMessage))
+	copy(message[4:24], call[4:24])
+	binary.BigEndian.PutUint32(message[16:20], messageReply)
+	binary.BigEndian.PutUint32(message[24:28], statusError)
+	message = binary.BigEndian.AppendUint32(message, uint32(libvirt.ErrOperationFailed))
+	// Domain of the error, the message is a present optional string.
+	message = binary.BigEndian.AppendUint32(message, 0)
+	message = binary.BigEndian.AppendUint32(message, 1)
+	message = binary.BigEndian.AppendUint32(message, uint32(len(chaosErrorMessage)))
+	message = append(message, chaosErrorMessage...)
+	message = append(message, make([]byte, (4-len(chaosErrorMessage)%4)%4)...)
+	// Level VIR_ERR_ERROR.
+	message = binary.BigEndian.AppendUint32(message, 2)
+	binary.BigEndian.PutUint32(message[0:4], uint32(len(message)))
+	return message
+}
@@ -0,0 +1,119 @@
+package connection
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+)
+
+// fakeDaemon answers the calls of go-libvirt needed to connect and to get the
+// library version, and records the procedures it received.
+type fakeDaemon struct {
+	mtx   sync.Mutex
+	procs []uint32
+}
+
+func (d *fakeDaemon) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		header := make([]byte, headerSize)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		payload := make([]byte, int(binary.BigEndian.Uint32(header[0:4]))-headerSize)
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			return
+		}
+		proc := binary.BigEndian.Uint32(header[12:16])
+		d.mtx.Lock()
+		d.procs = append(d.procs, proc)
+		d.mtx.Unlock()
+
+		var body []byte
+		switch proc {
+		case 66: // AuthList, no authentication
+			body = []byte{0, 0, 0, 0}
+		case 157: // ConnectGetLibVersion
+			body = binary.BigEndian.AppendUint64(nil, 8000000)
+		}
+		reply := make([]byte, headerSize, headerSize+len(body))
+		copy(reply[4:24], header[4:24])
+		binary.BigEndian.PutUint32(reply[16:20], messageReply)
+		reply = append(reply, body...)
+		binary.BigEndian.PutUint32(reply[0:4], uint32(len(reply)))
+		if _, err := conn.Write(reply); err != nil {
+			return
+		}
+	}
+}
+
+func (d *fakeDaemon) received(proc uint32) bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	for _, p := range d.procs {
+		if p == proc {
+			return true
+		}
+	}
+	return false
+}
+
+// pipeDialer connects to a fakeDaemon through an in-memory pipe.
+type pipeDialer struct {
+	daemon *fakeDaemon
+}
+
+func (d *pipeDialer) Dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	go d.daemon.serve(server)
+	return client, nil
+}
+
+func TestChaosErrorInjection(t *testing.T) {
+	for _, tt := range []struct {
+		name             string
+		errorProbability float64
+		wantErr          bool
+	}{
+		{"no errors", 0, false},
+		{"all calls fail", 1, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			daemon := &fakeDaemon{}
+			// Latency is set to enable chaos without errors.
+			config := ChaosConfig{ErrorProbability: tt.errorProbability, MaxLatency: 1}
+			l := libvirt.NewWithDialer(&chaosDialer{Dialer: &pipeDialer{daemon: daemon}, config: config})
+			if err := l.ConnectToURI(libvirt.QEMUSystem); err != nil {
+				t.Fatalf("connect: %v", err)
+			}
+			defer l.Disconnect()
+
+			version, err := l.ConnectGetLibVersion()
+			if tt.wantErr {
+				var lverr libvirt.Error
+				if !errors.As(err, &lverr) || lverr.Message != chaosErrorMessage {
+					t.Fatalf("ConnectGetLibVersion() error = %v, want %q", err, chaosErrorMessage)
+				}
+				if daemon.received(157) {
+					t.Error("failed call was sent to the daemon")
+				}
+				// The connection survives injected errors.
+				if !l.IsConnected() {
+					t.Error("connection lost after an injected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ConnectGetLibVersion() error = %v", err)
+			}
+			if version != 8000000 {
+				t.Errorf("ConnectGetLibVersion() = %d, want 8000000", version)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package connection
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/digitalocean/go-libvirt/socket"
+	"github.com/digitalocean/go-libvirt/socket/dialers"
+)
+
+const (
+	// DefaultURI is the libvirt URI used when none is configured.
+	DefaultURI = "qemu:///system"
+
+	defaultSocket  = "/var/run/libvirt/libvirt-sock"
+	defaultTCPPort = "16509"
+	defaultTLSPort = "16514"
+	defaultSSHPort = "22"
+)
+
+// Config holds everything needed to reach a libvirt daemon.
+type Config struct {
+	URI     string
+	Timeout time.Duration
+
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSCAFile             string
+	TLSInsecureSkipVerify bool
+
+	SSHUser                  string
+	SSHKeyFile               string
+	SSHKnownHostsFile        string
+	SSHInsecureIgnoreHostKey bool
+
+	KeepaliveInterval   time.Duration
+	KeepaliveTimeout    time.Duration
+	ReconnectMinBackoff time.Duration
+	ReconnectMaxBackoff time.Duration
+
+	Chaos ChaosConfig
+
+	// ObserveRPC, if set, is called for every RPC answered by the daemon.
+	ObserveRPC RPCObserver
+}
+
+// Connection couples a libvirt client with the driver URI it has to open
+// once the transport is established.
+type Connection struct {
+	Libvirt *libvirt.Libvirt
+	URI     libvirt.ConnectURI
+	// Local is set if the daemon is reached through a local unix socket,
+	// i.e. the exporter runs on the hypervisor itself.
+	Local bool
+
+	config   Config
+	socket   *socketDialer
+	tls      *tlsDialer
+	connMtx  sync.Mutex
+	tracker  *daemonTracker
+	hooksMtx sync.Mutex
+	hooks    []func(l *libvirt.Libvirt)
+}
+
+// New parses the configured URI and creates a libvirt client using the
+// matching transport. The client is not connected yet.
+func New(config Config) (*Connection, error) {
+	dialer, uri, err := newDialer(config)
+	if err != nil {
+		return nil, err
+	}
+	socket, local := dialer.(*socketDialer)
+	tls, _ := dialer.(*tlsDialer)
+	tracker := &daemonTracker{}
+	// The tracking dialer must see the raw connection to identify the
+	// daemon, so chaos is injected around it.
+	dialer = &trackingDialer{Dialer: dialer, tracker: tracker}
+	if config.Chaos.enabled() {
+		dialer = &chaosDialer{Dialer: dialer, config: config.Chaos}
+	}
+	// Calls are timed as go-libvirt sees them, including injected latency.
+	if config.ObserveRPC != nil {
+		dialer = &rpcDialer{Dialer: dialer, observe: config.ObserveRPC}
+	}
+	return &Connection{
+		Libvirt: libvirt.NewWithDialer(dialer),
+		URI:     uri,
+		Local:   local,
+		socket:  socket,
+		tls:     tls,
+		config:  config,
+		tracker: tracker,
+	}, nil
+}
+
+// Connect establishes the transport and opens the driver URI.
+func (c *Connection) Connect() error {
+	c.connMtx.Lock()
+	defer c.connMtx.Unlock()
+	if c.Libvirt.IsConnected() {
+		return nil
+	}
+	if err := c.Libvirt.ConnectToURI(c.URI); err != nil {
+		return err
+	}
+	c.tracker.connected(c.Libvirt)
+	return nil
+}
+
+// newDialer selects a dialer for the transport named in the URI and returns
+// it along with the URI which has to be sent to the daemon. The daemon only
+// expects the driver and path, e.g. qemu+ssh://host/system is opened as
+// qemu:///system on the remote side.
+func newDialer(config Config) (socket.Dialer, libvirt.ConnectURI, error) {
+	rawURI := config.URI
+	if rawURI == "" {
+		rawURI = DefaultURI
+	}
+	u, err := url.Parse(rawURI)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid libvirt uri %q: %w", rawURI, err)
+	}
+	driver, transport, _ := strings.Cut(u.Scheme, "+")
+	if driver == "" {
+		return nil, "", fmt.Errorf("invalid libvirt uri %q: missing driver", rawURI)
+	}
+	if transport == "" {
+		if u.Host == "" {
+			transport = "unix"
+		} else {
+			transport = "tls"
+		}
+	}
+	connectURI := libvirt.ConnectURI(fmt.Sprintf("%s://%s", driver, u.EscapedPath()))
+	query := u.Query()
+
+	switch transport {
+	case "unix":
+		if u.Host != "" {
+			return nil, "", fmt.Errorf("invalid libvirt uri %q: unix transport does not take a host", rawURI)
+		}
+		return newSocketDialer(driver, query.Get("socket"), config.Timeout), connectURI, nil
+	case "tcp":
+		port := u.Port()
+		if port == "" {
+			port = defaultTCPPort
+		}
+		opts := []dialers.RemoteOption{dialers.UsePort(port)}
+		if config.Timeout > 0 {
+			opts = append(opts, dialers.WithRemoteTimeout(config.Timeout))
+		}
+		return dialers.NewRemote(u.Hostname(), opts...), connectURI, nil
+	case "tls":
+		dialer, err := newTLSDialer(u, config)
+		if err != nil {
+			return nil, "", err
+		}
+		return dialer, connectURI, nil
+	case "ssh":
+		dialer, err := newSSHDialer(u, config)
+		if err != nil {
+			return nil, "", err
+		}
+		return dialer, connectURI, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported libvirt transport %q", transport)
+	}
+}
+
+// queryBool reports whether a libvirt style boolean query parameter
+// (e.g. no_verify=1) is set.
+func queryBool(query url.Values, key string) bool {
+	switch strings.ToLower(query.Get(key)) {
+	case "1", "true", "yes":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,172 @@
+package connection
+
+import (
+	"net"
+	"sync"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/digitalocean/go-libvirt/socket"
+	"github.com/prometheus/procfs"
+)
+
+// daemonIdentity identifies a single libvirtd process. The process is only
+// known for local unix socket connections, where the peer process can be
+// looked up. Otherwise the daemon is told apart by its version, which changes
+// with upgrades, and the CPU time of the host, which starts over when the host
+// reboots.
+type daemonIdentity struct {
+	pid       int
+	startTime float64
+	version   uint64
+	cpuTime   uint64
+}
+
+// restartedFrom reports whether i belongs to another daemon than prev. Without
+// the process only upgrades and host reboots are detected, a reconnect to the
+// same daemon, e.g. after a network interruption, is no restart.
+func (i *daemonIdentity) restartedFrom(prev *daemonIdentity) bool {
+	if i.pid > 0 && prev.pid > 0 {
+		return i.pid != prev.pid || i.startTime != prev.startTime
+	}
+	if i.version != 0 && prev.version != 0 && i.version != prev.version {
+		return true
+	}
+	return i.cpuTime != 0 && i.cpuTime < prev.cpuTime
+}
+
+// daemonTracker detects restarts of the daemon across reconnects.
+type daemonTracker struct {
+	mu         sync.Mutex
+	generation uint64
+	identity   *daemonIdentity
+	restarts   uint64
+	lastConn   net.Conn
+}
+
+// trackingDialer remembers the last connection it created so the peer of a
+// new libvirt connection can be inspected.
+type trackingDialer struct {
+	socket.Dialer
+	tracker *daemonTracker
+}
+
+// Dial implements socket.Dialer.
+func (d *trackingDialer) Dial() (net.Conn, error) {
+	conn, err := d.Dialer.Dial()
+	if err == nil {
+		d.tracker.mu.Lock()
+		d.tracker.lastConn = conn
+		d.tracker.mu.Unlock()
+	}
+	return conn, err
+}
+
+// connected is called after every successful connect. A new connection is
+// counted as a daemon restart if the identity of the daemon changed. If it
+// cannot be determined before or after the reconnect, no restart is counted.
+func (t *daemonTracker) connected(l *libvirt.Libvirt) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	identity := lookupDaemonIdentity(t.lastConn, l)
+	if t.generation > 0 && identity != nil && t.identity != nil && identity.restartedFrom(t.identity) {
+		t.restarts++
+	}
+	t.generation++
+	t.identity = identity
+}
+
+// closeLastConn forcibly closes the transport, which makes the libvirt client
+// notice the lost connection even if the daemon stopped responding.
+func (t *daemonTracker) closeLastConn() {
+	t.mu.Lock()
+	conn := t.lastConn
+	t.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+func lookupDaemonIdentity(conn net.Conn, l *libvirt.Libvirt) *daemonIdentity {
+	if identity := lookupDaemonProcess(conn); identity != nil {
+		return identity
+	}
+	var identity daemonIdentity
+	if version, err := l.ConnectGetLibVersion(); err == nil {
+		identity.version = version
+	}
+	identity.cpuTime = hostCPUTime(l)
+	if identity.version == 0 && identity.cpuTime == 0 {
+		return nil
+	}
+	return &identity
+}
+
+// lookupDaemonProcess identifies the peer process of a unix socket connection.
+func lookupDaemonProcess(conn net.Conn) *daemonIdentity {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+	pid, err := peerPID(unixConn)
+	if err != nil || pid <= 0 {
+		return nil
+	}
+	fs, err := procfs.NewDefaultFS()
+	if err != nil {
+		return nil
+	}
+	proc, err := fs.Proc(pid)
+	if err != nil {
+		return nil
+	}
+	stat, err := proc.Stat()
+	if err != nil {
+		return nil
+	}
+	startTime, err := stat.StartTime()
+	if err != nil {
+		return nil
+	}
+	return &daemonIdentity{pid: pid, startTime: startTime}
+}
+
+// hostCPUTime returns the CPU time in nanoseconds spent by all CPUs of the
+// host since it booted, or 0 if it is not known.
+func hostCPUTime(l *libvirt.Libvirt) uint64 {
+	// VIR_NODE_CPU_STATS_ALL_CPUS, asked for the number of fields first.
+	_, nparams, err := l.NodeGetCPUStats(-1, 0, 0)
+	if err != nil || nparams <= 0 {
+		return 0
+	}
+	stats, _, err := l.NodeGetCPUStats(-1, nparams, 0)
+	if err != nil {
+		return 0
+	}
+	var total uint64
+	for _, stat := range stats {
+		switch stat.Field {
+		case "kernel", "user", "idle", "iowait":
+			total += stat.Value
+		}
+	}
+	return total
+}
+
+// DaemonRestarts returns the number of detected libvirtd restarts.
+func (c *Connection) DaemonRestarts() uint64 {
+	c.tracker.mu.Lock()
+	defer c.tracker.mu.Unlock()
+	return c.tracker.restarts
+}
+
+// DaemonStartTime returns the start time of the connected libvirtd in seconds
+// since the epoch, if it could be determined.
+func (c *Connection) DaemonStartTime() (float64, bool) {
+	c.tracker.mu.Lock()
+	defer c.tracker.mu.Unlock()
+	if c.tracker.identity == nil || c.tracker.identity.pid <= 0 {
+		return 0, false
+	}
+	return c.tracker.identity.startTime, true
+}
@@ -0,0 +1,76 @@
+//go:build ignore
+
+// gen_procedures generates procedures.go from the procedure constants of the
+// go-libvirt version in go.mod. Run it with go generate after updating
+// go-libvirt.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", "github.com/digitalocean/go-libvirt").Output()
+	if err != nil {
+		log.Fatalf("locating go-libvirt: %v", err)
+	}
+	constants := filepath.Join(strings.TrimSpace(string(out)), "internal", "constants")
+
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by gen_procedures.go from the procedure constants of go-libvirt. DO NOT EDIT.\n\n")
+	buf.WriteString("package connection\n\n")
+	buf.WriteString("// remoteProcedures names the procedures of the remote program by number,\n")
+	buf.WriteString("// like the methods of go-libvirt calling them.\n")
+	writeProcedures(&buf, "remoteProcedures", filepath.Join(constants, "remote_protocol.gen.go"), `(?m)^\s+Proc(\w+) = (\d+)$`, "")
+	buf.WriteString("\n// qemuProcedures names the procedures of the QEMU program by number.\n")
+	writeProcedures(&buf, "qemuProcedures", filepath.Join(constants, "qemu_protocol.gen.go"), `(?m)^\s+QEMUProc(\w+) = (\d+)$`, "QEMU")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatalf("formatting procedures.go: %v", err)
+	}
+	if err := os.WriteFile("procedures.go", src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeProcedures writes the array name with the procedures matched by
+// pattern in file, prefixing their names with prefix.
+func writeProcedures(buf *bytes.Buffer, name, file, pattern, prefix string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	type procedure struct {
+		number int
+		name   string
+	}
+	var procedures []procedure
+	for _, m := range regexp.MustCompile(pattern).FindAllStringSubmatch(string(data), -1) {
+		number, err := strconv.Atoi(m[2])
+		if err != nil {
+			log.Fatalf("%s: invalid procedure number %q", file, m[2])
+		}
+		procedures = append(procedures, procedure{number, prefix + m[1]})
+	}
+	if len(procedures) == 0 {
+		log.Fatalf("%s: no procedures found", file)
+	}
+	sort.Slice(procedures, func(i, j int) bool { return procedures[i].number < procedures[j].number })
+
+	fmt.Fprintf(buf, "var %s = [...]string{\n", name)
+	for _, p := range procedures {
+		fmt.Fprintf(buf, "\t%d: %q,\n", p.number, p.name)
+	}
+	buf.WriteString("}\n")
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package connection
+
+import (
+	"errors"
+	"net"
+)
+
+// peerPID is only implemented on Linux.
+func peerPID(conn *net.UnixConn) (int, error) {
+	return 0, errors.New("peer credentials not supported on this platform")
+}
@@ -0,0 +1,444 @@
+// Code generated by gen_procedures.go from the procedure constants of go-libvirt. DO NOT EDIT.
+
+package connection
+
+// remoteProcedures names the procedures of the remote program by number,
+// like the methods of go-libvirt calling them.
+var remoteProcedures = [...]string{
+	1:   "ConnectOpen",
+	2:   "ConnectClose",
+	3:   "ConnectGetType",
+	4:   "ConnectGetVersion",
+	5:   "ConnectGetMaxVcpus",
+	6:   "NodeGetInfo",
+	7:   "ConnectGetCapabilities",
+	8:   "DomainAttachDevice",
+	9:   "DomainCreate",
+	10:  "DomainCreateXML",
+	11:  "DomainDefineXML",
+	12:  "DomainDestroy",
+	13:  "DomainDetachDevice",
+	14:  "DomainGetXMLDesc",
+	15:  "DomainGetAutostart",
+	16:  "DomainGetInfo",
+	17:  "DomainGetMaxMemory",
+	18:  "DomainGetMaxVcpus",
+	19:  "DomainGetOsType",
+	20:  "DomainGetVcpus",
+	21:  "ConnectListDefinedDomains",
+	22:  "DomainLookupByID",
+	23:  "DomainLookupByName",
+	24:  "DomainLookupByUUID",
+	25:  "ConnectNumOfDefinedDomains",
+	26:  "DomainPinVcpu",
+	27:  "DomainReboot",
+	28:  "DomainResume",
+	29:  "DomainSetAutostart",
+	30:  "DomainSetMaxMemory",
+	31:  "DomainSetMemory",
+	32:  "DomainSetVcpus",
+	33:  "DomainShutdown",
+	34:  "DomainSuspend",
+	35:  "DomainUndefine",
+	36:  "ConnectListDefinedNetworks",
+	37:  "ConnectListDomains",
+	38:  "ConnectListNetworks",
+	39:  "NetworkCreate",
+	40:  "NetworkCreateXML",
+	41:  "NetworkDefineXML",
+	42:  "NetworkDestroy",
+	43:  "NetworkGetXMLDesc",
+	44:  "NetworkGetAutostart",
+	45:  "NetworkGetBridgeName",
+	46:  "NetworkLookupByName",
+	47:  "NetworkLookupByUUID",
+	48:  "NetworkSetAutostart",
+	49:  "NetworkUndefine",
+	50:  "ConnectNumOfDefinedNetworks",
+	51:  "ConnectNumOfDomains",
+	52:  "ConnectNumOfNetworks",
+	53:  "DomainCoreDump",
+	54:  "DomainRestore",
+	55:  "DomainSave",
+	56:  "DomainGetSchedulerType",
+	57:  "DomainGetSchedulerParameters",
+	58:  "DomainSetSchedulerParameters",
+	59:  "ConnectGetHostname",
+	60:  "ConnectSupportsFeature",
+	61:  "DomainMigratePrepare",
+	62:  "DomainMigratePerform",
+	63:  "DomainMigrateFinish",
+	64:  "DomainBlockStats",
+	65:  "DomainInterfaceStats",
+	66:  "AuthList",
+	67:  "AuthSaslInit",
+	68:  "AuthSaslStart",
+	69:  "AuthSaslStep",
+	70:  "AuthPolkit",
+	71:  "ConnectNumOfStoragePools",
+	72:  "ConnectListStoragePools",
+	73:  "ConnectNumOfDefinedStoragePools",
+	74:  "ConnectListDefinedStoragePools",
+	75:  "ConnectFindStoragePoolSources",
+	76:  "StoragePoolCreateXML",
+	77:  "StoragePoolDefineXML",
+	78:  "StoragePoolCreate",
+	79:  "StoragePoolBuild",
+	80:  "StoragePoolDestroy",
+	81:  "StoragePoolDelete",
+	82:  "StoragePoolUndefine",
+	83:  "StoragePoolRefresh",
+	84:  "StoragePoolLookupByName",
+	85:  "StoragePoolLookupByUUID",
+	86:  "StoragePoolLookupByVolume",
+	87:  "StoragePoolGetInfo",
+	88:  "StoragePoolGetXMLDesc",
+	89:  "StoragePoolGetAutostart",
+	90:  "StoragePoolSetAutostart",
+	91:  "StoragePoolNumOfVolumes",
+	92:  "StoragePoolListVolumes",
+	93:  "StorageVolCreateXML",
+	94:  "StorageVolDelete",
+	95:  "StorageVolLookupByName",
+	96:  "StorageVolLookupByKey",
+	97:  "StorageVolLookupByPath",
+	98:  "StorageVolGetInfo",
+	99:  "StorageVolGetXMLDesc",
+	100: "StorageVolGetPath",
+	101: "NodeGetCellsFreeMemory",
+	102: "NodeGetFreeMemory",
+	103: "DomainBlockPeek",
+	104: "DomainMemoryPeek",
+	105: "ConnectDomainEventRegister",
+	106: "ConnectDomainEventDeregister",
+	107: "DomainEventLifecycle",
+	108: "DomainMigratePrepare2",
+	109: "DomainMigrateFinish2",
+	110: "ConnectGetUri",
+	111: "NodeNumOfDevices",
+	112: "NodeListDevices",
+	113: "NodeDeviceLookupByName",
+	114: "NodeDeviceGetXMLDesc",
+	115: "NodeDeviceGetParent",
+	116: "NodeDeviceNumOfCaps",
+	117: "NodeDeviceListCaps",
+	118: "NodeDeviceDettach",
+	119: "NodeDeviceReAttach",
+	120: "NodeDeviceReset",
+	121: "DomainGetSecurityLabel",
+	122: "NodeGetSecurityModel",
+	123: "NodeDeviceCreateXML",
+	124: "NodeDeviceDestroy",
+	125: "StorageVolCreateXMLFrom",
+	126: "ConnectNumOfInterfaces",
+	127: "ConnectListInterfaces",
+	128: "InterfaceLookupByName",
+	129: "InterfaceLookupByMacString",
+	130: "InterfaceGetXMLDesc",
+	131: "InterfaceDefineXML",
+	132: "InterfaceUndefine",
+	133: "InterfaceCreate",
+	134: "InterfaceDestroy",
+	135: "ConnectDomainXMLFromNative",
+	136: "ConnectDomainXMLToNative",
+	137: "ConnectNumOfDefinedInterfaces",
+	138: "ConnectListDefinedInterfaces",
+	139: "ConnectNumOfSecrets",
+	140: "ConnectListSecrets",
+	141: "SecretLookupByUUID",
+	142: "SecretDefineXML",
+	143: "SecretGetXMLDesc",
+	144: "SecretSetValue",
+	145: "SecretGetValue",
+	146: "SecretUndefine",
+	147: "SecretLookupByUsage",
+	148: "DomainMigratePrepareTunnel",
+	149: "ConnectIsSecure",
+	150: "DomainIsActive",
+	151: "DomainIsPersistent",
+	152: "NetworkIsActive",
+	153: "NetworkIsPersistent",
+	154: "StoragePoolIsActive",
+	155: "StoragePoolIsPersistent",
+	156: "InterfaceIsActive",
+	157: "ConnectGetLibVersion",
+	158: "ConnectCompareCPU",
+	159: "DomainMemoryStats",
+	160: "DomainAttachDeviceFlags",
+	161: "DomainDetachDeviceFlags",
+	162: "ConnectBaselineCPU",
+	163: "DomainGetJobInfo",
+	164: "DomainAbortJob",
+	165: "StorageVolWipe",
+	166: "DomainMigrateSetMaxDowntime",
+	167: "ConnectDomainEventRegisterAny",
+	168: "ConnectDomainEventDeregisterAny",
+	169: "DomainEventReboot",
+	170: "DomainEventRtcChange",
+	171: "DomainEventWatchdog",
+	172: "DomainEventIOError",
+	173: "DomainEventGraphics",
+	174: "DomainUpdateDeviceFlags",
+	175: "NwfilterLookupByName",
+	176: "NwfilterLookupByUUID",
+	177: "NwfilterGetXMLDesc",
+	178: "ConnectNumOfNwfilters",
+	179: "ConnectListNwfilters",
+	180: "NwfilterDefineXML",
+	181: "NwfilterUndefine",
+	182: "DomainManagedSave",
+	183: "DomainHasManagedSaveImage",
+	184: "DomainManagedSaveRemove",
+	185: "DomainSnapshotCreateXML",
+	186: "DomainSnapshotGetXMLDesc",
+	187: "DomainSnapshotNum",
+	188: "DomainSnapshotListNames",
+	189: "DomainSnapshotLookupByName",
+	190: "DomainHasCurrentSnapshot",
+	191: "DomainSnapshotCurrent",
+	192: "DomainRevertToSnapshot",
+	193: "DomainSnapshotDelete",
+	194: "DomainGetBlockInfo",
+	195: "DomainEventIOErrorReason",
+	196: "DomainCreateWithFlags",
+	197: "DomainSetMemoryParameters",
+	198: "DomainGetMemoryParameters",
+	199: "DomainSetVcpusFlags",
+	200: "DomainGetVcpusFlags",
+	201: "DomainOpenConsole",
+	202: "DomainIsUpdated",
+	203: "ConnectGetSysinfo",
+	204: "DomainSetMemoryFlags",
+	205: "DomainSetBlkioParameters",
+	206: "DomainGetBlkioParameters",
+	207: "DomainMigrateSetMaxSpeed",
+	208: "StorageVolUpload",
+	209: "StorageVolDownload",
+	210: "DomainInjectNmi",
+	211: "DomainScreenshot",
+	212: "DomainGetState",
+	213: "DomainMigrateBegin3",
+	214: "DomainMigratePrepare3",
+	215: "DomainMigratePrepareTunnel3",
+	216: "DomainMigratePerform3",
+	217: "DomainMigrateFinish3",
+	218: "DomainMigrateConfirm3",
+	219: "DomainSetSchedulerParametersFlags",
+	220: "InterfaceChangeBegin",
+	221: "InterfaceChangeCommit",
+	222: "InterfaceChangeRollback",
+	223: "DomainGetSchedulerParametersFlags",
+	224: "DomainEventControlError",
+	225: "DomainPinVcpuFlags",
+	226: "DomainSendKey",
+	227: "NodeGetCPUStats",
+	228: "NodeGetMemoryStats",
+	229: "DomainGetControlInfo",
+	230: "DomainGetVcpuPinInfo",
+	231: "DomainUndefineFlags",
+	232: "DomainSaveFlags",
+	233: "DomainRestoreFlags",
+	234: "DomainDestroyFlags",
+	235: "DomainSaveImageGetXMLDesc",
+	236: "DomainSaveImageDefineXML",
+	237: "DomainBlockJobAbort",
+	238: "DomainGetBlockJobInfo",
+	239: "DomainBlockJobSetSpeed",
+	240: "DomainBlockPull",
+	241: "DomainEventBlockJob",
+	242: "DomainMigrateGetMaxSpeed",
+	243: "DomainBlockStatsFlags",
+	244: "DomainSnapshotGetParent",
+	245: "DomainReset",
+	246: "DomainSnapshotNumChildren",
+	247: "DomainSnapshotListChildrenNames",
+	248: "DomainEventDiskChange",
+	249: "DomainOpenGraphics",
+	250: "NodeSuspendForDuration",
+	251: "DomainBlockResize",
+	252: "DomainSetBlockIOTune",
+	253: "DomainGetBlockIOTune",
+	254: "DomainSetNumaParameters",
+	255: "DomainGetNumaParameters",
+	256: "DomainSetInterfaceParameters",
+	257: "DomainGetInterfaceParameters",
+	258: "DomainShutdownFlags",
+	259: "StorageVolWipePattern",
+	260: "StorageVolResize",
+	261: "DomainPmSuspendForDuration",
+	262: "DomainGetCPUStats",
+	263: "DomainGetDiskErrors",
+	264: "DomainSetMetadata",
+	265: "DomainGetMetadata",
+	266: "DomainBlockRebase",
+	267: "DomainPmWakeup",
+	268: "DomainEventTrayChange",
+	269: "DomainEventPmwakeup",
+	270: "DomainEventPmsuspend",
+	271: "DomainSnapshotIsCurrent",
+	272: "DomainSnapshotHasMetadata",
+	273: "ConnectListAllDomains",
+	274: "DomainListAllSnapshots",
+	275: "DomainSnapshotListAllChildren",
+	276: "DomainEventBalloonChange",
+	277: "DomainGetHostname",
+	278: "DomainGetSecurityLabelList",
+	279: "DomainPinEmulator",
+	280: "DomainGetEmulatorPinInfo",
+	281: "ConnectListAllStoragePools",
+	282: "StoragePoolListAllVolumes",
+	283: "ConnectListAllNetworks",
+	284: "ConnectListAllInterfaces",
+	285: "ConnectListAllNodeDevices",
+	286: "ConnectListAllNwfilters",
+	287: "ConnectListAllSecrets",
+	288: "NodeSetMemoryParameters",
+	289: "NodeGetMemoryParameters",
+	290: "DomainBlockCommit",
+	291: "NetworkUpdate",
+	292: "DomainEventPmsuspendDisk",
+	293: "NodeGetCPUMap",
+	294: "DomainFstrim",
+	295: "DomainSendProcessSignal",
+	296: "DomainOpenChannel",
+	297: "NodeDeviceLookupScsiHostByWwn",
+	298: "DomainGetJobStats",
+	299: "DomainMigrateGetCompressionCache",
+	300: "DomainMigrateSetCompressionCache",
+	301: "NodeDeviceDetachFlags",
+	302: "DomainMigrateBegin3Params",
+	303: "DomainMigratePrepare3Params",
+	304: "DomainMigratePrepareTunnel3Params",
+	305: "DomainMigratePerform3Params",
+	306: "DomainMigrateFinish3Params",
+	307: "DomainMigrateConfirm3Params",
+	308: "DomainSetMemoryStatsPeriod",
+	309: "DomainCreateXMLWithFiles",
+	310: "DomainCreateWithFiles",
+	311: "DomainEventDeviceRemoved",
+	312: "ConnectGetCPUModelNames",
+	313: "ConnectNetworkEventRegisterAny",
+	314: "ConnectNetworkEventDeregisterAny",
+	315: "NetworkEventLifecycle",
+	316: "ConnectDomainEventCallbackRegisterAny",
+	317: "ConnectDomainEventCallbackDeregisterAny",
+	318: "DomainEventCallbackLifecycle",
+	319: "DomainEventCallbackReboot",
+	320: "DomainEventCallbackRtcChange",
+	321: "DomainEventCallbackWatchdog",
+	322: "DomainEventCallbackIOError",
+	323: "DomainEventCallbackGraphics",
+	324: "DomainEventCallbackIOErrorReason",
+	325: "DomainEventCallbackControlError",
+	326: "DomainEventCallbackBlockJob",
+	327: "DomainEventCallbackDiskChange",
+	328: "DomainEventCallbackTrayChange",
+	329: "DomainEventCallbackPmwakeup",
+	330: "DomainEventCallbackPmsuspend",
+	331: "DomainEventCallbackBalloonChange",
+	332: "DomainEventCallbackPmsuspendDisk",
+	333: "DomainEventCallbackDeviceRemoved",
+	334: "DomainCoreDumpWithFormat",
+	335: "DomainFsfreeze",
+	336: "DomainFsthaw",
+	337: "DomainGetTime",
+	338: "DomainSetTime",
+	339: "DomainEventBlockJob2",
+	340: "NodeGetFreePages",
+	341: "NetworkGetDhcpLeases",
+	342: "ConnectGetDomainCapabilities",
+	343: "DomainOpenGraphicsFd",
+	344: "ConnectGetAllDomainStats",
+	345: "DomainBlockCopy",
+	346: "DomainEventCallbackTunable",
+	347: "NodeAllocPages",
+	348: "DomainEventCallbackAgentLifecycle",
+	349: "DomainGetFsinfo",
+	350: "DomainDefineXMLFlags",
+	351: "DomainGetIothreadInfo",
+	352: "DomainPinIothread",
+	353: "DomainInterfaceAddresses",
+	354: "DomainEventCallbackDeviceAdded",
+	355: "DomainAddIothread",
+	356: "DomainDelIothread",
+	357: "DomainSetUserPassword",
+	358: "DomainRename",
+	359: "DomainEventCallbackMigrationIteration",
+	360: "ConnectRegisterCloseCallback",
+	361: "ConnectUnregisterCloseCallback",
+	362: "ConnectEventConnectionClosed",
+	363: "DomainEventCallbackJobCompleted",
+	364: "DomainMigrateStartPostCopy",
+	365: "DomainGetPerfEvents",
+	366: "DomainSetPerfEvents",
+	367: "DomainEventCallbackDeviceRemovalFailed",
+	368: "ConnectStoragePoolEventRegisterAny",
+	369: "ConnectStoragePoolEventDeregisterAny",
+	370: "StoragePoolEventLifecycle",
+	371: "DomainGetGuestVcpus",
+	372: "DomainSetGuestVcpus",
+	373: "StoragePoolEventRefresh",
+	374: "ConnectNodeDeviceEventRegisterAny",
+	375: "ConnectNodeDeviceEventDeregisterAny",
+	376: "NodeDeviceEventLifecycle",
+	377: "NodeDeviceEventUpdate",
+	378: "StorageVolGetInfoFlags",
+	379: "DomainEventCallbackMetadataChange",
+	380: "ConnectSecretEventRegisterAny",
+	381: "ConnectSecretEventDeregisterAny",
+	382: "SecretEventLifecycle",
+	383: "SecretEventValueChanged",
+	384: "DomainSetVcpu",
+	385: "DomainEventBlockThreshold",
+	386: "DomainSetBlockThreshold",
+	387: "DomainMigrateGetMaxDowntime",
+	388: "DomainManagedSaveGetXMLDesc",
+	389: "DomainManagedSaveDefineXML",
+	390: "DomainSetLifecycleAction",
+	391: "StoragePoolLookupByTargetPath",
+	392: "DomainDetachDeviceAlias",
+	393: "ConnectCompareHypervisorCPU",
+	394: "ConnectBaselineHypervisorCPU",
+	395: "NodeGetSevInfo",
+	396: "DomainGetLaunchSecurityInfo",
+	397: "NwfilterBindingLookupByPortDev",
+	398: "NwfilterBindingGetXMLDesc",
+	399: "NwfilterBindingCreateXML",
+	400: "NwfilterBindingDelete",
+	401: "ConnectListAllNwfilterBindings",
+	402: "DomainSetIothreadParams",
+	403: "ConnectGetStoragePoolCapabilities",
+	404: "NetworkListAllPorts",
+	405: "NetworkPortLookupByUUID",
+	406: "NetworkPortCreateXML",
+	407: "NetworkPortGetParameters",
+	408: "NetworkPortSetParameters",
+	409: "NetworkPortGetXMLDesc",
+	410: "NetworkPortDelete",
+	411: "DomainCheckpointCreateXML",
+	412: "DomainCheckpointGetXMLDesc",
+	413: "DomainListAllCheckpoints",
+	414: "DomainCheckpointListAllChildren",
+	415: "DomainCheckpointLookupByName",
+	416: "DomainCheckpointGetParent",
+	417: "DomainCheckpointDelete",
+	418: "DomainGetGuestInfo",
+	419: "ConnectSetIdentity",
+	420: "DomainAgentSetResponseTimeout",
+	421: "DomainBackupBegin",
+	422: "DomainBackupGetXMLDesc",
+	423: "DomainEventMemoryFailure",
+	424: "DomainAuthorizedSshKeysGet",
+	425: "DomainAuthorizedSshKeysSet",
+	426: "DomainGetMessages",
+}
+
+// qemuProcedures names the procedures of the QEMU program by number.
+var qemuProcedures = [...]string{
+	1: "QEMUDomainMonitorCommand",
+	2: "QEMUDomainAttach",
+	3: "QEMUDomainAgentCommand",
+	4: "QEMUConnectDomainMonitorEventRegister",
+	5: "QEMUConnectDomainMonitorEventDeregister",
+	6: "QEMUDomainMonitorEvent",
+}
@@ -0,0 +1,171 @@
+package connection
+
+//go:generate go run gen_procedures.go
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/go-libvirt/socket"
+)
+
+// Programs and message types of the libvirt RPC protocol, see
+// https://libvirt.org/kbase/internals/rpc.html
+const (
+	remoteProgram = 0x20008086
+	qemuProgram   = 0x20008087
+
+	messageCall         = 0
+	messageReply        = 1
+	messageCallWithFDs  = 4
+	messageReplyWithFDs = 5
+
+	statusError = 1
+
+	// headerSize is the size of the length prefix and the header of a
+	// message.
+	headerSize = 28
+)
+
+// RPCObserver is called for every RPC answered by the daemon with the name of
+// the procedure, e.g. DomainGetInfo, the time until the reply arrived and
+// whether the daemon returned an error.
+type RPCObserver func(proc string, duration time.Duration, failed bool)
+
+// procedureName returns the name of a procedure, or its number if unknown.
+func procedureName(program, proc uint32) string {
+	var names []string
+	switch program {
+	case remoteProgram:
+		names = remoteProcedures[:]
+	case qemuProgram:
+		names = qemuProcedures[:]
+	}
+	if int(proc) < len(names) && names[proc] != "" {
+		return names[proc]
+	}
+	return strconv.FormatUint(uint64(proc), 10)
+}
+
+// rpcDialer wraps the connections of its dialer into rpcConns.
+type rpcDialer struct {
+	socket.Dialer
+	observe RPCObserver
+}
+
+// Dial implements socket.Dialer.
+func (d *rpcDialer) Dial() (net.Conn, error) {
+	conn, err := d.Dialer.Dial()
+	if err != nil {
+		return nil, err
+	}
+	return &rpcConn{Conn: conn, observe: d.observe, pending: make(map[uint32]pendingCall)}, nil
+}
+
+type pendingCall struct {
+	program uint32
+	proc    uint32
+	at      time.Time
+}
+
+// rpcConn follows the messages written to and read from the connection and
+// times the calls by their serial number. go-libvirt does not expose its
+// calls, and the messages are the one place all of them pass.
+type rpcConn struct {
+	net.Conn
+	observe RPCObserver
+
+	// The reader and the writer of go-libvirt run concurrently, each with
+	// its own parser.
+	readParser  messageParser
+	writeParser messageParser
+	mtx         sync.Mutex
+	pending     map[uint32]pendingCall
+}
+
+func (c *rpcConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.readParser.feed(b[:n], c.received)
+	return n, err
+}
+
+func (c *rpcConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.writeParser.feed(b[:n], c.sent)
+	return n, err
+}
+
+func (c *rpcConn) sent(h messageHeader) {
+	if h.typ != messageCall && h.typ != messageCallWithFDs {
+		return
+	}
+	if h.program != remoteProgram && h.program != qemuProgram {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.pending[h.serial] = pendingCall{program: h.program, proc: h.proc, at: time.Now()}
+}
+
+func (c *rpcConn) received(h messageHeader) {
+	if h.typ != messageReply && h.typ != messageReplyWithFDs {
+		return
+	}
+	c.mtx.Lock()
+	call, ok := c.pending[h.serial]
+	delete(c.pending, h.serial)
+	c.mtx.Unlock()
+	if ok {
+		c.observe(procedureName(call.program, call.proc), time.Since(call.at), h.status == statusError)
+	}
+}
+
+type messageHeader struct {
+	program uint32
+	proc    uint32
+	typ     uint32
+	serial  uint32
+	status  uint32
+}
+
+// messageParser finds the message headers in a stream of messages read or
+// written in chunks of any size.
+type messageParser struct {
+	header [headerSize]byte
+	// n is the number of header bytes seen of the current message.
+	n int
+	// skip is the number of bytes left of the payload of the current
+	// message.
+	skip int
+}
+
+// feed parses b and calls fn for every complete header.
+func (p *messageParser) feed(b []byte, fn func(messageHeader)) {
+	for len(b) > 0 {
+		if p.skip > 0 {
+			n := min(p.skip, len(b))
+			p.skip -= n
+			b = b[n:]
+			continue
+		}
+		n := copy(p.header[p.n:], b)
+		p.n += n
+		b = b[n:]
+		if p.n < headerSize {
+			return
+		}
+		p.n = 0
+		length := int(binary.BigEndian.Uint32(p.header[0:4]))
+		p.skip = max(length-headerSize, 0)
+		fn(messageHeader{
+			program: binary.BigEndian.Uint32(p.header[4:8]),
+			proc:    binary.BigEndian.Uint32(p.header[12:16]),
+			typ:     binary.BigEndian.Uint32(p.header[16:20]),
+			serial:  binary.BigEndian.Uint32(p.header[20:24]),
+			status:  binary.BigEndian.Uint32(p.header[24:28]),
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const defaultLocalTimeout = 15 * time.Second
+
+// socketDialer connects to the first reachable of several local unix sockets.
+// In containers the libvirt socket is mounted at varying paths and modular
+// daemons (virtqemud etc.) listen on their own sockets, so probing the common
+// locations avoids the most frequent deployment failure.
+type socketDialer struct {
+	candidates []string
+	timeout    time.Duration
+
+	mu   sync.Mutex
+	used string
+}
+
+// newSocketDialer returns a dialer for the socket given in the URI or, if none
+// is given, for the usual socket paths of the driver.
+func newSocketDialer(driver, socketPath string, timeout time.Duration) *socketDialer {
+	if timeout <= 0 {
+		timeout = defaultLocalTimeout
+	}
+	candidates := []string{socketPath}
+	if socketPath == "" {
+		candidates = []string{
+			"/run/libvirt/libvirt-sock",
+			defaultSocket,
+			fmt.Sprintf("/run/libvirt/virt%sd-sock", driver),
+			fmt.Sprintf("/var/run/libvirt/virt%sd-sock", driver),
+		}
+	}
+	return &socketDialer{candidates: candidates, timeout: timeout}
+}
+
+// Dial implements socket.Dialer.
+func (d *socketDialer) Dial() (net.Conn, error) {
+	var errs []error
+	for _, path := range d.candidates {
+		conn, err := net.DialTimeout("unix", path, d.timeout)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		d.mu.Lock()
+		d.used = path
+		d.mu.Unlock()
+		return conn, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// path returns the socket of the last successful connection.
+func (d *socketDialer) path() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.used
+}
+
+// SocketPath returns the unix socket the daemon was last reached through, or
+// an empty string for remote connections or if no connection succeeded yet.
+func (c *Connection) SocketPath() string {
+	if c.socket == nil {
+		return ""
+	}
+	return c.socket.path()
+}
@@ -0,0 +1,143 @@
+package connection
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshDialer tunnels the libvirt unix socket of a remote host through SSH,
+// using the OpenSSH streamlocal forwarding extension.
+type sshDialer struct {
+	address   string
+	socket    string
+	sshConfig *ssh.ClientConfig
+}
+
+func newSSHDialer(u *url.URL, config Config) (*sshDialer, error) {
+	query := u.Query()
+
+	username := u.User.Username()
+	if username == "" {
+		username = config.SSHUser
+	}
+	if username == "" {
+		current, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine ssh user: %w", err)
+		}
+		username = current.Username
+	}
+
+	keyFile := query.Get("keyfile")
+	if keyFile == "" {
+		keyFile = config.SSHKeyFile
+	}
+	signer, err := loadSSHSigner(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+	if config.SSHInsecureIgnoreHostKey || queryBool(query, "no_verify") {
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	} else {
+		knownHostsFile := query.Get("known_hosts")
+		if knownHostsFile == "" {
+			knownHostsFile = config.SSHKnownHostsFile
+		}
+		if knownHostsFile == "" {
+			knownHostsFile = filepath.Join(homeDir(), ".ssh", "known_hosts")
+		}
+		hostKeyCallback, err = knownhosts.New(knownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ssh known hosts: %w", err)
+		}
+	}
+
+	socketPath := query.Get("socket")
+	if socketPath == "" {
+		socketPath = defaultSocket
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultSSHPort
+	}
+	return &sshDialer{
+		address: net.JoinHostPort(u.Hostname(), port),
+		socket:  socketPath,
+		sshConfig: &ssh.ClientConfig{
+			User:            username,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         config.Timeout,
+		},
+	}, nil
+}
+
+// Dial implements socket.Dialer. The returned connection owns the SSH client
+// and closes it along with the forwarded socket.
+func (d *sshDialer) Dial() (net.Conn, error) {
+	client, err := ssh.Dial("tcp", d.address, d.sshConfig)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.Dial("unix", d.socket)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to forward libvirt socket %s: %w", d.socket, err)
+	}
+	return &sshConn{Conn: conn, client: client}, nil
+}
+
+type sshConn struct {
+	net.Conn
+	client *ssh.Client
+}
+
+func (c *sshConn) Close() error {
+	err := c.Conn.Close()
+	c.client.Close()
+	return err
+}
+
+// loadSSHSigner reads the given private key, or the first default key found
+// in ~/.ssh when keyFile is empty.
+func loadSSHSigner(keyFile string) (ssh.Signer, error) {
+	candidates := []string{keyFile}
+	if keyFile == "" {
+		candidates = []string{
+			filepath.Join(homeDir(), ".ssh", "id_ed25519"),
+			filepath.Join(homeDir(), ".ssh", "id_ecdsa"),
+			filepath.Join(homeDir(), ".ssh", "id_rsa"),
+		}
+	}
+	for _, candidate := range candidates {
+		pem, err := os.ReadFile(candidate)
+		if err != nil {
+			if keyFile == "" && os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read ssh key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ssh key %s: %w", candidate, err)
+		}
+		return signer, nil
+	}
+	return nil, fmt.Errorf("no ssh private key found")
+}
+
+func homeDir() string {
+	if home, err := os.UserHomeDir(); err == nil {
+		return home
+	}
+	return "/root"
+}
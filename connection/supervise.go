@@ -0,0 +1,128 @@
+package connection
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+const (
+	defaultKeepaliveInterval = 5 * time.Second
+	defaultKeepaliveTimeout  = 5 * time.Second
+	defaultMinBackoff        = 1 * time.Second
+	defaultMaxBackoff        = 30 * time.Second
+)
+
+// OnConnect registers a hook which is run after every successful (re)connect,
+// e.g. to resubscribe to libvirt events.
+func (c *Connection) OnConnect(hook func(l *libvirt.Libvirt)) {
+	c.hooksMtx.Lock()
+	defer c.hooksMtx.Unlock()
+	c.hooks = append(c.hooks, hook)
+}
+
+// Supervise keeps the connection established until ctx is cancelled.
+// Failed connects are retried with exponential backoff, and an established
+// connection is checked with keepalive pings so a hung daemon is detected and
+// the connection re-established instead of blocking scrapes forever.
+func (c *Connection) Supervise(ctx context.Context, logger log.Logger) {
+	minBackoff, maxBackoff := c.config.ReconnectMinBackoff, c.config.ReconnectMaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = defaultMinBackoff
+	}
+	if maxBackoff < minBackoff {
+		maxBackoff = defaultMaxBackoff
+	}
+	backoff := minBackoff
+	for {
+		if !c.Libvirt.IsConnected() {
+			if err := c.Connect(); err != nil {
+				level.Error(logger).Log("msg", "failed to connect to libvirt", "uri", c.URI, "retry_in", backoff, "err", err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+				if backoff > maxBackoff {
+					backoff = maxBackoff
+				}
+				continue
+			}
+			level.Info(logger).Log("msg", "connected to libvirt", "uri", c.URI)
+			c.runHooks()
+		}
+		backoff = minBackoff
+
+		c.keepalive(ctx, logger)
+		if ctx.Err() != nil {
+			if err := c.Libvirt.Disconnect(); err != nil {
+				level.Debug(logger).Log("msg", "failed to disconnect from libvirt", "err", err)
+			}
+			return
+		}
+		level.Warn(logger).Log("msg", "lost connection to libvirt, reconnecting ...", "uri", c.URI)
+	}
+}
+
+// keepalive returns once the connection is lost or ctx is cancelled.
+func (c *Connection) keepalive(ctx context.Context, logger log.Logger) {
+	interval := c.config.KeepaliveInterval
+	if interval <= 0 {
+		interval = defaultKeepaliveInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	disconnected := c.Libvirt.Disconnected()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-disconnected:
+			return
+		case <-ticker.C:
+			if err := c.ping(); err != nil {
+				level.Warn(logger).Log("msg", "libvirt keepalive failed, closing connection", "err", err)
+				c.tracker.closeLastConn()
+				select {
+				case <-disconnected:
+				case <-time.After(interval):
+				}
+				return
+			}
+		}
+	}
+}
+
+// ping issues a cheap RPC and fails if it does not complete in time.
+func (c *Connection) ping() error {
+	timeout := c.config.KeepaliveTimeout
+	if timeout <= 0 {
+		timeout = defaultKeepaliveTimeout
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := c.Libvirt.ConnectGetLibVersion()
+		errCh <- err
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("no keepalive response within %s", timeout)
+	}
+}
+
+func (c *Connection) runHooks() {
+	c.hooksMtx.Lock()
+	hooks := append([]func(*libvirt.Libvirt){}, c.hooks...)
+	c.hooksMtx.Unlock()
+	for _, hook := range hooks {
+		hook(c.Libvirt)
+	}
+}
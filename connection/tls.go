@@ -0,0 +1,121 @@
+package connection
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Default locations of the client PKI files, as used by libvirt itself.
+const (
+	defaultTLSCAFile   = "/etc/pki/CA/cacert.pem"
+	defaultTLSCertFile = "/etc/pki/libvirt/clientcert.pem"
+	defaultTLSKeyFile  = "/etc/pki/libvirt/private/clientkey.pem"
+)
+
+// tlsDialer dials libvirtd over TCP and wraps the connection in TLS. The
+// certificates are reloaded on request, so rotated certificates are picked up
+// by the next connect without restarting the exporter.
+type tlsDialer struct {
+	address            string
+	timeout            time.Duration
+	serverName         string
+	insecureSkipVerify bool
+	caFile             string
+	certFile           string
+	keyFile            string
+
+	mtx       sync.Mutex
+	tlsConfig *tls.Config
+}
+
+func newTLSDialer(u *url.URL, config Config) (*tlsDialer, error) {
+	caFile, certFile, keyFile := defaultTLSCAFile, defaultTLSCertFile, defaultTLSKeyFile
+	if pkiPath := u.Query().Get("pkipath"); pkiPath != "" {
+		caFile = filepath.Join(pkiPath, "cacert.pem")
+		certFile = filepath.Join(pkiPath, "clientcert.pem")
+		keyFile = filepath.Join(pkiPath, "clientkey.pem")
+	}
+	if config.TLSCAFile != "" {
+		caFile = config.TLSCAFile
+	}
+	if config.TLSCertFile != "" {
+		certFile = config.TLSCertFile
+	}
+	if config.TLSKeyFile != "" {
+		keyFile = config.TLSKeyFile
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = defaultTLSPort
+	}
+	d := &tlsDialer{
+		address:            net.JoinHostPort(u.Hostname(), port),
+		timeout:            config.Timeout,
+		serverName:         u.Hostname(),
+		insecureSkipVerify: config.TLSInsecureSkipVerify || queryBool(u.Query(), "no_verify"),
+		caFile:             caFile,
+		certFile:           certFile,
+		keyFile:            keyFile,
+	}
+	if err := d.reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// reload reads the client certificate and the CA file. The CA file may be a
+// bundle of several certificates, e.g. the old and the new CA during a
+// rotation. On error the previous certificates stay in use.
+func (d *tlsDialer) reload() error {
+	tlsConfig := &tls.Config{
+		ServerName:         d.serverName,
+		InsecureSkipVerify: d.insecureSkipVerify,
+	}
+	cert, err := tls.LoadX509KeyPair(d.certFile, d.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load libvirt client certificate: %w", err)
+	}
+	tlsConfig.Certificates = []tls.Certificate{cert}
+	if !tlsConfig.InsecureSkipVerify {
+		caPEM, err := os.ReadFile(d.caFile)
+		if err != nil {
+			return fmt.Errorf("failed to read libvirt CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("no certificates found in libvirt CA file %s", d.caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.tlsConfig = tlsConfig
+	return nil
+}
+
+// Dial implements socket.Dialer.
+func (d *tlsDialer) Dial() (net.Conn, error) {
+	d.mtx.Lock()
+	tlsConfig := d.tlsConfig
+	d.mtx.Unlock()
+	return tls.DialWithDialer(&net.Dialer{Timeout: d.timeout}, "tcp", d.address, tlsConfig)
+}
+
+// ReloadTLS reloads the certificates of qemu+tls:// connections. They are
+// used from the next connect on, the established connection is kept. It is a
+// no-op for other transports.
+func (c *Connection) ReloadTLS() error {
+	if c.tls == nil {
+		return nil
+	}
+	return c.tls.reload()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// connectionStatus is the JSON document returned by the connection debug
+// endpoint.
+type connectionStatus struct {
+	URI            string `json:"uri"`
+	Connected      bool   `json:"connected"`
+	Local          bool   `json:"local"`
+	Socket         string `json:"socket,omitempty"`
+	DaemonRestarts uint64 `json:"daemon_restarts"`
+}
+
+// connectionDebug reports how the libvirt daemon is reached, in particular
+// which of the probed unix sockets was used.
+func (h *handler) connectionDebug(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(connectionStatus{
+		URI:            string(h.conn.URI),
+		Connected:      h.conn.Libvirt.IsConnected(),
+		Local:          h.conn.Local,
+		Socket:         h.conn.SocketPath(),
+		DaemonRestarts: h.conn.DaemonRestarts(),
+	})
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/log/level"
+	"github.com/nee541/libvirt-exporter/collector"
+)
+
+// domainInventory is the JSON document returned by the domains endpoint.
+type domainInventory struct {
+	Domains []collector.InventoryDomain `json:"domains"`
+}
+
+// domains lists the active domains with their state, size, disks and
+// interfaces, for tools which would otherwise call virsh.
+func (h *handler) domains(w http.ResponseWriter, r *http.Request) {
+	domains, err := collector.Inventory(h.conn)
+	if err != nil {
+		level.Error(h.logger).Log("msg", "Couldn't list domains", "err", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(domainInventory{Domains: domains})
+}
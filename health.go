@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// healthy answers liveness probes. The exporter is healthy as long as it
+// serves HTTP, a lost libvirt connection is re-established in the
+// background and must not get the exporter restarted.
+func (h *handler) healthy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "libvirt exporter is healthy.")
+}
+
+// ready answers readiness probes. The exporter is ready while it is connected
+// to libvirt, scrapes without a connection return no domain metrics.
+func (h *handler) ready(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if h.conn == nil || h.conn.Libvirt == nil || !h.conn.Libvirt.IsConnected() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "libvirt exporter is not connected to libvirt.")
+		return
+	}
+	fmt.Fprintln(w, "libvirt exporter is ready.")
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nee541/libvirt-exporter/state"
+)
+
+// inspect prints the last known domain inventory from the state file. It does
+// not need libvirt, so it also works while the daemon is unreachable.
+func inspect(path string, w io.Writer) error {
+	store, err := state.Open(path, true)
+	if err != nil {
+		return fmt.Errorf("couldn't open state file: %w", err)
+	}
+	defer store.Close()
+
+	domains, err := store.Domains()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "UUID\tNAME\tLAST SEEN\tDISKS\tINTERFACES\tPROJECT")
+	for _, domain := range domains {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\n",
+			domain.UUID,
+			domain.Name,
+			domain.LastSeen.Format(time.RFC3339),
+			len(domain.Schema.Devices.Disks),
+			len(domain.Schema.Devices.Interfaces),
+			domain.Schema.Metadata.NovaInstance.Owner.Project.ProjectName,
+		)
+	}
+	return tw.Flush()
+}
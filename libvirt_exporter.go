@@ -1,23 +1,29 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	stdlog "log"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
 	"os/user"
+	"regexp"
 	"runtime"
-	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/nee541/libvirt-exporter/collector"
+	"github.com/nee541/libvirt-exporter/connection"
+	"github.com/nee541/libvirt-exporter/state"
 
 	"github.com/prometheus/common/promlog"
 	"github.com/prometheus/common/promlog/flag"
 
 	"github.com/alecthomas/kingpin/v2"
-	libvirt "github.com/digitalocean/go-libvirt"
-	"github.com/digitalocean/go-libvirt/socket/dialers"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
@@ -29,52 +35,132 @@ import (
 )
 
 // handler wraps an unfiltered http.Handler but uses a filtered handler,
-// created on the fly, if filtering is requested. Create instances with
+// created on the fly, if filtering is requested. Both collect on the context
+// of the request, which carries the scrape deadline. Create instances with
 // newHandler.
 type handler struct {
 	unfilteredHandler http.Handler
+	// unfilteredMtx guards unfilteredHandler, which is replaced when the
+	// config file is reloaded.
+	unfilteredMtx sync.RWMutex
 	// exporterMetricsRegistry is a separate registry for the metrics about
 	// the exporter itself.
 	exporterMetricsRegistry *prometheus.Registry
 	includeExporterMetrics  bool
+	disableCompression      bool
 	maxRequests             int
-	pLibvirt                *libvirt.Libvirt
-	logger                  log.Logger
+	// inFlightSem limits the number of concurrent scrapes across the
+	// unfiltered and the on the fly created handlers.
+	inFlightSem   chan struct{}
+	timeoutOffset time.Duration
+	// exclude drops matching metric families, nil keeps all.
+	exclude *regexp.Regexp
+	// snapshot serves the result of the background collection, if enabled.
+	snapshot *snapshotGatherer
+	// flights shares running collections between concurrent scrapes.
+	flights *singleFlight
+	conn    *connection.Connection
+	logger  log.Logger
 }
 
-func newHandler(includeExporterMetrics bool, maxRequests int, pLibvirt *libvirt.Libvirt, logger log.Logger) *handler {
+func newHandler(includeExporterMetrics, disableCompression bool, maxRequests int, timeoutOffset, backgroundInterval time.Duration, exclude *regexp.Regexp, conn *connection.Connection, logger log.Logger) *handler {
 	h := &handler{
 		exporterMetricsRegistry: prometheus.NewRegistry(),
 		includeExporterMetrics:  includeExporterMetrics,
+		disableCompression:      disableCompression,
 		maxRequests:             maxRequests,
-		pLibvirt:                pLibvirt,
+		timeoutOffset:           timeoutOffset,
+		exclude:                 exclude,
+		flights:                 newSingleFlight(),
+		conn:                    conn,
 		logger:                  logger,
 	}
+	if maxRequests > 0 {
+		h.inFlightSem = make(chan struct{}, maxRequests)
+	}
 	if h.includeExporterMetrics {
 		h.exporterMetricsRegistry.MustRegister(
 			promcollectors.NewProcessCollector(promcollectors.ProcessCollectorOpts{}),
 			promcollectors.NewGoCollector(),
 		)
 	}
+	h.exporterMetricsRegistry.MustRegister(h.flights.dropped)
+	if backgroundInterval > 0 {
+		h.snapshot = &snapshotGatherer{}
+		h.exporterMetricsRegistry.MustRegister(prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Namespace: "libvirt_exporter",
+				Name:      "snapshot_age_seconds",
+				Help:      "Age of the metrics snapshot taken by the background collection.",
+			},
+			h.snapshot.age,
+		))
+		go h.snapshot.run(context.Background(), backgroundInterval, func(ctx context.Context) (prometheus.Gatherer, error) {
+			return h.newRegistry(ctx)
+		}, log.With(logger, "component", "background"))
+	}
 	if innerHandler, err := h.innerHandler(); err != nil {
 		panic(fmt.Sprintf("Couldn't create metrics handler: %s", err))
 	} else {
 		h.unfilteredHandler = innerHandler
 	}
+	level.Info(h.logger).Log("msg", "Enabled collectors")
+	for _, c := range collector.EnabledCollectors() {
+		level.Info(h.logger).Log("collector", c)
+	}
 	return h
 }
 
 // ServeHTTP implements http.Handler.
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	filters := r.URL.Query()["collect[]"]
-	level.Debug(h.logger).Log("msg", "collect query:", "filters", filters)
+	excludes := r.URL.Query()["exclude[]"]
+	level.Debug(h.logger).Log("msg", "collect query:", "filters", filters, "excludes", excludes)
+
+	if len(excludes) > 0 {
+		if len(filters) > 0 {
+			http.Error(w, "collect[] and exclude[] can not be combined", http.StatusBadRequest)
+			return
+		}
+		var err error
+		if filters, err = excludeCollectors(excludes); err != nil {
+			http.Error(w, fmt.Sprintf("Couldn't create filtered metrics handler: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if h.inFlightSem != nil {
+		select {
+		case h.inFlightSem <- struct{}{}:
+			defer func() { <-h.inFlightSem }()
+		default:
+			http.Error(w, fmt.Sprintf("Limit of concurrent requests reached (%d), try again later.", h.maxRequests), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	ctx := r.Context()
+	timeout, err := scrapeTimeout(r, h.timeoutOffset)
+	if err != nil {
+		level.Warn(h.logger).Log("msg", "Couldn't parse scrape timeout header:", "err", err)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	r = r.WithContext(ctx)
 
 	if len(filters) == 0 {
 		// No filters, use the prepared unfiltered handler.
-		h.unfilteredHandler.ServeHTTP(w, r)
+		h.unfilteredMtx.RLock()
+		unfilteredHandler := h.unfilteredHandler
+		h.unfilteredMtx.RUnlock()
+		unfilteredHandler.ServeHTTP(w, r)
 		return
 	}
-	// To serve filtered metrics, we create a filtering handler on the fly.
+	// To serve filtered metrics, we create a handler on the fly.
 	filteredHandler, err := h.innerHandler(filters...)
 	if err != nil {
 		level.Warn(h.logger).Log("msg", "Couldn't create filtered metrics handler:", "err", err)
@@ -85,53 +171,208 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	filteredHandler.ServeHTTP(w, r)
 }
 
+// rebuild replaces the unfiltered handler, so it runs the collectors enabled
+// after a config reload.
+func (h *handler) rebuild() {
+	innerHandler, err := h.innerHandler()
+	if err != nil {
+		level.Error(h.logger).Log("msg", "Couldn't rebuild metrics handler, keeping the previous one", "err", err)
+		return
+	}
+	h.unfilteredMtx.Lock()
+	h.unfilteredHandler = innerHandler
+	h.unfilteredMtx.Unlock()
+}
+
+// excludeCollectors turns an exclude[] list into the equivalent collect[]
+// list of all other enabled collectors.
+func excludeCollectors(excludes []string) ([]string, error) {
+	excluded := make(map[string]bool, len(excludes))
+	for _, name := range excludes {
+		if !collector.IsKnownCollector(name) {
+			return nil, fmt.Errorf("missing collector: %s", name)
+		}
+		excluded[name] = true
+	}
+	filters := []string{}
+	for _, name := range collector.EnabledCollectors() {
+		if !excluded[name] {
+			filters = append(filters, name)
+		}
+	}
+	if len(filters) == 0 {
+		return nil, fmt.Errorf("all collectors excluded")
+	}
+	return filters, nil
+}
+
+// scrapeTimeout returns the timeout announced by Prometheus in the
+// X-Prometheus-Scrape-Timeout-Seconds header minus offset, leaving time to
+// encode and transfer the response. A zero duration means no timeout.
+func scrapeTimeout(r *http.Request, offset time.Duration) (time.Duration, error) {
+	v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if v == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid scrape timeout %q: %w", v, err)
+	}
+	timeout := time.Duration(seconds*float64(time.Second)) - offset
+	if timeout <= 0 {
+		return 0, fmt.Errorf("scrape timeout %s is smaller than the offset %s", v, offset)
+	}
+	return timeout, nil
+}
+
+// instrument wraps next with metrics about the HTTP requests served by the
+// exporter itself, so slow responses can be told apart from slow collection.
+// The wrapped handler is returned unchanged if exporter metrics are disabled.
+func (h *handler) instrument(handlerName string, next http.Handler) http.Handler {
+	if !h.includeExporterMetrics {
+		return next
+	}
+	inFlight := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   "libvirt_exporter",
+		Subsystem:   "http",
+		Name:        "requests_in_flight",
+		Help:        "Current number of HTTP requests being served.",
+		ConstLabels: prometheus.Labels{"handler": handlerName},
+	})
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   "libvirt_exporter",
+		Subsystem:   "http",
+		Name:        "request_duration_seconds",
+		Help:        "Duration of HTTP requests served by the exporter.",
+		ConstLabels: prometheus.Labels{"handler": handlerName},
+		Buckets:     []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"code", "method"})
+	responseSize := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   "libvirt_exporter",
+		Subsystem:   "http",
+		Name:        "response_size_bytes",
+		Help:        "Size of HTTP responses sent by the exporter, after compression.",
+		ConstLabels: prometheus.Labels{"handler": handlerName},
+		Buckets:     prometheus.ExponentialBuckets(1024, 4, 10),
+	}, []string{"code", "method"})
+	h.exporterMetricsRegistry.MustRegister(inFlight, duration, responseSize)
+	return promhttp.InstrumentHandlerInFlight(inFlight,
+		promhttp.InstrumentHandlerDuration(duration,
+			promhttp.InstrumentHandlerResponseSize(responseSize, next)))
+}
+
 // innerHandler is used to create both the one unfiltered http.Handler to be
 // wrapped by the outer handler and also the filtered handlers created on the
-// fly. The former is accomplished by calling innerHandler without any arguments
-// (in which case it will log all the collectors enabled via command-line
-// flags).
+// fly. The former is accomplished by calling innerHandler without any filters.
+// In background collection mode the unfiltered handler serves the latest
+// snapshot, otherwise the handler collects on the context of each request.
 func (h *handler) innerHandler(filters ...string) (http.Handler, error) {
-	lc, err := collector.NewLibvirtCollector(h.pLibvirt, h.logger, filters...)
+	if h.snapshot != nil && len(filters) == 0 {
+		return h.handlerFor(h.snapshot), nil
+	}
+	lc, err := h.newCollector(filters...)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't create collector: %s", err)
+		return nil, err
 	}
+	return h.instrumentMetricHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gatherer := h.flights.gatherer(r.Context(), func(ctx context.Context) (prometheus.Gatherer, error) {
+			return h.registryFor(lc.WithContext(ctx))
+		}, filters...)
+		h.metricsHandler(gatherer).ServeHTTP(w, r)
+	})), nil
+}
 
-	// Only log the creation of an unfiltered handler, which should happen
-	// only once upon startup.
-	if len(filters) == 0 {
-		level.Info(h.logger).Log("msg", "Enabled collectors")
-		collectors := []string{}
-		for n := range lc.Collectors {
-			collectors = append(collectors, n)
-		}
-		sort.Strings(collectors)
-		for _, c := range collectors {
-			level.Info(h.logger).Log("collector", c)
-		}
+// handlerFor returns the http.Handler exposing gatherer along with the
+// exporter metrics.
+func (h *handler) handlerFor(gatherer prometheus.Gatherer) http.Handler {
+	return h.instrumentMetricHandler(h.metricsHandler(gatherer))
+}
+
+// metricsHandler returns the uninstrumented http.Handler exposing gatherer
+// along with the exporter metrics.
+func (h *handler) metricsHandler(gatherer prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(
+		prometheus.Gatherers{h.exporterMetricsRegistry, gatherer},
+		promhttp.HandlerOpts{
+			ErrorLog:      stdlog.New(log.NewStdlibAdapter(level.Error(h.logger)), "", 0),
+			ErrorHandling: promhttp.ContinueOnError,
+			Registry:      h.exporterMetricsRegistry,
+			// The scrape is gathered completely before anything is
+			// written, only the encoding is streamed into the response.
+			// It is gzip compressed if the scraper accepts it, which
+			// shrinks the exposition of large hypervisors by an order
+			// of magnitude, see BenchmarkMetricsResponse.
+			DisableCompression: h.disableCompression,
+		},
+	)
+}
+
+// instrumentMetricHandler adds the promhttp_* metrics to handler, if exporter
+// metrics are enabled.
+func (h *handler) instrumentMetricHandler(handler http.Handler) http.Handler {
+	if !h.includeExporterMetrics {
+		return handler
+	}
+	// Note that we have to use h.exporterMetricsRegistry here to use the
+	// same promhttp metrics for all expositions.
+	return promhttp.InstrumentMetricHandler(h.exporterMetricsRegistry, handler)
+}
+
+// newRegistry creates a registry with a libvirt collector bound to ctx and
+// restricted to the given collectors.
+func (h *handler) newRegistry(ctx context.Context, filters ...string) (*prometheus.Registry, error) {
+	lc, err := h.newCollector(filters...)
+	if err != nil {
+		return nil, err
+	}
+	return h.registryFor(lc.WithContext(ctx))
+}
+
+// newCollector creates a libvirt collector restricted to the given
+// collectors.
+func (h *handler) newCollector(filters ...string) (*collector.LibvirtCollector, error) {
+	lc, err := collector.NewLibvirtCollector(h.conn, h.logger, filters...)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create collector: %s", err)
 	}
+	return lc, nil
+}
 
+// registryFor creates a registry with lc.
+func (h *handler) registryFor(lc *collector.LibvirtCollector) (*prometheus.Registry, error) {
 	r := prometheus.NewRegistry()
 	r.MustRegister(version.NewCollector("libvirt_exporter"))
-	if err := r.Register(lc); err != nil {
+	if err := r.Register(h.excludeMetrics(lc)); err != nil {
 		return nil, fmt.Errorf("couldn't register libvirt collector: %s", err)
 	}
-	handler := promhttp.HandlerFor(
-		prometheus.Gatherers{h.exporterMetricsRegistry, r},
-		promhttp.HandlerOpts{
-			ErrorLog:            stdlog.New(log.NewStdlibAdapter(level.Error(h.logger)), "", 0),
-			ErrorHandling:       promhttp.ContinueOnError,
-			MaxRequestsInFlight: h.maxRequests,
-			Registry:            h.exporterMetricsRegistry,
-		},
-	)
-	if h.includeExporterMetrics {
-		// Note that we have to use h.exporterMetricsRegistry here to
-		// use the same promhttp metrics for all expositions.
-		handler = promhttp.InstrumentMetricHandler(
-			h.exporterMetricsRegistry, handler,
-		)
+	return r, nil
+}
+
+// excludeMetrics applies --metrics.exclude to c.
+func (h *handler) excludeMetrics(c prometheus.Collector) prometheus.Collector {
+	if h.exclude == nil {
+		return c
+	}
+	return collector.NewExcludeCollector(c, h.exclude)
+}
+
+// reloadOnSIGHUP reloads the config file, if any, and the TLS certificates
+// of the libvirt connection on every SIGHUP, so rotated certificates are used
+// without a restart.
+func reloadOnSIGHUP(conn *connection.Connection, rl *reloader, logger log.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if rl.path != "" {
+			// Errors are logged by the reloader.
+			rl.reload()
+		}
+		if err := conn.ReloadTLS(); err != nil {
+			level.Error(logger).Log("msg", "Couldn't reload TLS certificates, keeping the previous ones", "err", err)
+			continue
+		}
+		level.Info(logger).Log("msg", "Reloaded TLS certificates")
 	}
-	return handler, nil
 }
 
 func main() {
@@ -144,44 +385,382 @@ func main() {
 			"web.disable-exporter-metrics",
 			"Exclude metrics about the exporter itself (promhttp_*, process_*, go_*).",
 		).Bool()
+		disableCompression = kingpin.Flag(
+			"web.disable-compression",
+			"Never gzip compress the metrics response, even if the scraper accepts it.",
+		).Bool()
 		maxRequests = kingpin.Flag(
 			"web.max-requests",
 			"Maximum number of parallel scrape requests. Use 0 to disable.",
 		).Default("40").Int()
 		disableDefaultCollectors = kingpin.Flag(
 			"collector.disable-defaults",
-			"Set all collectors to disabled by default.",
+			"Set all collectors to disabled by default, only collectors enabled with --collector.<name> run.",
 		).Default("false").Bool()
+		metricsCompat = kingpin.Flag(
+			"metrics.compat",
+			"Additionally expose metrics compatible with another monitoring system, one of: ceilometer.",
+		).Default("").Enum("", "ceilometer")
+		metricsExclude = kingpin.Flag(
+			"metrics.exclude",
+			"Regular expression matched against the full metric name, matching metrics are dropped before they are exposed.",
+		).Default("").String()
+		stateFile = kingpin.Flag(
+			"state.file",
+			"Path to a state file persisting the domain inventory and event counters across restarts. Empty disables it.",
+		).Default("").String()
+		configFile = kingpin.Flag(
+			"config.file",
+			"Path to a YAML configuration file, command line flags take precedence over it.",
+		).Default("").String()
+		once = kingpin.Flag(
+			"once",
+			"Collect the metrics once, write them to --output.file and exit, e.g. for the textfile collector of node_exporter.",
+		).Bool()
+		outputFile = kingpin.Flag(
+			"output.file",
+			"File the metrics are written to with --once, - for stdout.",
+		).Default("-").String()
+		onceTimeout = kingpin.Flag(
+			"once.timeout",
+			"Timeout of the collection with --once, 0 means no timeout.",
+		).Default("1m").Duration()
+		enableLifecycle = kingpin.Flag(
+			"web.enable-lifecycle",
+			"Enable reloading the config file with POST requests to /-/reload.",
+		).Bool()
+		timeoutOffset = kingpin.Flag(
+			"scrape.timeout-offset",
+			"Offset to subtract from the timeout announced by Prometheus in the X-Prometheus-Scrape-Timeout-Seconds header.",
+		).Default("500ms").Duration()
+		backgroundInterval = kingpin.Flag(
+			"scrape.background-interval",
+			"Collect metrics in the background in this interval and serve the latest snapshot on scrapes instead of collecting on every scrape. 0 disables background collection.",
+		).Default("0s").Duration()
 		maxProcs = kingpin.Flag(
 			"runtime.gomaxprocs", "The target number of CPUs Go will run on (GOMAXPROCS)",
 		).Envar("GOMAXPROCS").Default("1").Int()
 		toolkitFlags = kingpinflag.AddFlags(kingpin.CommandLine, ":9177")
+		socketConfig = unixSocketConfig{}
+		rwConfig     = remoteWriteConfig{}
+		otlpCfg      = otlpConfig{}
+
+		connConfig = connection.Config{}
 	)
+	kingpin.Flag(
+		"web.unix-socket-mode",
+		"File mode of unix sockets given as unix:/path listen address.",
+	).Default("0660").StringVar(&socketConfig.mode)
+	kingpin.Flag(
+		"web.unix-socket-owner",
+		"Owner of unix sockets given as unix:/path listen address, in the form user[:group].",
+	).StringVar(&socketConfig.owner)
+	kingpin.Flag(
+		"remote-write.url",
+		"Push the metrics to this Prometheus remote write endpoint, e.g. https://prometheus.example.com/api/v1/write. Empty disables pushing.",
+	).StringVar(&rwConfig.url)
+	kingpin.Flag(
+		"remote-write.interval",
+		"Interval between pushes to the remote write endpoint.",
+	).Default("30s").DurationVar(&rwConfig.interval)
+	kingpin.Flag(
+		"remote-write.timeout",
+		"Timeout of a single request to the remote write endpoint.",
+	).Default("10s").DurationVar(&rwConfig.timeout)
+	kingpin.Flag(
+		"remote-write.max-samples-per-send",
+		"Maximum number of samples sent to the remote write endpoint in one request.",
+	).Default("2000").IntVar(&rwConfig.maxSamplesPerSend)
+	kingpin.Flag(
+		"remote-write.label",
+		"Label added to every pushed series, in the form name=value, repeatable (default: job=libvirt and instance=<hostname>).",
+	).StringMapVar(&rwConfig.labels)
+	kingpin.Flag(
+		"remote-write.bearer-token-file",
+		"File with the bearer token sent to the remote write endpoint, re-read when it changes.",
+	).StringVar(&rwConfig.bearerTokenFile)
+	kingpin.Flag(
+		"remote-write.tls.ca-file",
+		"CA certificate used to verify the remote write endpoint.",
+	).StringVar(&rwConfig.caFile)
+	kingpin.Flag(
+		"remote-write.tls.cert-file",
+		"Client certificate sent to the remote write endpoint.",
+	).StringVar(&rwConfig.certFile)
+	kingpin.Flag(
+		"remote-write.tls.key-file",
+		"Client private key of the certificate sent to the remote write endpoint.",
+	).StringVar(&rwConfig.keyFile)
+	kingpin.Flag(
+		"remote-write.tls.insecure-skip-verify",
+		"Do not verify the certificate of the remote write endpoint.",
+	).BoolVar(&rwConfig.insecureSkipVerify)
+	kingpin.Flag(
+		"otlp.endpoint",
+		"Push the metrics as OpenTelemetry metrics to this OTLP/HTTP endpoint, e.g. https://otel-collector:4318/v1/metrics. Empty disables pushing.",
+	).StringVar(&otlpCfg.endpoint)
+	kingpin.Flag(
+		"otlp.interval",
+		"Interval between pushes to the OTLP endpoint.",
+	).Default("30s").DurationVar(&otlpCfg.interval)
+	kingpin.Flag(
+		"otlp.timeout",
+		"Timeout of a single export to the OTLP endpoint.",
+	).Default("10s").DurationVar(&otlpCfg.timeout)
+	kingpin.Flag(
+		"otlp.header",
+		"Header sent to the OTLP endpoint, in the form name=value, repeatable, e.g. for an API key.",
+	).StringMapVar(&otlpCfg.headers)
+	kingpin.Flag(
+		"otlp.compression",
+		"Compression of requests to the OTLP endpoint, one of: gzip, none.",
+	).Default("gzip").EnumVar(&otlpCfg.compression, "gzip", "none")
+	kingpin.Flag(
+		"otlp.tls.ca-file",
+		"CA certificate used to verify the OTLP endpoint.",
+	).StringVar(&otlpCfg.caFile)
+	kingpin.Flag(
+		"otlp.tls.cert-file",
+		"Client certificate sent to the OTLP endpoint.",
+	).StringVar(&otlpCfg.certFile)
+	kingpin.Flag(
+		"otlp.tls.key-file",
+		"Client private key of the certificate sent to the OTLP endpoint.",
+	).StringVar(&otlpCfg.keyFile)
+	kingpin.Flag(
+		"otlp.tls.insecure-skip-verify",
+		"Do not verify the certificate of the OTLP endpoint.",
+	).BoolVar(&otlpCfg.insecureSkipVerify)
+	kingpin.Flag(
+		"libvirt.uri",
+		"Libvirt URI to connect to, e.g. qemu:///system, qemu+tcp://host/system, qemu+tls://host/system or qemu+ssh://user@host/system.",
+	).Default(connection.DefaultURI).StringVar(&connConfig.URI)
+	kingpin.Flag(
+		"libvirt.timeout",
+		"Timeout for establishing the libvirt connection.",
+	).Default("15s").DurationVar(&connConfig.Timeout)
+	kingpin.Flag(
+		"libvirt.tls.cert-file",
+		"Client certificate for qemu+tls:// connections (default: /etc/pki/libvirt/clientcert.pem).",
+	).StringVar(&connConfig.TLSCertFile)
+	kingpin.Flag(
+		"libvirt.tls.key-file",
+		"Client private key for qemu+tls:// connections (default: /etc/pki/libvirt/private/clientkey.pem).",
+	).StringVar(&connConfig.TLSKeyFile)
+	kingpin.Flag(
+		"libvirt.tls.ca-file",
+		"CA certificate or bundle of CA certificates used to verify the libvirt server for qemu+tls:// connections, reloaded with the client certificate on SIGHUP (default: /etc/pki/CA/cacert.pem).",
+	).StringVar(&connConfig.TLSCAFile)
+	kingpin.Flag(
+		"libvirt.tls.insecure-skip-verify",
+		"Do not verify the libvirt server certificate for qemu+tls:// connections.",
+	).BoolVar(&connConfig.TLSInsecureSkipVerify)
+	kingpin.Flag(
+		"libvirt.ssh.user",
+		"User for qemu+ssh:// connections when the URI does not contain one (default: current user).",
+	).StringVar(&connConfig.SSHUser)
+	kingpin.Flag(
+		"libvirt.ssh.key-file",
+		"Private key for qemu+ssh:// connections (default: ~/.ssh/id_ed25519, id_ecdsa or id_rsa).",
+	).StringVar(&connConfig.SSHKeyFile)
+	kingpin.Flag(
+		"libvirt.ssh.known-hosts-file",
+		"known_hosts file used to verify the host key for qemu+ssh:// connections (default: ~/.ssh/known_hosts).",
+	).StringVar(&connConfig.SSHKnownHostsFile)
+	kingpin.Flag(
+		"libvirt.ssh.insecure-ignore-host-key",
+		"Do not verify the host key for qemu+ssh:// connections.",
+	).BoolVar(&connConfig.SSHInsecureIgnoreHostKey)
+	kingpin.Flag(
+		"libvirt.keepalive-interval",
+		"Interval between keepalive pings on an established libvirt connection.",
+	).Default("5s").DurationVar(&connConfig.KeepaliveInterval)
+	kingpin.Flag(
+		"libvirt.keepalive-timeout",
+		"Time to wait for a keepalive response before the libvirt connection is considered dead.",
+	).Default("5s").DurationVar(&connConfig.KeepaliveTimeout)
+	kingpin.Flag(
+		"libvirt.reconnect-max-backoff",
+		"Maximum delay between attempts to reconnect to libvirt.",
+	).Default("30s").DurationVar(&connConfig.ReconnectMaxBackoff)
+	kingpin.Flag(
+		"libvirt.chaos.drop-probability",
+		"Testing only: probability with which the libvirt connection is dropped before a request.",
+	).Hidden().Default("0").Float64Var(&connConfig.Chaos.DropProbability)
+	kingpin.Flag(
+		"libvirt.chaos.max-latency",
+		"Testing only: delay reads from the libvirt connection by a random duration up to this value.",
+	).Hidden().Default("0s").DurationVar(&connConfig.Chaos.MaxLatency)
+	kingpin.Flag(
+		"libvirt.chaos.error-probability",
+		"Testing only: probability with which a libvirt call is answered with an error instead of being sent.",
+	).Hidden().Default("0").Float64Var(&connConfig.Chaos.ErrorProbability)
 
 	promlogConfig := &promlog.Config{}
 	flag.AddFlags(kingpin.CommandLine, promlogConfig)
 	kingpin.Version(version.Print("libvirt_exporter"))
 	kingpin.CommandLine.UsageWriter(os.Stdout)
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	kingpin.Command("serve", "Serve metrics (default).").Default()
+	inspectCmd := kingpin.Command("inspect", "Print the last known domain inventory from --state.file without connecting to libvirt.")
+	docsMetricsCmd := kingpin.Command("docs-metrics", "Write the catalog of all metrics the exporter can emit as JSON and exit.")
+	docsMetricsOutput := docsMetricsCmd.Flag("output", "File to write the catalog to, - for stdout.").Short('o').Default("-").String()
+	command := kingpin.Parse()
 	logger := promlog.New(promlogConfig)
 
-	if *disableDefaultCollectors {
-		collector.DisableDefaultCollectors()
+	if command == docsMetricsCmd.FullCommand() {
+		out := os.Stdout
+		if *docsMetricsOutput != "-" {
+			f, err := os.Create(*docsMetricsOutput)
+			if err != nil {
+				level.Error(logger).Log("err", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			out = f
+		}
+		if err := writeMetricsCatalog(out, logger); err != nil {
+			level.Error(logger).Log("msg", "Couldn't write metrics catalog", "err", err)
+			os.Exit(1)
+		}
+		return
 	}
+
+	if command == inspectCmd.FullCommand() {
+		if *stateFile == "" {
+			kingpin.Fatalf("inspect requires --state.file")
+		}
+		if err := inspect(*stateFile, os.Stdout); err != nil {
+			level.Error(logger).Log("err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	level.Info(logger).Log("msg", "Starting libvirt_exporter", "version", version.Info())
 	level.Info(logger).Log("msg", "Build context", "build_context", version.BuildContext())
 	if user, err := user.Current(); err == nil && user.Uid == "0" {
 		level.Warn(logger).Log("msg", "libvirt Exporter is running as root user. This exporter is designed to run as unprivileged user, root is not required.")
 	}
+	if *disableDefaultCollectors {
+		collector.DisableDefaultCollectors()
+	}
+	if *metricsCompat == "ceilometer" {
+		collector.SetCollectorState("ceilometer", true)
+	}
+	rl, err := newReloader(*configFile, log.With(logger, "component", "config"))
+	if err != nil {
+		level.Error(logger).Log("msg", "Couldn't parse command line", "err", err)
+		os.Exit(1)
+	}
+	if *configFile != "" {
+		cfg, err := rl.load()
+		if err != nil {
+			level.Error(logger).Log("msg", "Couldn't load config file", "err", err)
+			os.Exit(1)
+		}
+		cfg.Libvirt.apply(&connConfig, rl.commandLine)
+		if err := cfg.Enrichment.apply(log.With(logger, "component", "enrichment")); err != nil {
+			level.Error(logger).Log("msg", "Invalid enrichment in config file", "err", err)
+			os.Exit(1)
+		}
+	}
 	runtime.GOMAXPROCS(*maxProcs)
 	level.Debug(logger).Log("msg", "Go MAXPROCS", "procs", runtime.GOMAXPROCS(0))
 
-	// TODO: Add support for user-defined connection, now only default local connection is supported
-	local := dialers.NewLocal()
-	pLibvirt := libvirt.NewWithDialer(local)
+	rpcs := newRPCMetrics()
+	connConfig.ObserveRPC = rpcs.observe
+	conn, err := connection.New(connConfig)
+	if err != nil {
+		level.Error(logger).Log("msg", "Couldn't create libvirt connection", "err", err)
+		os.Exit(1)
+	}
+	level.Info(logger).Log("msg", "Using libvirt", "uri", connConfig.URI)
+	if connConfig.Chaos.DropProbability > 0 || connConfig.Chaos.MaxLatency > 0 || connConfig.Chaos.ErrorProbability > 0 {
+		level.Warn(logger).Log("msg", "Chaos mode enabled, the libvirt connection misbehaves on purpose", "drop_probability", connConfig.Chaos.DropProbability, "max_latency", connConfig.Chaos.MaxLatency, "error_probability", connConfig.Chaos.ErrorProbability)
+	}
+	if *stateFile != "" {
+		store, err := state.Open(*stateFile, false)
+		if err != nil {
+			level.Error(logger).Log("msg", "Couldn't open state file", "err", err)
+			os.Exit(1)
+		}
+		defer store.Close()
+		if err := collector.UseStateStore(store, log.With(logger, "component", "state")); err != nil {
+			level.Error(logger).Log("msg", "Couldn't restore state", "err", err)
+			os.Exit(1)
+		}
+	}
+	var exclude *regexp.Regexp
+	if *metricsExclude != "" {
+		// Anchored like the regular expressions of relabeling rules.
+		exclude, err = regexp.Compile("^(?:" + *metricsExclude + ")$")
+		if err != nil {
+			level.Error(logger).Log("msg", "Invalid --metrics.exclude", "err", err)
+			os.Exit(1)
+		}
+	}
+	if *once {
+		if err := collectOnce(conn, exclude, *outputFile, *onceTimeout, logger); err != nil {
+			level.Error(logger).Log("msg", "Couldn't write metrics", "file", *outputFile, "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+	collector.WatchEvents(conn, log.With(logger, "component", "events"))
+	go conn.Supervise(context.Background(), log.With(logger, "component", "connection"))
 
-	http.Handle(*metricsPath, newHandler(!*disableExporterMetrics, *maxRequests, pLibvirt, logger))
+	metricsHandler := newHandler(!*disableExporterMetrics, *disableCompression, *maxRequests, *timeoutOffset, *backgroundInterval, exclude, conn, logger)
+	configMetrics, err := newConfigCollector(*configFile)
+	if err != nil {
+		level.Error(logger).Log("msg", "Couldn't hash configuration", "err", err)
+		os.Exit(1)
+	}
+	metricsHandler.exporterMetricsRegistry.MustRegister(configMetrics, rpcs)
+	rl.metrics = configMetrics
+	rl.onReload = metricsHandler.rebuild
+	go reloadOnSIGHUP(conn, rl, log.With(logger, "component", "connection"))
+	if *enableLifecycle {
+		http.Handle("/-/reload", rl)
+	}
+	if rwConfig.url != "" {
+		if rwConfig.labels == nil {
+			rwConfig.labels = map[string]string{}
+		}
+		if _, ok := rwConfig.labels["job"]; !ok {
+			rwConfig.labels["job"] = "libvirt"
+		}
+		if _, ok := rwConfig.labels["instance"]; !ok {
+			hostname, err := os.Hostname()
+			if err != nil {
+				level.Error(logger).Log("msg", "Couldn't get hostname, set the instance with --remote-write.label", "err", err)
+				os.Exit(1)
+			}
+			rwConfig.labels["instance"] = hostname
+		}
+		rw, err := newRemoteWriter(rwConfig, metricsHandler.pushGatherer, log.With(logger, "component", "remote_write"))
+		if err != nil {
+			level.Error(logger).Log("msg", "Invalid remote write configuration", "err", err)
+			os.Exit(1)
+		}
+		metricsHandler.exporterMetricsRegistry.MustRegister(rw)
+		level.Info(logger).Log("msg", "Pushing metrics with remote write", "url", rwConfig.url, "interval", rwConfig.interval)
+		go rw.run(context.Background())
+	}
+	if otlpCfg.endpoint != "" {
+		if err := startOTLPExporter(otlpCfg, metricsHandler.pushGatherer, log.With(logger, "component", "otlp")); err != nil {
+			level.Error(logger).Log("msg", "Invalid OTLP configuration", "err", err)
+			os.Exit(1)
+		}
+		level.Info(logger).Log("msg", "Pushing metrics with OTLP", "endpoint", otlpCfg.endpoint, "interval", otlpCfg.interval)
+	}
+	http.Handle(*metricsPath, metricsHandler.instrument(*metricsPath, metricsHandler))
+	http.HandleFunc("/-/selftest", metricsHandler.selfTest)
+	http.HandleFunc("/api/v1/metrics-catalog", metricsHandler.metricsCatalog)
+	http.HandleFunc("/api/v1/domains", metricsHandler.domains)
+	http.HandleFunc("/debug/connection", metricsHandler.connectionDebug)
+	http.HandleFunc("/-/healthy", metricsHandler.healthy)
+	http.HandleFunc("/-/ready", metricsHandler.ready)
 	if *metricsPath != "/" {
 		landingConfig := web.LandingConfig{
 			Name:        "libvirt Exporter",
@@ -192,6 +771,30 @@ func main() {
 					Address: *metricsPath,
 					Text:    "Metrics",
 				},
+				{
+					Address: "/-/selftest",
+					Text:    "Self-test",
+				},
+				{
+					Address: "/api/v1/metrics-catalog",
+					Text:    "Metrics catalog",
+				},
+				{
+					Address: "/api/v1/domains",
+					Text:    "Domains",
+				},
+				{
+					Address: "/debug/connection",
+					Text:    "Connection",
+				},
+				{
+					Address: "/-/healthy",
+					Text:    "Health",
+				},
+				{
+					Address: "/-/ready",
+					Text:    "Readiness",
+				},
 			},
 		}
 		landingPage, err := web.NewLandingPage(landingConfig)
@@ -202,8 +805,14 @@ func main() {
 		http.Handle("/", landingPage)
 	}
 
+	// Fail early on a broken web config instead of on the first request.
+	if err := web.Validate(*toolkitFlags.WebConfigFile); err != nil {
+		level.Error(logger).Log("msg", "Invalid web config file", "err", err)
+		os.Exit(1)
+	}
+
 	server := &http.Server{}
-	if err := web.ListenAndServe(server, toolkitFlags, logger); err != nil {
+	if err := listenAndServe(server, toolkitFlags, socketConfig, logger); err != nil {
 		level.Error(logger).Log("err", err)
 		os.Exit(1)
 	}
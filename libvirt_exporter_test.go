@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// benchmarkFamilies returns families with series series in total, labeled
+// like the per-device metrics of a large hypervisor.
+func benchmarkFamilies(series int) []*dto.MetricFamily {
+	const families = 100
+	mfs := make([]*dto.MetricFamily, 0, families)
+	for f := 0; f < families; f++ {
+		mf := &dto.MetricFamily{
+			Name: stringPtr(fmt.Sprintf("libvirt_domain_benchmark_%d_total", f)),
+			Help: stringPtr("Synthetic counter of the metrics response benchmark"),
+			Type: dto.MetricType_COUNTER.Enum(),
+		}
+		for i := 0; i < series/families; i++ {
+			mf.Metric = append(mf.Metric, &dto.Metric{
+				Label: []*dto.LabelPair{
+					{Name: stringPtr("domain_uuid"), Value: stringPtr(fmt.Sprintf("6a5b4c3d-2e1f-4a0b-9c8d-%012d", i/8))},
+					{Name: stringPtr("target_device"), Value: stringPtr(fmt.Sprintf("vd%c", 'a'+i%8))},
+				},
+				Counter: &dto.Counter{Value: float64Ptr(float64(i) * 4096)},
+			})
+		}
+		mfs = append(mfs, mf)
+	}
+	return mfs
+}
+
+func stringPtr(s string) *string { return &s }
+
+func float64Ptr(f float64) *float64 { return &f }
+
+// BenchmarkMetricsResponse measures encoding and sending 200k series, with and
+// without gzip, and reports the response size.
+func BenchmarkMetricsResponse(b *testing.B) {
+	mfs := benchmarkFamilies(200000)
+	gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return mfs, nil
+	})
+	for _, encoding := range []string{"identity", "gzip"} {
+		b.Run(encoding, func(b *testing.B) {
+			h := &handler{
+				exporterMetricsRegistry: prometheus.NewRegistry(),
+				logger:                  log.NewNopLogger(),
+			}
+			handler := h.handlerFor(gatherer)
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			req.Header.Set("Accept-Encoding", encoding)
+			var size int
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				if rec.Code != http.StatusOK {
+					b.Fatalf("unexpected status %d", rec.Code)
+				}
+				body, _ := io.ReadAll(rec.Body)
+				size = len(body)
+			}
+			b.ReportMetric(float64(size), "bytes/response")
+		})
+	}
+}
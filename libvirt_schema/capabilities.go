@@ -0,0 +1,38 @@
+package libvirt_schema
+
+import (
+	"encoding/xml"
+)
+
+// Capabilities is the host description returned by virConnectGetCapabilities,
+// reduced to the NUMA topology.
+type Capabilities struct {
+	Host CapabilitiesHost `xml:"host"`
+}
+
+type CapabilitiesHost struct {
+	Cells []CapabilitiesCell `xml:"topology>cells>cell"`
+}
+
+type CapabilitiesCell struct {
+	ID     uint32              `xml:"id,attr"`
+	Memory MemorySize          `xml:"memory"`
+	Pages  []CapabilitiesPages `xml:"pages"`
+}
+
+// CapabilitiesPages is the number of pages of one size in a NUMA cell. Sizes
+// are in KiB.
+type CapabilitiesPages struct {
+	Unit  string `xml:"unit,attr"`
+	Size  uint32 `xml:"size,attr"`
+	Count uint64 `xml:",chardata"`
+}
+
+func NewCapabilitiesFromXML(xmlDesc []byte) (Capabilities, error) {
+	capabilities := Capabilities{}
+	err := xml.Unmarshal(xmlDesc, &capabilities)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	return capabilities, nil
+}
@@ -2,6 +2,8 @@ package libvirt_schema
 
 import (
 	"encoding/xml"
+	"fmt"
+	"strings"
 
 	"github.com/digitalocean/go-libvirt"
 )
@@ -9,17 +11,136 @@ import (
 type LvDomain struct {
 	Domain libvirt.Domain
 	Schema Domain
+	// Running is false for active domains in another state, e.g. paused.
+	Running bool
 }
 
+// Domain is the part of a domain XML description the collectors use. It
+// covers the flavors of the qemu and bhyve drivers, elements specific to a
+// driver, such as <bhyve:commandline>, are ignored.
 type Domain struct {
-	Devices  Devices  `xml:"devices"`
-	Name     string   `xml:"name"`
-	UUID     string   `xml:"uuid"`
-	Metadata Metadata `xml:"metadata"`
+	// Type is the virtualization type, e.g. kvm, qemu or bhyve.
+	Type          string        `xml:"type,attr"`
+	Devices       Devices       `xml:"devices"`
+	Name          string        `xml:"name"`
+	Title         string        `xml:"title"`
+	OS            OS            `xml:"os"`
+	UUID          string        `xml:"uuid"`
+	Metadata      Metadata      `xml:"metadata"`
+	Vcpu          Vcpu          `xml:"vcpu"`
+	Memory        MemorySize    `xml:"memory"`
+	CurrentMemory MemorySize    `xml:"currentMemory"`
+	MemoryBacking MemoryBacking `xml:"memoryBacking"`
+	OnPoweroff    string        `xml:"on_poweroff"`
+	OnReboot      string        `xml:"on_reboot"`
+	OnCrash       string        `xml:"on_crash"`
+	// LaunchSecurity is set for confidential guests, e.g. AMD SEV.
+	LaunchSecurity *LaunchSecurity `xml:"launchSecurity"`
+}
+
+// UnknownElement is a child element the schema does not model. It is
+// captured by the structs whose children the collectors rely on, so Validate
+// can report elements added by newer libvirt versions.
+type UnknownElement struct {
+	XMLName xml.Name
+}
+
+// LaunchSecurity is the confidential computing technology of a domain. The
+// type is sev, sev-snp or s390-pv, Policy the guest policy of SEV guests as
+// hex number, e.g. 0x0007.
+type LaunchSecurity struct {
+	Type   string `xml:"type,attr"`
+	Policy string `xml:"policy"`
+}
+
+// Vcpu is the maximum number of vCPUs of a domain and, in Current, the number
+// of vCPUs online at boot if fewer.
+type Vcpu struct {
+	Current uint `xml:"current,attr"`
+	Value   uint `xml:",chardata"`
+}
+
+// Configured returns the number of vCPUs online at boot.
+func (v Vcpu) Configured() uint {
+	if v.Current > 0 {
+		return v.Current
+	}
+	return v.Value
+}
+
+type OS struct {
+	Type OSType `xml:"type"`
+}
+
+type OSType struct {
+	Arch    string `xml:"arch,attr"`
+	Machine string `xml:"machine,attr"`
+	Value   string `xml:",chardata"`
+}
+
+type MemoryBacking struct {
+	// Hugepages is nil if the domain is not backed by huge pages.
+	Hugepages *Hugepages       `xml:"hugepages"`
+	Unknown   []UnknownElement `xml:",any"`
+}
+
+// Hugepages lists the huge page sizes backing the memory of a domain. Without
+// pages the default huge page size of the host is used.
+type Hugepages struct {
+	Pages []HugepagesPage `xml:"page"`
+}
+
+type HugepagesPage struct {
+	Size    uint64 `xml:"size,attr"`
+	Unit    string `xml:"unit,attr"`
+	Nodeset string `xml:"nodeset,attr"`
+}
+
+// Bytes returns the page size in bytes.
+func (p HugepagesPage) Bytes() uint64 {
+	return MemorySize{Unit: p.Unit, Value: p.Size}.Bytes()
+}
+
+// MemorySize is an amount of memory with a libvirt unit attribute.
+type MemorySize struct {
+	Unit  string `xml:"unit,attr"`
+	Value uint64 `xml:",chardata"`
+}
+
+var memoryUnits = map[string]uint64{
+	"b": 1, "bytes": 1,
+	"KB": 1000, "k": 1 << 10, "KiB": 1 << 10,
+	"MB": 1000 * 1000, "M": 1 << 20, "MiB": 1 << 20,
+	"GB": 1000 * 1000 * 1000, "G": 1 << 30, "GiB": 1 << 30,
+	"TB": 1000 * 1000 * 1000 * 1000, "T": 1 << 40, "TiB": 1 << 40,
+}
+
+// Bytes returns the size in bytes. Without a unit libvirt assumes KiB.
+func (m MemorySize) Bytes() uint64 {
+	if m.Unit == "" {
+		return m.Value << 10
+	}
+	return m.Value * memoryUnits[m.Unit]
 }
 
 type Metadata struct {
-	NovaInstance NovaInstance `xml:"instance"`
+	NovaInstance NovaInstance       `xml:"instance"`
+	Collectors   ExporterCollectors `xml:"https://github.com/nee541/libvirt-exporter collectors"`
+}
+
+// ExporterCollectors allows a domain to override the enabled collectors, e.g.
+//
+//	<metadata>
+//	  <exporter:collectors xmlns:exporter="https://github.com/nee541/libvirt-exporter">
+//	    <disable>memory</disable>
+//	  </exporter:collectors>
+//	</metadata>
+//
+// If any collector is listed under enable, only those collectors are used for
+// the domain. Collectors listed under disable are never used for the domain.
+type ExporterCollectors struct {
+	Enable  []string `xml:"enable"`
+	Disable []string `xml:"disable"`
 }
 
 type NovaInstance struct {
@@ -52,35 +173,275 @@ type NovaFlavor struct {
 type Devices struct {
 	Disks      []Disk      `xml:"disk"`
 	Interfaces []Interface `xml:"interface"`
+	Hostdevs   []Hostdev   `xml:"hostdev"`
+	Graphics   []Graphics  `xml:"graphics"`
+}
+
+// Graphics is a graphical console of the domain, e.g. VNC or SPICE. Ports
+// allocated automatically are only known while the domain is running.
+type Graphics struct {
+	Type    string           `xml:"type,attr"`
+	Port    int              `xml:"port,attr"`
+	TLSPort int              `xml:"tlsPort,attr"`
+	Listen  string           `xml:"listen,attr"`
+	Listens []GraphicsListen `xml:"listen"`
+}
+
+// GraphicsListen is where a console listens: an address, the address of a
+// virtual network or a unix socket.
+type GraphicsListen struct {
+	Type    string `xml:"type,attr"`
+	Address string `xml:"address,attr"`
+	Network string `xml:"network,attr"`
+	Socket  string `xml:"socket,attr"`
+}
+
+// ListenAddress returns the address the console listens on, the path for
+// unix sockets.
+func (g Graphics) ListenAddress() string {
+	for _, listen := range g.Listens {
+		switch listen.Type {
+		case "address", "network":
+			if listen.Address != "" {
+				return listen.Address
+			}
+		case "socket":
+			return listen.Socket
+		}
+	}
+	return g.Listen
+}
+
+// Hostdev is a host device passed through to the domain. The source address
+// of PCI devices (mode subsystem, type pci) is a PCI address, the one of
+// mediated devices (type mdev) the UUID of the device.
+type Hostdev struct {
+	Mode   string `xml:"mode,attr"`
+	Type   string `xml:"type,attr"`
+	Source struct {
+		Address *HostdevAddress `xml:"address"`
+	} `xml:"source"`
+}
+
+type HostdevAddress struct {
+	PCIAddress
+	UUID string `xml:"uuid,attr"`
 }
 
 type Disk struct {
-	Device string     `xml:"device,attr"`
-	Source DiskSource `xml:"source"`
-	Target DiskTarget `xml:"target"`
+	// Type is the source type: file, block, dir, network or volume.
+	Type    string           `xml:"type,attr"`
+	Device  string           `xml:"device,attr"`
+	Driver  DiskDriver       `xml:"driver"`
+	Source  DiskSource       `xml:"source"`
+	Target  DiskTarget       `xml:"target"`
+	Iotune  DiskIotune       `xml:"iotune"`
+	Unknown []UnknownElement `xml:",any" json:"-"`
 }
 
+// DiskDriver holds the settings of the hypervisor driver of a disk, empty
+// attributes take the hypervisor default.
+type DiskDriver struct {
+	Name    string `xml:"name,attr"`
+	Type    string `xml:"type,attr"`
+	Cache   string `xml:"cache,attr"`
+	IO      string `xml:"io,attr"`
+	Discard string `xml:"discard,attr"`
+}
+
+// DiskSource holds the attributes of the disk source, which of them are set
+// depends on the disk type.
 type DiskSource struct {
-	File string `xml:"file,attr"`
+	File     string           `xml:"file,attr"`
+	Dev      string           `xml:"dev,attr"`
+	Dir      string           `xml:"dir,attr"`
+	Protocol string           `xml:"protocol,attr"`
+	Name     string           `xml:"name,attr"`
+	Pool     string           `xml:"pool,attr"`
+	Volume   string           `xml:"volume,attr"`
+	Hosts    []DiskSourceHost `xml:"host"`
+}
+
+type DiskSourceHost struct {
+	Name string `xml:"name,attr"`
+	Port string `xml:"port,attr"`
+}
+
+// SourceType returns the source type of the disk. Without a type attribute
+// libvirt assumes a file.
+func (d Disk) SourceType() string {
+	if d.Type == "" {
+		return "file"
+	}
+	return d.Type
+}
+
+// SourcePath describes the source of the disk: the path of file, block and
+// dir disks, pool/volume of volume disks and the name of network disks, e.g.
+// the pool/image of RBD disks. Network disks without name are described by
+// their first host.
+func (d Disk) SourcePath() string {
+	switch d.SourceType() {
+	case "file":
+		return d.Source.File
+	case "block":
+		return d.Source.Dev
+	case "dir":
+		return d.Source.Dir
+	case "volume":
+		return d.Source.Pool + "/" + d.Source.Volume
+	case "network":
+		if d.Source.Name != "" || len(d.Source.Hosts) == 0 {
+			return d.Source.Name
+		}
+		host := d.Source.Hosts[0]
+		if host.Port != "" {
+			return host.Name + ":" + host.Port
+		}
+		return host.Name
+	}
+	return ""
 }
 
 type DiskTarget struct {
 	Device string `xml:"dev,attr"`
 }
 
+// DiskIotune holds the I/O limits of a disk, 0 means no limit.
+type DiskIotune struct {
+	TotalBytesSec uint64           `xml:"total_bytes_sec"`
+	ReadBytesSec  uint64           `xml:"read_bytes_sec"`
+	WriteBytesSec uint64           `xml:"write_bytes_sec"`
+	TotalIopsSec  uint64           `xml:"total_iops_sec"`
+	ReadIopsSec   uint64           `xml:"read_iops_sec"`
+	WriteIopsSec  uint64           `xml:"write_iops_sec"`
+	Unknown       []UnknownElement `xml:",any"`
+}
+
 type Interface struct {
-	Source InterfaceSource `xml:"source"`
-	Target InterfaceTarget `xml:"target"`
+	// Type is the kind of network connection, e.g. bridge, network or direct.
+	Type      string             `xml:"type,attr"`
+	MAC       InterfaceMAC       `xml:"mac"`
+	Model     InterfaceModel     `xml:"model"`
+	Source    InterfaceSource    `xml:"source"`
+	Target    InterfaceTarget    `xml:"target"`
+	Vlan      InterfaceVlan      `xml:"vlan"`
+	Bandwidth InterfaceBandwidth `xml:"bandwidth"`
+	Unknown   []UnknownElement   `xml:",any"`
 }
 
+type InterfaceMAC struct {
+	Address string `xml:"address,attr"`
+}
+
+// InterfaceModel is the device model emulated for the guest, e.g. virtio or
+// e1000.
+type InterfaceModel struct {
+	Type string `xml:"type,attr"`
+}
+
+// InterfaceSource holds the attributes of the interface source, which of them
+// are set depends on the interface type.
 type InterfaceSource struct {
-	Bridge string `xml:"bridge,attr"`
+	Bridge  string      `xml:"bridge,attr"`
+	Network string      `xml:"network,attr"`
+	Dev     string      `xml:"dev,attr"`
+	Mode    string      `xml:"mode,attr"`
+	Path    string      `xml:"path,attr"`
+	Address *PCIAddress `xml:"address"`
+}
+
+// PCIAddress is the address of a PCI device of the host, e.g. of the virtual
+// function of hostdev interfaces.
+type PCIAddress struct {
+	Domain   string `xml:"domain,attr"`
+	Bus      string `xml:"bus,attr"`
+	Slot     string `xml:"slot,attr"`
+	Function string `xml:"function,attr"`
+}
+
+// String formats the address like lspci, e.g. 0000:03:10.1.
+func (a PCIAddress) String() string {
+	var domain, bus, slot, function uint64
+	fmt.Sscanf(a.Domain, "0x%x", &domain)
+	fmt.Sscanf(a.Bus, "0x%x", &bus)
+	fmt.Sscanf(a.Slot, "0x%x", &slot)
+	fmt.Sscanf(a.Function, "0x%x", &function)
+	return fmt.Sprintf("%04x:%02x:%02x.%x", domain, bus, slot, function)
+}
+
+// SourceName returns what the interface is attached to on the host: the
+// bridge of bridge and network interfaces (the network name if the domain is
+// not running), the host device of direct (macvtap) interfaces, the socket
+// path of vhostuser interfaces and the PCI address of hostdev interfaces.
+func (i Interface) SourceName() string {
+	switch i.Type {
+	case "network":
+		if i.Source.Bridge != "" {
+			return i.Source.Bridge
+		}
+		return i.Source.Network
+	case "direct":
+		return i.Source.Dev
+	case "vhostuser":
+		return i.Source.Path
+	case "hostdev":
+		if i.Source.Address != nil {
+			return i.Source.Address.String()
+		}
+		return ""
+	}
+	return i.Source.Bridge
 }
 
 type InterfaceTarget struct {
 	Device string `xml:"dev,attr"`
 }
 
+// InterfaceBandwidth holds the QoS settings of an interface. Rates are in
+// KiB/s and bursts in KiB, 0 means not set.
+type InterfaceBandwidth struct {
+	Inbound  InterfaceBandwidthLimit `xml:"inbound"`
+	Outbound InterfaceBandwidthLimit `xml:"outbound"`
+	Unknown  []UnknownElement        `xml:",any"`
+}
+
+type InterfaceBandwidthLimit struct {
+	Average uint64 `xml:"average,attr"`
+	Peak    uint64 `xml:"peak,attr"`
+	Burst   uint64 `xml:"burst,attr"`
+}
+
+type InterfaceVlan struct {
+	Trunk string             `xml:"trunk,attr"`
+	Tags  []InterfaceVlanTag `xml:"tag"`
+}
+
+type InterfaceVlanTag struct {
+	ID         string `xml:"id,attr"`
+	NativeMode string `xml:"nativeMode,attr"`
+}
+
+// CollectorEnabled reports whether the domain metadata allows the named
+// collector to gather metrics of this domain.
+func (d Domain) CollectorEnabled(collector string) bool {
+	overrides := d.Metadata.Collectors
+	for _, name := range overrides.Disable {
+		if strings.TrimSpace(name) == collector {
+			return false
+		}
+	}
+	if len(overrides.Enable) == 0 {
+		return true
+	}
+	for _, name := range overrides.Enable {
+		if strings.TrimSpace(name) == collector {
+			return true
+		}
+	}
+	return false
+}
+
 func NewDomainFromXML(xmlDesc []byte) (Domain, error) {
 	domain := Domain{}
 	err := xml.Unmarshal(xmlDesc, &domain)
@@ -0,0 +1,98 @@
+package libvirt_schema
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// NodeDevice is the description of a host device returned by
+// virNodeDeviceGetXMLDesc, reduced to PCI and mediated devices.
+type NodeDevice struct {
+	Name   string `xml:"name"`
+	Parent string `xml:"parent"`
+	Driver struct {
+		Name string `xml:"name"`
+	} `xml:"driver"`
+	Capability NodeDeviceCapability `xml:"capability"`
+}
+
+// NodeDeviceCapability holds the PCI address and identity of a device, and
+// the SR-IOV relations and mediated device types in the nested capabilities.
+// For mediated devices (type mdev) only MdevType and UUID are set.
+type NodeDeviceCapability struct {
+	Type string `xml:"type,attr"`
+	// Class is the PCI class code, e.g. 0x030000 for VGA controllers.
+	Class        string                       `xml:"class"`
+	Domain       uint32                       `xml:"domain"`
+	Bus          uint32                       `xml:"bus"`
+	Slot         uint32                       `xml:"slot"`
+	Function     uint32                       `xml:"function"`
+	Product      NodeDeviceID                 `xml:"product"`
+	Vendor       NodeDeviceID                 `xml:"vendor"`
+	Capabilities []NodeDeviceNestedCapability `xml:"capability"`
+	MdevType     struct {
+		ID string `xml:"id,attr"`
+	} `xml:"type"`
+	UUID string `xml:"uuid"`
+}
+
+type NodeDeviceID struct {
+	ID   string `xml:"id,attr"`
+	Name string `xml:",chardata"`
+}
+
+// NodeDeviceNestedCapability is a nested capability, e.g. virt_functions
+// listing the virtual functions of a physical function, phys_function of a
+// virtual function or mdev_types of a GPU supporting mediated devices.
+type NodeDeviceNestedCapability struct {
+	Type      string               `xml:"type,attr"`
+	MaxCount  uint32               `xml:"maxCount,attr"`
+	Addresses []PCIAddress         `xml:"address"`
+	MdevTypes []NodeDeviceMdevType `xml:"type"`
+}
+
+// NodeDeviceMdevType is a type of mediated device a parent device can
+// create, e.g. a vGPU profile.
+type NodeDeviceMdevType struct {
+	ID                 string `xml:"id,attr"`
+	Name               string `xml:"name"`
+	AvailableInstances uint32 `xml:"availableInstances"`
+}
+
+// MdevUUID returns the UUID of a mediated device. libvirt before 7.3 has no
+// uuid element, the UUID is part of the device name mdev_<uuid> instead.
+func (d NodeDevice) MdevUUID() string {
+	if d.Capability.UUID != "" {
+		return d.Capability.UUID
+	}
+	name := strings.TrimPrefix(d.Name, "mdev_")
+	if len(name) > 36 {
+		name = name[:36]
+	}
+	return strings.ReplaceAll(name, "_", "-")
+}
+
+// Address formats the PCI address of the device like lspci, e.g. 0000:03:10.1.
+func (c NodeDeviceCapability) Address() string {
+	return fmt.Sprintf("%04x:%02x:%02x.%x", c.Domain, c.Bus, c.Slot, c.Function)
+}
+
+// Nested returns the nested capability of the given type, if any.
+func (c NodeDeviceCapability) Nested(capType string) (NodeDeviceNestedCapability, bool) {
+	for _, nested := range c.Capabilities {
+		if nested.Type == capType {
+			return nested, true
+		}
+	}
+	return NodeDeviceNestedCapability{}, false
+}
+
+func NewNodeDeviceFromXML(xmlDesc []byte) (NodeDevice, error) {
+	device := NodeDevice{}
+	err := xml.Unmarshal(xmlDesc, &device)
+	if err != nil {
+		return NodeDevice{}, err
+	}
+	return device, nil
+}
@@ -0,0 +1,36 @@
+package libvirt_schema
+
+import (
+	"encoding/xml"
+)
+
+// Sysinfo is the host SMBIOS information returned by virConnectGetSysinfo.
+type Sysinfo struct {
+	Type   string         `xml:"type,attr"`
+	BIOS   []SysinfoEntry `xml:"bios>entry"`
+	System []SysinfoEntry `xml:"system>entry"`
+}
+
+type SysinfoEntry struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+// Lookup returns the value of the named entry, or an empty string.
+func Lookup(entries []SysinfoEntry, name string) string {
+	for _, entry := range entries {
+		if entry.Name == name {
+			return entry.Value
+		}
+	}
+	return ""
+}
+
+func NewSysinfoFromXML(xmlDesc []byte) (Sysinfo, error) {
+	sysinfo := Sysinfo{}
+	err := xml.Unmarshal(xmlDesc, &sysinfo)
+	if err != nil {
+		return Sysinfo{}, err
+	}
+	return sysinfo, nil
+}
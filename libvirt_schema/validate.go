@@ -0,0 +1,113 @@
+package libvirt_schema
+
+import "fmt"
+
+// ParseWarning is a part of a domain description the schema does not
+// understand, so the collectors relying on it miss data.
+type ParseWarning struct {
+	// Element is the path of the element or attribute, e.g.
+	// devices/disk/@type. It does not contain values, to keep the number of
+	// distinct elements small.
+	Element string
+	// Detail describes the offending value.
+	Detail string
+	// Collectors are the collectors missing data because of the element.
+	Collectors []string
+}
+
+// ignoredElements lists the children libvirt documents for the elements whose
+// unknown children are captured, but which no collector needs. Children
+// missing here were added by a newer libvirt and are reported.
+var ignoredElements = map[string]map[string]bool{
+	"memoryBacking": setOf("nosharepages", "locked", "source", "access", "allocation", "discard"),
+	"devices/disk": setOf("address", "alias", "auth", "backenddomain", "backingStore", "blockio", "boot",
+		"encryption", "geometry", "mirror", "product", "readonly", "serial", "shareable", "throttlefilters",
+		"transient", "vendor", "wwn"),
+	"devices/disk/iotune": setOf("total_bytes_sec_max", "read_bytes_sec_max", "write_bytes_sec_max",
+		"total_iops_sec_max", "read_iops_sec_max", "write_iops_sec_max", "total_bytes_sec_max_length",
+		"read_bytes_sec_max_length", "write_bytes_sec_max_length", "total_iops_sec_max_length",
+		"read_iops_sec_max_length", "write_iops_sec_max_length", "size_iops_sec", "group_name"),
+	"devices/interface": setOf("acpi", "address", "alias", "backend", "backenddomain", "boot", "coalesce",
+		"downscript", "driver", "filterref", "guest", "ip", "link", "mtu", "port", "portForward", "rom",
+		"route", "script", "teaming", "tune", "virtualport"),
+	"devices/interface/bandwidth": setOf(),
+}
+
+func setOf(names ...string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
+
+var knownDiskTypes = map[string]bool{
+	"file":    true,
+	"block":   true,
+	"dir":     true,
+	"network": true,
+	"volume":  true,
+}
+
+// Validate checks a parsed domain for elements the schema does not handle,
+// such as new disk source types, unknown memory units or child elements
+// added by a newer libvirt.
+func (d Domain) Validate() []ParseWarning {
+	var warnings []ParseWarning
+	warn := func(element, detail string, collectors ...string) {
+		warnings = append(warnings, ParseWarning{Element: element, Detail: detail, Collectors: collectors})
+	}
+	warnUnknown := func(parent string, unknown []UnknownElement, of string, collectors ...string) {
+		for _, child := range unknown {
+			if name := child.XMLName.Local; !ignoredElements[parent][name] {
+				warn(parent+"/"+name, "unknown element"+of, collectors...)
+			}
+		}
+	}
+
+	for _, m := range []struct {
+		element string
+		unit    string
+	}{
+		{"memory/@unit", d.Memory.Unit},
+		{"currentMemory/@unit", d.CurrentMemory.Unit},
+	} {
+		if _, ok := memoryUnits[m.unit]; m.unit != "" && !ok {
+			warn(m.element, fmt.Sprintf("unknown unit %q", m.unit), "allocation")
+		}
+	}
+	warnUnknown("memoryBacking", d.MemoryBacking.Unknown, "", "memtune")
+	if hugepages := d.MemoryBacking.Hugepages; hugepages != nil {
+		for _, page := range hugepages.Pages {
+			if _, ok := memoryUnits[page.Unit]; page.Unit != "" && !ok {
+				warn("memoryBacking/hugepages/page/@unit", fmt.Sprintf("unknown unit %q", page.Unit), "memtune")
+			}
+		}
+	}
+
+	for _, disk := range d.Devices.Disks {
+		if disk.Device == "cdrom" || disk.Device == "floppy" {
+			continue
+		}
+		if !knownDiskTypes[disk.SourceType()] {
+			warn("devices/disk/@type", fmt.Sprintf("unknown disk type %q of %s", disk.Type, disk.Target.Device), "block")
+		} else if disk.SourcePath() == "" {
+			warn("devices/disk/source", fmt.Sprintf("no source for %s disk %s", disk.SourceType(), disk.Target.Device), "block")
+		}
+		if disk.Target.Device == "" {
+			warn("devices/disk/target/@dev", "disk without target device", "block")
+		}
+		of := fmt.Sprintf(" of disk %s", disk.Target.Device)
+		warnUnknown("devices/disk", disk.Unknown, of, "block")
+		warnUnknown("devices/disk/iotune", disk.Iotune.Unknown, of, "block")
+	}
+	for _, iface := range d.Devices.Interfaces {
+		if iface.Target.Device == "" && iface.Type != "hostdev" {
+			warn("devices/interface/target/@dev", "interface without target device", "interface")
+		}
+		of := fmt.Sprintf(" of interface %s", iface.Target.Device)
+		warnUnknown("devices/interface", iface.Unknown, of, "interface")
+		warnUnknown("devices/interface/bandwidth", iface.Bandwidth.Unknown, of, "interface")
+	}
+	return warnings
+}
@@ -0,0 +1,42 @@
+package libvirt_schema
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateUnknownElements(t *testing.T) {
+	domain, err := NewDomainFromXML([]byte(`<domain type="kvm">
+  <memoryBacking><hugepages/><locked/><future/></memoryBacking>
+  <devices>
+    <disk type="file" device="disk">
+      <source file="/var/lib/libvirt/images/vm.qcow2"/>
+      <target dev="vda"/>
+      <address type="pci"/>
+      <iotune><total_bytes_sec>1</total_bytes_sec><total_bytes_sec_max>2</total_bytes_sec_max><total_pages_sec>3</total_pages_sec></iotune>
+      <quota/>
+    </disk>
+    <interface type="bridge">
+      <target dev="vnet0"/>
+      <mtu size="9000"/>
+      <bandwidth><inbound average="1"/><burst/></bandwidth>
+    </interface>
+  </devices>
+</domain>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, warning := range domain.Validate() {
+		got = append(got, warning.Element+": "+warning.Detail)
+	}
+	want := []string{
+		"memoryBacking/future: unknown element",
+		"devices/disk/quota: unknown element of disk vda",
+		"devices/disk/iotune/total_pages_sec: unknown element of disk vda",
+		"devices/interface/bandwidth/burst: unknown element of interface vnet0",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Validate() = %q, want %q", got, want)
+	}
+}
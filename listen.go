@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+const unixAddressPrefix = "unix:"
+
+// unixSocketConfig describes how unix sockets given as listen addresses are
+// created.
+type unixSocketConfig struct {
+	mode  string
+	owner string
+}
+
+// listenAndServe behaves like web.ListenAndServe, but additionally accepts
+// listen addresses of the form unix:/path/to/socket or unix:@abstract, so the
+// exporter can be scraped by a local agent without opening a TCP port.
+func listenAndServe(server *http.Server, flags *web.FlagConfig, socketConfig unixSocketConfig, logger log.Logger) error {
+	if flags.WebSystemdSocket != nil && *flags.WebSystemdSocket {
+		return web.ListenAndServe(server, flags, logger)
+	}
+	if flags.WebListenAddresses == nil || len(*flags.WebListenAddresses) == 0 {
+		return web.ErrNoListeners
+	}
+
+	listeners := make([]net.Listener, 0, len(*flags.WebListenAddresses))
+	for _, address := range *flags.WebListenAddresses {
+		var (
+			listener net.Listener
+			err      error
+		)
+		if path, ok := strings.CutPrefix(address, unixAddressPrefix); ok {
+			listener, err = listenUnix(path, socketConfig, logger)
+		} else {
+			listener, err = net.Listen("tcp", address)
+		}
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+		listeners = append(listeners, listener)
+	}
+	return web.ServeMultiple(listeners, server, flags, logger)
+}
+
+// listenUnix creates a unix socket listener. Paths starting with @ denote
+// sockets in the Linux abstract namespace, which have no file and therefore
+// no permissions.
+func listenUnix(path string, config unixSocketConfig, logger log.Logger) (net.Listener, error) {
+	path = strings.TrimPrefix(path, "//")
+	if path == "" {
+		return nil, errors.New("empty unix socket path")
+	}
+	abstract := strings.HasPrefix(path, "@")
+	if !abstract {
+		// Remove a stale socket left behind by a previous run.
+		if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			level.Debug(logger).Log("msg", "Removing stale unix socket", "path", path)
+			os.Remove(path)
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if abstract {
+		return listener, nil
+	}
+
+	if config.mode != "" {
+		mode, err := strconv.ParseUint(config.mode, 8, 32)
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("invalid unix socket mode %q: %w", config.mode, err)
+		}
+		if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	if config.owner != "" {
+		uid, gid, err := lookupOwner(config.owner)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		if err := os.Chown(path, uid, gid); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+// lookupOwner resolves an owner in the form user[:group], where both parts
+// may be names or numeric ids. -1 keeps the respective id unchanged.
+func lookupOwner(owner string) (int, int, error) {
+	userName, groupName, _ := strings.Cut(owner, ":")
+	uid, gid := -1, -1
+	if userName != "" {
+		id := userName
+		if _, err := strconv.Atoi(userName); err != nil {
+			u, err := user.Lookup(userName)
+			if err != nil {
+				return 0, 0, err
+			}
+			id = u.Uid
+		}
+		uid, _ = strconv.Atoi(id)
+	}
+	if groupName != "" {
+		id := groupName
+		if _, err := strconv.Atoi(groupName); err != nil {
+			g, err := user.LookupGroup(groupName)
+			if err != nil {
+				return 0, 0, err
+			}
+			id = g.Gid
+		}
+		gid, _ = strconv.Atoi(id)
+	}
+	return uid, gid, nil
+}
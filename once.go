@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/nee541/libvirt-exporter/connection"
+	"github.com/prometheus/common/expfmt"
+)
+
+// collectOnce runs a single collection and writes the metrics in the text
+// exposition format to path, or stdout for -. Files are replaced atomically,
+// so the textfile collector of node_exporter never reads a partial file.
+func collectOnce(conn *connection.Connection, exclude *regexp.Regexp, path string, timeout time.Duration, logger log.Logger) error {
+	if err := conn.Connect(); err != nil {
+		return fmt.Errorf("couldn't connect to libvirt: %w", err)
+	}
+	defer conn.Libvirt.Disconnect()
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	h := &handler{conn: conn, exclude: exclude, logger: logger}
+	r, err := h.newRegistry(ctx)
+	if err != nil {
+		return err
+	}
+	mfs, err := r.Gather()
+	if err != nil {
+		// Like a scrape, partial results are written, failed collectors
+		// report libvirt_scrape_collector_success 0.
+		level.Warn(logger).Log("msg", "Collection finished with errors", "err", err)
+	}
+
+	write := func(w io.Writer) error {
+		for _, mf := range mfs {
+			if _, err := expfmt.MetricFamilyToText(w, mf); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if path == "-" {
+		return write(os.Stdout)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
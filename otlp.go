@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	promconfig "github.com/prometheus/common/config"
+	"github.com/prometheus/common/version"
+	promotel "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// otlpConfig configures pushing the metrics as OpenTelemetry metrics over
+// OTLP/HTTP, for pipelines without a Prometheus server.
+type otlpConfig struct {
+	endpoint           string
+	interval           time.Duration
+	timeout            time.Duration
+	headers            map[string]string
+	compression        string
+	caFile             string
+	certFile           string
+	keyFile            string
+	insecureSkipVerify bool
+}
+
+// startOTLPExporter converts every interval a collection into OTLP metrics
+// and pushes them to cfg.endpoint, until the process exits.
+func startOTLPExporter(cfg otlpConfig, newGatherer func(ctx context.Context) (prometheus.Gatherer, error), logger log.Logger) error {
+	u, err := url.Parse(cfg.endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid otlp endpoint: %w", err)
+	}
+	if u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid otlp endpoint %q, expected http(s)://host:port/path", cfg.endpoint)
+	}
+	opts := []otlpmetrichttp.Option{
+		otlpmetrichttp.WithEndpoint(u.Host),
+		otlpmetrichttp.WithTimeout(cfg.timeout),
+		otlpmetrichttp.WithHeaders(cfg.headers),
+	}
+	if u.Path != "" {
+		opts = append(opts, otlpmetrichttp.WithURLPath(u.Path))
+	}
+	if cfg.compression == "gzip" {
+		opts = append(opts, otlpmetrichttp.WithCompression(otlpmetrichttp.GzipCompression))
+	}
+	if u.Scheme == "http" {
+		opts = append(opts, otlpmetrichttp.WithInsecure())
+	} else {
+		tlsConfig, err := promconfig.NewTLSConfig(&promconfig.TLSConfig{
+			CAFile:             cfg.caFile,
+			CertFile:           cfg.certFile,
+			KeyFile:            cfg.keyFile,
+			InsecureSkipVerify: cfg.insecureSkipVerify,
+		})
+		if err != nil {
+			return err
+		}
+		opts = append(opts, otlpmetrichttp.WithTLSClientConfig(tlsConfig))
+	}
+	exporter, err := otlpmetrichttp.New(context.Background(), opts...)
+	if err != nil {
+		return err
+	}
+
+	// OTEL_RESOURCE_ATTRIBUTES is honored like by other OpenTelemetry
+	// exporters, e.g. to set the deployment environment.
+	res, err := resource.New(context.Background(),
+		resource.WithFromEnv(),
+		resource.WithHost(),
+		resource.WithAttributes(
+			attribute.String("service.name", "libvirt_exporter"),
+			attribute.String("service.version", version.Version),
+		),
+	)
+	if err != nil {
+		return err
+	}
+
+	// Failed exports are reported to the global error handler of OpenTelemetry.
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		level.Error(logger).Log("msg", "OTLP export failed", "err", err)
+	}))
+	producer := promotel.NewMetricProducer(promotel.WithGatherer(&otlpGatherer{
+		newGatherer: newGatherer,
+		timeout:     cfg.interval,
+		logger:      logger,
+	}))
+	reader := sdkmetric.NewPeriodicReader(exporter,
+		sdkmetric.WithInterval(cfg.interval),
+		sdkmetric.WithTimeout(cfg.timeout),
+		sdkmetric.WithProducer(producer),
+	)
+	// The meter provider only starts the reader, the exporter has no
+	// instruments of its own.
+	sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader), sdkmetric.WithResource(res))
+	return nil
+}
+
+// otlpGatherer runs a collection bounded by timeout on every Gather, for the
+// OpenTelemetry bridge which has no notion of a scrape.
+type otlpGatherer struct {
+	newGatherer func(ctx context.Context) (prometheus.Gatherer, error)
+	timeout     time.Duration
+	logger      log.Logger
+}
+
+// Gather implements prometheus.Gatherer.
+func (g *otlpGatherer) Gather() ([]*dto.MetricFamily, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	defer cancel()
+	gatherer, err := g.newGatherer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mfs, err := gatherer.Gather()
+	if err != nil {
+		// The bridge drops the whole result on an error, push the
+		// partial result like a scrape instead.
+		level.Warn(g.logger).Log("msg", "Collection finished with errors", "err", err)
+	}
+	// The bridge converts counters, gauges and histograms only. Other
+	// families, e.g. the go_gc_duration_seconds summary, are dropped instead
+	// of failing every export.
+	supported := make([]*dto.MetricFamily, 0, len(mfs))
+	for _, mf := range mfs {
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER, dto.MetricType_GAUGE, dto.MetricType_HISTOGRAM:
+			supported = append(supported, mf)
+		}
+	}
+	return supported, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/nee541/libvirt-exporter/collector"
+)
+
+// reloader applies the config file at startup and again on SIGHUP or
+// /-/reload. Settings given on the command line keep precedence over the
+// file, settings removed from the file fall back to their defaults.
+type reloader struct {
+	mtx  sync.Mutex
+	path string
+	// commandLine holds the flags given on the command line.
+	commandLine map[string]bool
+	// defaults holds the values of the flags before the file was applied.
+	defaults map[string]string
+	// states holds the collector states before the file was applied.
+	states map[string]bool
+	// applied holds the flag values set by the file.
+	applied map[string]string
+	current *config
+	// onReload is called after the file was reloaded.
+	onReload func()
+	metrics  *configCollector
+	logger   log.Logger
+}
+
+// newReloader records the flags given on the command line and the state
+// before the file at path is applied.
+func newReloader(path string, logger log.Logger) (*reloader, error) {
+	ctx, err := kingpin.CommandLine.ParseContext(os.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+	commandLine := make(map[string]bool)
+	for _, element := range ctx.Elements {
+		if flag, ok := element.Clause.(*kingpin.FlagClause); ok {
+			commandLine[flag.Model().Name] = true
+		}
+	}
+	defaults := make(map[string]string)
+	for _, flag := range kingpin.CommandLine.Model().Flags {
+		defaults[flag.Name] = flag.Value.String()
+	}
+	return &reloader{
+		path:        path,
+		commandLine: commandLine,
+		defaults:    defaults,
+		states:      collector.CollectorStates(),
+		logger:      logger,
+	}, nil
+}
+
+// load reads and applies the file for the first time.
+func (r *reloader) load() (*config, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	cfg, err := loadConfig(r.path)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.apply(cfg); err != nil {
+		return nil, err
+	}
+	r.current = cfg
+	return cfg, nil
+}
+
+// reload reads the file again and applies the collectors, domains, labels
+// and collector_options sections. A file with changed connection settings
+// is rejected, changes of the enrichment only take effect after a restart.
+func (r *reloader) reload() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.path == "" {
+		return errors.New("no config file given with --config.file")
+	}
+	err := r.reloadFile()
+	if r.metrics != nil {
+		r.metrics.reloaded(r.path, err == nil)
+	}
+	if err != nil {
+		level.Error(r.logger).Log("msg", "Couldn't reload config file, keeping the previous configuration", "err", err)
+		return err
+	}
+	level.Info(r.logger).Log("msg", "Reloaded config file", "file", r.path)
+	return nil
+}
+
+func (r *reloader) reloadFile() error {
+	cfg, err := loadConfig(r.path)
+	if err != nil {
+		return err
+	}
+	// The connection is only set up at startup, a changed connection
+	// setting would silently stay ineffective.
+	if !reflect.DeepEqual(cfg.Libvirt, r.current.Libvirt) {
+		return errors.New("the libvirt section cannot be changed by a reload, restart the exporter to apply it")
+	}
+	if !reflect.DeepEqual(cfg.Enrichment, r.current.Enrichment) {
+		level.Warn(r.logger).Log("msg", "Changes of the enrichment in the config file need a restart")
+	}
+	if err := r.apply(cfg); err != nil {
+		return err
+	}
+	r.current = cfg
+	if r.onReload != nil {
+		r.onReload()
+	}
+	return nil
+}
+
+// apply sets the collector states and flag values of cfg. Nothing is
+// changed if cfg is invalid.
+func (r *reloader) apply(cfg *config) error {
+	values, err := cfg.flagValues()
+	if err != nil {
+		return err
+	}
+	return collector.Reconfigure(func() error {
+		collector.RestoreCollectorStates(r.states)
+		if err := cfg.Collectors.apply(); err != nil {
+			return err
+		}
+		return r.setFlags(values)
+	})
+}
+
+// setFlags sets the flags in values which were not given on the command
+// line, and resets the flags set by the file before but missing in values to
+// their defaults. All flags are restored if a value is invalid.
+func (r *reloader) setFlags(values map[string]string) error {
+	names := make(map[string]bool)
+	for name := range values {
+		names[name] = true
+	}
+	for name := range r.applied {
+		names[name] = true
+	}
+	previous := make(map[string]string)
+	restore := func() {
+		for name, value := range previous {
+			kingpin.CommandLine.GetFlag(name).Model().Value.Set(value)
+		}
+	}
+	for name := range names {
+		if r.commandLine[name] {
+			continue
+		}
+		value, ok := values[name]
+		if !ok {
+			value = r.defaults[name]
+		}
+		flag := kingpin.CommandLine.GetFlag(name).Model()
+		previous[name] = flag.Value.String()
+		if err := flag.Value.Set(value); err != nil {
+			restore()
+			return fmt.Errorf("invalid value %q of --%s: %w", value, name, err)
+		}
+	}
+	r.applied = values
+	return nil
+}
+
+// ServeHTTP reloads the config file on POST requests, like /-/reload of
+// Prometheus.
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost && req.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "Only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.reload(); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to reload config: %s", err), http.StatusInternalServerError)
+	}
+}
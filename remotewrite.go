@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	promconfig "github.com/prometheus/common/config"
+	"github.com/prometheus/common/version"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// remoteWriteConfig configures pushing the metrics with the Prometheus
+// remote_write protocol, for hypervisors which cannot be scraped, e.g. behind
+// NAT.
+type remoteWriteConfig struct {
+	url                string
+	interval           time.Duration
+	timeout            time.Duration
+	maxSamplesPerSend  int
+	labels             map[string]string
+	bearerTokenFile    string
+	caFile             string
+	certFile           string
+	keyFile            string
+	insecureSkipVerify bool
+}
+
+// remoteWriter collects the metrics every interval and pushes them to a
+// remote_write endpoint.
+type remoteWriter struct {
+	config remoteWriteConfig
+	client *http.Client
+	// gatherer returns a gatherer for a collection bounded by ctx.
+	gatherer func(ctx context.Context) (prometheus.Gatherer, error)
+	logger   log.Logger
+
+	samples       prometheus.Counter
+	samplesFailed prometheus.Counter
+	lastSuccess   prometheus.Gauge
+}
+
+func newRemoteWriter(cfg remoteWriteConfig, gatherer func(ctx context.Context) (prometheus.Gatherer, error), logger log.Logger) (*remoteWriter, error) {
+	if _, err := url.Parse(cfg.url); err != nil {
+		return nil, fmt.Errorf("invalid remote write url: %w", err)
+	}
+	if cfg.maxSamplesPerSend <= 0 {
+		return nil, fmt.Errorf("invalid maximum samples per send %d", cfg.maxSamplesPerSend)
+	}
+	httpConfig := promconfig.HTTPClientConfig{
+		BearerTokenFile: cfg.bearerTokenFile,
+		TLSConfig: promconfig.TLSConfig{
+			CAFile:             cfg.caFile,
+			CertFile:           cfg.certFile,
+			KeyFile:            cfg.keyFile,
+			InsecureSkipVerify: cfg.insecureSkipVerify,
+		},
+		FollowRedirects: true,
+		EnableHTTP2:     true,
+	}
+	if err := httpConfig.Validate(); err != nil {
+		return nil, err
+	}
+	// The bearer token file and certificates are read again when they
+	// change, so rotating them needs no restart.
+	client, err := promconfig.NewClientFromConfig(httpConfig, "remote_write")
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = cfg.timeout
+	return &remoteWriter{
+		config:   cfg,
+		client:   client,
+		gatherer: gatherer,
+		logger:   logger,
+		samples: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "libvirt_exporter",
+			Subsystem: "remote_write",
+			Name:      "samples_total",
+			Help:      "Number of samples accepted by the remote write endpoint.",
+		}),
+		samplesFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "libvirt_exporter",
+			Subsystem: "remote_write",
+			Name:      "samples_failed_total",
+			Help:      "Number of samples dropped because the remote write endpoint rejected them or was unreachable.",
+		}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "libvirt_exporter",
+			Subsystem: "remote_write",
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Time of the last collection pushed completely to the remote write endpoint since unix epoch in seconds.",
+		}),
+	}, nil
+}
+
+// Describe implements prometheus.Collector.
+func (w *remoteWriter) Describe(ch chan<- *prometheus.Desc) {
+	w.samples.Describe(ch)
+	w.samplesFailed.Describe(ch)
+	w.lastSuccess.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (w *remoteWriter) Collect(ch chan<- prometheus.Metric) {
+	w.samples.Collect(ch)
+	w.samplesFailed.Collect(ch)
+	w.lastSuccess.Collect(ch)
+}
+
+// run pushes a collection every interval until ctx is done. Every push is
+// bounded by the interval, so a slow endpoint never delays the next one.
+func (w *remoteWriter) run(ctx context.Context) {
+	ticker := time.NewTicker(w.config.interval)
+	defer ticker.Stop()
+	for {
+		runCtx, cancel := context.WithTimeout(ctx, w.config.interval)
+		if err := w.push(runCtx); err != nil {
+			level.Error(w.logger).Log("msg", "Remote write failed", "err", err)
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// push collects the metrics once and sends them in batches of at most
+// maxSamplesPerSend samples.
+func (w *remoteWriter) push(ctx context.Context) error {
+	begin := time.Now()
+	gatherer, err := w.gatherer(ctx)
+	if err != nil {
+		return err
+	}
+	mfs, err := gatherer.Gather()
+	if err != nil {
+		// Like a scrape, partial results are pushed.
+		level.Warn(w.logger).Log("msg", "Collection finished with errors", "err", err)
+	}
+	series, metadata := toTimeSeries(mfs, w.config.labels, time.Now())
+
+	var failed int
+	for start := 0; start < len(series); start += w.config.maxSamplesPerSend {
+		end := min(start+w.config.maxSamplesPerSend, len(series))
+		var batchMetadata []metricMetadata
+		if start == 0 {
+			batchMetadata = metadata
+		}
+		if err := w.send(ctx, encodeWriteRequest(series[start:end], batchMetadata)); err != nil {
+			w.samplesFailed.Add(float64(end - start))
+			failed += end - start
+			level.Debug(w.logger).Log("msg", "Couldn't send batch", "samples", end-start, "err", err)
+			continue
+		}
+		w.samples.Add(float64(end - start))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d samples dropped", failed, len(series))
+	}
+	w.lastSuccess.SetToCurrentTime()
+	level.Debug(w.logger).Log("msg", "Remote write finished", "samples", len(series), "duration_seconds", time.Since(begin).Seconds())
+	return nil
+}
+
+// send posts a write request, retrying with backoff on network errors, 5xx
+// and 429 responses until ctx is done. Other responses are not retried, the
+// endpoint would reject the request again.
+func (w *remoteWriter) send(ctx context.Context, req []byte) error {
+	body := snappy.Encode(nil, req)
+	backoff := 500 * time.Millisecond
+	for {
+		retry, err := w.post(ctx, body)
+		if err == nil || !retry {
+			return err
+		}
+		level.Debug(w.logger).Log("msg", "Retrying remote write", "backoff", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 10*time.Second)
+	}
+}
+
+// post sends one request and reports whether a failure is worth retrying.
+func (w *remoteWriter) post(ctx context.Context, body []byte) (bool, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("User-Agent", "libvirt_exporter/"+version.Version)
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := w.client.Do(httpReq)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	return resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+type label struct {
+	name, value string
+}
+
+// timeSeries is a series of the remote write protocol with a single sample.
+type timeSeries struct {
+	labels    []label
+	value     float64
+	timestamp int64
+}
+
+// metricMetadata is the metadata of a metric family of the remote write
+// protocol.
+type metricMetadata struct {
+	metricType int
+	family     string
+	help       string
+}
+
+// Metric types of the remote write protocol.
+const (
+	metricTypeUnknown = 0
+	metricTypeCounter = 1
+	metricTypeGauge   = 2
+	metricTypeHisto   = 3
+	metricTypeSummary = 5
+)
+
+// toTimeSeries flattens metric families into series like a scrape by
+// Prometheus does, histograms and summaries into _bucket, _sum and _count
+// series. extraLabels are added to every series unless it has the label
+// already, samples without a timestamp get now.
+func toTimeSeries(mfs []*dto.MetricFamily, extraLabels map[string]string, now time.Time) ([]timeSeries, []metricMetadata) {
+	var (
+		series   []timeSeries
+		metadata []metricMetadata
+	)
+	for _, mf := range mfs {
+		name := mf.GetName()
+		metricType := metricTypeUnknown
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			metricType = metricTypeCounter
+		case dto.MetricType_GAUGE:
+			metricType = metricTypeGauge
+		case dto.MetricType_HISTOGRAM:
+			metricType = metricTypeHisto
+		case dto.MetricType_SUMMARY:
+			metricType = metricTypeSummary
+		}
+		metadata = append(metadata, metricMetadata{metricType, name, mf.GetHelp()})
+
+		for _, m := range mf.GetMetric() {
+			ts := now.UnixMilli()
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			add := func(suffix string, value float64, extra ...label) {
+				labels := []label{{"__name__", name + suffix}}
+				seen := map[string]bool{}
+				for _, lp := range m.GetLabel() {
+					labels = append(labels, label{lp.GetName(), lp.GetValue()})
+					seen[lp.GetName()] = true
+				}
+				for _, l := range extra {
+					labels = append(labels, l)
+					seen[l.name] = true
+				}
+				for name, value := range extraLabels {
+					if !seen[name] {
+						labels = append(labels, label{name, value})
+					}
+				}
+				sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+				series = append(series, timeSeries{labels, value, ts})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add("", q.GetValue(), label{"quantile", formatFloat(q.GetQuantile())})
+				}
+				add("_sum", s.GetSampleSum())
+				add("_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				infSeen := false
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						infSeen = true
+					}
+					add("_bucket", float64(b.GetCumulativeCount()), label{"le", formatFloat(b.GetUpperBound())})
+				}
+				if !infSeen {
+					add("_bucket", float64(h.GetSampleCount()), label{"le", "+Inf"})
+				}
+				add("_sum", h.GetSampleSum())
+				add("_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+	return series, metadata
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeWriteRequest encodes a WriteRequest message of the remote write
+// protocol, see prompb/remote.proto and prompb/types.proto of Prometheus:
+//
+//	WriteRequest   { repeated TimeSeries timeseries = 1; repeated MetricMetadata metadata = 3; }
+//	TimeSeries     { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label          { string name = 1; string value = 2; }
+//	Sample         { double value = 1; int64 timestamp = 2; }
+//	MetricMetadata { MetricType type = 1; string metric_family_name = 2; string help = 4; }
+func encodeWriteRequest(series []timeSeries, metadata []metricMetadata) []byte {
+	var buf, ts, msg []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			msg = msg[:0]
+			msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.name)
+			msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, msg)
+		}
+		msg = msg[:0]
+		msg = protowire.AppendTag(msg, 1, protowire.Fixed64Type)
+		msg = protowire.AppendFixed64(msg, math.Float64bits(s.value))
+		msg = protowire.AppendTag(msg, 2, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(s.timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, msg)
+
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, ts)
+	}
+	for _, m := range metadata {
+		msg = msg[:0]
+		msg = protowire.AppendTag(msg, 1, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(m.metricType))
+		msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+		msg = protowire.AppendString(msg, m.family)
+		msg = protowire.AppendTag(msg, 4, protowire.BytesType)
+		msg = protowire.AppendString(msg, m.help)
+		buf = protowire.AppendTag(buf, 3, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, msg)
+	}
+	return buf
+}
+
+// pushGatherer returns the gatherer of a push, like the unfiltered handler it
+// uses the background snapshot if enabled.
+func (h *handler) pushGatherer(ctx context.Context) (prometheus.Gatherer, error) {
+	if h.snapshot != nil {
+		return prometheus.Gatherers{h.exporterMetricsRegistry, h.snapshot}, nil
+	}
+	lc, err := h.newCollector()
+	if err != nil {
+		return nil, err
+	}
+	return prometheus.Gatherers{h.exporterMetricsRegistry, h.flights.gatherer(ctx, func(ctx context.Context) (prometheus.Gatherer, error) {
+		return h.registryFor(lc.WithContext(ctx))
+	})}, nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// writeRequestDescriptor describes the WriteRequest message of prompb, the
+// Prometheus module is too heavy a dependency for decoding it in tests. The
+// messages are declared as in prompb/remote.proto and prompb/types.proto,
+// including the fields the exporter never sends.
+func writeRequestDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Type:   typ.Enum(),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	repeated := func(f *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+		f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		return f
+	}
+	message := func(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+		return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: fields}
+	}
+	var metricTypes []*descriptorpb.EnumValueDescriptorProto
+	for i, name := range []string{"UNKNOWN", "COUNTER", "GAUGE", "HISTOGRAM", "GAUGEHISTOGRAM", "SUMMARY", "INFO", "STATESET"} {
+		metricTypes = append(metricTypes, &descriptorpb.EnumValueDescriptorProto{Name: proto.String(name), Number: proto.Int32(int32(i))})
+	}
+	const (
+		messageType = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+		stringType  = descriptorpb.FieldDescriptorProto_TYPE_STRING
+	)
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("remote.proto"),
+		Package: proto.String("prometheus"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			message("WriteRequest",
+				repeated(field("timeseries", 1, messageType, ".prometheus.TimeSeries")),
+				repeated(field("metadata", 3, messageType, ".prometheus.MetricMetadata")),
+			),
+			message("TimeSeries",
+				repeated(field("labels", 1, messageType, ".prometheus.Label")),
+				repeated(field("samples", 2, messageType, ".prometheus.Sample")),
+			),
+			message("Label", field("name", 1, stringType, ""), field("value", 2, stringType, "")),
+			message("Sample",
+				field("value", 1, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, ""),
+				field("timestamp", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+			),
+			{
+				Name: proto.String("MetricMetadata"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("type", 1, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".prometheus.MetricMetadata.MetricType"),
+					field("metric_family_name", 2, stringType, ""),
+					field("help", 4, stringType, ""),
+					field("unit", 5, stringType, ""),
+				},
+				EnumType: []*descriptorpb.EnumDescriptorProto{{Name: proto.String("MetricType"), Value: metricTypes}},
+			},
+		},
+	}
+	fd, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fd.Messages().ByName("WriteRequest")
+}
+
+// decodeWriteRequest decodes an encoded WriteRequest into one line per series
+// in the text exposition format with the timestamp after an @, and one line
+// per metadata entry. It fails on fields the descriptor does not know.
+func decodeWriteRequest(t *testing.T, desc protoreflect.MessageDescriptor, data []byte) (series, metadata []string) {
+	t.Helper()
+	req := dynamicpb.NewMessage(desc)
+	if err := proto.Unmarshal(data, req); err != nil {
+		t.Fatalf("couldn't decode write request: %v", err)
+	}
+	get := func(m protoreflect.Message, name string) protoreflect.Value {
+		if len(m.GetUnknown()) > 0 {
+			t.Errorf("%s has unknown fields %x", m.Descriptor().Name(), m.GetUnknown())
+		}
+		return m.Get(m.Descriptor().Fields().ByName(protoreflect.Name(name)))
+	}
+	timeseries := get(req, "timeseries").List()
+	for i := 0; i < timeseries.Len(); i++ {
+		ts := timeseries.Get(i).Message()
+		var name string
+		var labels []string
+		list := get(ts, "labels").List()
+		for j := 0; j < list.Len(); j++ {
+			l := list.Get(j).Message()
+			if get(l, "name").String() == "__name__" {
+				name = get(l, "value").String()
+				continue
+			}
+			labels = append(labels, fmt.Sprintf("%s=%q", get(l, "name").String(), get(l, "value").String()))
+		}
+		samples := get(ts, "samples").List()
+		if samples.Len() != 1 {
+			t.Errorf("series %s has %d samples, want 1", name, samples.Len())
+			continue
+		}
+		s := samples.Get(0).Message()
+		series = append(series, fmt.Sprintf("%s{%s} %v @%d", name, strings.Join(labels, ","), get(s, "value").Float(), get(s, "timestamp").Int()))
+	}
+	metadataList := get(req, "metadata").List()
+	for i := 0; i < metadataList.Len(); i++ {
+		m := metadataList.Get(i).Message()
+		typ := m.Descriptor().Fields().ByName("type").Enum().Values().ByNumber(get(m, "type").Enum()).Name()
+		metadata = append(metadata, fmt.Sprintf("%s %s %s", typ, get(m, "metric_family_name").String(), get(m, "help").String()))
+	}
+	return series, metadata
+}
+
+func TestEncodeWriteRequest(t *testing.T) {
+	metricFamily := func(name string, typ dto.MetricType, metrics ...*dto.Metric) *dto.MetricFamily {
+		return &dto.MetricFamily{Name: proto.String(name), Help: proto.String(name + " help"), Type: typ.Enum(), Metric: metrics}
+	}
+	labelPair := func(name, value string) *dto.LabelPair {
+		return &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)}
+	}
+	bucket := func(upperBound float64, count uint64) *dto.Bucket {
+		return &dto.Bucket{UpperBound: proto.Float64(upperBound), CumulativeCount: proto.Uint64(count)}
+	}
+	mfs := []*dto.MetricFamily{
+		metricFamily("libvirt_up", dto.MetricType_GAUGE, &dto.Metric{
+			Gauge: &dto.Gauge{Value: proto.Float64(1)},
+		}),
+		metricFamily("libvirt_domain_events_total", dto.MetricType_COUNTER, &dto.Metric{
+			// A label of the series takes precedence over an extra label.
+			Label:       []*dto.LabelPair{labelPair("instance", "vm1"), labelPair("event", "started")},
+			Counter:     &dto.Counter{Value: proto.Float64(3)},
+			TimestampMs: proto.Int64(1000),
+		}),
+		metricFamily("libvirt_scrape_duration_seconds", dto.MetricType_HISTOGRAM, &dto.Metric{
+			Histogram: &dto.Histogram{
+				SampleCount: proto.Uint64(4),
+				SampleSum:   proto.Float64(2.5),
+				Bucket:      []*dto.Bucket{bucket(0.5, 1), bucket(1, 3)},
+			},
+		}, &dto.Metric{
+			Label: []*dto.LabelPair{labelPair("collector", "block")},
+			Histogram: &dto.Histogram{
+				SampleCount: proto.Uint64(2),
+				SampleSum:   proto.Float64(0.5),
+				Bucket:      []*dto.Bucket{bucket(1, 2), bucket(math.Inf(+1), 2)},
+			},
+		}),
+		metricFamily("go_gc_duration_seconds", dto.MetricType_SUMMARY, &dto.Metric{
+			Summary: &dto.Summary{
+				SampleCount: proto.Uint64(10),
+				SampleSum:   proto.Float64(0.1),
+				Quantile: []*dto.Quantile{
+					{Quantile: proto.Float64(0.5), Value: proto.Float64(0.01)},
+					{Quantile: proto.Float64(1), Value: proto.Float64(0.02)},
+				},
+			},
+		}),
+	}
+	now := time.UnixMilli(2000)
+	series, metadata := toTimeSeries(mfs, map[string]string{"instance": "kvm1"}, now)
+	gotSeries, gotMetadata := decodeWriteRequest(t, writeRequestDescriptor(t), encodeWriteRequest(series, metadata))
+
+	wantSeries := []string{
+		`libvirt_up{instance="kvm1"} 1 @2000`,
+		`libvirt_domain_events_total{event="started",instance="vm1"} 3 @1000`,
+		`libvirt_scrape_duration_seconds_bucket{instance="kvm1",le="0.5"} 1 @2000`,
+		`libvirt_scrape_duration_seconds_bucket{instance="kvm1",le="1"} 3 @2000`,
+		`libvirt_scrape_duration_seconds_bucket{instance="kvm1",le="+Inf"} 4 @2000`,
+		`libvirt_scrape_duration_seconds_sum{instance="kvm1"} 2.5 @2000`,
+		`libvirt_scrape_duration_seconds_count{instance="kvm1"} 4 @2000`,
+		`libvirt_scrape_duration_seconds_bucket{collector="block",instance="kvm1",le="1"} 2 @2000`,
+		`libvirt_scrape_duration_seconds_bucket{collector="block",instance="kvm1",le="+Inf"} 2 @2000`,
+		`libvirt_scrape_duration_seconds_sum{collector="block",instance="kvm1"} 0.5 @2000`,
+		`libvirt_scrape_duration_seconds_count{collector="block",instance="kvm1"} 2 @2000`,
+		`go_gc_duration_seconds{instance="kvm1",quantile="0.5"} 0.01 @2000`,
+		`go_gc_duration_seconds{instance="kvm1",quantile="1"} 0.02 @2000`,
+		`go_gc_duration_seconds_sum{instance="kvm1"} 0.1 @2000`,
+		`go_gc_duration_seconds_count{instance="kvm1"} 10 @2000`,
+	}
+	if !reflect.DeepEqual(gotSeries, wantSeries) {
+		t.Errorf("series =\n%s\nwant\n%s", strings.Join(gotSeries, "\n"), strings.Join(wantSeries, "\n"))
+	}
+	wantMetadata := []string{
+		"GAUGE libvirt_up libvirt_up help",
+		"COUNTER libvirt_domain_events_total libvirt_domain_events_total help",
+		"HISTOGRAM libvirt_scrape_duration_seconds libvirt_scrape_duration_seconds help",
+		"SUMMARY go_gc_duration_seconds go_gc_duration_seconds help",
+	}
+	if !reflect.DeepEqual(gotMetadata, wantMetadata) {
+		t.Errorf("metadata =\n%s\nwant\n%s", strings.Join(gotMetadata, "\n"), strings.Join(wantMetadata, "\n"))
+	}
+}
+
+func TestRemoteWriterMetadataFirstBatch(t *testing.T) {
+	desc := writeRequestDescriptor(t)
+	var (
+		mtx      sync.Mutex
+		requests [][]string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, _ := io.ReadAll(r.Body)
+		data, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Errorf("couldn't decompress write request: %v", err)
+		}
+		series, metadata := decodeWriteRequest(t, desc, data)
+		mtx.Lock()
+		requests = append(requests, []string{fmt.Sprintf("%d series", len(series)), fmt.Sprintf("%d metadata", len(metadata))})
+		mtx.Unlock()
+	}))
+	defer server.Close()
+
+	registry := prometheus.NewRegistry()
+	for _, name := range []string{"a", "b", "c"} {
+		registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: name}))
+	}
+	w, err := newRemoteWriter(remoteWriteConfig{
+		url:               server.URL,
+		interval:          time.Minute,
+		timeout:           time.Minute,
+		maxSamplesPerSend: 2,
+	}, func(context.Context) (prometheus.Gatherer, error) {
+		return registry, nil
+	}, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"2 series", "3 metadata"}, {"1 series", "0 metadata"}}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests = %v, want %v", requests, want)
+	}
+}
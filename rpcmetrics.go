@@ -0,0 +1,62 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// rpcMetrics instruments the RPCs the exporter sends to libvirt, to tell
+// which calls slow down scrapes.
+type rpcMetrics struct {
+	calls    *prometheus.CounterVec
+	failures *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func newRPCMetrics() *rpcMetrics {
+	return &rpcMetrics{
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "libvirt_exporter",
+			Subsystem: "rpc",
+			Name:      "calls_total",
+			Help:      "Number of libvirt RPCs answered by the daemon, by procedure.",
+		}, []string{"proc"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "libvirt_exporter",
+			Subsystem: "rpc",
+			Name:      "failures_total",
+			Help:      "Number of libvirt RPCs the daemon answered with an error, by procedure.",
+		}, []string{"proc"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "libvirt_exporter",
+			Subsystem: "rpc",
+			Name:      "duration_seconds",
+			Help:      "Time until libvirt answered an RPC, by procedure.",
+			Buckets:   []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		}, []string{"proc"}),
+	}
+}
+
+// observe implements connection.RPCObserver.
+func (m *rpcMetrics) observe(proc string, duration time.Duration, failed bool) {
+	m.calls.WithLabelValues(proc).Inc()
+	if failed {
+		m.failures.WithLabelValues(proc).Inc()
+	}
+	m.duration.WithLabelValues(proc).Observe(duration.Seconds())
+}
+
+// Describe implements prometheus.Collector.
+func (m *rpcMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.calls.Describe(ch)
+	m.failures.Describe(ch)
+	m.duration.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *rpcMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.calls.Collect(ch)
+	m.failures.Collect(ch)
+	m.duration.Collect(ch)
+}
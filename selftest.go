@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/log/level"
+	"github.com/nee541/libvirt-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/version"
+)
+
+// selfTestResult is the JSON document returned by the self-test endpoint.
+type selfTestResult struct {
+	Status   string   `json:"status"`
+	Families int      `json:"families"`
+	Series   int      `json:"series"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// selfTest runs a full collection into a pedantic registry and reports
+// duplicate series or descriptors and inconsistent label sets. It is meant as
+// a post-deploy check after enabling collectors or changing label flags. The
+// collection counts against --web.max-requests like a scrape, but is a dry run
+// which does not change the state the scrapes see.
+func (h *handler) selfTest(w http.ResponseWriter, r *http.Request) {
+	if h.inFlightSem != nil {
+		select {
+		case h.inFlightSem <- struct{}{}:
+			defer func() { <-h.inFlightSem }()
+		default:
+			http.Error(w, fmt.Sprintf("Limit of concurrent requests reached (%d), try again later.", h.maxRequests), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	result := selfTestResult{Status: "pass"}
+	fail := func(err error) {
+		result.Status = "fail"
+		result.Errors = append(result.Errors, err.Error())
+	}
+
+	lc, err := collector.NewLibvirtCollector(h.conn, h.logger)
+	if err != nil {
+		fail(err)
+	} else {
+		reg := prometheus.NewPedanticRegistry()
+		if err := reg.Register(version.NewCollector("libvirt_exporter")); err != nil {
+			fail(err)
+		}
+		if err := reg.Register(h.excludeMetrics(lc.WithContext(r.Context()).DryRun())); err != nil {
+			fail(err)
+		}
+		mfs, err := prometheus.Gatherers{h.exporterMetricsRegistry, reg}.Gather()
+		if err != nil {
+			var multiErr prometheus.MultiError
+			if errors.As(err, &multiErr) {
+				for _, err := range multiErr {
+					fail(err)
+				}
+			} else {
+				fail(err)
+			}
+		}
+		result.Families = len(mfs)
+		for _, mf := range mfs {
+			result.Series += len(mf.GetMetric())
+			// The registry only checks label names against the descriptor,
+			// which does not catch families collected from several descriptors.
+			labelSets := map[string]bool{}
+			for _, m := range mf.GetMetric() {
+				names := make([]string, 0, len(m.GetLabel()))
+				for _, l := range m.GetLabel() {
+					names = append(names, l.GetName())
+				}
+				sort.Strings(names)
+				labelSets[strings.Join(names, ",")] = true
+			}
+			if len(labelSets) > 1 {
+				sets := make([]string, 0, len(labelSets))
+				for set := range labelSets {
+					sets = append(sets, "{"+set+"}")
+				}
+				sort.Strings(sets)
+				fail(fmt.Errorf("metric family %s has inconsistent label sets: %s", mf.GetName(), strings.Join(sets, " ")))
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Status != "pass" {
+		level.Warn(h.logger).Log("msg", "Self-test failed", "errors", len(result.Errors))
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// gatherCall is an in-progress or finished collection shared by concurrent
+// scrapes.
+type gatherCall struct {
+	done chan struct{}
+	mfs  []*dto.MetricFamily
+	err  error
+	// ctx bounds the collection, see flightContext.
+	ctx *flightContext
+	// waiters counts the scrapes still waiting for the collection.
+	waiters int
+}
+
+// singleFlight deduplicates concurrent collections of the same set of
+// collectors: a scrape arriving while an identical collection is running
+// waits for it and reuses its result instead of querying libvirtd again.
+type singleFlight struct {
+	mtx     sync.Mutex
+	calls   map[string]*gatherCall
+	dropped prometheus.Counter
+}
+
+func newSingleFlight() *singleFlight {
+	return &singleFlight{
+		calls: make(map[string]*gatherCall),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "libvirt_exporter",
+			Name:      "concurrent_scrapes_dropped_total",
+			Help:      "Number of scrapes that reused a concurrently running collection instead of collecting themselves.",
+		}),
+	}
+}
+
+// gatherer returns a gatherer for the scrape of ctx whose Gather shares one
+// collection with concurrent calls for the same filters. The collection runs
+// on a context of its own, which newGatherer has to bind the collectors to.
+// It ends at the latest deadline of the scrapes waiting for it, or once all
+// of them are gone. A scrape gives up waiting once ctx is done.
+func (s *singleFlight) gatherer(ctx context.Context, newGatherer func(context.Context) (prometheus.Gatherer, error), filters ...string) prometheus.Gatherer {
+	sorted := append([]string(nil), filters...)
+	sort.Strings(sorted)
+	key := strings.Join(sorted, ",")
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return s.do(ctx, key, newGatherer)
+	})
+}
+
+func (s *singleFlight) do(ctx context.Context, key string, newGatherer func(context.Context) (prometheus.Gatherer, error)) ([]*dto.MetricFamily, error) {
+	s.mtx.Lock()
+	call, ok := s.calls[key]
+	if ok {
+		s.dropped.Inc()
+		call.waiters++
+		call.ctx.extend(ctx)
+	} else {
+		call = &gatherCall{done: make(chan struct{}), ctx: newFlightContext(), waiters: 1}
+		call.ctx.extend(ctx)
+		s.calls[key] = call
+		go s.run(key, call, newGatherer)
+	}
+	s.mtx.Unlock()
+
+	select {
+	case <-call.done:
+		return call.mfs, call.err
+	case <-ctx.Done():
+		s.leave(key, call)
+		return nil, ctx.Err()
+	}
+}
+
+// run collects for all scrapes waiting for call.
+func (s *singleFlight) run(key string, call *gatherCall, newGatherer func(context.Context) (prometheus.Gatherer, error)) {
+	g, err := newGatherer(call.ctx)
+	if err == nil {
+		call.mfs, err = g.Gather()
+	}
+	call.err = err
+
+	s.mtx.Lock()
+	if s.calls[key] == call {
+		delete(s.calls, key)
+	}
+	s.mtx.Unlock()
+	call.ctx.cancel(context.Canceled)
+	close(call.done)
+}
+
+// leave is called by a scrape which stopped waiting for call. The collection
+// is cancelled once no scrape waits for it anymore, later scrapes start a new
+// one.
+func (s *singleFlight) leave(key string, call *gatherCall) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if call.waiters--; call.waiters > 0 {
+		return
+	}
+	if s.calls[key] == call {
+		delete(s.calls, key)
+	}
+	call.ctx.cancel(context.Canceled)
+}
+
+// flightContext is the context of a shared collection. It is not derived
+// from the context of any scrape, so it is neither cut short by the scrape
+// which started the collection nor by its client going away. Its deadline is
+// the latest deadline of the scrapes joining the collection, and it has none
+// if one of them has none.
+type flightContext struct {
+	mtx       sync.Mutex
+	done      chan struct{}
+	err       error
+	deadline  time.Time
+	unbounded bool
+	timer     *time.Timer
+}
+
+func newFlightContext() *flightContext {
+	return &flightContext{done: make(chan struct{})}
+}
+
+// extend moves the deadline to the one of ctx if that is later.
+func (c *flightContext) extend(ctx context.Context) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.err != nil || c.unbounded {
+		return
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		c.unbounded = true
+		c.deadline = time.Time{}
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+		return
+	}
+	if !deadline.After(c.deadline) {
+		return
+	}
+	c.deadline = deadline
+	if c.timer == nil {
+		c.timer = time.AfterFunc(time.Until(deadline), c.expire)
+	} else {
+		c.timer.Reset(time.Until(deadline))
+	}
+}
+
+// expire ends the context when the deadline is reached. It ignores a timer
+// which fired while the deadline was extended.
+func (c *flightContext) expire() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.unbounded || time.Now().Before(c.deadline) {
+		return
+	}
+	c.cancelLocked(context.DeadlineExceeded)
+}
+
+// cancel ends the context with err, later calls have no effect.
+func (c *flightContext) cancel(err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.cancelLocked(err)
+}
+
+func (c *flightContext) cancelLocked(err error) {
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	close(c.done)
+}
+
+// Deadline implements context.Context.
+func (c *flightContext) Deadline() (time.Time, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.deadline, !c.deadline.IsZero()
+}
+
+// Done implements context.Context.
+func (c *flightContext) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err implements context.Context.
+func (c *flightContext) Err() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.err
+}
+
+// Value implements context.Context, a shared collection carries no values.
+func (c *flightContext) Value(key any) any {
+	return nil
+}
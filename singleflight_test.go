@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestSingleFlightContext(t *testing.T) {
+	s := newSingleFlight()
+	started := make(chan context.Context, 1)
+	release := make(chan struct{})
+	newGatherer := func(ctx context.Context) (prometheus.Gatherer, error) {
+		return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			started <- ctx
+			select {
+			case <-release:
+				return []*dto.MetricFamily{{}}, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}), nil
+	}
+
+	short, cancelShort := context.WithTimeout(context.Background(), time.Hour)
+	long, cancelLong := context.WithTimeout(context.Background(), 2*time.Hour)
+	defer cancelLong()
+
+	shortErr := make(chan error, 1)
+	go func() {
+		_, err := s.gatherer(short, newGatherer).Gather()
+		shortErr <- err
+	}()
+	collection := <-started
+	longResult := make(chan []*dto.MetricFamily, 1)
+	go func() {
+		mfs, _ := s.gatherer(long, newGatherer).Gather()
+		longResult <- mfs
+	}()
+
+	// The second scrape extends the deadline of the shared collection.
+	want, _ := long.Deadline()
+	for deadline, _ := collection.Deadline(); !deadline.Equal(want); deadline, _ = collection.Deadline() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The scrape which started the collection goes away, the collection
+	// keeps running for the other one.
+	cancelShort()
+	if err := <-shortErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Gather() of the cancelled scrape error = %v, want %v", err, context.Canceled)
+	}
+	if err := collection.Err(); err != nil {
+		t.Fatalf("collection cancelled with the scrape which started it: %v", err)
+	}
+	close(release)
+	if mfs := <-longResult; len(mfs) != 1 {
+		t.Fatalf("Gather() of the waiting scrape = %v, want the shared result", mfs)
+	}
+}
+
+func TestSingleFlightCancelledWithoutWaiters(t *testing.T) {
+	s := newSingleFlight()
+	started := make(chan context.Context, 1)
+	newGatherer := func(ctx context.Context) (prometheus.Gatherer, error) {
+		return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			started <- ctx
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}), nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.gatherer(ctx, newGatherer).Gather()
+		close(done)
+	}()
+	collection := <-started
+	cancel()
+	<-done
+	select {
+	case <-collection.Done():
+	case <-time.After(time.Second):
+		t.Fatal("collection not cancelled after its last scrape went away")
+	}
+}
@@ -0,0 +1,146 @@
+// Package state persists what the exporter learned about the hypervisor
+// across restarts in a local bbolt database.
+package state
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/nee541/libvirt-exporter/libvirt_schema"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	domainsBucket = []byte("domains")
+	valuesBucket  = []byte("values")
+)
+
+// Domain is the last known state of a domain.
+type Domain struct {
+	UUID     string                `json:"uuid"`
+	Name     string                `json:"name"`
+	LastSeen time.Time             `json:"last_seen"`
+	Schema   libvirt_schema.Domain `json:"schema"`
+}
+
+// Store is a state file. The database is only opened for the duration of an
+// operation, as bbolt locks it exclusively while it is open for writing and
+// the file must stay readable for the inspect command of a second process.
+// It is safe for concurrent use.
+type Store struct {
+	path     string
+	readOnly bool
+	mtx      sync.Mutex
+}
+
+// Open opens or creates the state file at path.
+func Open(path string, readOnly bool) (*Store, error) {
+	s := &Store{path: path, readOnly: readOnly}
+	if readOnly {
+		return s, s.withDB(func(*bolt.DB) error { return nil })
+	}
+	return s, s.update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{domainsBucket, valuesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close releases the store. The database is not held open, so it is a no-op.
+func (s *Store) Close() error {
+	return nil
+}
+
+func (s *Store) withDB(fn func(db *bolt.DB) error) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	db, err := bolt.Open(s.path, 0o600, &bolt.Options{Timeout: time.Second, ReadOnly: s.readOnly})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return fn(db)
+}
+
+func (s *Store) update(fn func(tx *bolt.Tx) error) error {
+	return s.withDB(func(db *bolt.DB) error { return db.Update(fn) })
+}
+
+func (s *Store) view(fn func(tx *bolt.Tx) error) error {
+	return s.withDB(func(db *bolt.DB) error { return db.View(fn) })
+}
+
+// SaveDomains replaces the stored domain inventory.
+func (s *Store) SaveDomains(domains []Domain) error {
+	return s.update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(domainsBucket); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		b, err := tx.CreateBucket(domainsBucket)
+		if err != nil {
+			return err
+		}
+		for _, domain := range domains {
+			data, err := json.Marshal(domain)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(domain.UUID), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Domains returns the stored domain inventory ordered by UUID.
+func (s *Store) Domains() ([]Domain, error) {
+	var domains []Domain
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(domainsBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, data []byte) error {
+			var domain Domain
+			if err := json.Unmarshal(data, &domain); err != nil {
+				return err
+			}
+			domains = append(domains, domain)
+			return nil
+		})
+	})
+	return domains, err
+}
+
+// SaveValue stores v as JSON under key.
+func (s *Store) SaveValue(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(valuesBucket).Put([]byte(key), data)
+	})
+}
+
+// LoadValue decodes the value stored under key into v. It reports false if
+// there is no such value.
+func (s *Store) LoadValue(key string, v interface{}) (bool, error) {
+	var data []byte
+	err := s.view(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(valuesBucket); b != nil {
+			// The slice is only valid during the transaction.
+			data = append(data, b.Get([]byte(key))...)
+		}
+		return nil
+	})
+	if err != nil || data == nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}